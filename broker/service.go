@@ -70,7 +70,7 @@ func (s *Service) listen(address string) {
 	// Set the read timeout on our mux listener
 	l.SetReadTimeout(120 * time.Second)
 
-	l.ServeAsync(s.http.Serve)
+	l.ServeAsync(listener.MatchAny(), s.http.Serve)
 
 	// l.ServeAsync(listener.MatchAny(), s.tcp.Serve)
 	go l.Serve()
@@ -1,6 +1,10 @@
 package config
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+
 	"github.com/spf13/viper"
 )
 
@@ -18,3 +22,91 @@ func ReadConfig(filename string, defaults map[string]interface{}) (*viper.Viper,
 	err := v.ReadInConfig()
 	return v, err
 }
+
+// GetStringSlice returns the value of a list-valued key. A value coming from
+// the environment is a comma-separated list, such as "a.com,b.com", and
+// replaces the list defined in the configuration file.
+func GetStringSlice(v *viper.Viper, key string) []string {
+	if value, ok := v.Get(key).(string); ok {
+		return splitList(value)
+	}
+	return v.GetStringSlice(key)
+}
+
+// splitList splits a comma-separated list, dropping the blank items.
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ApplyOverrides sets the key=value pairs, such as the ones given with --set on
+// the command line, which take precedence over the configuration file and the
+// environment. Nested keys are dotted, like "server.port=9090". The type of a
+// value is inferred, as a bool for true and false, then as an integer, a float
+// or a string, unless the key states it, like "version:string=1.10", with one
+// of string, int, float, bool or list, a comma-separated list. A colon followed
+// by anything else is part of the key, like in "routes.host:8080=api".
+func ApplyOverrides(v *viper.Viper, overrides []string) error {
+	for _, override := range overrides {
+		pair := strings.SplitN(override, "=", 2)
+		key := strings.TrimSpace(pair[0])
+		if len(pair) != 2 || key == "" {
+			return fmt.Errorf("config: malformed override %q, want key=value", override)
+		}
+
+		kind := ""
+		if i := strings.LastIndex(key, ":"); i >= 0 && overrideTypes[key[i+1:]] {
+			key, kind = key[:i], key[i+1:]
+		}
+		value, err := parseOverride(kind, pair[1])
+		if err != nil {
+			return fmt.Errorf("config: override %q: %v", override, err)
+		}
+		v.Set(key, value)
+	}
+	return nil
+}
+
+// overrideTypes are the types an override key can state.
+var overrideTypes = map[string]bool{
+	"string": true,
+	"int":    true,
+	"float":  true,
+	"bool":   true,
+	"list":   true,
+}
+
+// parseOverride parses the value of an override as the type, or infers the type
+// if it is empty.
+func parseOverride(kind, value string) (interface{}, error) {
+	switch kind {
+	case "":
+		if value == "true" || value == "false" {
+			return value == "true", nil
+		}
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return int(i), nil
+		}
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f, nil
+		}
+		return value, nil
+	case "string":
+		return value, nil
+	case "int":
+		i, err := strconv.ParseInt(value, 10, 64)
+		return int(i), err
+	case "float":
+		return strconv.ParseFloat(value, 64)
+	case "bool":
+		return strconv.ParseBool(value)
+	case "list":
+		return splitList(value), nil
+	}
+	return nil, fmt.Errorf("unknown type %q", kind)
+}
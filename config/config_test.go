@@ -0,0 +1,106 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// newTestViper returns a viper reading the YAML configuration and the RTMS_
+// environment variables.
+func newTestViper(t *testing.T, yaml string) *viper.Viper {
+	t.Helper()
+	v := viper.New()
+	v.SetConfigType("yaml")
+	v.SetEnvPrefix("rtms")
+	v.AutomaticEnv()
+	if err := v.ReadConfig(strings.NewReader(yaml)); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestGetStringSlice(t *testing.T) {
+	const yaml = "allowed_origins:\n  - c.com\n  - d.com\n"
+	tests := []struct {
+		name string
+		env  string
+		want []string
+	}{
+		{"file", "", []string{"c.com", "d.com"}},
+		{"env replaces file", "a.com,b.com", []string{"a.com", "b.com"}},
+		{"blank items dropped", " a.com , ,b.com,", []string{"a.com", "b.com"}},
+		{"single value", "a.com", []string{"a.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				t.Setenv("RTMS_ALLOWED_ORIGINS", tt.env)
+			}
+			v := newTestViper(t, yaml)
+			if got := GetStringSlice(v, "allowed_origins"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetStringSlice() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyOverrides(t *testing.T) {
+	const yaml = "server:\n  port: 8080\n  host: a.com\ntls:\n  enabled: false\n"
+	// The overrides take precedence over the environment too
+	t.Setenv("RTMS_SERVER.PORT", "7070")
+	v := newTestViper(t, yaml)
+	overrides := []string{
+		"server.port=9090",
+		"tls.enabled=true",
+		"server.timeout=1.5",
+		"server.name=edge-1",
+		"version:string=1.10",
+		"origins:list=a.com, b.com",
+		"routes.host:8080=api",
+		"query=a=b",
+	}
+	if err := ApplyOverrides(v, overrides); err != nil {
+		t.Fatalf("ApplyOverrides() = %v", err)
+	}
+
+	want := map[string]interface{}{
+		"server.port":      9090,
+		"server.host":      "a.com",
+		"tls.enabled":      true,
+		"server.timeout":   1.5,
+		"server.name":      "edge-1",
+		"version":          "1.10",
+		"origins":          []string{"a.com", "b.com"},
+		"routes.host:8080": "api",
+		"query":            "a=b",
+	}
+	for key, value := range want {
+		if got := v.Get(key); !reflect.DeepEqual(got, value) {
+			t.Errorf("Get(%q) = %#v, want %#v", key, got, value)
+		}
+	}
+}
+
+func TestApplyOverridesErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		override string
+	}{
+		{"no value", "server.port"},
+		{"no key", "=9090"},
+		{"not an int", "server.port:int=http"},
+		{"not a bool", "tls.enabled:bool=yes"},
+		{"not a float", "server.timeout:float=1s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := viper.New()
+			if err := ApplyOverrides(v, []string{"server.host=a.com", tt.override}); err == nil {
+				t.Errorf("ApplyOverrides(%q) = nil, want an error", tt.override)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package listener
+
+import (
+	"hash/fnv"
+	"net"
+)
+
+// Workers splits the route into n virtual listeners, the workers, and returns
+// them. Every matched connection is then accepted by the worker the affinity
+// function of the route assigns it, instead of by the route itself, so the
+// handlers can keep shard-local state without locks, with better cache
+// locality. The price is the balance: a few busy clients hashing to the same
+// worker overload it while the other ones idle, which a plain route shared by
+// several goroutines does not suffer from.
+//
+// Workers must be called before the listener serves. Each worker has the queue
+// size of the route. A route is split into one worker at least.
+func (r *Route) Workers(n int) []net.Listener {
+	if n < 1 {
+		n = 1
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.workers = make([]chan net.Conn, n)
+	listeners := make([]net.Listener, n)
+	for i := range r.workers {
+		r.workers[i] = make(chan net.Conn, cap(r.connections))
+		listeners[i] = &worker{Listener: r.Listener, connections: r.workers[i], done: r.done}
+	}
+	return listeners
+}
+
+// SetWorkerAffinity sets the function returning the worker of a connection,
+// whose result is taken modulo the number of workers. It defaults to
+// RemoteIPAffinity.
+func (r *Route) SetWorkerAffinity(affinity func(net.Conn) int) {
+	r.Lock()
+	defer r.Unlock()
+	r.guards.affinity.reset()
+	r.affinity = affinity
+}
+
+// RemoteIPAffinity hashes the remote IP address of the connection, so all the
+// connections of a client go to the same worker.
+func RemoteIPAffinity(c net.Conn) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(remoteIP(c)))
+	return int(h.Sum32() & 0x7fffffff)
+}
+
+// queue returns the queue a matched connection is dispatched to.
+func (r *Route) queue(c net.Conn) chan net.Conn {
+	r.Lock()
+	workers, affinity := r.workers, r.affinity
+	r.Unlock()
+
+	if len(workers) == 0 {
+		return r.connections
+	}
+	return workers[shardOf(c, affinity, len(workers), &r.guards.affinity)]
+}
+
+// shardOf returns the shard of the connection among n shards, the result of
+// the affinity function, RemoteIPAffinity if it is nil, modulo n. It is the
+// first shard if the affinity function panics.
+func shardOf(c net.Conn, affinity func(net.Conn) int, n int, guard *hookGuard) int {
+	if affinity == nil {
+		affinity = RemoteIPAffinity
+	}
+
+	i := 0
+	guard.call("worker affinity", func() { i = affinity(c) % n })
+	if i < 0 {
+		i += n
+	}
+	return i
+}
+
+// closeQueues closes the queues of the route and closes the connections
+// which are still queued.
+func (r *Route) closeQueues() {
+	r.Lock()
+	queues := append([]chan net.Conn{r.connections}, r.workers...)
+	r.Unlock()
+
+	for _, q := range queues {
+		close(q)
+		for c := range q {
+			_ = c.Close()
+		}
+	}
+}
+
+// worker is a virtual listener accepting a shard of the connections of a route.
+type worker struct {
+	net.Listener
+	connections chan net.Conn
+	done        <-chan struct{} // The done channel of the route.
+}
+
+// Accept waits for and returns the next connection assigned to the worker.
+func (w *worker) Accept() (net.Conn, error) {
+	select {
+	case c, ok := <-w.connections:
+		if !ok {
+			return nil, ErrListenerClosed
+		}
+		return c, nil
+	case <-w.done:
+		return nil, ErrListenerClosed
+	}
+}
@@ -0,0 +1,122 @@
+package listener
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// addrListener gives its connections the remote addresses in turn.
+type addrListener struct {
+	net.Listener
+	mu    sync.Mutex
+	addrs []string
+}
+
+func (l *addrListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	addr, _ := net.ResolveTCPAddr("tcp", l.addrs[0])
+	l.addrs = l.addrs[1:]
+	return &addrConn{Conn: c, remote: addr}, nil
+}
+
+type addrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *addrConn) RemoteAddr() net.Addr { return c.remote }
+
+// acceptShards accepts the connections of the workers, sending the index of
+// the worker of each.
+func acceptShards(t *testing.T, workers []net.Listener) <-chan int {
+	shards := make(chan int)
+	for i, w := range workers {
+		go func(i int, w net.Listener) {
+			for {
+				c, err := w.Accept()
+				if err != nil {
+					return
+				}
+				t.Cleanup(func() { _ = c.Close() })
+				shards <- i
+			}
+		}(i, w)
+	}
+	return shards
+}
+
+func TestWorkerAffinity(t *testing.T) {
+	addrs := []string{"10.0.0.1:4000", "10.0.0.2:4000", "10.0.0.1:4001", "10.0.0.3:4000", "10.0.0.2:5000", "10.0.0.1:4002"}
+	mem := NewMemoryListener()
+	m := &testMux{Listener: New(&addrListener{Listener: mem, addrs: append([]string(nil), addrs...)}), t: t, dialer: mem, mem: mem}
+	workers := m.Route("pubsub", MatchAny()).Workers(4)
+	m.serve()
+	shards := acceptShards(t, workers)
+
+	// Every connection of a client goes to the worker its IP hashes to
+	shardOfIP := make(map[string]int)
+	for _, addr := range addrs {
+		remote, _ := net.ResolveTCPAddr("tcp", addr)
+		hashed := RemoteIPAffinity(&addrConn{remote: remote}) % len(workers)
+		m.dial("x")
+		var shard int
+		select {
+		case shard = <-shards:
+		case <-time.After(testTimeout):
+			t.Fatalf("connection from %s not accepted", addr)
+		}
+
+		ip, _, _ := net.SplitHostPort(addr)
+		if want, ok := shardOfIP[ip]; ok && shard != want {
+			t.Errorf("connection from %s accepted by worker %d, want %d like before", addr, shard, want)
+		} else if shard != hashed {
+			t.Errorf("connection from %s accepted by worker %d, want %d", addr, shard, hashed)
+		}
+		shardOfIP[ip] = shard
+	}
+}
+
+func TestWorkersAtLeastOne(t *testing.T) {
+	for _, n := range []int{0, -1} {
+		m := newTestMux(t)
+		workers := m.Route("pubsub", MatchAny()).Workers(n)
+		if len(workers) != 1 {
+			t.Fatalf("Workers(%d) returned %d workers, want 1", n, len(workers))
+		}
+		m.serve()
+
+		m.dial("x")
+		select {
+		case <-acceptShards(t, workers):
+		case <-time.After(testTimeout):
+			t.Fatalf("Workers(%d): connection not accepted", n)
+		}
+	}
+}
+
+func TestShardOf(t *testing.T) {
+	var guard hookGuard
+	tests := []struct {
+		name     string
+		affinity func(net.Conn) int
+		want     int
+	}{
+		{"modulo", func(net.Conn) int { return 9 }, 1},
+		{"negative", func(net.Conn) int { return -1 }, 3},
+		{"panic", func(net.Conn) int { panic("affinity") }, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shardOf(nil, tt.affinity, 4, &guard); got != tt.want {
+				t.Errorf("shardOf() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
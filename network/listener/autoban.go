@@ -0,0 +1,112 @@
+package listener
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/numb3r3/live-go/log"
+)
+
+// maxAutobanEntries bounds the number of addresses the autoban keeps track of.
+// New addresses are not tracked while it is full of live entries.
+const maxAutobanEntries = 64 * 1024
+
+// ErrBanned is the error the connections from an address banned for its
+// unmatched connections are closed with.
+var ErrBanned error = errRejected("mux: address banned")
+
+// SetUnmatchedAutoban bans the IP addresses opening threshold unmatched
+// connections within the window, such as scanners, for the cooldown: their
+// new connections are then closed right away with ErrBanned, without sniffing
+// nor any response. A zero threshold disables it, lifting the current bans.
+func (m *Listener) SetUnmatchedAutoban(threshold int, window, cooldown time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.autoban = nil
+	if threshold > 0 {
+		m.autoban = &autoban{
+			threshold: threshold,
+			window:    window,
+			cooldown:  cooldown,
+			entries:   make(map[string]*autobanEntry),
+		}
+	}
+}
+
+// autoban counts the unmatched connections of the IP addresses and bans the
+// ones over the threshold.
+type autoban struct {
+	sync.Mutex
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	entries   map[string]*autobanEntry
+	swept     time.Time // When the expired entries were last removed.
+}
+
+// autobanEntry is the state of an address tracked by the autoban.
+type autobanEntry struct {
+	start  time.Time // The start of the current window.
+	count  int       // The unmatched connections within the window.
+	banned time.Time // When the ban ends, zero if not banned.
+}
+
+// record counts an unmatched connection from the address and bans it once it
+// reaches the threshold.
+func (a *autoban) record(ip string, now time.Time) {
+	a.Lock()
+	defer a.Unlock()
+
+	e, ok := a.entries[ip]
+	if !ok {
+		if len(a.entries) >= maxAutobanEntries {
+			a.sweep(now)
+			if len(a.entries) >= maxAutobanEntries {
+				return
+			}
+		}
+		e = &autobanEntry{start: now}
+		a.entries[ip] = e
+	}
+
+	if now.Sub(e.start) > a.window {
+		e.start, e.count = now, 0
+	}
+	if e.count++; e.count >= a.threshold && now.After(e.banned) {
+		e.banned = now.Add(a.cooldown)
+		logging.Infof("address %s banned for %v after %d unmatched connections", ip, a.cooldown, e.count)
+	}
+}
+
+// banned returns whether the address is banned.
+func (a *autoban) banned(ip string, now time.Time) bool {
+	a.Lock()
+	defer a.Unlock()
+
+	if now.Sub(a.swept) > a.window+a.cooldown {
+		a.sweep(now)
+	}
+	e, ok := a.entries[ip]
+	return ok && now.Before(e.banned)
+}
+
+// sweep removes the entries whose window and ban are over.
+func (a *autoban) sweep(now time.Time) {
+	a.swept = now
+	for ip, e := range a.entries {
+		if now.Sub(e.start) > a.window && !now.Before(e.banned) {
+			delete(a.entries, ip)
+		}
+	}
+}
+
+// remoteIP returns the IP address of the remote end of the connection.
+func remoteIP(c net.Conn) string {
+	host := remoteAddr(c)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return host
+}
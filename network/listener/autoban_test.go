@@ -0,0 +1,63 @@
+package listener
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestUnmatchedAutoban(t *testing.T) {
+	const cooldown = 100 * time.Millisecond
+	mem := NewMemoryListener()
+	addrs := []string{
+		"10.0.0.1:4000", "10.0.0.1:4001", "10.0.0.1:4002", // Scanning
+		"10.0.0.1:4003", "10.0.0.2:4000", // While banned
+		"10.0.0.1:4004", // After the cooldown
+	}
+	m := &testMux{Listener: New(&addrListener{Listener: mem, addrs: addrs}), t: t, dialer: mem, mem: mem}
+	m.SetUnmatchedAutoban(3, time.Minute, cooldown)
+	r := m.Match(MatchPrefix("*"))
+	m.serve()
+
+	for i := 0; i < 3; i++ {
+		expectClosed(t, m.dial("GET / HTTP/1.1\r\n"))
+	}
+	banned := time.Now()
+	expectClosed(t, m.dial("*1\r\n"))
+	m.dial("*1\r\n")
+	if c := accept(t, r); remoteIP(c) != "10.0.0.2" {
+		t.Errorf("accepted a connection from %s, want 10.0.0.2", remoteIP(c))
+	}
+
+	time.Sleep(cooldown - time.Since(banned) + 10*time.Millisecond)
+	m.dial("*1\r\n")
+	if c := accept(t, r); remoteIP(c) != "10.0.0.1" {
+		t.Errorf("accepted a connection from %s, want 10.0.0.1 once unbanned", remoteIP(c))
+	}
+}
+
+func TestAutobanBounded(t *testing.T) {
+	a := &autoban{threshold: 2, window: time.Second, cooldown: time.Second, entries: make(map[string]*autobanEntry)}
+	now := time.Now()
+	for i := 0; i < maxAutobanEntries; i++ {
+		a.record(strconv.Itoa(i), now)
+	}
+
+	// Live entries are kept, and new addresses are not tracked
+	a.record("new", now)
+	a.record("new", now)
+	if len(a.entries) != maxAutobanEntries || a.banned("new", now) {
+		t.Errorf("%d entries while full, new address banned = %v", len(a.entries), a.banned("new", now))
+	}
+
+	// Expired ones are swept
+	later := now.Add(2 * time.Second)
+	a.record("new", later)
+	a.record("new", later)
+	if len(a.entries) != 1 || !a.banned("new", later) {
+		t.Errorf("%d entries after the window, new address banned = %v, want 1 and true", len(a.entries), a.banned("new", later))
+	}
+	if a.banned("new", later.Add(3*time.Second)) || len(a.entries) != 0 {
+		t.Errorf("%d entries after the ban, want none", len(a.entries))
+	}
+}
@@ -0,0 +1,66 @@
+package listener
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// The bounds of the delay of the accept loop after a failed accept.
+const (
+	minAcceptBackoff = 5 * time.Millisecond
+	maxAcceptBackoff = time.Second
+)
+
+// AcceptBackoff returns the delay the accept loop waits before accepting again,
+// while the accepts fail with the temporary errors the error handler lets it
+// retry, such as EMFILE when the process runs out of file descriptors. The
+// delay starts at 5ms and doubles with every failure, up to a second. It is
+// zero when the loop is healthy, once an accept succeeds.
+func (m *Listener) AcceptBackoff() time.Duration {
+	return time.Duration(atomic.LoadInt64(&m.acceptBackoff))
+}
+
+// ResetAcceptBackoff clears the backoff of the accept loop, which stops waiting
+// and accepts again at once, for instance once file descriptors were freed
+// during an exhaustion incident. The backoff starts over from 5ms if the next
+// accept fails.
+func (m *Listener) ResetAcceptBackoff() {
+	atomic.StoreInt64(&m.acceptBackoff, 0)
+	select {
+	case m.backoffReset <- struct{}{}:
+	default:
+	}
+}
+
+// backoffAccept waits after a failed accept, for twice as long as after the
+// previous one, until the backoff is reset or the listener is closed.
+func (m *Listener) backoffAccept() {
+	// Forget the resets which happened while the loop was healthy
+	select {
+	case <-m.backoffReset:
+	default:
+	}
+
+	delay := nextBackoff(time.Duration(atomic.LoadInt64(&m.acceptBackoff)))
+	atomic.StoreInt64(&m.acceptBackoff, int64(delay))
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-m.backoffReset:
+	case <-m.root.closing():
+	}
+}
+
+// nextBackoff returns the delay after a failure which follows a delay, twice
+// as long, between 5ms and a second.
+func nextBackoff(delay time.Duration) time.Duration {
+	delay *= 2
+	if delay < minAcceptBackoff {
+		return minAcceptBackoff
+	} else if delay > maxAcceptBackoff {
+		return maxAcceptBackoff
+	}
+	return delay
+}
@@ -0,0 +1,101 @@
+package listener
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tempError is a temporary accept error, such as EMFILE.
+type tempError struct{}
+
+func (tempError) Error() string   { return "too many open files" }
+func (tempError) Timeout() bool   { return false }
+func (tempError) Temporary() bool { return true }
+
+// failingListener fails its accepts with a temporary error while failing is
+// set.
+type failingListener struct {
+	net.Listener
+	failing int32
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	if atomic.LoadInt32(&l.failing) == 1 {
+		return nil, tempError{}
+	}
+	return l.Listener.Accept()
+}
+
+func TestAcceptBackoff(t *testing.T) {
+	mem := NewMemoryListener()
+	failing := &failingListener{Listener: mem, failing: 1}
+	m := &testMux{Listener: New(failing), t: t, dialer: mem, mem: mem}
+	t.Cleanup(func() { _ = m.Close() })
+	m.HandleError(func(error) bool { return true })
+	r := m.Route("r", MatchAny())
+	m.serve()
+
+	// The delay doubles with every failure
+	waitFor(t, "the backoff to grow", func() bool { return m.AcceptBackoff() >= 320*time.Millisecond })
+	if d := m.AcceptBackoff(); d > maxAcceptBackoff {
+		t.Errorf("AcceptBackoff() = %v, want at most %v", d, maxAcceptBackoff)
+	}
+
+	// Once reset, the loop accepts at once rather than waiting the delay out
+	atomic.StoreInt32(&failing.failing, 0)
+	m.ResetAcceptBackoff()
+	if d := m.AcceptBackoff(); d != 0 {
+		t.Errorf("AcceptBackoff() once reset = %v, want 0", d)
+	}
+	start := time.Now()
+	m.dial("x")
+	readN(t, accept(t, r), 1)
+	if d := time.Since(start); d >= 200*time.Millisecond {
+		t.Errorf("accepted after %v, want no wait once reset", d)
+	}
+	if d := m.AcceptBackoff(); d != 0 {
+		t.Errorf("AcceptBackoff() once healthy = %v, want 0", d)
+	}
+}
+
+func TestAcceptBackoffRecovers(t *testing.T) {
+	mem := NewMemoryListener()
+	failing := &failingListener{Listener: mem, failing: 1}
+	m := &testMux{Listener: New(failing), t: t, dialer: mem, mem: mem}
+	t.Cleanup(func() { _ = m.Close() })
+	m.HandleError(func(error) bool { return true })
+	r := m.Route("r", MatchAny())
+	if d := m.AcceptBackoff(); d != 0 {
+		t.Errorf("AcceptBackoff() before serving = %v, want 0", d)
+	}
+	m.serve()
+
+	waitFor(t, "the backoff", func() bool { return m.AcceptBackoff() >= minAcceptBackoff })
+	atomic.StoreInt32(&failing.failing, 0)
+	m.dial("x")
+	readN(t, accept(t, r), 1)
+	if d := m.AcceptBackoff(); d != 0 {
+		t.Errorf("AcceptBackoff() after an accept = %v, want 0", d)
+	}
+}
+
+func TestAcceptBackoffCap(t *testing.T) {
+	m := New(NewMemoryListener())
+	defer m.Close()
+	atomic.StoreInt64(&m.acceptBackoff, int64(800*time.Millisecond))
+	done := make(chan struct{})
+	go func() {
+		m.backoffAccept()
+		close(done)
+	}()
+
+	waitFor(t, "the capped backoff", func() bool { return m.AcceptBackoff() == maxAcceptBackoff })
+	m.ResetAcceptBackoff()
+	select {
+	case <-done:
+	case <-time.After(maxAcceptBackoff / 2):
+		t.Error("backoff not interrupted by the reset")
+	}
+}
@@ -0,0 +1,69 @@
+package listener
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// backpressureInterval is the minimum time between two backpressure callbacks
+// for the same route.
+const backpressureInterval = time.Second
+
+// SetBackpressureCallback sets a function called with the name of a route when
+// a matched connection can not be queued for it right away, because its server
+// does not accept the connections as fast as they are matched. The callback is
+// called at most once per second and route, from the goroutine matching the
+// connection, so it should return quickly. A nil function disables it.
+func (m *Listener) SetBackpressureCallback(fn func(route string)) {
+	m.Lock()
+	defer m.Unlock()
+	m.backpressure = fn
+	m.hooks.backpressure.reset()
+}
+
+// dispatch queues a matched connection for the route, reporting the
+// backpressure if the queue is full. It returns false if the listener or the
+// route is closed before the connection could be queued.
+func (m *Listener) dispatch(r *Route, c net.Conn, donec <-chan struct{}) bool {
+	if r.closed() {
+		return false
+	}
+
+	queue := r.queue(c)
+	select {
+	case queue <- c:
+	default:
+		m.reportBackpressure(r)
+		select {
+		case queue <- c:
+		case <-donec:
+			return false
+		case <-r.done:
+			return false
+		}
+	}
+	if r.closed() {
+		// The route may have drained its queues before the connection got in
+		r.drainQueues()
+	}
+	return true
+}
+
+// reportBackpressure calls the backpressure callback for the route, unless it
+// was already called for it within the last interval.
+func (m *Listener) reportBackpressure(r *Route) {
+	m.RLock()
+	fn := m.backpressure
+	m.RUnlock()
+	if fn == nil {
+		return
+	}
+
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&r.lastPressure)
+	if now-last < int64(backpressureInterval) || !atomic.CompareAndSwapInt64(&r.lastPressure, last, now) {
+		return
+	}
+	m.hooks.backpressure.call("backpressure callback", func() { fn(r.name) })
+}
@@ -0,0 +1,46 @@
+package listener
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackpressureCallback(t *testing.T) {
+	m := newTestMux(t)
+	m.SetOptions(Options{BufferSize: 1})
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	m.SetBackpressureCallback(func(route string) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls[route]++
+	})
+	slow := m.Route("slow", MatchPrefix("S"))
+	fast := m.Route("fast", MatchPrefix("F"))
+	m.serve()
+
+	m.dial("F")
+	accept(t, fast)
+
+	// The stalled consumer leaves one connection queued, the next ones wait
+	for i := 0; i < 4; i++ {
+		m.dial("S")
+	}
+	waitFor(t, "the backpressure callback", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls["slow"] > 0
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	mu.Lock()
+	if calls["slow"] != 1 || calls["fast"] != 0 {
+		t.Errorf("calls = %v, want a single one for slow", calls)
+	}
+	mu.Unlock()
+
+	for i := 0; i < 4; i++ {
+		accept(t, slow)
+	}
+}
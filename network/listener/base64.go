@@ -0,0 +1,150 @@
+package listener
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+)
+
+// maxBase64Line bounds the length of the base64 lines, and so of the frames
+// they carry.
+const maxBase64Line = 64 * 1024
+
+var errBase64Line = errors.New("mux: invalid base64 line")
+
+// MatchBase64Framed matches the connections of a binary protocol carried as
+// base64 over a text channel, one frame per line, such as the clients of
+// restricted networks: the first line must be valid base64, padded or not, and
+// the inner matcher is applied to the decoded stream. The handler decodes it
+// too when the route has the Base64FramedConn transform:
+//
+//	l.MatchWithTransform("mqtt-b64", listener.Base64FramedConn,
+//		listener.MatchBase64Framed(listener.MatchMagic(2, []byte("\x00\x04MQTT"))))
+func MatchBase64Framed(inner Matcher) Matcher {
+	return func(r io.Reader) bool {
+		lines := &base64Reader{line: func() ([]byte, error) {
+			if line, ok := readLine(r, maxBase64Line); ok {
+				return line, nil
+			}
+			return nil, errBase64Line
+		}}
+
+		line, err := lines.line()
+		if err != nil {
+			return false
+		}
+		if lines.pending, err = decodeBase64(line); err != nil || len(lines.pending) == 0 {
+			return false
+		}
+		return inner(lines)
+	}
+}
+
+// Base64FramedConn is the transform of the routes matching with
+// MatchBase64Framed: the handler reads the decoded stream of the connection,
+// and what it writes is sent as base64, one line per write.
+func Base64FramedConn(c net.Conn) net.Conn {
+	buffered := bufio.NewReaderSize(c, 4096)
+	return &base64Conn{
+		Conn: c,
+		reader: base64Reader{line: func() ([]byte, error) {
+			return readBase64Line(buffered)
+		}},
+	}
+}
+
+// base64Conn is a connection carrying its stream as base64 lines.
+type base64Conn struct {
+	net.Conn
+	reader base64Reader
+}
+
+// Read reads the decoded stream.
+func (c *base64Conn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// Write writes the bytes as a base64 line.
+func (c *base64Conn) Write(p []byte) (int, error) {
+	line := make([]byte, base64.StdEncoding.EncodedLen(len(p))+1)
+	base64.StdEncoding.Encode(line, p)
+	line[len(line)-1] = '\n'
+	if _, err := c.Conn.Write(line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// NetConn returns the connection carrying the base64 lines.
+func (c *base64Conn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// base64Reader decodes a stream of base64 lines.
+type base64Reader struct {
+	line    func() ([]byte, error)
+	pending []byte // The decoded bytes not read yet.
+}
+
+// Read reads the bytes decoded from the lines, skipping the empty ones.
+func (r *base64Reader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		line, err := r.line()
+		if err != nil {
+			return 0, err
+		}
+		if r.pending, err = decodeBase64(line); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// readBase64Line reads a line, without its line ending, of up to
+// maxBase64Line bytes.
+func readBase64Line(r *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > maxBase64Line+2 {
+			return nil, errBase64Line
+		}
+
+		switch err {
+		case nil:
+			line = line[:len(line)-1]
+			if n := len(line); n > 0 && line[n-1] == '\r' {
+				line = line[:n-1]
+			}
+			return line, nil
+		case bufio.ErrBufferFull:
+			continue
+		default:
+			if err == io.EOF && len(line) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+}
+
+// decodeBase64 decodes a line of base64, padded or not.
+func decodeBase64(line []byte) ([]byte, error) {
+	encoding := base64.StdEncoding
+	if len(line)%4 != 0 {
+		encoding = base64.RawStdEncoding
+	}
+
+	decoded := make([]byte, encoding.DecodedLen(len(line)))
+	n, err := encoding.Decode(decoded, line)
+	if err != nil {
+		return nil, errBase64Line
+	}
+	return decoded[:n], nil
+}
@@ -0,0 +1,79 @@
+package listener
+
+import (
+	"encoding/base64"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mqttConnect is an MQTT 3.1.1 CONNECT packet with an empty client ID.
+const mqttConnect = "\x10\x0c\x00\x04MQTT\x04\x02\x00\x3c\x00\x00"
+
+func TestMatchBase64Framed(t *testing.T) {
+	encode := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	raw := func(s string) string { return base64.RawStdEncoding.EncodeToString([]byte(s)) }
+	testMatcher(t, MatchBase64Framed(MatchMagic(2, []byte("\x00\x04MQTT"))), []matcherCase{
+		{"padded", encode(mqttConnect) + "\n", true},
+		{"unpadded crlf", raw(mqttConnect) + "\r\n", true},
+		{"split across lines", encode(mqttConnect[:3]) + "\n" + encode(mqttConnect[3:]) + "\n", true},
+		{"other protocol", encode("GET / HTTP/1.1\r\n") + "\n", false},
+		{"raw mqtt", mqttConnect + "\n", false},
+		{"not base64", "GET / HTTP/1.1\r\n", false},
+		{"empty line", "\n" + encode(mqttConnect) + "\n", false},
+		{"no line ending", encode(mqttConnect), false},
+	})
+}
+
+func TestBase64FramedRoute(t *testing.T) {
+	m := newTestMux(t)
+	mqtt := m.MatchWithTransform("mqtt-b64", Base64FramedConn,
+		MatchBase64Framed(MatchMagic(2, []byte("\x00\x04MQTT"))))
+	fallback := m.Route("fallback", MatchAny())
+	m.serve()
+
+	const ping = "\xc0\x00"
+	lines := base64.StdEncoding.EncodeToString([]byte(mqttConnect)) + "\n" +
+		base64.StdEncoding.EncodeToString([]byte(ping)) + "\n"
+	client := m.dial(lines)
+	c := accept(t, mqtt)
+	if got := readN(t, c, len(mqttConnect)+len(ping)); got != mqttConnect+ping {
+		t.Errorf("handler read %q, want the decoded packets", got)
+	}
+
+	// The CONNACK written by the handler goes back as a base64 line
+	go func() { _, _ = c.Write([]byte("\x20\x02\x00\x00")) }()
+	want := base64.StdEncoding.EncodeToString([]byte("\x20\x02\x00\x00")) + "\n"
+	if got := readN(t, client, len(want)); got != want {
+		t.Errorf("client read %q, want %q", got, want)
+	}
+
+	// A raw packet would keep the matcher waiting for the line ending
+	raw := mqttConnect + "\n"
+	m.dial(raw)
+	if got := readN(t, accept(t, fallback), len(raw)); got != raw {
+		t.Errorf("fallback handler read %q, want the raw packet", got)
+	}
+	expectNoAccept(t, mqtt, 20*time.Millisecond)
+}
+
+func TestBase64FramedConnErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  error
+	}{
+		{"invalid line", "not base64!\n", errBase64Line},
+		{"truncated line", "aGVsbG8", io.ErrUnexpectedEOF},
+		{"end of stream", "", io.EOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Base64FramedConn(readerConn{strings.NewReader(tt.input)})
+			if _, err := c.Read(make([]byte, 16)); err != tt.want {
+				t.Errorf("Read() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package listener
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// ErrChaosDrop is the error of the reads and writes of the connections dropped
+// by the chaos settings.
+var ErrChaosDrop = errors.New("mux: connection dropped by chaos")
+
+// ChaosConfig represents the faults injected into the served connections, to
+// check how their handlers cope with slow or failing clients.
+type ChaosConfig struct {
+	ReadLatency     time.Duration // The delay added to every read.
+	WriteLatency    time.Duration // The delay added to every write.
+	DropProbability float64       // The probability of every read or write to drop the connection.
+}
+
+// SetChaos makes the listener inject faults into the connections it serves
+// from then on: their reads and writes are delayed and, at random, the
+// connection gets closed instead, failing with ErrChaosDrop. It is meant for
+// testing and staging environments only. A zero config disables it.
+func (m *Listener) SetChaos(config ChaosConfig) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.chaos = nil
+	if config != (ChaosConfig{}) {
+		m.chaos = &config
+	}
+}
+
+// chaosConn is a served connection whose reads and writes get faults
+// injected.
+type chaosConn struct {
+	net.Conn
+	config *ChaosConfig
+}
+
+// Read reads from the connection after the read latency, unless it drops it.
+func (c *chaosConn) Read(p []byte) (int, error) {
+	time.Sleep(c.config.ReadLatency)
+	if c.drop() {
+		return 0, ErrChaosDrop
+	}
+	return c.Conn.Read(p)
+}
+
+// Write writes to the connection after the write latency, unless it drops it.
+func (c *chaosConn) Write(p []byte) (int, error) {
+	time.Sleep(c.config.WriteLatency)
+	if c.drop() {
+		return 0, ErrChaosDrop
+	}
+	return c.Conn.Write(p)
+}
+
+// NetConn returns the connection the faults are injected into.
+func (c *chaosConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// drop closes the connection at random, as likely as the drop probability.
+func (c *chaosConn) drop() bool {
+	if c.config.DropProbability <= 0 || rand.Float64() >= c.config.DropProbability {
+		return false
+	}
+	_ = c.Conn.Close()
+	return true
+}
@@ -0,0 +1,57 @@
+package listener
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChaosLatency(t *testing.T) {
+	m := newTestMux(t)
+	m.SetChaos(ChaosConfig{ReadLatency: 20 * time.Millisecond, WriteLatency: 30 * time.Millisecond})
+	r := m.Match(MatchAny())
+	m.serve()
+
+	client := m.dial("x")
+	c := accept(t, r)
+	start := time.Now()
+	readN(t, c, 1)
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("read took %v, want the 20ms read latency", d)
+	}
+
+	go func() { _, _ = client.Read(make([]byte, 1)) }()
+	start = time.Now()
+	if _, err := c.Write([]byte("y")); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Errorf("write took %v, want the 30ms write latency", d)
+	}
+}
+
+func TestChaosDrops(t *testing.T) {
+	const n, probability = 2000, 0.3
+	c := &chaosConn{Conn: readerConn{strings.NewReader(strings.Repeat("x", n))}, config: &ChaosConfig{DropProbability: probability}}
+	drops := 0
+	for i := 0; i < n; i++ {
+		if _, err := c.Read(make([]byte, 1)); err == ErrChaosDrop {
+			drops++
+		}
+	}
+	if rate := float64(drops) / n; rate < probability-0.05 || rate > probability+0.05 {
+		t.Errorf("%d reads of %d dropped, want about %v", drops, n, probability)
+	}
+
+	// A served connection is closed when dropped
+	m := newTestMux(t)
+	m.SetChaos(ChaosConfig{DropProbability: 1})
+	r := m.Match(MatchAny())
+	m.serve()
+
+	client := m.dial("x")
+	if _, err := accept(t, r).Read(make([]byte, 1)); err != ErrChaosDrop {
+		t.Errorf("Read() = %v, want ErrChaosDrop", err)
+	}
+	expectClosed(t, client)
+}
@@ -0,0 +1,136 @@
+package listener
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"time"
+)
+
+// errTLSHandshake is the error a connection is rejected with when the TLS
+// handshake of a client certificate matcher fails.
+type errTLSHandshake struct {
+	err error
+}
+
+func (e errTLSHandshake) Error() string   { return "mux: tls handshake failed: " + e.err.Error() }
+func (e errTLSHandshake) Temporary() bool { return true }
+func (e errTLSHandshake) Timeout() bool   { return false }
+
+// MatchClientCert terminates TLS while matching and matches the connections
+// whose verified client certificate satisfies the predicate, so they can be
+// routed by client identity, for instance by organizational unit or SAN. The
+// predicate is called with nil when the client sends no certificate, which the
+// config allows with tls.VerifyClientCertIfGiven, so such clients can be routed
+// elsewhere.
+//
+// The handshake is performed once per connection, by the first of these
+// matchers, which should then share the config. From then on, whichever route
+// matches gets the terminated *tls.Conn instead of the raw connection, as the
+// handshake can not be replayed. A failed handshake rejects the connection,
+// and a predicate which panics does not match.
+//
+// The handshake takes a slot of the concurrent handshake limit set with
+// SetMaxConcurrentHandshakes while it runs, and the connection is rejected with
+// ErrHandshakeLimit if it does not get one, as the handshake policy says.
+func MatchClientCert(config *tls.Config, predicate func(*x509.Certificate) bool) Matcher {
+	var guard hookGuard
+	return func(r io.Reader) bool {
+		hs, ok := handshakeTLS(r, config)
+		if !ok {
+			return false
+		}
+
+		var cert *x509.Certificate
+		if certs := hs.conn.ConnectionState().PeerCertificates; len(certs) > 0 {
+			cert = certs[0]
+		}
+		matched := false
+		guard.call("client certificate predicate", func() { matched = predicate(cert) })
+		return matched
+	}
+}
+
+// tlsHandshake is the TLS handshake performed while sniffing a connection.
+type tlsHandshake struct {
+	conn      *tls.Conn
+	transport *handshakeConn
+}
+
+// handshakeTLS returns the TLS handshake of the connection sniffed through r,
+// performing it if no matcher did it yet.
+func handshakeTLS(r io.Reader, config *tls.Config) (*tlsHandshake, bool) {
+	sr, ok := r.(*sniffReader)
+	if !ok || sr.state == nil || sr.state.conn == nil {
+		return nil, false
+	}
+	if sr.state.handshake != nil {
+		return sr.state.handshake, true
+	}
+
+	// Make sure it's TLS before answering anything
+	header := make([]byte, recordHeaderLength)
+	if _, err := io.ReadFull(r, header); err != nil || header[0] != recordTypeHandshake || header[1] != 0x03 {
+		return nil, false
+	}
+
+	c := sr.state.conn
+	if slots := sr.config.handshakes; slots != nil && !c.exempt {
+		taken := false
+		if sr.config.tlsPolicy == HandshakeReject {
+			taken = slots.acquire(1)
+		} else {
+			taken = slots.wait(1, sr.state.deadline, sr.config.closing)
+		}
+		if !taken {
+			reject(r, ErrHandshakeLimit)
+			return nil, false
+		}
+		defer slots.release(1)
+	}
+
+	transport := &handshakeConn{
+		Conn:  c,
+		sniff: io.MultiReader(bytes.NewReader(header), r),
+	}
+	conn := tls.Server(transport, config)
+
+	_ = c.SetWriteDeadline(sr.state.deadline)
+	err := conn.Handshake()
+	_ = c.SetWriteDeadline(time.Time{})
+	if err != nil {
+		reject(r, errTLSHandshake{err: err})
+		return nil, false
+	}
+
+	sr.state.handshake = &tlsHandshake{conn: conn, transport: transport}
+	return sr.state.handshake, true
+}
+
+// handshakeConn is the transport of a TLS connection terminated while sniffing.
+// It reads the sniffed bytes, counting them, until the connection is
+// dispatched, and then the connection itself.
+type handshakeConn struct {
+	*Conn
+	sniff io.Reader
+	read  int // The number of sniffed bytes read.
+}
+
+// Read reads from the sniffed bytes, or from the connection once matched.
+func (c *handshakeConn) Read(p []byte) (int, error) {
+	if c.sniff == nil {
+		return c.Conn.Read(p)
+	}
+
+	n, err := c.sniff.Read(p)
+	c.read += n
+	return n, err
+}
+
+// dispatched switches the transport to the connection, whose sniffed bytes
+// are dropped as the handshake already consumed them.
+func (h *tlsHandshake) dispatched(skipped int) {
+	h.transport.Conn.discard(skipped + h.transport.read)
+	h.transport.sniff = nil
+}
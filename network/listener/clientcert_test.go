@@ -0,0 +1,89 @@
+package listener
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func TestMatchClientCert(t *testing.T) {
+	ca := newTestCA(t)
+	config := &tls.Config{
+		Certificates: []tls.Certificate{ca.issue("rtms.example.com", true)},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    ca.pool(),
+	}
+	commonName := func(name string) func(*x509.Certificate) bool {
+		return func(cert *x509.Certificate) bool { return cert != nil && cert.Subject.CommonName == name }
+	}
+
+	m := newTestMux(t)
+	routes := map[string]*Route{
+		"ingest":    m.Route("ingest", MatchClientCert(config, commonName("ingest"))),
+		"admin":     m.Route("admin", MatchClientCert(config, commonName("admin"))),
+		"anonymous": m.Route("anonymous", MatchClientCert(config, func(cert *x509.Certificate) bool { return cert == nil })),
+	}
+	m.serve()
+
+	for _, client := range []string{"admin", "ingest", ""} {
+		route := client
+		clientConfig := &tls.Config{ServerName: "rtms.example.com", RootCAs: ca.pool()}
+		if client != "" {
+			clientConfig.Certificates = []tls.Certificate{ca.issue(client, false)}
+		} else {
+			route = "anonymous"
+		}
+		handshake := m.dialTLS(clientConfig)
+
+		tc, ok := accept(t, routes[route]).(*tls.Conn)
+		if !ok {
+			t.Fatalf("%s: matched connection is not a *tls.Conn", route)
+		}
+		if err := <-handshake; err != nil {
+			t.Fatalf("%s: client handshake: %v", route, err)
+		}
+		if certs := tc.ConnectionState().PeerCertificates; (len(certs) > 0) != (client != "") {
+			t.Errorf("%s: %d peer certificates", route, len(certs))
+		}
+	}
+}
+
+func TestClientCertHandshakeWaitInterruptedByClose(t *testing.T) {
+	ca := newTestCA(t)
+	var g gatedHandshakes
+	config := g.config(ca.issue("rtms.example.com", true))
+	defer close(g.gate)
+	m := newTestMux(t)
+	errs := make(chan error, 4)
+	m.HandleError(func(err error) bool {
+		errs <- err
+		return true
+	})
+	m.SetMaxConcurrentHandshakes(1)
+	m.SetHandshakePolicy(HandshakeWait)
+	m.Route("mtls", MatchClientCert(config, func(*x509.Certificate) bool { return true }))
+	m.serve()
+
+	// The second handshake waits for the slot of the first one, without a
+	// read deadline
+	client := &tls.Config{RootCAs: ca.pool(), ServerName: "rtms.example.com"}
+	m.dialTLS(client)
+	waitFor(t, "the slot to be taken", func() bool { running, _ := g.counts(); return running == 1 })
+	m.dialTLS(client)
+	waitFor(t, "the second match", func() bool { return len(m.MatchingConnections()) == 2 })
+
+	// The accept loop reports the close, and the waiting match gives up
+	_ = m.Close()
+	timeout := time.After(testTimeout)
+	for {
+		select {
+		case err := <-errs:
+			if err == ErrHandshakeLimit {
+				return
+			}
+		case <-timeout:
+			t.Fatal("handshake still waiting for a slot after Close")
+		}
+	}
+}
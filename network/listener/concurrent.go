@@ -0,0 +1,184 @@
+package listener
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// errMatchStopped is returned to the matchers still running concurrently once
+// the match is decided.
+var errMatchStopped = errors.New("mux: match decided")
+
+// SetPeekOnly declares that the matchers of the route only read the bytes they
+// are given, without side effects: no writes, deadlines, rejections nor state
+// kept between calls. The matchers of consecutive peek-only routes are then
+// run concurrently, each over its own view of the sniffed bytes, so a slow
+// matcher waiting for more bytes does not delay the others, and the connection
+// goes to the first one in registration order which matches, as if they were
+// run in turn. Matchers which are not pure must not be declared so, as their
+// side effects would race. Peek sniffing runs them in turn.
+func (r *Route) SetPeekOnly(enabled bool) {
+	r.Lock()
+	defer r.Unlock()
+	r.peekOnly = enabled
+}
+
+// isPeekOnly returns whether the matchers of the route are declared pure.
+func (r *Route) isPeekOnly() bool {
+	r.Lock()
+	defer r.Unlock()
+	return r.peekOnly
+}
+
+// peekOnlyBatch returns the number of matchers at the start of the list which
+// can run concurrently.
+func peekOnlyBatch(matchers []processor) int {
+	n := 0
+	for n < len(matchers) && matchers[n].listen.isPeekOnly() {
+		n++
+	}
+	return n
+}
+
+// concurrentResult is the outcome of a matcher run concurrently.
+type concurrentResult struct {
+	i       int
+	matched bool
+	lead    *leadingSkipper
+}
+
+// matchConcurrently runs the matchers concurrently over views of the sniffed
+// bytes of the connection, and returns the index of the first one matching,
+// once all the previous ones did not, or -1 if none did, along with the
+// leading bytes it skipped. The matchers still running are interrupted and
+// waited for, so the connection is read by nothing else on return.
+func (m *Listener) matchConcurrently(c *Conn, matchers []processor, config *sniffConfig, skip, traced bool, deadline time.Time) (int, *leadingSkipper) {
+	views := &sniffViews{source: c.startSniffing()}
+	views.cond = sync.NewCond(&views.mu)
+
+	results := make(chan concurrentResult, len(matchers))
+	for i, sl := range matchers {
+		go func(i int, matcher Matcher, name string) {
+			var r io.Reader = &sniffView{views: views}
+			var counter *countingReader
+			if traced {
+				counter = &countingReader{Reader: r}
+				r = counter
+			}
+			var lead *leadingSkipper
+			if skip {
+				lead = &leadingSkipper{source: r}
+				r = lead
+			}
+
+			state := &sniffState{deadline: deadline}
+			matched := matcher(&sniffReader{Reader: r, config: config, state: state}) && state.rejected == nil
+			if counter != nil {
+				traceMatch(c, name, counter.n, matched, state)
+			}
+			results <- concurrentResult{i: i, matched: matched, lead: lead}
+		}(i, sl.matcher, sl.name)
+	}
+
+	done := make([]*concurrentResult, len(matchers))
+	winner, pending := -1, len(matchers)
+	var lead *leadingSkipper
+	for decided := false; !decided; {
+		res := <-results
+		done[res.i] = &res
+		pending--
+
+		// The first match wins once all the matchers before it are done
+		decided = true
+		for _, d := range done {
+			if d == nil {
+				decided = false
+				break
+			}
+			if d.matched {
+				winner, lead = d.i, d.lead
+				break
+			}
+		}
+	}
+
+	views.stop(c)
+	for ; pending > 0; pending-- {
+		<-results
+	}
+	_ = c.Conn.SetReadDeadline(deadline)
+	return winner, lead
+}
+
+// sniffViews shares the sniffed bytes of a connection between concurrent
+// readers, reading more of them from the source, one reader at a time, as the
+// readers need them.
+type sniffViews struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	source  io.Reader
+	buffer  []byte
+	err     error
+	reading bool // Whether a reader is reading from the source.
+	stopped bool
+}
+
+// stop makes the views fail their reads, interrupting the one from the source
+// in progress, if any, with an expired read deadline.
+func (v *sniffViews) stop(c *Conn) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.stopped = true
+	if v.reading {
+		_ = c.Conn.SetReadDeadline(time.Now())
+	}
+	v.cond.Broadcast()
+}
+
+// sniffView is a reader of the shared sniffed bytes.
+type sniffView struct {
+	views  *sniffViews
+	offset int
+}
+
+// Read reads the next sniffed bytes, reading them from the source if no other
+// view did yet.
+func (r *sniffView) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	v := r.views
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for r.offset >= len(v.buffer) {
+		switch {
+		case v.stopped:
+			return 0, errMatchStopped
+		case v.err != nil:
+			return 0, v.err
+		case v.reading:
+			v.cond.Wait()
+			continue
+		}
+
+		v.reading = true
+		v.mu.Unlock()
+		b := make([]byte, len(p))
+		n, err := v.source.Read(b)
+		v.mu.Lock()
+		v.reading = false
+		v.buffer = append(v.buffer, b[:n]...)
+		if err != nil && !v.stopped {
+			v.err = err
+		}
+		v.cond.Broadcast()
+	}
+
+	n := copy(p, v.buffer[r.offset:])
+	r.offset += n
+	return n, nil
+}
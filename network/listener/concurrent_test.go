@@ -0,0 +1,97 @@
+package listener
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// slowMatcher is a pure matcher taking the delay to match the prefix, like a
+// matcher parsing a large handshake.
+func slowMatcher(d time.Duration, prefix string) Matcher {
+	match := MatchPrefix(prefix)
+	return func(r io.Reader) bool {
+		time.Sleep(d)
+		return match(r)
+	}
+}
+
+func TestPeekOnlyRouting(t *testing.T) {
+	m := newTestMux(t)
+	routes := map[string]*Route{
+		"ab":   m.Route("ab", slowMatcher(10*time.Millisecond, "AB")),
+		"a":    m.Route("a", MatchPrefix("A")),
+		"http": m.Route("http", MatchHTTP()),
+	}
+	for _, r := range routes {
+		r.SetPeekOnly(true)
+	}
+	routes["fallback"] = m.Route("fallback", MatchAny())
+	m.serve()
+
+	// The first route in registration order wins, as if run in turn
+	tests := []struct {
+		data  string
+		route string
+	}{
+		{"ABC", "ab"},
+		{"AXY", "a"},
+		{"GET / HTTP/1.1\r\nHost: a\r\n\r\n", "http"},
+		{"zzz", "fallback"},
+	}
+	for _, tt := range tests {
+		m.dial(tt.data)
+		if got := readN(t, accept(t, routes[tt.route]), len(tt.data)); got != tt.data {
+			t.Errorf("%s handler read %q, want %q", tt.route, got, tt.data)
+		}
+	}
+}
+
+func TestPeekOnlyInterrupted(t *testing.T) {
+	m := newTestMux(t)
+	short := m.Route("short", MatchPrefix("A"))
+	short.SetPeekOnly(true)
+	long := m.Route("long", MatchPrefix("AAAA"))
+	long.SetPeekOnly(true)
+	m.serve()
+
+	// The later matcher, still waiting for bytes, does not hold the match up,
+	// nor lose the bytes sent once it is interrupted
+	c := m.dial("A")
+	conn := accept(t, short)
+	go func() { _, _ = c.Write([]byte("BC")) }()
+	if got := readN(t, conn, 3); got != "ABC" {
+		t.Errorf("handler read %q, want ABC", got)
+	}
+	expectNoAccept(t, long, 20*time.Millisecond)
+}
+
+// benchmarkMatch routes connections to the last of four slow matchers, run
+// concurrently if they are peek-only.
+func benchmarkMatch(b *testing.B, peekOnly bool) {
+	m := newTestMux(b)
+	var last *Route
+	for _, prefix := range []string{"A", "B", "C", "D"} {
+		last = m.Route(prefix, slowMatcher(200*time.Microsecond, prefix))
+		last.SetPeekOnly(peekOnly)
+	}
+	m.serve()
+
+	buf := make([]byte, 1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c := m.dial("D")
+		conn, err := last.Accept()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			b.Fatal(err)
+		}
+		_ = conn.Close()
+		_ = c.Close()
+	}
+}
+
+func BenchmarkMatchConcurrent(b *testing.B) { benchmarkMatch(b, true) }
+func BenchmarkMatchSequential(b *testing.B) { benchmarkMatch(b, false) }
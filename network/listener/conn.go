@@ -0,0 +1,306 @@
+package listener
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The counter used to generate the connection identifiers.
+var nextConnID uint64
+
+// Conn wraps a net.Conn and provides transparent sniffing of connection data.
+type Conn struct {
+	net.Conn
+	buffer   sniffer
+	id       string
+	accepted time.Time
+	route    string
+	bytesIn  int64
+	bytesOut int64
+	active   int64 // When the connection was last read or written, in Unix nanoseconds.
+	once     sync.Once
+	mu       sync.Mutex
+	onClose  []func()
+	meta     map[string]interface{}
+	limiter  *readLimiter // The read rate limit, once served.
+	sampled  bool         // Whether the accept and match of the connection are logged.
+	owner    *Route       // The route the connection was dispatched to.
+	exempt   bool         // Whether the connection is exempt from the limits.
+	deadline *time.Timer  // The handle deadline of the route, if any.
+	handoff  []func()     // Run once the route gives the connection up.
+}
+
+// NewConn creates a new sniffed connection.
+func newConn(c net.Conn) *Conn {
+	return &Conn{
+		Conn:     c,
+		buffer:   sniffer{source: c},
+		id:       strconv.FormatUint(atomic.AddUint64(&nextConnID, 1), 10),
+		accepted: time.Now(),
+	}
+}
+
+// ID returns the identifier of the connection, unique within the process.
+func (m *Conn) ID() string {
+	return m.id
+}
+
+// Route returns the name of the route the connection was matched for.
+func (m *Conn) Route() string {
+	return m.route
+}
+
+// SetMeta attaches a value to the connection under the key, for example the
+// identity or the tenant the handler authenticated.
+func (m *Conn) SetMeta(key string, value interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.meta == nil {
+		m.meta = make(map[string]interface{})
+	}
+	m.meta[key] = value
+}
+
+// Meta returns the value attached to the connection under the key.
+func (m *Conn) Meta(key string) (interface{}, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, ok := m.meta[key]
+	return value, ok
+}
+
+// Read reads the block of data from the underlying buffer.
+func (m *Conn) Read(p []byte) (int, error) {
+	if m.limiter != nil {
+		p = m.limiter.limit(p)
+	}
+
+	n, err := m.buffer.Read(p)
+	atomic.AddInt64(&m.bytesIn, int64(n))
+	atomic.StoreInt64(&m.active, time.Now().UnixNano())
+	if m.limiter != nil {
+		m.limiter.wait(n)
+	}
+	return n, err
+}
+
+// Write writes the block of data to the underlying connection.
+func (m *Conn) Write(p []byte) (int, error) {
+	n, err := m.Conn.Write(p)
+	atomic.AddInt64(&m.bytesOut, int64(n))
+	atomic.StoreInt64(&m.active, time.Now().UnixNano())
+	return n, err
+}
+
+// lastActivity returns when the connection was last read or written, or
+// accepted if it was not yet.
+func (m *Conn) lastActivity() time.Time {
+	if active := atomic.LoadInt64(&m.active); active != 0 {
+		return time.Unix(0, active)
+	}
+	return m.accepted
+}
+
+// Close closes the connection and runs the close notifications once.
+func (m *Conn) Close() error {
+	err := m.Conn.Close()
+	m.once.Do(func() {
+		m.mu.Lock()
+		fns := m.onClose
+		m.mu.Unlock()
+		for _, fn := range fns {
+			fn()
+		}
+		m.handOff()
+	})
+	return err
+}
+
+// notifyClose registers a function to run when the connection gets closed.
+func (m *Conn) notifyClose(fn func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onClose = append(m.onClose, fn)
+}
+
+// notifyHandoff registers a function to run when the route the connection was
+// dispatched to gives it up, as it gets closed or handed back to a listener.
+func (m *Conn) notifyHandoff(fn func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handoff = append(m.handoff, fn)
+}
+
+// setHandleDeadline sets the timer of the handle deadline of the route the
+// connection was dispatched to.
+func (m *Conn) setHandleDeadline(timer *time.Timer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deadline = timer
+}
+
+// handOff detaches the connection from its route: the handle deadline of the
+// route is stopped and the functions registered with notifyHandoff are run.
+func (m *Conn) handOff() {
+	m.mu.Lock()
+	timer, fns := m.deadline, m.handoff
+	m.deadline, m.handoff = nil, nil
+	m.mu.Unlock()
+
+	if timer != nil {
+		timer.Stop()
+	}
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+func (m *Conn) startSniffing() io.Reader {
+	m.buffer.reset(true)
+	return &m.buffer
+}
+
+func (m *Conn) doneSniffing() {
+	m.buffer.reset(false)
+}
+
+// discard drops the next n bytes of the connection, from the sniffed bytes if
+// they were buffered or from the socket if they were only peeked.
+func (m *Conn) discard(n int) {
+	if buffered := m.buffer.bufferSize - m.buffer.bufferRead; buffered < n {
+		_, _ = io.CopyN(ioutil.Discard, m.Conn, int64(n-buffered))
+	}
+	m.buffer.discard(n)
+}
+
+// ------------------------------------------------------------------------------------
+
+// Sniffer represents a io.Reader which can peek incoming bytes and reset back to normal.
+type sniffer struct {
+	source     io.Reader
+	buffer     bytes.Buffer
+	bufferRead int
+	bufferSize int
+	sniffing   bool
+	lastErr    error
+	budget     *sniffReservation
+}
+
+// Read reads data from the buffer.
+func (s *sniffer) Read(p []byte) (int, error) {
+	if s.bufferSize > s.bufferRead {
+		bn := copy(p, s.buffer.Bytes()[s.bufferRead:s.bufferSize])
+		s.bufferRead += bn
+		return bn, s.lastErr
+	} else if !s.sniffing && s.buffer.Cap() != 0 {
+		s.buffer = bytes.Buffer{}
+	}
+
+	if s.sniffing && s.budget != nil && !s.budget.grow(s.buffer.Len()+len(p)) {
+		return 0, errSniffMemory
+	}
+
+	sn, sErr := s.source.Read(p)
+	if sn > 0 && s.sniffing {
+		s.lastErr = sErr
+		if wn, wErr := s.buffer.Write(p[:sn]); wErr != nil {
+			return wn, wErr
+		}
+	}
+	return sn, sErr
+}
+
+// Reset resets the buffer.
+func (s *sniffer) reset(snif bool) {
+	s.sniffing = snif
+	s.bufferRead = 0
+	s.bufferSize = s.buffer.Len()
+}
+
+// rebase drops the replayed bytes the handler already read, so sniffing starts
+// over from the first byte it did not read.
+func (s *sniffer) rebase() {
+	var rest []byte
+	if s.bufferRead < s.bufferSize && s.bufferSize <= s.buffer.Len() {
+		rest = append(rest, s.buffer.Bytes()[s.bufferRead:s.bufferSize]...)
+	}
+	s.buffer = *bytes.NewBuffer(rest)
+	s.bufferRead, s.bufferSize = 0, len(rest)
+	s.lastErr = nil
+}
+
+// sniffed returns the bytes buffered so far.
+func (s *sniffer) sniffed() []byte {
+	return s.buffer.Bytes()
+}
+
+// Discard drops the next n buffered bytes so they are not replayed.
+func (s *sniffer) discard(n int) {
+	s.bufferRead += n
+	if s.bufferRead > s.bufferSize {
+		s.bufferRead = s.bufferSize
+	}
+}
+
+// ------------------------------------------------------------------------------------
+
+// The UTF-8 encoded byte order mark some clients prepend to their requests.
+var byteOrderMark = []byte{0xEF, 0xBB, 0xBF}
+
+// leadingSkipper is a reader which drops a leading byte order mark and any
+// whitespace before the first byte it returns.
+type leadingSkipper struct {
+	source  io.Reader
+	skipped int    // The number of bytes dropped so far.
+	pending []byte // The bytes read ahead which need to be returned.
+	done    bool
+}
+
+// Read reads data from the source once the leading bytes have been skipped.
+func (s *leadingSkipper) Read(p []byte) (int, error) {
+	if len(s.pending) > 0 {
+		n := copy(p, s.pending)
+		s.pending = s.pending[n:]
+		return n, nil
+	}
+
+	if s.done {
+		return s.source.Read(p)
+	}
+
+	b := make([]byte, 1)
+	var bom []byte
+	for {
+		if _, err := io.ReadFull(s.source, b); err != nil {
+			return 0, err
+		}
+
+		// A byte order mark is only expected at the very beginning
+		if s.skipped == 0 && len(bom) < len(byteOrderMark) && b[0] == byteOrderMark[len(bom)] {
+			if bom = append(bom, b[0]); len(bom) == len(byteOrderMark) {
+				s.skipped += len(bom)
+				bom = nil
+			}
+			continue
+		}
+
+		if len(bom) == 0 {
+			switch b[0] {
+			case ' ', '\t', '\r', '\n':
+				s.skipped++
+				continue
+			}
+		}
+
+		// This is the first byte of actual data
+		s.done = true
+		s.pending = append(bom, b[0])
+		return s.Read(p)
+	}
+}
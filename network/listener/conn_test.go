@@ -0,0 +1,93 @@
+package listener
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestConnMeta(t *testing.T) {
+	m := newTestMux(t)
+	r := m.Match(MatchAny())
+	m.serve()
+
+	m.dial("a")
+	m.dial("b")
+	a, ok := accept(t, r).(*Conn)
+	if !ok {
+		t.Fatal("accepted connection is not a *Conn")
+	}
+	b := accept(t, r).(*Conn)
+
+	a.SetMeta("tenant", "acme")
+	if v, ok := a.Meta("tenant"); !ok || v != "acme" {
+		t.Errorf("Meta(tenant) = %v, %v, want acme", v, ok)
+	}
+	if v, ok := b.Meta("tenant"); ok {
+		t.Errorf("Meta(tenant) of the other connection = %v, want none", v)
+	}
+
+	// The metadata is safe for concurrent use
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := strconv.Itoa(i)
+			b.SetMeta(key, i)
+			if v, ok := b.Meta(key); !ok || v != i {
+				t.Errorf("Meta(%s) = %v, %v, want %d", key, v, ok, i)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestSessionIDExtractor(t *testing.T) {
+	m := newTestMux(t)
+	m.SetSessionIDExtractor(func(sniffed []byte) (string, bool) {
+		const prefix = "RESUME "
+		s := string(sniffed)
+		if !strings.HasPrefix(s, prefix) || !strings.HasSuffix(s, "\n") {
+			return "", false
+		}
+		return strings.TrimSuffix(s[len(prefix):], "\n"), true
+	})
+	r := m.Match(MatchFirstLine(func(line string) bool { return strings.HasPrefix(line, "RESUME ") || line == "HELLO" }))
+	m.serve()
+
+	m.dial("RESUME s-1234\n")
+	c := accept(t, r).(*Conn)
+	if v, ok := c.Meta(MetaSessionID); !ok || v != "s-1234" {
+		t.Errorf("Meta(SessionID) = %v, %v, want s-1234", v, ok)
+	}
+	if got := readN(t, c, 14); got != "RESUME s-1234\n" {
+		t.Errorf("handler read %q, want the resume frame", got)
+	}
+
+	m.dial("HELLO\n")
+	if v, ok := accept(t, r).(*Conn).Meta(MetaSessionID); ok {
+		t.Errorf("Meta(SessionID) = %v without a token, want none", v)
+	}
+}
+
+func TestCompressionDetector(t *testing.T) {
+	m := newTestMux(t)
+	m.SetCompressionDetector(func(sniffed []byte) (string, bool) {
+		ok := strings.Contains(string(sniffed), "\r\nSec-WebSocket-Extensions: permessage-deflate")
+		return "permessage-deflate", ok
+	})
+	r := m.Match(MatchWebSocket())
+	m.serve()
+
+	m.dial(browserRequest)
+	if v, ok := accept(t, r).(*Conn).Meta(MetaCompression); !ok || v != "permessage-deflate" {
+		t.Errorf("Meta(Compression) = %v, %v, want permessage-deflate", v, ok)
+	}
+
+	m.dial(httpRequest("Host: a", "Upgrade: websocket"))
+	if v, ok := accept(t, r).(*Conn).Meta(MetaCompression); ok {
+		t.Errorf("Meta(Compression) = %v without the extension, want none", v)
+	}
+}
@@ -0,0 +1,72 @@
+package listener
+
+import (
+	"sync"
+	"time"
+)
+
+// deadline signals when a read or write deadline of an in-process connection
+// expires, like the deadlines of the connections of net.Pipe.
+type deadline struct {
+	sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{} // Closed when the deadline expires.
+}
+
+// newDeadline creates a deadline which is not set.
+func newDeadline() *deadline {
+	return &deadline{cancel: make(chan struct{})}
+}
+
+// set sets the point in time when the deadline expires. A zero time clears the
+// deadline and a time in the past expires it immediately.
+func (d *deadline) set(t time.Time) {
+	d.Lock()
+	defer d.Unlock()
+
+	// Wait for the timer callback to finish if it's already running
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel
+	}
+	d.timer = nil
+
+	expired := isClosed(d.cancel)
+	if t.IsZero() {
+		if expired {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+
+	if dur := time.Until(t); dur > 0 {
+		if expired {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() {
+			close(cancel)
+		})
+		return
+	}
+
+	if !expired {
+		close(d.cancel)
+	}
+}
+
+// wait returns a channel which is closed once the deadline expires.
+func (d *deadline) wait() <-chan struct{} {
+	d.Lock()
+	defer d.Unlock()
+	return d.cancel
+}
+
+// isClosed checks whether the channel is closed without blocking.
+func isClosed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
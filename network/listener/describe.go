@@ -0,0 +1,79 @@
+package listener
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// NamedMatcher is a matcher with a name, such as the protocol it matches,
+// which DescribeRoutes, the match traces and MatchingConnections describe it
+// by.
+type NamedMatcher interface {
+	Scorer
+	Name() string
+}
+
+// Named gives a name to the matcher, or scorer, to be registered with
+// ScoredRoute:
+//
+//	l.ScoredRoute("web", listener.Named("http", listener.MatchHTTP()))
+func Named(name string, s Scorer) NamedMatcher {
+	return namedMatcher{Scorer: s, name: name}
+}
+
+// namedMatcher is a scorer along with its name.
+type namedMatcher struct {
+	Scorer
+	name string
+}
+
+// Name returns the name of the matcher.
+func (n namedMatcher) Name() string {
+	return n.name
+}
+
+// DescribeRoutes returns a human-readable description of the routing table:
+// the routes in matching order, their matchers and their settings. Matchers
+// are described by their name if they are a NamedMatcher, or else by their
+// type.
+func (m *Listener) DescribeRoutes() string {
+	m.RLock()
+	routes := make([]*Route, len(m.routes))
+	copy(routes, m.routes)
+	matchers := make([]processor, len(m.matchers))
+	copy(matchers, m.matchers)
+	m.RUnlock()
+
+	var b bytes.Buffer
+	for _, r := range routes {
+		r.Lock()
+		drain := "none"
+		if r.drainDeadline > 0 {
+			drain = r.drainDeadline.String()
+		}
+		transform := r.transform != nil
+		r.Unlock()
+
+		fmt.Fprintf(&b, "route %s: buffer %d, drain deadline %s", r.name, cap(r.connections), drain)
+		if transform {
+			b.WriteString(", transformed")
+		}
+		b.WriteString("\n")
+
+		for _, p := range matchers {
+			if p.listen == r {
+				fmt.Fprintf(&b, "  - %s\n", p.name)
+			}
+		}
+	}
+	return b.String()
+}
+
+// matcherName returns the name of a matcher registered with a route: its own
+// if it is a NamedMatcher, or else its type, such as listener.Matcher.
+func matcherName(s Scorer) string {
+	if n, ok := s.(NamedMatcher); ok {
+		return n.Name()
+	}
+	return fmt.Sprintf("%T", s)
+}
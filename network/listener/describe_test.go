@@ -0,0 +1,33 @@
+package listener
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDescribeRoutes(t *testing.T) {
+	m := newTestMux(t)
+	web := m.ScoredRoute("web", Named("http", MatchHTTP()), Named("websocket", MatchWebSocket()))
+	web.SetDrainDeadline(2 * time.Second)
+	m.MatchWithTransform("raw", func(c net.Conn) net.Conn { return c }, MatchPrefix("RAW"))
+	m.ScoredRoute("", ScoreFunc(func(io.Reader) float64 { return 1 }))
+	m.Route("", MatchAny())
+
+	buffer := m.Options().BufferSize
+	want := "" +
+		"route web: buffer " + strconv.Itoa(buffer) + ", drain deadline 2s\n" +
+		"  - http\n" +
+		"  - websocket\n" +
+		"route raw: buffer " + strconv.Itoa(buffer) + ", drain deadline none, transformed\n" +
+		"  - listener.Matcher\n" +
+		"route route-2: buffer " + strconv.Itoa(buffer) + ", drain deadline none\n" +
+		"  - listener.ScoreFunc\n" +
+		"route route-3: buffer " + strconv.Itoa(buffer) + ", drain deadline none\n" +
+		"  - listener.Matcher\n"
+	if got := m.DescribeRoutes(); got != want {
+		t.Errorf("DescribeRoutes() = \n%s\nwant\n%s", got, want)
+	}
+}
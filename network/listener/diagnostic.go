@@ -0,0 +1,79 @@
+package listener
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+)
+
+// EchoServer is a Server sending back everything its connections receive, like
+// the Echo protocol of RFC 862. Combined with MatchAny, it wires a diagnostic
+// route in one line:
+//
+//	l.Handle("echo", EchoServer{}, MatchAny())
+type EchoServer struct{}
+
+// Serve echoes the connections of the listener until it's closed.
+func (EchoServer) Serve(l net.Listener) {
+	serveEach(l, func(c net.Conn) {
+		_, _ = io.Copy(c, c)
+	})
+}
+
+// DiscardServer is a Server reading and discarding everything its connections
+// receive, like the Discard protocol of RFC 863.
+type DiscardServer struct{}
+
+// Serve discards the data of the connections of the listener until it's closed.
+func (DiscardServer) Serve(l net.Listener) {
+	serveEach(l, func(c net.Conn) {
+		_, _ = io.Copy(ioutil.Discard, c)
+	})
+}
+
+// BannerServer is a Server writing a banner to its connections and closing
+// them, for instance a protocol-level "upgrade your client" message. Combined
+// with a matcher of the client version, ahead of the regular route, it turns
+// away a cohort of clients during a maintenance window:
+//
+//	l.Handle("outdated", listener.BannerServer{
+//		Banner: []byte("-ERR client too old, please upgrade\r\n"),
+//	}, listener.MatchPrefix("HELLO v1."))
+type BannerServer struct {
+	Banner       []byte
+	WriteTimeout time.Duration // The write deadline of the banner, a second if zero.
+}
+
+// Serve writes the banner to the connections of the listener until it's
+// closed.
+func (s BannerServer) Serve(l net.Listener) {
+	timeout := s.WriteTimeout
+	if timeout <= 0 {
+		timeout = rejectTimeout
+	}
+
+	serveEach(l, func(c net.Conn) {
+		_ = c.SetWriteDeadline(time.Now().Add(timeout))
+		_, _ = c.Write(s.Banner)
+	})
+}
+
+// serveEach accepts the connections of the listener until it's closed and
+// handles each of them in its own goroutine, closing it afterwards.
+func serveEach(l net.Listener, handle func(c net.Conn)) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+
+		go func() {
+			defer c.Close()
+			handle(c)
+		}()
+	}
+}
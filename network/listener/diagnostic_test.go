@@ -0,0 +1,58 @@
+package listener
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestEchoServer(t *testing.T) {
+	m := newTestMux(t)
+	m.Handle("echo", EchoServer{}, MatchPrefix("ECHO"))
+	m.serve()
+
+	c := m.dial("ECHO hello")
+	if got := readN(t, c, 10); got != "ECHO hello" {
+		t.Errorf("echoed %q, want the sniffed bytes back", got)
+	}
+	go func() { _, _ = c.Write([]byte(" world")) }()
+	if got := readN(t, c, 6); got != " world" {
+		t.Errorf("echoed %q, want the following bytes back", got)
+	}
+}
+
+func TestDiscardServer(t *testing.T) {
+	m := newTestMux(t)
+	m.Handle("discard", DiscardServer{}, MatchPrefix("DISCARD"))
+	m.serve()
+
+	// Pipe writes block until read, so the payload is consumed whole
+	c := m.dial("")
+	_ = c.SetWriteDeadline(time.Now().Add(testTimeout))
+	if _, err := c.Write(append([]byte("DISCARD"), bytes.Repeat([]byte("x"), 64*1024)...)); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+
+	_ = c.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if n, err := c.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read() = %d, %v, want nothing sent back", n, err)
+	}
+}
+
+func TestBannerServer(t *testing.T) {
+	const banner = "-ERR client too old, please upgrade\r\n"
+	m := newTestMux(t)
+	m.Handle("outdated", BannerServer{Banner: []byte(banner)}, MatchPrefix("HELLO v1."))
+	current := m.Route("current", MatchPrefix("HELLO "))
+	m.serve()
+
+	if got := expectClosed(t, m.dial("HELLO v1.9\r\n")); got != banner {
+		t.Errorf("old client read %q, want the banner", got)
+	}
+	m.dial("HELLO v2.0\r\n")
+	if got := readN(t, accept(t, current), 12); got != "HELLO v2.0\r\n" {
+		t.Errorf("current handler read %q, want the greeting", got)
+	}
+}
@@ -0,0 +1,212 @@
+package listener
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/numb3r3/live-go/log"
+)
+
+// ErrUnknownRoute is returned when delivering a connection to a route which is
+// not registered.
+var ErrUnknownRoute = errors.New("mux: unknown route")
+
+// Dispatcher delivers the matched connections to their route, which separates
+// the delivery from the accept and match logic. The connection given to
+// Dispatch is the one the handler of the route accepts, transformed as the
+// route says. The dispatcher delivers it with Deliver, at once or later, from
+// any goroutine, and owns it from then on: it closes it if it can not be
+// delivered. An error makes the listener close the connection, so the
+// dispatcher must not deliver it then.
+type Dispatcher interface {
+	Dispatch(route string, c net.Conn) error
+}
+
+// DispatcherFunc adapts a function to the Dispatcher interface.
+type DispatcherFunc func(route string, c net.Conn) error
+
+// Dispatch dispatches the connection to the route.
+func (f DispatcherFunc) Dispatch(route string, c net.Conn) error {
+	return f(route, c)
+}
+
+// SetDispatcher sets the dispatcher delivering the matched connections to their
+// route. The default one, which DirectDispatcher returns, delivers them from
+// the goroutine which matched them. A nil dispatcher restores it. The
+// connection is closed if the dispatcher panics, and the default one takes
+// over once it panicked three times, until a dispatcher is set again.
+func (m *Listener) SetDispatcher(d Dispatcher) {
+	m.Lock()
+	defer m.Unlock()
+	m.hooks.dispatcher.reset()
+	m.dispatcher = d
+}
+
+// Deliver queues a matched connection for the route named route, to be
+// accepted by its handler or its worker, as the dispatchers do. It waits while
+// the queue is full, reporting the backpressure of the route, until the
+// listener is closed. A connection matched by the listener goes to the route
+// it was matched for, even if other routes have the same name. It returns
+// ErrUnknownRoute if there's no such route, or ErrListenerClosed once the
+// listener or the route is closed, in which case the connection is not
+// delivered and the caller must close it.
+func (m *Listener) Deliver(route string, c net.Conn) error {
+	m.RLock()
+	select {
+	case <-m.closing:
+		m.RUnlock()
+		return ErrListenerClosed
+	default:
+	}
+	m.delivering.Add(1)
+	r := m.routeOf(route, c)
+	m.RUnlock()
+	defer m.delivering.Done()
+
+	if r == nil {
+		return ErrUnknownRoute
+	}
+	if !m.dispatch(r, c, m.closing) {
+		return ErrListenerClosed
+	}
+	return nil
+}
+
+// routeOf returns the route named route the connection goes to: the one it was
+// matched for, or else the first one registered with the name.
+func (m *Listener) routeOf(route string, c net.Conn) *Route {
+	if muc, ok := AsConn(c); ok && muc.owner != nil && muc.owner.name == route {
+		return muc.owner
+	}
+	for _, r := range m.routes {
+		if r.name == route {
+			return r
+		}
+	}
+	return nil
+}
+
+// deliverOrClose delivers a connection, or closes it if it can not be
+// delivered.
+func (m *Listener) deliverOrClose(route string, c net.Conn) {
+	if err := m.Deliver(route, c); err != nil {
+		_ = c.Close()
+		logging.Debugf("connection from %v for route %s closed: %v", c.RemoteAddr(), route, err)
+	}
+}
+
+// DirectDispatcher returns the default dispatcher of the listener, which
+// delivers the connections right away from the goroutine which matched them,
+// so a route whose queue is full holds the match of its connections.
+func DirectDispatcher(m *Listener) Dispatcher {
+	return DispatcherFunc(m.Deliver)
+}
+
+// asyncDispatcher hands the connections to goroutines delivering them, which
+// start with the first connection and stop once the listener is closed,
+// closing the connections left.
+type asyncDispatcher struct {
+	m      *Listener
+	mu     sync.RWMutex // Read held to queue, held by the goroutines to drain.
+	queues []chan dispatched
+	pick   func(net.Conn) int // The queue of a connection.
+	once   sync.Once
+	guard  hookGuard // The guard of the affinity function.
+}
+
+// dispatched is a connection waiting to be delivered.
+type dispatched struct {
+	route string
+	c     net.Conn
+}
+
+// PooledDispatcher returns a dispatcher delivering the connections from a pool
+// of workers goroutines, which take them from a shared queue of queueSize
+// connections, so the goroutine which matched a connection is freed as soon as
+// it is queued, while a route whose queue is full holds a worker instead. A
+// full shared queue holds the matches until a worker takes a connection. The
+// connections are delivered in no particular order, ordered dispatch
+// included.
+func PooledDispatcher(m *Listener, workers, queueSize int) Dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+
+	d := &asyncDispatcher{m: m, queues: []chan dispatched{make(chan dispatched, queueSize)}}
+	d.pick = func(net.Conn) int { return 0 }
+	for i := 1; i < workers; i++ {
+		// Each worker delivers from the shared queue
+		d.queues = append(d.queues, d.queues[0])
+	}
+	return d
+}
+
+// ShardedDispatcher returns a dispatcher delivering the connections from one
+// goroutine per shard, each with its own queue of queueSize connections, the
+// shard of a connection being the result of the affinity function modulo the
+// number of shards, RemoteIPAffinity if it is nil. The connections of a shard
+// are delivered in the order they were matched, so the ones of a client keep
+// their order, and a route whose queue is full only holds the shards of its
+// connections.
+func ShardedDispatcher(m *Listener, shards, queueSize int, affinity func(net.Conn) int) Dispatcher {
+	if shards < 1 {
+		shards = 1
+	}
+
+	d := &asyncDispatcher{m: m, queues: make([]chan dispatched, shards)}
+	for i := range d.queues {
+		d.queues[i] = make(chan dispatched, queueSize)
+	}
+	d.pick = func(c net.Conn) int { return shardOf(c, affinity, shards, &d.guard) }
+	return d
+}
+
+// Dispatch queues the connection for its delivery, waiting while the queue is
+// full, until the listener is closed. Once closed, it closes the connection
+// and returns ErrListenerClosed, as the queues may have been drained already.
+func (d *asyncDispatcher) Dispatch(route string, c net.Conn) error {
+	d.once.Do(d.start)
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	select {
+	case <-d.m.closing:
+		_ = c.Close()
+		return ErrListenerClosed
+	default:
+	}
+
+	select {
+	case d.queues[d.pick(c)] <- dispatched{route: route, c: c}:
+		return nil
+	case <-d.m.closing:
+		_ = c.Close()
+		return ErrListenerClosed
+	}
+}
+
+// start starts a goroutine delivering the connections of each queue.
+func (d *asyncDispatcher) start() {
+	for _, queue := range d.queues {
+		go func(queue chan dispatched) {
+			for {
+				select {
+				case next := <-queue:
+					d.m.deliverOrClose(next.route, next.c)
+				case <-d.m.closing:
+					// No connection is queued past the lock
+					d.mu.Lock()
+					defer d.mu.Unlock()
+					for {
+						select {
+						case next := <-queue:
+							_ = next.c.Close()
+						default:
+							return
+						}
+					}
+				}
+			}
+		}(queue)
+	}
+}
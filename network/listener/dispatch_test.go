@@ -0,0 +1,125 @@
+package listener
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSetDispatcher(t *testing.T) {
+	m := newTestMux(t)
+	routes := make(chan string, 2)
+	m.SetDispatcher(DispatcherFunc(func(route string, c net.Conn) error {
+		routes <- route
+		return m.Deliver(route, c)
+	}))
+	ws := m.Route("ws", MatchPrefix("W"))
+	proxy := m.Route("proxy", MatchPrefix("P"))
+	m.serve()
+
+	for _, tt := range []struct {
+		data  string
+		route *Route
+	}{{"W", ws}, {"P", proxy}} {
+		m.dial(tt.data)
+		if got := readN(t, accept(t, tt.route), 1); got != tt.data {
+			t.Errorf("%s handler read %q, want %q", tt.route.Name(), got, tt.data)
+		}
+		if got := <-routes; got != tt.route.Name() {
+			t.Errorf("dispatched to %q, want %q", got, tt.route.Name())
+		}
+	}
+}
+
+func TestDispatcherError(t *testing.T) {
+	m := newTestMux(t)
+	m.SetDispatcher(DispatcherFunc(func(string, net.Conn) error {
+		return errors.New("no room")
+	}))
+	r := m.Route("ws", MatchAny())
+	m.serve()
+
+	// The listener closes the connections it could not dispatch
+	expectClosed(t, m.dial("a"))
+	expectNoAccept(t, r, 50*time.Millisecond)
+}
+
+func TestDeliverUnknownRoute(t *testing.T) {
+	m := newTestMux(t)
+	m.Route("ws", MatchAny())
+
+	c, peer := net.Pipe()
+	defer func() { _ = peer.Close() }()
+	defer func() { _ = c.Close() }()
+	if err := m.Deliver("proxy", c); err != ErrUnknownRoute {
+		t.Errorf("Deliver() = %v, want ErrUnknownRoute", err)
+	}
+}
+
+// asyncDispatchers are the dispatchers delivering from their own goroutines.
+var asyncDispatchers = map[string]func(*Listener) Dispatcher{
+	"pooled":  func(m *Listener) Dispatcher { return PooledDispatcher(m, 3, 4) },
+	"sharded": func(m *Listener) Dispatcher { return ShardedDispatcher(m, 3, 4, nil) },
+}
+
+func TestAsyncDispatchers(t *testing.T) {
+	for name, dispatcher := range asyncDispatchers {
+		t.Run(name, func(t *testing.T) {
+			m := newTestMux(t)
+			m.SetDispatcher(dispatcher(m.Listener))
+			r := m.Route("ws", MatchAny())
+			m.serve()
+
+			for _, data := range []string{"a", "b", "c", "d", "e"} {
+				m.dial(data)
+				if got := readN(t, accept(t, r), 1); got != data {
+					t.Errorf("handler read %q, want %q", got, data)
+				}
+			}
+		})
+	}
+}
+
+func TestAsyncDispatchWhileClosing(t *testing.T) {
+	for name, dispatcher := range asyncDispatchers {
+		t.Run(name, func(t *testing.T) {
+			m := newTestMux(t)
+			d := dispatcher(m.Listener)
+			m.SetDispatcher(d)
+			m.Route("ws", MatchAny())
+			m.serve()
+
+			// The connections dispatched while the listener closes are either
+			// delivered, and closed with the queue of the route, or closed
+			// right away, none is left in the queues of the dispatcher
+			const n = 40
+			var clients []net.Conn
+			var wg sync.WaitGroup
+			for i := 0; i < n; i++ {
+				client, server := net.Pipe()
+				clients = append(clients, client)
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_ = d.Dispatch("ws", server)
+				}()
+				if i == n/2 {
+					_ = m.Close()
+				}
+			}
+			wg.Wait()
+			m.wait()
+			for _, c := range clients {
+				expectClosed(t, c)
+			}
+
+			client, server := net.Pipe()
+			if err := d.Dispatch("ws", server); err != ErrListenerClosed {
+				t.Errorf("Dispatch() once closed = %v, want ErrListenerClosed", err)
+			}
+			expectClosed(t, client)
+		})
+	}
+}
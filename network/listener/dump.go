@@ -0,0 +1,47 @@
+package listener
+
+import (
+	"encoding/hex"
+	"io"
+
+	"github.com/numb3r3/live-go/log"
+)
+
+// SetSniffDumpConfig makes the listener log, at debug level, a hex dump of the
+// first maxBytes bytes sniffed from each connection once it is matched or found
+// unmatched, to diagnose the protocol of the clients. Only the bytes the
+// matchers read are sniffed, so the dump may be shorter. The redactor, if any, is
+// given a copy of those bytes and returns the ones to dump, with the sensitive
+// regions, such as the TLS client random or authentication tokens, masked; it
+// may change its argument in place. Nothing is dumped while the log level is
+// above debug. Zero bytes disables it.
+func (m *Listener) SetSniffDumpConfig(maxBytes int, redactor func([]byte) []byte) {
+	m.Lock()
+	defer m.Unlock()
+	m.dumpBytes = maxBytes
+	m.hooks.dumpRedactor.reset()
+	m.dumpRedactor = redactor
+}
+
+// dumpSniffed logs the dump of the bytes sniffed from the connection through
+// r, with the outcome of its matching. Nothing is dumped if the redactor
+// panics.
+func dumpSniffed(c *Conn, r io.Reader, outcome string, maxBytes int, redactor func([]byte) []byte, guard *hookGuard) {
+	if maxBytes <= 0 || logging.GetLogLevel()&logging.LogLevel(logging.LOG_DEBUG) == 0 {
+		return
+	}
+	sr, ok := r.(sniffedReader)
+	if !ok {
+		return
+	}
+
+	sniffed := sr.sniffed()
+	if len(sniffed) > maxBytes {
+		sniffed = sniffed[:maxBytes]
+	}
+	b := append([]byte(nil), sniffed...)
+	if redactor != nil && !guard.call("sniff dump redactor", func() { b = redactor(b) }) {
+		return
+	}
+	logging.Debugf("connection %s from %v %s, sniffed %d bytes:\n%s", c.id, c.RemoteAddr(), outcome, len(b), hex.Dump(b))
+}
@@ -0,0 +1,80 @@
+package listener
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	logging "github.com/numb3r3/live-go/log"
+)
+
+// redactBearer masks the bearer tokens of the sniffed bytes.
+func redactBearer(b []byte) []byte {
+	if i := bytes.Index(b, []byte("Bearer ")); i >= 0 {
+		for j := i + 7; j < len(b) && b[j] != '\r'; j++ {
+			b[j] = '*'
+		}
+	}
+	return b
+}
+
+func TestSniffDump(t *testing.T) {
+	const request = "GET / HTTP/1.1\r\nAuthorization: Bearer s3cr3t\r\n\r\n"
+	out := captureLog(t)
+	m := newTestMux(t)
+	m.SetSniffDumpConfig(40, redactBearer)
+	api := m.Route("api", MatchHTTPHeader("Authorization", func(string) bool { return true }))
+	m.serve()
+
+	m.dial(request)
+	if got := readN(t, accept(t, api), len(request)); got != request {
+		t.Errorf("handler read %q, want the request unredacted", got)
+	}
+	m.dial("\x00\x01binary\n")
+	waitFor(t, "the dumps", func() bool { return strings.Count(out.String(), "sniffed") == 2 })
+
+	log := out.String()
+	redacted := redactBearer([]byte(request))[:40]
+	if !strings.Contains(log, "matched for route api, sniffed 40 bytes:\n"+hex.Dump(redacted)) {
+		t.Errorf("log = %q, want the redacted dump of the first 40 bytes", log)
+	}
+	if strings.Contains(log, "s3cr3t") || strings.Contains(log, hex.EncodeToString([]byte("s3"))) {
+		t.Errorf("log = %q, want the token redacted", log)
+	}
+	if !strings.Contains(log, "not matched, sniffed") {
+		t.Errorf("log = %q, want the dump of the unmatched connection", log)
+	}
+}
+
+func TestSniffDumpDisabled(t *testing.T) {
+	tests := []struct {
+		name     string
+		level    logging.LogLevel
+		redactor func([]byte) []byte
+	}{
+		{"above debug", logging.LOG_LEVEL_INFO, nil},
+		{"redactor panics", logging.LOG_LEVEL_DEBUG, func([]byte) []byte { panic("bad redactor") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := captureLog(t)
+			logging.SetLevel(tt.level)
+			m := newTestMux(t)
+			m.SetSniffDumpConfig(64, tt.redactor)
+			r := m.Route("r", MatchPrefix("HELLO"))
+			m.serve()
+
+			m.dial("HELLO")
+			if got := readN(t, accept(t, r), 5); got != "HELLO" {
+				t.Errorf("handler read %q, want HELLO", got)
+			}
+			if strings.Contains(out.String(), " bytes:\n") {
+				t.Errorf("log = %q, want no dump", out.String())
+			}
+			if tt.redactor != nil && !strings.Contains(out.String(), "sniff dump redactor panicked") {
+				t.Errorf("log = %q, want the panic of the redactor", out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package listener
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/numb3r3/live-go/log"
+)
+
+// The types of the connection lifecycle events.
+const (
+	EventAccepted = "accepted"
+	EventMatched  = "matched"
+	EventClosed   = "closed"
+)
+
+// eventBufferSize is the number of events which can be pending for the sink
+// before new ones get dropped.
+const eventBufferSize = 1024
+
+// Event represents a connection lifecycle event, written as a JSON object on
+// its own line to the event sink.
+type Event struct {
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	ID       string    `json:"id"`
+	Remote   string    `json:"remote"`
+	Route    string    `json:"route,omitempty"`
+	BytesIn  int64     `json:"bytes_in"`
+	BytesOut int64     `json:"bytes_out"`
+	Duration float64   `json:"duration_ms"`
+}
+
+// eventSink writes the encoded events to a writer from its own goroutine.
+type eventSink struct {
+	events  chan []byte
+	dropped uint64
+}
+
+// newEventSink creates a sink and starts writing to the writer.
+func newEventSink(w io.Writer, guard *hookGuard) *eventSink {
+	s := &eventSink{
+		events: make(chan []byte, eventBufferSize),
+	}
+
+	go func() {
+		for b := range s.events {
+			guard.call("event sink", func() {
+				if _, err := w.Write(b); err != nil {
+					logging.Warningf("unable to write connection event: %v", err)
+				}
+			})
+		}
+	}()
+	return s
+}
+
+// SetEventSink sets the writer which receives one JSON object per connection
+// lifecycle event. Writes never block the listener: when the writer can not
+// keep up, the events are dropped and counted. A nil writer disables events.
+func (m *Listener) SetEventSink(w io.Writer) {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.sink != nil {
+		close(m.sink.events)
+		m.sink = nil
+	}
+
+	if w != nil {
+		m.hooks.eventSink.reset()
+		m.sink = newEventSink(w, &m.hooks.eventSink)
+	}
+}
+
+// DroppedEvents returns the number of events the current sink has dropped.
+func (m *Listener) DroppedEvents() uint64 {
+	m.RLock()
+	defer m.RUnlock()
+
+	if m.sink == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&m.sink.dropped)
+}
+
+// SetAcceptLogSampling sets the fraction of the connections whose accept and
+// match are logged, to keep the log volume manageable. The sampling is
+// deterministic, a rate of 0.1 selecting one of every ten connections. The
+// connections which are rejected or not matched, and the errors, are still all
+// logged, as are the events written to the event sink. A rate of 1 or more, the
+// default, logs every connection.
+func (m *Listener) SetAcceptLogSampling(rate float64) {
+	m.Lock()
+	defer m.Unlock()
+	m.sampleRate = rate
+	m.sampling = rate < 1
+}
+
+// sample returns whether the accept and match of a new connection are logged.
+func (m *Listener) sample() bool {
+	m.RLock()
+	sampling, rate := m.sampling, m.sampleRate
+	m.RUnlock()
+	if !sampling {
+		return true
+	}
+
+	// Select a connection every time the sampled count reaches a new integer
+	seq := atomic.AddInt64(&m.sampleSeq, 1)
+	return int64(float64(seq)*rate) != int64(float64(seq-1)*rate)
+}
+
+// emit sends an event for the connection to the sink, if there's one.
+func (m *Listener) emit(typ string, c *Conn) {
+	m.RLock()
+	defer m.RUnlock()
+	if m.sink == nil {
+		return
+	}
+
+	now := time.Now()
+	b, err := json.Marshal(Event{
+		Type:     typ,
+		Time:     now.UTC(),
+		ID:       c.id,
+		Remote:   remoteAddr(c),
+		Route:    c.route,
+		BytesIn:  atomic.LoadInt64(&c.bytesIn),
+		BytesOut: atomic.LoadInt64(&c.bytesOut),
+		Duration: float64(now.Sub(c.accepted)) / float64(time.Millisecond),
+	})
+	if err != nil {
+		return
+	}
+
+	select {
+	case m.sink.events <- append(b, '\n'):
+	default:
+		atomic.AddUint64(&m.sink.dropped, 1)
+	}
+}
+
+// remoteAddr returns the remote address of the connection as a string.
+func remoteAddr(c net.Conn) string {
+	if addr := c.RemoteAddr(); addr != nil {
+		return addr.String()
+	}
+	return ""
+}
@@ -0,0 +1,165 @@
+package listener
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// waitFor polls the condition until it holds, failing the test if it does not
+// in time.
+func waitFor(t testing.TB, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// readEvents decodes the events written to the sink.
+func readEvents(t testing.TB, sink *syncBuffer) []Event {
+	t.Helper()
+	var events []Event
+	scanner := bufio.NewScanner(strings.NewReader(sink.String()))
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("invalid JSON line %q: %v", scanner.Text(), err)
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestEventSink(t *testing.T) {
+	sink := new(syncBuffer)
+	m := newTestMux(t)
+	m.SetEventSink(sink)
+	r := m.Route("echo", MatchPrefix("PING"))
+	m.serve()
+
+	client := m.dial("PING")
+	c := accept(t, r)
+	readN(t, c, 4)
+	go func() { _, _ = client.Read(make([]byte, 4)) }()
+	if _, err := c.Write([]byte("PONG")); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Close()
+
+	waitFor(t, "three events", func() bool { return strings.Count(sink.String(), "\n") == 3 })
+	events := readEvents(t, sink)
+	for i, typ := range []string{EventAccepted, EventMatched, EventClosed} {
+		e := events[i]
+		if e.Type != typ {
+			t.Errorf("event %d type = %q, want %q", i, e.Type, typ)
+		}
+		if e.ID == "" || e.ID != events[0].ID || e.Remote == "" || e.Time.IsZero() {
+			t.Errorf("event %d = %+v, want the ID, remote and time of the connection", i, e)
+		}
+	}
+	if closed := events[2]; closed.Route != "echo" || closed.BytesIn != 4 || closed.BytesOut != 4 || closed.Duration <= 0 {
+		t.Errorf("closed event = %+v, want route echo, 4 bytes each way and a duration", closed)
+	}
+}
+
+// blockingWriter blocks every write until it is closed.
+type blockingWriter chan struct{}
+
+func (w blockingWriter) Write(p []byte) (int, error) {
+	<-w
+	return len(p), nil
+}
+
+func TestEventSinkDropsOnOverflow(t *testing.T) {
+	w := make(blockingWriter)
+	defer close(w)
+	m := newTestMux(t)
+	m.SetEventSink(w)
+	client, server := net.Pipe()
+	defer client.Close()
+	c := newConn(server)
+
+	const events = eventBufferSize + 100
+	for i := 0; i < events; i++ {
+		m.emit(EventAccepted, c)
+	}
+	// The writer takes one event, the buffer holds the next ones
+	if dropped := m.DroppedEvents(); dropped < events-eventBufferSize-1 {
+		t.Errorf("DroppedEvents() = %d, want at least %d", dropped, events-eventBufferSize-1)
+	}
+}
+
+func TestAcceptLogSampling(t *testing.T) {
+	const n, rate = 40, 0.25
+	out := captureLog(t)
+	errs := make(chan error, 10)
+	m := newTestMux(t)
+	m.HandleError(func(err error) bool {
+		errs <- err
+		return true
+	})
+	m.SetAcceptLogSampling(rate)
+	r := m.Route("echo", MatchPrefix("PING"))
+	m.serve()
+
+	for i := 0; i < n; i++ {
+		m.dial("PING")
+		_ = accept(t, r).Close()
+	}
+	// The errors are always logged, whether the connection was sampled or not
+	for i := 0; i < 4; i++ {
+		expectClosed(t, m.dial("QUIT\n"))
+		<-errs
+	}
+	m.SetMaxConnections(1)
+	m.dial("PING")
+	accept(t, r)
+	for i := 0; i < 4; i++ {
+		expectClosed(t, m.dial("PING"))
+		<-errs
+	}
+
+	count := func(substr string) int {
+		return strings.Count(out.String(), substr)
+	}
+	if got := count(" matched route echo"); got != n*rate {
+		t.Errorf("%d matches logged, want %d", got, int(n*rate))
+	}
+	// The unmatched and rejected connections are sampled too
+	if got, total := count(" accepted"), n+9; got < n*rate || float64(got) > float64(total)*rate {
+		t.Errorf("%d accepts logged out of %d, want about %v", got, total, float64(total)*rate)
+	}
+	if got := count(" not matched."); got != 4 {
+		t.Errorf("%d unmatched connections logged, want 4", got)
+	}
+	if got := count(" rejected: "); got != 4 {
+		t.Errorf("%d rejected connections logged, want 4", got)
+	}
+}
@@ -0,0 +1,21 @@
+package listener_test
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/numb3r3/live-go/network/listener"
+)
+
+func ExampleMatchBytes() {
+	captured := []byte("GET /metrics HTTP/1.1\r\nHost: example.com\r\n\r\n")
+
+	route, _ := listener.MatchBytes(func(l *listener.Listener) map[string]net.Listener {
+		return map[string]net.Listener{
+			"redis": l.Match(listener.MatchPrefix("*")),
+			"http":  l.Match(listener.MatchHTTP()),
+		}
+	}, captured)
+	fmt.Println(route)
+	// Output: http
+}
@@ -0,0 +1,34 @@
+package listener
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// SetLimitExemption sets a function telling which accepted connections are
+// exempt from the limits of the listener, such as the ones of health checkers,
+// monitoring or administration, so they keep being served during an overload.
+// It is called with each connection before any limit applies to it, and the
+// exempt ones are still matched and routed as usual, but skip the connection
+// limits of the listener and their route, the sniff memory budget, the read
+// rate limit and the concurrent TLS handshake limit, and give their matching
+// slot back at once. The accept rate limit and the matching bound apply before
+// a connection is accepted, so they can not be skipped, and neither can the
+// address filters nor the bans, which are no limits. The exempt connections are
+// not counted by the connection limits either, so they take none of the
+// capacity of the others, though Stats counts them as active. A nil function
+// exempts none.
+func (m *Listener) SetLimitExemption(fn func(net.Conn) bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.hooks.exemption.reset()
+	m.exemption = fn
+}
+
+// countLimited counts a connection subject to the limits until it is closed.
+func (m *Listener) countLimited(c *Conn) {
+	atomic.AddInt64(&m.limited, 1)
+	c.notifyClose(func() {
+		atomic.AddInt64(&m.limited, -1)
+	})
+}
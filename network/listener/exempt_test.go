@@ -0,0 +1,69 @@
+package listener
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+func TestLimitExemption(t *testing.T) {
+	limits := map[string]func(*Listener, *Route){
+		"listener": func(m *Listener, _ *Route) { m.SetMaxConnections(1) },
+		"route":    func(_ *Listener, r *Route) { r.SetMaxConnections(1) },
+	}
+	for name, limit := range limits {
+		t.Run(name, func(t *testing.T) {
+			m := newTestMux(t)
+			errs := make(chan error, 4)
+			m.HandleError(func(err error) bool {
+				errs <- err
+				return true
+			})
+			var exempt int32
+			m.SetLimitExemption(func(net.Conn) bool { return atomic.LoadInt32(&exempt) == 1 })
+			r := m.Route("ws", MatchAny())
+			limit(m.Listener, r)
+			m.serve()
+
+			m.dial("a")
+			first := accept(t, r)
+			expectClosed(t, m.dial("b"))
+			if err := <-errs; err != ErrTooManyConnections && err != ErrRouteFull {
+				t.Errorf("error past the limit = %v, want a limit error", err)
+			}
+
+			// The exempt connections are served past the limit
+			atomic.StoreInt32(&exempt, 1)
+			for _, data := range []string{"c", "d"} {
+				m.dial(data)
+				if got := readN(t, accept(t, r), 1); got != data {
+					t.Errorf("exempt handler read %q, want %q", got, data)
+				}
+			}
+
+			// and take none of the capacity of the others, though they are active
+			if active := m.Stats().Active; active != 3 {
+				t.Errorf("Stats().Active = %d, want the 3 connections served", active)
+			}
+			atomic.StoreInt32(&exempt, 0)
+			_ = first.Close()
+			m.dial("e")
+			if got := readN(t, accept(t, r), 1); got != "e" {
+				t.Errorf("handler read %q, want e in the slot freed", got)
+			}
+			expectClosed(t, m.dial("f"))
+		})
+	}
+}
+
+func TestLimitExemptionNil(t *testing.T) {
+	m := newTestMux(t)
+	r := m.Route("ws", MatchAny())
+	m.SetMaxConnections(1)
+	m.SetLimitExemption(nil)
+	m.serve()
+
+	m.dial("a")
+	accept(t, r)
+	expectClosed(t, m.dial("b"))
+}
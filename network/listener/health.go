@@ -0,0 +1,35 @@
+package listener
+
+import "sync/atomic"
+
+// HealthStatus represents the state of the listener, as reported to readiness
+// probes.
+type HealthStatus struct {
+	Serving  bool  // Whether the listener is accepting connections.
+	Routes   int   // The number of routes registered.
+	Draining bool  // Whether new connections are rejected, see StartDraining.
+	LameDuck bool  // Whether the listener is shutting down, draining its connections.
+	Active   int64 // The number of accepted connections which are not closed yet.
+}
+
+// Health returns the state of the listener, for a health handler to render. A
+// listener should be considered ready only while it is serving, neither
+// draining nor in lame duck mode:
+//
+//	h := l.Health()
+//	if !h.Serving || h.Draining || h.LameDuck {
+//		w.WriteHeader(http.StatusServiceUnavailable)
+//	}
+func (m *Listener) Health() HealthStatus {
+	m.RLock()
+	routes := len(m.routes)
+	m.RUnlock()
+
+	return HealthStatus{
+		Serving:  atomic.LoadInt32(&m.serving) == 1,
+		Routes:   routes,
+		Draining: m.IsDraining(),
+		LameDuck: atomic.LoadInt32(&m.lameDuck) == 1,
+		Active:   m.Stats().Active,
+	}
+}
@@ -0,0 +1,47 @@
+package listener
+
+import (
+	"context"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	m := newTestMux(t)
+	r := m.Route("api", MatchAny())
+	m.Route("admin", MatchPrefix("ADMIN"))
+	if h := m.Health(); h.Serving || h.Routes != 2 {
+		t.Errorf("Health() before serving = %+v, want not serving with 2 routes", h)
+	}
+	m.serve()
+
+	m.dial("x")
+	c := accept(t, r)
+	waitFor(t, "the listener to serve", func() bool { return m.Health().Serving })
+	if h := m.Health(); h.Draining || h.LameDuck || h.Active != 1 {
+		t.Errorf("Health() while serving = %+v, want ready with 1 active connection", h)
+	}
+
+	m.StartDraining()
+	if h := m.Health(); !h.Serving || !h.Draining {
+		t.Errorf("Health() while draining = %+v, want serving and draining", h)
+	}
+	m.StopDraining()
+	if h := m.Health(); h.Draining {
+		t.Errorf("Health() once draining stopped = %+v, want not draining", h)
+	}
+
+	// Shutdown waits for the connection, in lame duck mode
+	done := make(chan error, 1)
+	go func() {
+		_, err := m.Shutdown(context.Background())
+		done <- err
+	}()
+	waitFor(t, "the lame duck mode", func() bool { return m.Health().LameDuck })
+	_ = c.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+	if h := m.Health(); h.Serving || h.LameDuck || h.Active != 0 {
+		t.Errorf("Health() after shutdown = %+v, want stopped with no connection", h)
+	}
+}
@@ -0,0 +1,91 @@
+package listener
+
+import (
+	"errors"
+	"runtime/debug"
+	"sync/atomic"
+
+	"github.com/numb3r3/live-go/log"
+)
+
+// maxHookPanics is the number of panics after which a hook is disabled.
+const maxHookPanics = 3
+
+// errHookPanicked is the error of the operations whose hook panicked.
+var errHookPanicked = errors.New("mux: hook panicked")
+
+// hookGuard isolates the listener from the panics of a user-provided hook: the
+// panics are recovered and the hook is disabled once it panicked maxHookPanics
+// times, until it gets set again.
+type hookGuard struct {
+	panics int32
+}
+
+// hookGuards are the guards of the hooks the listener calls.
+type hookGuards struct {
+	tracer        hookGuard
+	frameObserver hookGuard
+	stateChange   hookGuard
+	backpressure  hookGuard
+	drainProgress hookGuard
+	leakReporter  hookGuard
+	eventSink     hookGuard
+	tap           hookGuard
+	sessionID     hookGuard
+	compression   hookGuard
+	preAuth       hookGuard
+	exemption     hookGuard
+	traceFilter   hookGuard
+	dumpRedactor  hookGuard
+	dispatcher    hookGuard
+}
+
+// routeGuards are the guards of the hooks of a route.
+type routeGuards struct {
+	responder hookGuard
+	affinity  hookGuard
+}
+
+// call calls the hook unless it is disabled, recovering its panic, and returns
+// whether the hook returned normally. The first panic is logged with its stack,
+// and so is the disabling of the hook.
+func (g *hookGuard) call(name string, fn func()) (ok bool) {
+	if g.disabled() {
+		return false
+	}
+
+	defer func() {
+		if v := recover(); v != nil {
+			ok = false
+			switch atomic.AddInt32(&g.panics, 1) {
+			case 1:
+				logging.Warningf("%s panicked: %v\n%s", name, v, debug.Stack())
+			case maxHookPanics:
+				logging.Warningf("%s disabled after %d panics", name, maxHookPanics)
+			}
+		}
+	}()
+	fn()
+	return true
+}
+
+// disabled returns whether the hook panicked too many times to be called.
+func (g *hookGuard) disabled() bool {
+	return atomic.LoadInt32(&g.panics) >= maxHookPanics
+}
+
+// reset enables the hook again, once it is replaced.
+func (g *hookGuard) reset() {
+	atomic.StoreInt32(&g.panics, 0)
+}
+
+// guardedSpan is a span whose End is guarded like its tracer.
+type guardedSpan struct {
+	Span
+	guard *hookGuard
+}
+
+// End ends the span.
+func (s *guardedSpan) End() {
+	s.guard.call("tracer", s.Span.End)
+}
@@ -0,0 +1,82 @@
+package listener
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func TestHookGuard(t *testing.T) {
+	captureLog(t)
+	var g hookGuard
+	calls := 0
+	panicking := func() {
+		calls++
+		panic("buggy hook")
+	}
+	for i := 0; i < maxHookPanics+2; i++ {
+		if g.call("hook", panicking) {
+			t.Fatalf("call %d of a panicking hook = true", i)
+		}
+	}
+	if calls != maxHookPanics || !g.disabled() {
+		t.Errorf("hook called %d times, disabled %v, want disabled after %d", calls, g.disabled(), maxHookPanics)
+	}
+
+	g.reset()
+	if !g.call("hook", func() {}) || g.disabled() {
+		t.Error("hook not called again once reset")
+	}
+}
+
+// panickingTracer is a tracer whose StartSpan panics, counting its calls.
+type panickingTracer struct{ calls int32 }
+
+func (t *panickingTracer) StartSpan(name string, attrs ...Attr) Span {
+	atomic.AddInt32(&t.calls, 1)
+	panic("buggy tracer")
+}
+
+// panickingWriter is an event sink whose writes panic, counting them.
+type panickingWriter struct{ writes int32 }
+
+func (w *panickingWriter) Write(p []byte) (int, error) {
+	atomic.AddInt32(&w.writes, 1)
+	panic("buggy sink")
+}
+
+func TestPanickingHooks(t *testing.T) {
+	captureLog(t)
+	m := newTestMux(t)
+	tracer, sink := new(panickingTracer), new(panickingWriter)
+	m.SetTracer(tracer)
+	m.SetEventSink(sink)
+	r := m.Route("r", MatchPrefix("HELLO"))
+	m.serve()
+
+	// The connections are served, while the hooks get disabled
+	for i := 0; i < maxHookPanics+2; i++ {
+		m.dial("HELLO")
+		c := accept(t, r)
+		if got := readN(t, c, 5); got != "HELLO" {
+			t.Errorf("handler read %q, want HELLO", got)
+		}
+		_ = c.Close()
+	}
+	if n := atomic.LoadInt32(&tracer.calls); n != maxHookPanics {
+		t.Errorf("tracer called %d times, want %d", n, maxHookPanics)
+	}
+	// The events are written in the background, more than one per connection
+	waitFor(t, "the event sink to be disabled", func() bool { return atomic.LoadInt32(&sink.writes) >= maxHookPanics })
+	if n := atomic.LoadInt32(&sink.writes); n != maxHookPanics {
+		t.Errorf("event sink written %d times, want %d", n, maxHookPanics)
+	}
+
+	// Setting the hook again enables it
+	tracer = new(panickingTracer)
+	m.SetTracer(tracer)
+	m.dial("HELLO")
+	readN(t, accept(t, r), 5)
+	if n := atomic.LoadInt32(&tracer.calls); n == 0 {
+		t.Error("new tracer not called")
+	}
+}
@@ -0,0 +1,156 @@
+package listener
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// MatchWebSocket matches the HTTP requests upgrading to the WebSocket protocol.
+func MatchWebSocket() Matcher {
+	return MatchHTTPHeader("Upgrade", func(value string) bool {
+		return strings.EqualFold(strings.TrimSpace(value), "websocket")
+	})
+}
+
+// MatchHTTPHost matches the HTTP requests whose Host header, without its port,
+// is one of the hosts. A host starting with "*." matches any subdomain.
+func MatchHTTPHost(hosts ...string) Matcher {
+	return MatchHTTPHeader("Host", func(value string) bool {
+		host := strings.TrimSpace(value)
+		if i := strings.LastIndexByte(host, ':'); i >= 0 && !strings.HasSuffix(host, "]") {
+			host = host[:i]
+		}
+
+		for _, pattern := range hosts {
+			if matchServerName(pattern, host) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// MatchHTTPContentType matches the HTTP requests whose Content-Type header,
+// without its parameters, is one of the media types, case-insensitively, like
+// "application/ocsp-request". Requests without a Content-Type do not match.
+func MatchHTTPContentType(types ...string) Matcher {
+	return MatchHTTPHeader("Content-Type", func(value string) bool {
+		if i := strings.IndexByte(value, ';'); i >= 0 {
+			value = value[:i]
+		}
+		value = strings.TrimSpace(value)
+
+		for _, typ := range types {
+			if strings.EqualFold(value, typ) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// MatchHTTPPath matches the HTTP requests whose target path, without its query,
+// is one of the paths. A path ending with "*" matches any path it prefixes, so
+// "/api/v1/write" matches only that path and "/api/*" every path under /api/.
+// Only the request line is sniffed, within the limit set with
+// SetMaxHTTPHeaderSniffBytes.
+func MatchHTTPPath(paths ...string) Matcher {
+	return func(r io.Reader) bool {
+		line, ok := readLine(limitHTTPHeaders(r), maxLineLength)
+		if !ok {
+			return false
+		}
+
+		fields := bytes.Fields(line)
+		if len(fields) != 3 || !bytes.HasPrefix(fields[2], []byte("HTTP/1.")) {
+			return false
+		}
+
+		path := string(fields[1])
+		if i := strings.Index(path, "://"); i >= 0 {
+			// Absolute-form target, as sent to proxies
+			path = path[i+3:]
+			if i = strings.IndexByte(path, '/'); i < 0 {
+				return false
+			}
+			path = path[i:]
+		}
+		if i := strings.IndexAny(path, "?#"); i >= 0 {
+			path = path[:i]
+		}
+
+		for _, pattern := range paths {
+			if strings.HasSuffix(pattern, "*") {
+				if strings.HasPrefix(path, pattern[:len(pattern)-1]) {
+					return true
+				}
+			} else if path == pattern {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MatchHTTPExpectContinue matches the HTTP/1.1 requests carrying an
+// "Expect: 100-continue" header, so they can be routed to a handler sending the
+// interim response. The request is replayed whole, headers included.
+func MatchHTTPExpectContinue() Matcher {
+	return func(r io.Reader) bool {
+		r = limitHTTPHeaders(r)
+		line, ok := readLine(r, maxLineLength)
+		if !ok || !bytes.HasSuffix(line, []byte(" HTTP/1.1")) {
+			return false
+		}
+
+		return scanHeaderLines(r, func(key, value []byte) bool {
+			return bytes.EqualFold(key, []byte("Expect")) && bytes.EqualFold(value, []byte("100-continue"))
+		})
+	}
+}
+
+// MatchHTTPHeader matches the HTTP requests carrying the header with a value
+// satisfying the predicate. Headers are sniffed up to the limit set with
+// SetMaxSniffHeaderLines.
+func MatchHTTPHeader(name string, fn func(value string) bool) Matcher {
+	return func(r io.Reader) bool {
+		return scanHTTPHeaders(r, func(key, value []byte) bool {
+			return strings.EqualFold(string(key), name) && fn(string(value))
+		})
+	}
+}
+
+// scanHTTPHeaders reads an HTTP request line and its headers until fn returns
+// true for one of them. It stops at the end of the headers, or once the header
+// line or byte limit of the listener is reached.
+func scanHTTPHeaders(r io.Reader, fn func(key, value []byte) bool) bool {
+	r = limitHTTPHeaders(r)
+	line, ok := readLine(r, maxLineLength)
+	if !ok || !bytes.Contains(line, []byte(" HTTP/1.")) {
+		return false
+	}
+	return scanHeaderLines(r, fn)
+}
+
+// scanHeaderLines reads the header lines following the request line until fn
+// returns true for one of them, like scanHTTPHeaders does.
+func scanHeaderLines(r io.Reader, fn func(key, value []byte) bool) bool {
+	config := configOf(r)
+	for i := 0; i < config.maxHeaderLines; i++ {
+		line, ok := readLine(r, maxLineLength)
+		if !ok || len(line) == 0 {
+			return false
+		}
+
+		colon := bytes.IndexByte(line, ':')
+		if colon <= 0 {
+			return false
+		}
+
+		if fn(bytes.TrimSpace(line[:colon]), bytes.TrimSpace(line[colon+1:])) {
+			return true
+		}
+	}
+	return false
+}
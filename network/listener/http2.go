@@ -0,0 +1,125 @@
+package listener
+
+import (
+	"io"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// The HTTP/2 constants needed to sniff the first requests of a connection.
+const (
+	http2Preface        = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+	http2FrameHeaderLen = 9
+	http2MaxFrameSize   = 16384
+	http2MaxFrames      = 8
+	http2FrameHeaders   = 0x1
+	http2FrameCont      = 0x9
+	http2FlagEndHeaders = 0x4
+	http2FlagPadded     = 0x8
+	http2FlagPriority   = 0x20
+)
+
+// MatchWebSocketH2 matches h2c connections (HTTP/2 with prior knowledge) whose
+// first request is an RFC 8441 extended CONNECT bootstrapping a WebSocket,
+// that is a HEADERS frame with ":method: CONNECT" and ":protocol: websocket".
+//
+// Clients only send an extended CONNECT once the server advertised the
+// SETTINGS_ENABLE_CONNECT_PROTOCOL setting, which a matcher can not do, so a
+// client waiting for it is left unmatched when the read timeout expires.
+func MatchWebSocketH2() Matcher {
+	return func(r io.Reader) bool {
+		fields, ok := readHTTP2Headers(r)
+		if !ok {
+			return false
+		}
+
+		var method, protocol string
+		for _, f := range fields {
+			switch f.Name {
+			case ":method":
+				method = f.Value
+			case ":protocol":
+				protocol = f.Value
+			}
+		}
+		return method == "CONNECT" && protocol == "websocket"
+	}
+}
+
+// readHTTP2Headers reads the connection preface and the frames which precede
+// the first header block, then decodes that block.
+func readHTTP2Headers(r io.Reader) ([]hpack.HeaderField, bool) {
+	r = limitHTTPHeaders(r)
+	preface := make([]byte, len(http2Preface))
+	if _, err := io.ReadFull(r, preface); err != nil || string(preface) != http2Preface {
+		return nil, false
+	}
+
+	var block []byte
+	open := false // Whether a header block was started, maybe with no fragment yet.
+	for i := 0; i < http2MaxFrames; i++ {
+		typ, flags, payload, ok := readHTTP2Frame(r)
+		if !ok {
+			return nil, false
+		}
+
+		switch {
+		case typ == http2FrameHeaders && !open:
+			if payload, ok = headersFragment(flags, payload); !ok {
+				return nil, false
+			}
+			open = true
+		case typ == http2FrameCont && open:
+		case open:
+			// Nothing but continuations may follow an unfinished header block
+			return nil, false
+		default:
+			// Skip the settings, window updates and such
+			continue
+		}
+
+		block = append(block, payload...)
+		if flags&http2FlagEndHeaders != 0 {
+			fields, err := hpack.NewDecoder(4096, nil).DecodeFull(block)
+			return fields, err == nil
+		}
+	}
+	return nil, false
+}
+
+// readHTTP2Frame reads a frame of at most the default max frame size.
+func readHTTP2Frame(r io.Reader) (typ, flags byte, payload []byte, ok bool) {
+	header := make([]byte, http2FrameHeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return
+	}
+
+	length := int(header[0])<<16 | int(header[1])<<8 | int(header[2])
+	if length > http2MaxFrameSize {
+		return
+	}
+
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return
+	}
+	return header[3], header[4], payload, true
+}
+
+// headersFragment strips the padding and priority fields of a HEADERS frame.
+func headersFragment(flags byte, payload []byte) ([]byte, bool) {
+	if flags&http2FlagPadded != 0 {
+		if len(payload) < 1 || int(payload[0]) >= len(payload) {
+			return nil, false
+		}
+		payload = payload[1 : len(payload)-int(payload[0])]
+	}
+
+	if flags&http2FlagPriority != 0 {
+		if len(payload) < 5 {
+			return nil, false
+		}
+		payload = payload[5:]
+	}
+	return payload, true
+}
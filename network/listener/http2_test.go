@@ -0,0 +1,83 @@
+package listener
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// h2cStream returns the connection preface, a SETTINGS frame and a header
+// block of the fields, split into fragments of at most split bytes if split is
+// positive, as an h2c client sends them.
+func h2cStream(split int, padded bool, fields ...string) string {
+	var block bytes.Buffer
+	enc := hpack.NewEncoder(&block)
+	for i := 0; i+1 < len(fields); i += 2 {
+		_ = enc.WriteField(hpack.HeaderField{Name: fields[i], Value: fields[i+1]})
+	}
+
+	var b bytes.Buffer
+	b.WriteString(http2.ClientPreface)
+	fr := http2.NewFramer(&b, nil)
+	_ = fr.WriteSettings()
+
+	fragment := block.Bytes()
+	if split <= 0 || split > len(fragment) {
+		split = len(fragment)
+	}
+	headers := http2.HeadersFrameParam{
+		StreamID:      1,
+		BlockFragment: fragment[:split],
+		EndHeaders:    split == len(fragment),
+	}
+	if padded {
+		headers.PadLength = 4
+		headers.Priority = http2.PriorityParam{StreamDep: 0, Weight: 15}
+	}
+	_ = fr.WriteHeaders(headers)
+	for rest := fragment[split:]; len(rest) > 0; {
+		n := split
+		if n > len(rest) {
+			n = len(rest)
+		}
+		_ = fr.WriteContinuation(1, n == len(rest), rest[:n])
+		rest = rest[n:]
+	}
+	return b.String()
+}
+
+// h2cEmptyHeaders returns the connection preface, a HEADERS frame with an empty
+// fragment and a CONTINUATION frame with the header block of the fields.
+func h2cEmptyHeaders(fields ...string) string {
+	var block bytes.Buffer
+	enc := hpack.NewEncoder(&block)
+	for i := 0; i+1 < len(fields); i += 2 {
+		_ = enc.WriteField(hpack.HeaderField{Name: fields[i], Value: fields[i+1]})
+	}
+
+	var b bytes.Buffer
+	b.WriteString(http2.ClientPreface)
+	fr := http2.NewFramer(&b, nil)
+	_ = fr.WriteHeaders(http2.HeadersFrameParam{StreamID: 1})
+	_ = fr.WriteContinuation(1, true, block.Bytes())
+	return b.String()
+}
+
+func TestMatchWebSocketH2(t *testing.T) {
+	connect := []string{":method", "CONNECT", ":protocol", "websocket", ":scheme", "http", ":path", "/chat", ":authority", "a"}
+	get := []string{":method", "GET", ":scheme", "http", ":path", "/", ":authority", "a"}
+	testMatcher(t, MatchWebSocketH2(), []matcherCase{
+		{"extended connect", h2cStream(0, false, connect...), true},
+		{"continuations", h2cStream(16, false, connect...), true},
+		{"too many frames", h2cStream(4, false, connect...), false},
+		{"padded with priority", h2cStream(0, true, connect...), true},
+		{"empty headers fragment", h2cEmptyHeaders(connect...), true},
+		{"h2c get", h2cStream(0, false, get...), false},
+		{"plain connect", h2cStream(0, false, ":method", "CONNECT", ":authority", "a:443"), false},
+		{"other protocol", h2cStream(0, false, ":method", "CONNECT", ":protocol", "webtransport"), false},
+		{"preface only", http2.ClientPreface, false},
+		{"http/1.1 upgrade", httpRequest("Upgrade: websocket"), false},
+	})
+}
@@ -0,0 +1,161 @@
+package listener
+
+import (
+	"strings"
+	"testing"
+)
+
+// httpRequest returns an HTTP/1.1 GET request with the header lines.
+func httpRequest(headers ...string) string {
+	return "GET /chat HTTP/1.1\r\n" + strings.Join(append(headers, ""), "\r\n") + "\r\n"
+}
+
+func TestMatchWebSocket(t *testing.T) {
+	testMatcher(t, MatchWebSocket(), []matcherCase{
+		{"upgrade", httpRequest("Host: a", "Connection: Upgrade", "Upgrade: websocket"), true},
+		{"case insensitive", httpRequest("upgrade:  WebSocket "), true},
+		{"h2c", httpRequest("Upgrade: h2c"), false},
+		{"no upgrade", httpRequest("Host: a"), false},
+		{"not http", "PING\r\n", false},
+	})
+}
+
+func TestMatchHTTPHost(t *testing.T) {
+	testMatcher(t, MatchHTTPHost("example.com", "*.example.net"), []matcherCase{
+		{"exact", httpRequest("Host: example.com"), true},
+		{"with port", httpRequest("Host: example.com:8080"), true},
+		{"wildcard", httpRequest("Host: api.example.net"), true},
+		{"other", httpRequest("Host: example.org"), false},
+		{"no host", httpRequest("Accept: */*"), false},
+	})
+}
+
+func TestMatchHTTPHeader(t *testing.T) {
+	match := MatchHTTPHeader("X-Tenant", func(v string) bool { return v == "acme" })
+	testMatcher(t, match, []matcherCase{
+		{"value", httpRequest("Host: a", "X-Tenant: acme"), true},
+		{"other value", httpRequest("X-Tenant: other"), false},
+		{"after the headers", httpRequest("Host: a") + "X-Tenant: acme\r\n", false},
+		{"malformed line", httpRequest("garbage", "X-Tenant: acme"), false},
+	})
+}
+
+func TestMaxSniffHeaderLines(t *testing.T) {
+	buried := httpRequest("A: 1", "B: 2", "C: 3", "Upgrade: websocket")
+	tests := []struct {
+		name  string
+		lines int
+		route string
+	}{
+		{"within the cap", 4, "ws"},
+		{"past the cap", 3, "fallback"},
+		{"default cap", 0, "ws"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMux(t)
+			if tt.lines > 0 {
+				m.SetMaxSniffHeaderLines(tt.lines)
+			}
+			routes := map[string]*Route{
+				"ws":       m.Route("ws", MatchWebSocket()),
+				"fallback": m.Route("fallback", MatchAny()),
+			}
+			m.serve()
+
+			m.dial(buried)
+			c := accept(t, routes[tt.route])
+			if got := readN(t, c, len(buried)); got != buried {
+				t.Errorf("handler read %q, want the request", got)
+			}
+		})
+	}
+}
+
+func TestMatchHTTPExpectContinue(t *testing.T) {
+	post := "POST /upload HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n"
+	testMatcher(t, MatchHTTPExpectContinue(), []matcherCase{
+		{"expect continue", post, true},
+		{"case insensitive", "PUT / HTTP/1.1\r\nexpect: 100-Continue\r\n\r\n", true},
+		{"normal request", httpRequest("Host: a", "Content-Length: 5"), false},
+		{"http 1.0", "POST / HTTP/1.0\r\nExpect: 100-continue\r\n\r\n", false},
+		{"other expectation", "POST / HTTP/1.1\r\nExpect: 200-ok\r\n\r\n", false},
+	})
+}
+
+func TestExpectContinueRoute(t *testing.T) {
+	m := newTestMux(t)
+	expect := m.Route("expect", MatchHTTPExpectContinue())
+	plain := m.Route("plain", MatchHTTP())
+	m.serve()
+
+	// The handler reads the whole request, the Expect header included
+	tests := []struct {
+		request string
+		route   *Route
+	}{
+		{"POST /upload HTTP/1.1\r\nHost: a\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n", expect},
+		{"POST /upload HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\n\r\nhello", plain},
+	}
+	for _, tt := range tests {
+		m.dial(tt.request)
+		if got := readN(t, accept(t, tt.route), len(tt.request)); got != tt.request {
+			t.Errorf("%s handler read %q, want %q", tt.route.Name(), got, tt.request)
+		}
+	}
+}
+
+func TestMaxHTTPHeaderSniffBytes(t *testing.T) {
+	padding := "X-Padding: " + strings.Repeat("a", 1024)
+	matchers := map[string]Matcher{
+		"websocket": MatchWebSocket(),
+		"host":      MatchHTTPHost("example.com"),
+		"header":    MatchHTTPHeader("Upgrade", func(string) bool { return true }),
+	}
+	for name, matcher := range matchers {
+		t.Run(name, func(t *testing.T) {
+			m := newTestMux(t)
+			m.SetMaxHTTPHeaderSniffBytes(512)
+			r := m.Route(name, matcher)
+			fallback := m.Route("fallback", MatchAny())
+			m.serve()
+
+			small := httpRequest("Host: example.com", "Upgrade: websocket")
+			m.dial(small)
+			readN(t, accept(t, r), len(small))
+
+			// The wanted headers come after the cap
+			large := httpRequest(padding, "Host: example.com", "Upgrade: websocket")
+			m.dial(large)
+			if got := readN(t, accept(t, fallback), len(large)); got != large {
+				t.Error("fallback handler did not read the request")
+			}
+		})
+	}
+}
+
+func TestMatchHTTPPath(t *testing.T) {
+	testMatcher(t, MatchHTTPPath("/api/v1/write", "/push/*"), []matcherCase{
+		{"exact", "POST /api/v1/write HTTP/1.1\r\nHost: a\r\n\r\n", true},
+		{"query", "POST /api/v1/write?tenant=a HTTP/1.1\r\n\r\n", true},
+		{"absolute form", "POST http://a:9090/api/v1/write HTTP/1.1\r\n\r\n", true},
+		{"prefix", "PUT /push/metrics/job/a HTTP/1.1\r\n\r\n", true},
+		{"other path", "GET /metrics HTTP/1.1\r\n\r\n", false},
+		{"exact is not a prefix", "POST /api/v1/write/extra HTTP/1.1\r\n\r\n", false},
+		{"prefix without slash", "PUT /push HTTP/1.1\r\n\r\n", false},
+		{"http/2 preface", "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n", false},
+		{"not http", "/api/v1/write\r\n", false},
+	})
+}
+
+func TestMatchHTTPContentType(t *testing.T) {
+	post := func(headers ...string) string {
+		return "POST / HTTP/1.1\r\n" + strings.Join(append(headers, ""), "\r\n") + "\r\n"
+	}
+	testMatcher(t, MatchHTTPContentType("application/ocsp-request"), []matcherCase{
+		{"ocsp", post("Host: ocsp.example.com", "Content-Type: application/ocsp-request", "Content-Length: 83"), true},
+		{"case and parameters", post("content-type: Application/OCSP-Request; charset=binary"), true},
+		{"json", post("Content-Type: application/json"), false},
+		{"no content type", post("Host: ocsp.example.com", "Content-Length: 83"), false},
+	})
+}
@@ -0,0 +1,66 @@
+package listener
+
+import (
+	"context"
+	"net"
+	"net/http"
+)
+
+// connContextKey is the context key of the connection of an HTTP request.
+type connContextKey struct{}
+
+// ConnContext is a ConnContext callback for an http.Server serving a route. It
+// stores the connection of the listener in the context of the requests, where
+// handlers get it with ConnFromContext. Combined with ConnState, the ID and the
+// route of the connection correlate the requests, the states of the server and
+// the events of the listener:
+//
+//	srv := &http.Server{
+//		Handler:     handler,
+//		ConnContext: listener.ConnContext,
+//		ConnState: listener.ConnState(func(c *listener.Conn, state http.ConnState) {
+//			log.Printf("conn %s on %s: %v", c.ID(), c.Route(), state)
+//		}),
+//	}
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if muc, ok := AsConn(c); ok {
+		return context.WithValue(ctx, connContextKey{}, muc)
+	}
+	return ctx
+}
+
+// ConnFromContext returns the connection stored in the context by ConnContext.
+func ConnFromContext(ctx context.Context) (*Conn, bool) {
+	c, ok := ctx.Value(connContextKey{}).(*Conn)
+	return c, ok
+}
+
+// ConnState returns a ConnState callback for an http.Server serving a route,
+// which calls fn with the connection of the listener for every state change.
+// The changes of the connections which do not come from a listener are ignored.
+func ConnState(fn func(c *Conn, state http.ConnState)) func(net.Conn, http.ConnState) {
+	return func(c net.Conn, state http.ConnState) {
+		if muc, ok := AsConn(c); ok {
+			fn(muc, state)
+		}
+	}
+}
+
+// AsConn returns the connection of the listener a net.Conn served by a route
+// is, or wraps: a TLS connection terminated by the listener, or any wrapper
+// exposing the connection it wraps with a NetConn method, like *tls.Conn.
+func AsConn(c net.Conn) (*Conn, bool) {
+	for c != nil {
+		switch v := c.(type) {
+		case *Conn:
+			return v, true
+		case *handshakeConn:
+			return v.Conn, true
+		case interface{ NetConn() net.Conn }:
+			c = v.NetConn()
+		default:
+			return nil, false
+		}
+	}
+	return nil, false
+}
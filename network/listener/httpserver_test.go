@@ -0,0 +1,66 @@
+package listener
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestHTTPServerCorrelation(t *testing.T) {
+	m := newTestMux(t)
+	r := m.Route("api", MatchHTTP())
+	m.serve()
+
+	var mu sync.Mutex
+	var events []string
+	record := func(format string, args ...interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, fmt.Sprintf(format, args...))
+	}
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if c, ok := ConnFromContext(req.Context()); ok {
+				record("request %s %s", c.ID(), c.Route())
+			}
+		}),
+		ConnContext: ConnContext,
+		ConnState: ConnState(func(c *Conn, state http.ConnState) {
+			record("%v %s %s", state, c.ID(), c.Route())
+		}),
+	}
+	go func() { _ = srv.Serve(r) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	client := m.httpClient()
+	resp, err := client.Get("http://mux/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	client.CloseIdleConnections()
+	closed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(events) > 0 && strings.HasPrefix(events[len(events)-1], "closed")
+	}
+	waitFor(t, "the connection to be closed", closed)
+
+	mu.Lock()
+	defer mu.Unlock()
+	var id string
+	if len(events) > 0 {
+		fmt.Sscanf(events[0], "new %s", &id)
+	}
+	want := []string{"new", "active", "request", "idle", "closed"}
+	if len(events) != len(want) || id == "" {
+		t.Fatalf("events = %q, want %q", events, want)
+	}
+	for i, e := range events {
+		if e != fmt.Sprintf("%s %s api", want[i], id) {
+			t.Errorf("event %d = %q, want %s of connection %s on api", i, e, want[i], id)
+		}
+	}
+}
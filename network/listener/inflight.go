@@ -0,0 +1,118 @@
+package listener
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/numb3r3/live-go/log"
+)
+
+// ErrMatchCanceled is the error reported for the connections whose match was
+// canceled with CancelMatch.
+var ErrMatchCanceled error = errRejected("mux: match canceled")
+
+// ConnInfo describes a connection being matched, or served for the leak
+// reports of handlers.
+type ConnInfo struct {
+	ID         string    // The identifier of the connection.
+	RemoteAddr string    // The address of the client.
+	Started    time.Time // When the match started, or the connection was accepted if served.
+	Matcher    string    // The name of the matcher being tried.
+	Route      string    // The name of the route serving the connection.
+}
+
+// MatchingConnections returns the connections being matched, oldest first, so
+// the ones stuck sniffing, like a flood of stalled handshakes, can be spotted.
+func (m *Listener) MatchingConnections() []ConnInfo {
+	m.inflight.Lock()
+	infos := make([]ConnInfo, 0, len(m.inflight.matches))
+	for _, im := range m.inflight.matches {
+		infos = append(infos, ConnInfo{
+			ID:         im.conn.id,
+			RemoteAddr: remoteAddr(im.conn),
+			Started:    im.started,
+			Matcher:    im.matcher,
+		})
+	}
+	m.inflight.Unlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Started.Before(infos[j].Started)
+	})
+	return infos
+}
+
+// CancelMatch aborts the match of a connection, given its ID, and closes it.
+// The error handler gets ErrMatchCanceled for it. It returns false if the
+// connection is not being matched.
+func (m *Listener) CancelMatch(connID string) bool {
+	m.inflight.Lock()
+	im, ok := m.inflight.matches[connID]
+	if ok {
+		im.canceled = true
+	}
+	m.inflight.Unlock()
+
+	if ok {
+		_ = im.conn.Close()
+	}
+	return ok
+}
+
+// inflight keeps track of the connections being matched.
+type inflight struct {
+	sync.Mutex
+	matches map[string]*inflightMatch
+}
+
+// inflightMatch is the state of a connection being matched.
+type inflightMatch struct {
+	conn     *Conn
+	started  time.Time
+	matcher  string // The name of the matcher being tried.
+	canceled bool
+}
+
+// add registers a connection whose match starts.
+func (f *inflight) add(c *Conn) *inflightMatch {
+	im := &inflightMatch{conn: c, started: time.Now()}
+	f.Lock()
+	defer f.Unlock()
+	if f.matches == nil {
+		f.matches = make(map[string]*inflightMatch)
+	}
+	f.matches[c.id] = im
+	return im
+}
+
+// remove forgets a connection whose match is over.
+func (f *inflight) remove(im *inflightMatch) {
+	f.Lock()
+	defer f.Unlock()
+	delete(f.matches, im.conn.id)
+}
+
+// try records the name of the matcher about to be tried, and returns false if
+// the match was canceled.
+func (f *inflight) try(im *inflightMatch, matcher string) bool {
+	f.Lock()
+	defer f.Unlock()
+	im.matcher = matcher
+	return !im.canceled
+}
+
+// canceled returns whether the match was canceled.
+func (f *inflight) canceled(im *inflightMatch) bool {
+	f.Lock()
+	defer f.Unlock()
+	return im.canceled
+}
+
+// cancelConn reports a connection whose match was canceled.
+func (m *Listener) cancelConn(c *Conn) error {
+	_ = c.Close()
+	logging.Debugf("connection from %v: match canceled", c.RemoteAddr())
+	_ = m.handleErr(ErrMatchCanceled)
+	return ErrMatchCanceled
+}
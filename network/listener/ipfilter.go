@@ -0,0 +1,177 @@
+package listener
+
+import (
+	"net"
+	"strings"
+)
+
+// ErrIPBlocked is the error the connections from an address refused by the IP
+// allowlist or blocklist are closed with.
+var ErrIPBlocked error = errRejected("mux: address blocked")
+
+// IPClass is the scope of an IP address.
+type IPClass int
+
+// The classes of IP addresses.
+const (
+	IPGlobal      IPClass = iota // Any other address.
+	IPLoopback                   // 127.0.0.0/8 and ::1.
+	IPLinkLocal                  // 169.254.0.0/16 and fe80::/10.
+	IPUniqueLocal                // The IPv6 unique local addresses, fc00::/7.
+	IPPrivate                    // The IPv4 private networks, 10.0.0.0/8, 172.16.0.0/12 and 192.168.0.0/16.
+)
+
+// String returns the name of the class.
+func (c IPClass) String() string {
+	switch c {
+	case IPLoopback:
+		return "loopback"
+	case IPLinkLocal:
+		return "link-local"
+	case IPUniqueLocal:
+		return "unique-local"
+	case IPPrivate:
+		return "private"
+	}
+	return "global"
+}
+
+// ClassifyIP returns the class of the IP address. The IPv4-mapped IPv6
+// addresses are classified as their IPv4 address.
+func ClassifyIP(ip net.IP) IPClass {
+	switch {
+	case ip.IsLoopback():
+		return IPLoopback
+	case ip.IsLinkLocalUnicast(), ip.IsLinkLocalMulticast():
+		return IPLinkLocal
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		switch {
+		case ip4[0] == 10, ip4[0] == 172 && ip4[1]&0xf0 == 16, ip4[0] == 192 && ip4[1] == 168:
+			return IPPrivate
+		}
+		return IPGlobal
+	}
+	if len(ip) == net.IPv6len && ip[0]&0xfe == 0xfc {
+		return IPUniqueLocal
+	}
+	return IPGlobal
+}
+
+// ipRules are the IP address settings the connections are accepted by.
+type ipRules struct {
+	allow     []*net.IPNet // The networks accepted, all if empty.
+	block     []*net.IPNet // The networks rejected.
+	linkLocal bool         // Whether the link-local addresses are always accepted.
+}
+
+// SetIPAllowlist restricts the connections accepted to the ones from the
+// networks, given in CIDR notation like "10.0.0.0/8" or "fd00::/8", or as single
+// addresses. The others are closed with ErrIPBlocked, without sniffing nor any
+// response. No network, the default, accepts all the addresses.
+func (m *Listener) SetIPAllowlist(networks ...string) error {
+	nets, err := parseNetworks(networks)
+	if err != nil {
+		return err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	m.ipRules.allow = nets
+	return nil
+}
+
+// SetIPBlocklist rejects the connections from the networks, given like for
+// SetIPAllowlist, with ErrIPBlocked, even if the allowlist has them. No network,
+// the default, blocks nothing.
+func (m *Listener) SetIPBlocklist(networks ...string) error {
+	nets, err := parseNetworks(networks)
+	if err != nil {
+		return err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	m.ipRules.block = nets
+	return nil
+}
+
+// AllowLinkLocal sets whether the connections from the link-local addresses,
+// 169.254.0.0/16 and fe80::/10, such as the ones of the services of the host,
+// are always accepted, whatever the IP allowlist, the IP blocklist and the
+// unmatched autoban say. The unique local addresses, fc00::/7, are not
+// link-local: they are listed like the global ones.
+func (m *Listener) AllowLinkLocal(allow bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.ipRules.linkLocal = allow
+}
+
+// exempt returns whether the address bypasses the IP rules and the autoban.
+func (r *ipRules) exempt(ip net.IP) bool {
+	return r.linkLocal && ip != nil && ClassifyIP(ip) == IPLinkLocal
+}
+
+// allowed returns whether the IP rules accept the address. The connections of
+// unknown addresses, such as the in-memory ones, are accepted unless there's an
+// allowlist.
+func (r *ipRules) allowed(ip net.IP) bool {
+	if r.exempt(ip) {
+		return true
+	}
+	if ip == nil {
+		return len(r.allow) == 0
+	}
+
+	for _, n := range r.block {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(r.allow) == 0 {
+		return true
+	}
+	for _, n := range r.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseNetworks parses networks in CIDR notation or single addresses.
+func parseNetworks(networks []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, network := range networks {
+		if !strings.Contains(network, "/") {
+			ip := net.ParseIP(network)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: network}
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(network)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// parseRemoteIP returns the IP address of the remote end of the connection,
+// without the zone of a link-local address, or nil if it has none.
+func parseRemoteIP(c net.Conn) net.IP {
+	host := remoteIP(c)
+	if i := strings.IndexByte(host, '%'); i >= 0 {
+		host = host[:i]
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,116 @@
+package listener
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestClassifyIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want IPClass
+	}{
+		{"127.0.0.1", IPLoopback},
+		{"::1", IPLoopback},
+		{"169.254.10.1", IPLinkLocal},
+		{"fe80::1", IPLinkLocal},
+		{"ff02::1", IPLinkLocal},
+		{"fd00::1", IPUniqueLocal},
+		{"fc00::1", IPUniqueLocal},
+		{"10.1.2.3", IPPrivate},
+		{"172.16.0.1", IPPrivate},
+		{"172.32.0.1", IPGlobal},
+		{"192.168.1.1", IPPrivate},
+		{"::ffff:192.168.1.1", IPPrivate},
+		{"8.8.8.8", IPGlobal},
+		{"2001:db8::1", IPGlobal},
+	}
+	for _, tt := range tests {
+		if got := ClassifyIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("ClassifyIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestIPRules(t *testing.T) {
+	const linkLocal, uniqueLocal = "fe80::1", "fd00::1"
+	tests := []struct {
+		name      string
+		allow     []string
+		block     []string
+		linkLocal bool
+		want      map[string]bool
+	}{
+		{"no rules", nil, nil, false, map[string]bool{linkLocal: true, uniqueLocal: true}},
+		{"allowlist", []string{"10.0.0.0/8"}, nil, false, map[string]bool{linkLocal: false, uniqueLocal: false}},
+		{"allowlist and link-local", []string{"10.0.0.0/8"}, nil, true, map[string]bool{linkLocal: true, uniqueLocal: false}},
+		{"unique-local allowed", []string{"fd00::/8"}, nil, true, map[string]bool{linkLocal: true, uniqueLocal: true}},
+		{"blocklist", nil, []string{"fe80::/10", "fc00::/7"}, false, map[string]bool{linkLocal: false, uniqueLocal: false}},
+		{"blocklist and link-local", nil, []string{"fe80::/10", "fc00::/7"}, true, map[string]bool{linkLocal: true, uniqueLocal: false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allow, err := parseNetworks(tt.allow)
+			if err != nil {
+				t.Fatal(err)
+			}
+			block, err := parseNetworks(tt.block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rules := ipRules{allow: allow, block: block, linkLocal: tt.linkLocal}
+			for ip, want := range tt.want {
+				if got := rules.allowed(net.ParseIP(ip)); got != want {
+					t.Errorf("allowed(%s) = %v, want %v", ip, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAllowLinkLocal(t *testing.T) {
+	mem := NewMemoryListener()
+	addrs := []string{"[fe80::1]:4000", "[fd00::1]:4000", "[2001:db8::1]:4000"}
+	m := &testMux{Listener: New(&addrListener{Listener: mem, addrs: addrs}), t: t, dialer: mem, mem: mem}
+	t.Cleanup(func() { _ = m.Close() })
+	if err := m.SetIPAllowlist("2001:db8::/32"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetIPBlocklist("fe80::/10"); err != nil {
+		t.Fatal(err)
+	}
+	m.AllowLinkLocal(true)
+	r := m.Route("r", MatchAny())
+	m.serve()
+
+	// The link-local client bypasses both lists, the unique local one does not
+	m.dial("L")
+	if got := readN(t, accept(t, r), 1); got != "L" {
+		t.Errorf("handler read %q, want the link-local client", got)
+	}
+	expectClosed(t, m.dial("U"))
+	m.dial("G")
+	if got := readN(t, accept(t, r), 1); got != "G" {
+		t.Errorf("handler read %q, want the allowed client", got)
+	}
+	expectNoAccept(t, r, 20*time.Millisecond)
+}
+
+func TestParseNetworks(t *testing.T) {
+	nets, err := parseNetworks([]string{"10.0.0.0/8", "fd00::/8", "192.168.1.7", "fe80::1"})
+	if err != nil {
+		t.Fatalf("parseNetworks() = %v", err)
+	}
+	want := []string{"10.0.0.0/8", "fd00::/8", "192.168.1.7/32", "fe80::1/128"}
+	for i, n := range nets {
+		if n.String() != want[i] {
+			t.Errorf("network %d = %v, want %s", i, n, want[i])
+		}
+	}
+	for _, bad := range []string{"10.0.0.0/33", "example.com"} {
+		if _, err := parseNetworks([]string{bad}); err == nil {
+			t.Errorf("parseNetworks(%q) = nil error, want one", bad)
+		}
+	}
+}
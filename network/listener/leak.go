@@ -0,0 +1,77 @@
+package listener
+
+import "time"
+
+// minLeakScanInterval bounds how often the served connections are scanned for
+// leaked handlers.
+const minLeakScanInterval = 10 * time.Millisecond
+
+// SetHandlerLeakThreshold sets a function called with the connections served
+// for longer than d since they were accepted, as a safety net for the handlers
+// which never return: the served connections are scanned every quarter of d,
+// and each is reported once, with the route serving it. The connections are
+// only reported, never closed; SetHandleDeadline closes them. A zero duration
+// or a nil function stops the scans.
+func (m *Listener) SetHandlerLeakThreshold(d time.Duration, fn func(ConnInfo)) {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.leakScan != nil {
+		close(m.leakScan)
+		m.leakScan = nil
+	}
+	if d <= 0 || fn == nil {
+		return
+	}
+
+	m.hooks.leakReporter.reset()
+	m.leakScan = make(chan struct{})
+	go m.scanLeaks(d, fn, m.leakScan, m.closing)
+}
+
+// scanLeaks reports the connections served for longer than the threshold,
+// until stop or closing is closed.
+func (m *Listener) scanLeaks(threshold time.Duration, fn func(ConnInfo), stop, closing <-chan struct{}) {
+	interval := threshold / 4
+	if interval < minLeakScanInterval {
+		interval = minLeakScanInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	reported := make(map[string]bool)
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		case <-closing:
+			return
+		}
+
+		now := time.Now()
+		served := make(map[string]bool, len(reported))
+		for _, c := range m.ActiveConnections() {
+			served[c.ID] = true
+			if c.Age < threshold || reported[c.ID] {
+				continue
+			}
+
+			reported[c.ID] = true
+			info := ConnInfo{
+				ID:         c.ID,
+				RemoteAddr: c.RemoteAddr,
+				Started:    now.Add(-c.Age),
+				Route:      c.Route,
+			}
+			m.hooks.leakReporter.call("handler leak reporter", func() { fn(info) })
+		}
+
+		// Forget the connections closed since
+		for id := range reported {
+			if !served[id] {
+				delete(reported, id)
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package listener
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHandlerLeakThreshold(t *testing.T) {
+	m := newTestMux(t)
+	leaks := make(chan ConnInfo, 4)
+	m.SetHandlerLeakThreshold(50*time.Millisecond, func(info ConnInfo) { leaks <- info })
+	stuck := m.Route("stuck", MatchPrefix("S"))
+	quick := m.Route("quick", MatchPrefix("Q"))
+	m.serve()
+
+	start := time.Now()
+	client := m.dial("S")
+	held, _ := AsConn(accept(t, stuck))
+	m.dial("Q")
+	_ = accept(t, quick).Close()
+
+	select {
+	case info := <-leaks:
+		if info.ID != held.ID() || info.Route != "stuck" {
+			t.Errorf("reported %s on %s, want %s on stuck", info.ID, info.Route, held.ID())
+		}
+		if d := time.Since(start); d < 50*time.Millisecond {
+			t.Errorf("reported after %v, want the 50ms threshold", d)
+		}
+		if info.Started.Before(start.Add(-time.Second)) || info.Started.After(time.Now()) {
+			t.Errorf("Started = %v, want about %v", info.Started, start)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("stuck handler not reported")
+	}
+
+	// Each connection is only reported once, and never closed
+	select {
+	case info := <-leaks:
+		t.Errorf("reported %s on %s again", info.ID, info.Route)
+	case <-time.After(100 * time.Millisecond):
+	}
+	go func() { _, _ = held.Write([]byte("x")) }()
+	if got := readN(t, client, 1); got != "x" {
+		t.Errorf("client read %q, want the connection still served", got)
+	}
+}
+
+func TestHandlerLeakThresholdStop(t *testing.T) {
+	m := newTestMux(t)
+	leaks := make(chan ConnInfo, 1)
+	m.SetHandlerLeakThreshold(20*time.Millisecond, func(info ConnInfo) { leaks <- info })
+	m.SetHandlerLeakThreshold(0, nil)
+	r := m.Route("stuck", MatchAny())
+	m.serve()
+
+	m.dial("S")
+	accept(t, r)
+	select {
+	case info := <-leaks:
+		t.Errorf("reported %s once the scans were stopped", info.ID)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -1,11 +1,12 @@
 package listener
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/numb3r3/live-go/log"
@@ -48,6 +49,36 @@ func (e errListenerClosed) Timeout() bool   { return false }
 // listener is closed.
 var ErrListenerClosed = errListenerClosed("mux: listener closed")
 
+// ErrTooManyRoutes is returned when registering a route would exceed the
+// maximum number of routes of the listener.
+var ErrTooManyRoutes = errors.New("mux: too many routes")
+
+// ErrTooManyConnections is the error a connection is closed with when the
+// maximum number of active connections is reached.
+var ErrTooManyConnections error = errRejected("mux: too many connections")
+
+type errRejected string
+
+func (e errRejected) Error() string   { return string(e) }
+func (e errRejected) Temporary() bool { return true }
+func (e errRejected) Timeout() bool   { return false }
+
+// ErrNoMatchers is returned by Serve when no matcher is registered, as every
+// connection would be dropped.
+var ErrNoMatchers = errors.New("mux: no matchers registered")
+
+// ErrHandleDeadline is reported for the connections closed as they reached
+// the handle deadline of their route.
+var ErrHandleDeadline = errors.New("mux: connection handle deadline exceeded")
+
+// ErrIdleTimeout is reported for the served connections closed as they went
+// without reads or writes for the IdleTimeout of the options.
+var ErrIdleTimeout = errors.New("mux: connection idle timeout exceeded")
+
+// ErrCloseTimeout is returned by CloseTimeout when the root listener does not
+// close in time.
+var ErrCloseTimeout = errors.New("mux: timed out closing the listener")
+
 // for readability of readTimeout
 var noTimeout time.Duration
 
@@ -62,24 +93,102 @@ func NewListener(address string) (*Listener, error) {
 		return nil, err
 	}
 
-	return &Listener{
-		root:         l,
-		bufferSize:   1024,
-		connections:  make(chan net.Conn, 1024),
-		errorHandler: func(_ error) bool { return true },
-		closing:      make(chan struct{}),
-		readTimeout:  noTimeout,
-	}, nil
+	return New(l), nil
+}
+
+// New creates a listener multiplexing the connections accepted by the root
+// listener.
+func New(root net.Listener) *Listener {
+	m := &Listener{
+		root:           newRootListener(root),
+		errorHandler:   func(_ error) bool { return true },
+		closing:        make(chan struct{}),
+		backoffReset:   make(chan struct{}, 1),
+		order:          newSequencer(),
+		counters:       newCounters(),
+		maxHeaderLines: defaultMaxHeaderLines,
+	}
+	m.options.Store(defaultOptions)
+	return m
 }
 
 // Listener represents a listener used for multiplexing protocols.
 type Listener struct {
-	root         net.Listener
-	bufferSize   int
-	connections  chan net.Conn
-	errorHandler ErrorHandler
-	closing      chan struct{}
-	readTimeout  time.Duration
+	sync.RWMutex
+	sampleSeq      int64 // The connections subject to the sampling of the logs, accessed atomically.
+	limited        int64 // The active connections which are not exempt from the limits, accessed atomically.
+	acceptBackoff  int64 // The delay of the accept loop after failed accepts, accessed atomically.
+	draining       int32 // Whether new connections are rejected, accessed atomically.
+	serving        int32 // Whether Serve is accepting connections, accessed atomically.
+	lameDuck       int32 // Whether Shutdown is in progress, 1, or done, 2, accessed atomically.
+	root           *rootListener
+	options        atomic.Value // The current Options, loaded once per connection.
+	errorHandler   ErrorHandler
+	closing        chan struct{}
+	backoffReset   chan struct{} // Signaled to stop the backoff of the accept loop.
+	matchers       []processor
+	routes         []*Route
+	servers        sync.WaitGroup
+	skipLeading    bool // Whether matchers skip a leading byte order mark and whitespace.
+	consumeLead    bool // Whether the skipped bytes are also dropped for the handler.
+	sink           *eventSink
+	tarpit         time.Duration // The delay before unmatched connections are closed.
+	drainProgress  func(remaining int)
+	peek           bool // Whether matchers peek the socket instead of buffering.
+	ordered        bool // Whether connections are dispatched in accept order.
+	order          *sequencer
+	maxRoutes      int
+	counters       *counters
+	maxHeaderLines int
+	maxHeaderBytes int
+	minTLSVersion  uint16
+	tracer         Tracer
+	backpressure   func(route string)
+	sniffPrealloc  int
+	matching       chan struct{} // The semaphore bounding the connections being matched.
+	rejectHTTP     []byte        // The response sent to rejected HTTP clients.
+	capacityHTTP   []byte        // The response sent to HTTP clients over the connection limit.
+	rejectMode     RejectMode
+	rejectWrite    time.Duration // The write deadline of the reject responses.
+	sessionID      func(sniffed []byte) (string, bool)
+	traceFilter    func(net.Conn) bool
+	rematching     sync.WaitGroup // The connections being matched again.
+	delivering     sync.WaitGroup // The connections being delivered by the dispatchers.
+	accepting      sync.WaitGroup // Serve, until it stops matching connections.
+	autoban        *autoban
+	inflight       inflight // The connections being matched.
+	preAuth        func(sniffed []byte) (bool, int)
+	sampleRate     float64 // The fraction of the connections whose accept and match are logged.
+	sampling       bool    // Whether the logs are sampled.
+	chaos          *ChaosConfig
+	compression    func(sniffed []byte) (string, bool)
+	frameObserver  func(connID string, opcode byte)
+	stateChange    func(from, to State)
+	stateMu        sync.Mutex // Serializes the state change notifications.
+	lastState      State      // The state last notified.
+	sniffMemory    *sniffBudget
+	sniffPolicy    SniffMemoryPolicy
+	ipRules        ipRules
+	leakScan       chan struct{} // Closed to stop the scans for leaked handlers.
+	tap            *tap
+	tapWriter      io.Writer
+	tapFormat      TapFormat
+	dumpBytes      int
+	dumpRedactor   func([]byte) []byte
+	hooks          hookGuards // The panic guards of the user-provided hooks.
+	tlsHandshakes  *semaphore
+	tlsPolicy      HandshakePolicy
+	scoring        bool // Whether the connections go to the highest scoring matcher.
+	exemption      func(net.Conn) bool
+	dispatcher     Dispatcher
+}
+
+// processor binds a matcher to the route it dispatches to.
+type processor struct {
+	matcher Matcher
+	scorer  Scorer // The matcher, or the scorer it was derived from.
+	listen  *Route
+	name    string // The name the matcher is described by.
 }
 
 // Accept waits for and returns the next connection to the listener.
@@ -87,73 +196,582 @@ func (m *Listener) Accept() (net.Conn, error) {
 	return m.root.Accept()
 }
 
+// RootListener returns the listener the connections are accepted from, for
+// composing with libraries which need the raw net.Listener. The connections
+// accepted from it directly bypass the multiplexer, so they are not matched.
+// It is the current socket, which Rebind replaces.
+func (m *Listener) RootListener() net.Listener {
+	return m.root.current()
+}
+
+// Match returns a net.Listener that sees (i.e., accepts) only
+// the connections matched by at least one of the matcher.
+func (m *Listener) Match(matchers ...Matcher) net.Listener {
+	return m.Route("", matchers...)
+}
+
+// Route registers a named route and returns its virtual listener, which sees
+// only the connections matched by at least one of the matchers. An empty name
+// is replaced by a generated one.
+//
+// If the route can not be registered, the returned route is closed and its
+// Accept method returns the error; use AddRoute to get the error directly.
+func (m *Listener) Route(name string, matchers ...Matcher) *Route {
+	r, err := m.AddRoute(name, matchers...)
+	if err != nil {
+		r = newRoute(name, m.root, 0)
+		r.err = err
+		close(r.connections)
+	}
+	return r
+}
+
+// AddRoute registers a named route like Route does, but returns
+// ErrTooManyRoutes if the maximum number of routes is reached.
+func (m *Listener) AddRoute(name string, matchers ...Matcher) (*Route, error) {
+	scorers := make([]Scorer, len(matchers))
+	for i, matcher := range matchers {
+		scorers[i] = matcher
+	}
+	return m.addRoute(name, scorers)
+}
+
+// addRoute registers a named route matched by the scorers.
+func (m *Listener) addRoute(name string, scorers []Scorer) (*Route, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.maxRoutes > 0 && len(m.routes) >= m.maxRoutes {
+		return nil, ErrTooManyRoutes
+	}
+
+	if name == "" {
+		name = fmt.Sprintf("route-%d", len(m.routes))
+	}
+
+	r := newRoute(name, m.root, m.Options().BufferSize)
+	r.mux = m
+	for _, scorer := range scorers {
+		matcher, ok := scorer.(Matcher)
+		if !ok {
+			matcher = scoreMatcher(scorer)
+		}
+		m.matchers = append(m.matchers, processor{matcher: matcher, scorer: scorer, listen: r, name: matcherName(scorer)})
+	}
+	m.routes = append(m.routes, r)
+	return r, nil
+}
+
+// removeMatchers unregisters the matchers of the route, so no connection is
+// matched for it anymore. The route stays registered, for its stats.
+func (m *Listener) removeMatchers(r *Route) {
+	m.Lock()
+	defer m.Unlock()
+
+	matchers := make([]processor, 0, len(m.matchers))
+	for _, p := range m.matchers {
+		if p.listen != r {
+			matchers = append(matchers, p)
+		}
+	}
+	m.matchers = matchers
+}
+
+// SetMaxRoutes sets the maximum number of routes which can be registered, as a
+// safety rail when routes are built from configuration. Zero means no limit.
+func (m *Listener) SetMaxRoutes(n int) {
+	m.Lock()
+	defer m.Unlock()
+	m.maxRoutes = n
+}
+
+// MatchWithTransform registers a named route whose matched connections are
+// wrapped by the transform before they reach the handler.
+func (m *Listener) MatchWithTransform(name string, transform func(net.Conn) net.Conn, matchers ...Matcher) *Route {
+	r := m.Route(name, matchers...)
+	r.SetTransform(transform)
+	return r
+}
+
+// MatchWithHandleDeadline registers a named route whose matched connections
+// are closed once they have been handled for d, active or not.
+func (m *Listener) MatchWithHandleDeadline(d time.Duration, name string, matchers ...Matcher) *Route {
+	r := m.Route(name, matchers...)
+	r.SetHandleDeadline(d)
+	return r
+}
+
+// Handle registers a named route and serves its virtual listener with the
+// server. The server is stopped once the listener stops serving, and Serve
+// waits for it to return.
+func (m *Listener) Handle(name string, srv Server, matchers ...Matcher) *Route {
+	r := m.Route(name, matchers...)
+	m.servers.Add(1)
+	go func() {
+		defer m.servers.Done()
+		srv.Serve(r)
+	}()
+	return r
+}
+
 // ServeAsync adds a protocol based on the matcher and serves it.
-func (m *Listener) ServeAsync(serve func(l net.Listener) error) {
-	// ml := muxListener{
-	// 	Listener:    m.root,
-	// 	connections: make(chan net.Conn, m.bufferSize),
-	// }
-	// go serve(ml)
-	go serve(m.root)
+func (m *Listener) ServeAsync(matcher Matcher, serve func(l net.Listener) error) {
+	m.Handle("", ServerFunc(serve), matcher)
 }
 
 // SetReadTimeout sets a timeout for the read of matchers.
 func (m *Listener) SetReadTimeout(t time.Duration) {
-	m.readTimeout = t
+	m.updateOptions(func(o *Options) {
+		o.ReadTimeout = t
+	})
+}
+
+// SetSkipLeadingWhitespace sets whether a leading UTF-8 byte order mark and
+// whitespace are skipped while sniffing, so the text matchers see the actual
+// first token. The skipped bytes are still replayed to the handler.
+func (m *Listener) SetSkipLeadingWhitespace(skip bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.skipLeading = skip
 }
 
-// Serve starts multiplexing the listener.
+// SetConsumeLeadingWhitespace sets whether the bytes skipped by
+// SetSkipLeadingWhitespace are dropped instead of being replayed to the handler.
+func (m *Listener) SetConsumeLeadingWhitespace(consume bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.consumeLead = consume
+}
+
+// SetUnmatchedCloseDelay sets how long unmatched connections are held, without
+// being read, before they get closed. This throttles scanners which reconnect
+// as soon as they are dropped, at the cost of a goroutine and a descriptor per
+// held connection. Closing the listener interrupts the delay.
+func (m *Listener) SetUnmatchedCloseDelay(d time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.tarpit = d
+}
+
+// SetPeekSniffing sets whether matchers peek the incoming bytes of the socket
+// with MSG_PEEK, where supported, instead of copying them into a buffer which
+// is replayed to the handler. Connections which can not be peeked, on other
+// platforms or wrapping other transports, still use the buffering sniffer.
+//
+// Peeked bytes stay in the socket receive buffer, so matchers may peek up to
+// 64KB and a matcher waiting for more bytes than the client sends is only
+// released by the read timeout.
+func (m *Listener) SetPeekSniffing(enabled bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.peek = enabled
+}
+
+// Serve starts multiplexing the listener. It returns ErrNoMatchers right away,
+// without closing the listener, if no route has been registered yet.
 func (m *Listener) Serve() error {
+	m.RLock()
+	empty := len(m.matchers) == 0
+	m.RUnlock()
+	if empty {
+		return ErrNoMatchers
+	}
+
 	var wg sync.WaitGroup
 
+	m.accepting.Add(1)
+	atomic.StoreInt32(&m.serving, 1)
+	m.notifyState()
+	stopSampling := m.sampleListenOverflows()
 	defer func() {
+		// The root listener is closed, and nothing more gets accepted. The
+		// connections being matched are dispatched, or closed if their
+		// route's queue is full, before the routes are torn down.
+		stopSampling()
+		atomic.StoreInt32(&m.serving, 0)
+		m.Lock()
 		close(m.closing)
+		m.Unlock()
+		m.notifyState()
 		wg.Wait()
+		m.rematching.Wait()
+		m.delivering.Wait()
+		m.accepting.Done()
+
+		m.RLock()
+		for _, r := range m.routes {
+			// Drain the connections enqueued for the listener.
+			r.closeQueues()
+		}
+		m.RUnlock()
 
-		// TODO: drain the connections aequneued for the listener.
+		// Wait for the servers of the routes to return.
+		m.servers.Wait()
 	}()
 
+	var limiter acceptLimiter
 	for {
+		limiter.wait(m.Options(), m.root.closing())
+		slot, err := m.acquireMatching()
+		if err != nil {
+			return err
+		}
 		c, err := m.root.Accept()
 		if err != nil {
+			slot.release()
 			if !m.handleErr(err) {
 				return err
 			}
+			m.backoffAccept()
 			continue
 		}
+		if atomic.LoadInt64(&m.acceptBackoff) != 0 {
+			atomic.StoreInt64(&m.acceptBackoff, 0)
+		}
+
+		m.RLock()
+		var t *turn
+		if m.ordered {
+			t = m.order.take()
+		}
+		m.RUnlock()
 
 		wg.Add(1)
-		go m.serve(c, m.closing, &wg)
+		go m.serve(c, m.closing, &wg, t, slot)
 	}
 }
 
-func (m *Listener) serve(c net.Conn, donec <-chan struct{}, wg *sync.WaitGroup) {
+func (m *Listener) serve(c net.Conn, donec <-chan struct{}, wg *sync.WaitGroup, t *turn, slot *matchingSlot) {
 	defer wg.Done()
+	m.match(m.accepted(c), donec, t, slot)
+}
+
+// accepted wraps a connection accepted by the listener.
+func (m *Listener) accepted(c net.Conn) *Conn {
+	m.RLock()
+	prealloc := m.sniffPrealloc
+	bounded := m.sniffMemory != nil
+	m.RUnlock()
 
 	muc := newConn(c)
-	if m.readTimeout > noTimeout {
-		_ = c.SetReadDeadline(time.Now().Add(m.readTimeout))
+	if prealloc > 0 && !bounded {
+		// Else the buffer is grown once its memory is reserved
+		muc.buffer.buffer.Grow(prealloc)
+	}
+	muc.sampled = m.sample()
+	if muc.sampled {
+		logging.Debugf("connection %s from %v accepted", muc.id, c.RemoteAddr())
 	}
+	m.countConn(muc)
+	m.emit(EventAccepted, muc)
+	muc.notifyClose(func() { m.emit(EventClosed, muc) })
+	return muc
+}
 
-	muc.doneSniffing()
-	if m.readTimeout > noTimeout {
-		_ = c.SetReadDeadline(time.Time{})
+// match runs the matchers over the connection and dispatches it to the route
+// matched. It returns the error the connection was rejected with, if any.
+func (m *Listener) match(muc *Conn, donec <-chan struct{}, t *turn, slot *matchingSlot) error {
+	defer t.release()
+	defer slot.release()
+
+	opts := m.Options()
+	readTimeout := opts.ReadTimeout
+	m.RLock()
+	matchers := m.matchers
+	skip, consume := m.skipLeading, m.consumeLead
+	tarpit := m.tarpit
+	peek := m.peek
+	extractSession, detectCompression := m.sessionID, m.compression
+	filter := m.traceFilter
+	ban := m.autoban
+	preAuth := m.preAuth
+	chaos, observer, mirror := m.chaos, m.frameObserver, m.tap
+	budget, budgetPolicy, prealloc := m.sniffMemory, m.sniffPolicy, m.sniffPrealloc
+	rules := m.ipRules
+	dumpBytes, redactor := m.dumpBytes, m.dumpRedactor
+	scoring := m.scoring
+	exemption := m.exemption
+	dispatcher := m.dispatcher
+	m.RUnlock()
+	config := m.sniffConfig()
+
+	c := muc.Conn
+	state := &sniffState{conn: muc}
+	defer m.startSpan(SpanMatch, muc).End()
+	if exemption != nil {
+		m.hooks.exemption.call("limit exemption", func() { muc.exempt = exemption(muc) })
 	}
-	select {
-	case m.connections <- muc:
-		logging.Info("connection listened.")
-	case <-donec:
-		logging.Info("connection closed.")
-		_ = c.Close()
+	if muc.exempt {
+		budget = nil
+		slot.release()
+	} else {
+		m.countLimited(muc)
+	}
+	if max := opts.MaxConnections; max > 0 && !muc.exempt && atomic.LoadInt64(&m.limited) > int64(max) {
+		t.release()
+		m.rejectConn(muc, nil, ErrTooManyConnections)
+		return ErrTooManyConnections
+	}
+	if m.IsDraining() {
+		t.release()
+		m.rejectConn(muc, nil, ErrDraining)
+		return ErrDraining
+	}
+	ip := parseRemoteIP(c)
+	if !rules.allowed(ip) {
+		t.release()
+		m.rejectConn(muc, nil, ErrIPBlocked)
+		return ErrIPBlocked
+	}
+	if rules.exempt(ip) {
+		ban = nil
+	}
+	if ban != nil && ban.banned(remoteIP(c), time.Now()) {
+		t.release()
+		m.rejectConn(muc, nil, ErrBanned)
+		return ErrBanned
+	}
+	if readTimeout > noTimeout {
+		state.deadline = time.Now().Add(readTimeout)
+		_ = c.SetReadDeadline(state.deadline)
+	}
+
+	im := m.inflight.add(muc)
+	defer m.inflight.remove(im)
+
+	if budget != nil {
+		reserve := sniffReserve
+		if prealloc > reserve {
+			reserve = prealloc
+		}
+		reserved := budget.reserve(int64(reserve), budgetPolicy, state.deadline, donec)
+		if reserved == nil {
+			slot.release()
+			t.release()
+			m.rejectConn(muc, nil, ErrSniffMemory)
+			return ErrSniffMemory
+		}
+		defer reserved.release()
+		muc.buffer.budget = reserved
+		if prealloc > 0 {
+			muc.buffer.buffer.Grow(prealloc)
+		}
+	}
+
+	if preAuth != nil {
+		if !preAuthenticate(muc, preAuth, &m.hooks.preAuth) {
+			slot.release()
+			t.release()
+			m.rejectConn(muc, nil, ErrPreAuth)
+			return ErrPreAuth
+		}
+		peek = false
+	}
+
+	traced := false
+	if filter != nil {
+		m.hooks.traceFilter.call("match trace filter", func() { traced = filter(c) })
+	}
+	sniff := muc.startSniffing
+	peeking := false
+	if peek {
+		if peeker := newPeeker(c, muc.buffer.budget); peeker != nil {
+			sniff, peeking = peeker, true
+		}
+	}
+
+	if scoring {
+		matchers = m.rankMatchers(muc, im, matchers, sniff, config, skip, state.deadline)
+	}
+
+	var last io.Reader
+	for i := 0; i < len(matchers); i++ {
+		sl := matchers[i]
+		if !m.inflight.try(im, sl.name) {
+			slot.release()
+			t.release()
+			return m.cancelConn(muc)
+		}
+
+		var lead *leadingSkipper
+		var src io.Reader
+		matched := false
+		if batch := peekOnlyBatch(matchers[i:]); batch > 1 && !peeking {
+			var winner int
+			winner, lead = m.matchConcurrently(muc, matchers[i:i+batch], config, skip, traced, state.deadline)
+			if winner < 0 {
+				i += batch - 1
+				continue
+			}
+			sl, src, matched = matchers[i+winner], &muc.buffer, true
+		} else {
+			src = sniff()
+			last = src
+			r := src
+			var counter *countingReader
+			if traced {
+				counter = &countingReader{Reader: r}
+				r = counter
+			}
+			if skip {
+				lead = &leadingSkipper{source: r}
+				r = lead
+			}
+
+			matched = sl.matcher(&sniffReader{Reader: r, config: config, state: state})
+			if counter != nil {
+				traceMatch(muc, sl.name, counter.n, matched, state)
+			}
+			if state.rejected != nil {
+				slot.release()
+				t.release()
+				m.rejectConn(muc, sl.listen, state.rejected)
+				return state.rejected
+			}
+		}
+
+		if matched {
+			slot.release()
+			if !sl.listen.track(muc) {
+				t.release()
+				m.rejectConn(muc, sl.listen, ErrRouteFull)
+				return ErrRouteFull
+			}
+			stampMeta(muc, src, MetaSessionID, "session ID extractor", extractSession, &m.hooks.sessionID)
+			stampMeta(muc, src, MetaCompression, "compression detector", detectCompression, &m.hooks.compression)
+			dumpSniffed(muc, src, "matched for route "+sl.listen.name, dumpBytes, redactor, &m.hooks.dumpRedactor)
+			if !muc.exempt {
+				muc.limiter = newReadLimiter(m, muc)
+			}
+			muc.doneSniffing()
+			var conn net.Conn = muc
+			if hs := state.handshake; hs != nil {
+				skipped := 0
+				if lead != nil {
+					skipped = lead.skipped
+				}
+				hs.dispatched(skipped)
+				conn = hs.conn
+			} else if lead != nil && consume {
+				muc.discard(lead.skipped)
+			}
+			if sl.listen.noReplayBuffer() {
+				muc.buffer.rebase()
+			}
+			if mirror != nil {
+				conn = newTapConn(conn, muc.id, mirror)
+			}
+			if observer != nil {
+				conn = &frameObserver{Conn: conn, id: muc.id, observe: observer, guard: &m.hooks.frameObserver}
+			}
+			if chaos != nil {
+				conn = &chaosConn{Conn: conn, config: chaos}
+			}
+			if readTimeout > noTimeout {
+				_ = c.SetReadDeadline(time.Time{})
+			}
+			m.startHandleDeadline(sl.listen, muc)
+			m.startIdleTimeout(muc, opts.IdleTimeout)
+			m.emit(EventMatched, muc)
+			if muc.sampled {
+				logging.Debugf("connection %s from %v matched route %s", muc.id, c.RemoteAddr(), muc.route)
+			}
+			muc.notifyHandoff(m.startSpan(SpanConn, muc).End)
+			t.wait()
+			if dispatcher != nil && !m.hooks.dispatcher.disabled() {
+				err := errHookPanicked
+				wrapped := sl.listen.wrap(conn)
+				m.hooks.dispatcher.call("dispatcher", func() { err = dispatcher.Dispatch(sl.listen.name, wrapped) })
+				if err != nil {
+					_ = muc.Close()
+					logging.Debugf("connection from %v not dispatched: %v", c.RemoteAddr(), err)
+					return err
+				}
+				return nil
+			}
+			if !m.dispatch(sl.listen, sl.listen.wrap(conn), donec) {
+				_ = muc.Close()
+				return ErrListenerClosed
+			}
+			return nil
+		}
+	}
+
+	slot.release()
+	t.release()
+	if m.inflight.canceled(im) {
+		return m.cancelConn(muc)
+	}
+	if last == nil {
+		last = &muc.buffer
+	}
+	dumpSniffed(muc, last, "not matched", dumpBytes, redactor, &m.hooks.dumpRedactor)
+	if ban != nil {
+		ban.record(remoteIP(c), time.Now())
+	}
+	if tarpit > 0 {
+		timer := time.NewTimer(tarpit)
+		select {
+		case <-timer.C:
+		case <-donec:
+		}
+		timer.Stop()
 	}
 
-	// _ = c.Close()
-	// logging.Info("connection closed.")
+	_ = muc.Close()
+	logging.Debugf("connection from %v not matched.", c.RemoteAddr())
 	err := ErrNotMatched{c: c}
 	if !m.handleErr(err) {
-		logging.Info("listener closed as %s", fmt.Errorf("Error when reading config: %v", err))
+		logging.Infof("listener closed as %v", err)
 		_ = m.root.Close()
 	}
+	return err
+}
+
+// startHandleDeadline closes the connection once it reaches the handle
+// deadline of its route, unless it gets closed before.
+func (m *Listener) startHandleDeadline(r *Route, c *Conn) {
+	r.Lock()
+	d := r.handleLimit
+	r.Unlock()
+	if d <= 0 {
+		return
+	}
+
+	c.setHandleDeadline(time.AfterFunc(d, func() {
+		_ = c.Close()
+		logging.Debugf("connection %s from %v closed after its handle deadline of %v", c.id, c.RemoteAddr(), d)
+		_ = m.handleErr(ErrHandleDeadline)
+	}))
+}
+
+// startIdleTimeout closes the connection once it goes without reads or writes
+// for the idle timeout, until its route gives it up.
+func (m *Listener) startIdleTimeout(c *Conn, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+
+	done := make(chan struct{})
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if idle := time.Since(c.lastActivity()); idle < d {
+			timer.Reset(d - idle)
+			return
+		}
+		_ = c.Close()
+		logging.Debugf("connection %s from %v closed after being idle for %v", c.id, c.RemoteAddr(), d)
+		_ = m.handleErr(ErrIdleTimeout)
+	})
+	c.notifyHandoff(func() {
+		close(done)
+		timer.Stop()
+	})
 }
 
 // HandleError registers an error handler that handles listener errors.
@@ -178,86 +796,21 @@ func (m *Listener) Close() error {
 	return m.root.Close()
 }
 
-// // ------------------------------------------------------------------------------------
-
-// type muxListener struct {
-// 	net.Listener
-// 	connections chan net.Conn
-// }
-
-// func (l muxListener) Accept() (net.Conn, error) {
-// 	c, ok := <-l.connections
-// 	if !ok {
-// 		return nil, ErrListenerClosed
-// 	}
-// 	return c, nil
-// }
-
-// ------------------------------------------------------------------------------------
-
-// Conn wraps a net.Conn and provides transparent sniffing of connection data.
-type Conn struct {
-	net.Conn
-	buffer sniffer
-}
-
-// NewConn creates a new sniffed connection.
-func newConn(c net.Conn) *Conn {
-	return &Conn{
-		Conn:   c,
-		buffer: sniffer{source: c},
-	}
-}
-
-// Read reads the block of data from the underlying buffer.
-func (m *Conn) Read(p []byte) (int, error) {
-	return m.buffer.Read(p)
-}
-
-func (m *Conn) startSniffing() io.Reader {
-	m.buffer.reset(true)
-	return &m.buffer
-}
-
-func (m *Conn) doneSniffing() {
-	m.buffer.reset(false)
-}
-
-// ------------------------------------------------------------------------------------
-
-// Sniffer represents a io.Reader which can peek incoming bytes and reset back to normal.
-type sniffer struct {
-	source     io.Reader
-	buffer     bytes.Buffer
-	bufferRead int
-	bufferSize int
-	sniffing   bool
-	lastErr    error
-}
-
-// Read reads data from the buffer.
-func (s *sniffer) Read(p []byte) (int, error) {
-	if s.bufferSize > s.bufferRead {
-		bn := copy(p, s.buffer.Bytes()[s.bufferRead:s.bufferSize])
-		s.bufferRead += bn
-		return bn, s.lastErr
-	} else if !s.sniffing && s.buffer.Cap() != 0 {
-		s.buffer = bytes.Buffer{}
-	}
+// CloseTimeout closes the listener like Close, but gives up waiting and
+// returns ErrCloseTimeout if the root listener does not close within d. The
+// close keeps going in the background and the serve loop exits once it's done.
+func (m *Listener) CloseTimeout(d time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- m.root.Close()
+	}()
 
-	sn, sErr := s.source.Read(p)
-	if sn > 0 && s.sniffing {
-		s.lastErr = sErr
-		if wn, wErr := s.buffer.Write(p[:sn]); wErr != nil {
-			return wn, wErr
-		}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return ErrCloseTimeout
 	}
-	return sn, sErr
-}
-
-// Reset resets the buffer.
-func (s *sniffer) reset(snif bool) {
-	s.sniffing = snif
-	s.bufferRead = 0
-	s.bufferSize = s.buffer.Len()
 }
@@ -0,0 +1,523 @@
+package listener
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// testTimeout bounds the waits of the tests for something which should happen.
+const testTimeout = 5 * time.Second
+
+// testMux is a listener accepting loopback connections.
+type testMux struct {
+	*Listener
+	t      testing.TB
+	dialer dialer
+	mem    *MemoryListener // The in-memory socket, if the listener has one.
+	done   chan error      // Receives the error Serve returned.
+}
+
+// dialer opens connections to a listener.
+type dialer interface {
+	Dial() (net.Conn, error)
+}
+
+// loopback dials a listener over the loopback interface.
+type loopback struct {
+	addr string
+}
+
+// Dial opens a connection to the listener.
+func (l loopback) Dial() (net.Conn, error) {
+	return net.Dial("tcp", l.addr)
+}
+
+// newTestMux creates a listener accepting loopback connections, which is
+// closed at the end of the test.
+func newTestMux(t testing.TB) *testMux {
+	l, err := NewListener("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewListener() = %v", err)
+	}
+	m := &testMux{Listener: l, t: t, dialer: loopback{addr: l.root.Addr().String()}}
+	t.Cleanup(func() { _ = m.Close() })
+	return m
+}
+
+// newMemoryTestMux creates a listener accepting in-memory connections, which
+// is closed at the end of the test, for the tests which swap or wrap its
+// socket or rely on the synchronous pipes of MemoryListener.
+func newMemoryTestMux(t testing.TB) *testMux {
+	mem := NewMemoryListener()
+	m := &testMux{Listener: New(mem), t: t, dialer: mem, mem: mem}
+	t.Cleanup(func() { _ = m.Close() })
+	return m
+}
+
+// serve serves the listener in the background until the end of the test.
+func (m *testMux) serve() {
+	m.done = make(chan error, 1)
+	go func() { m.done <- m.Serve() }()
+	m.t.Cleanup(func() {
+		_ = m.Close()
+		m.wait()
+	})
+}
+
+// wait waits for Serve to return and returns its error.
+func (m *testMux) wait() error {
+	select {
+	case err := <-m.done:
+		m.done <- err
+		return err
+	case <-time.After(testTimeout):
+		m.t.Fatal("Serve did not return")
+		return nil
+	}
+}
+
+// dial opens a connection which sends the data, without waiting for it to be
+// read, and is closed at the end of the test.
+func (m *testMux) dial(data string) net.Conn {
+	m.t.Helper()
+	c, err := m.dialer.Dial()
+	if err != nil {
+		m.t.Fatalf("Dial() = %v", err)
+	}
+	m.t.Cleanup(func() { _ = c.Close() })
+	if data != "" {
+		go func() { _, _ = c.Write([]byte(data)) }()
+	}
+	return c
+}
+
+// httpClient returns an HTTP client whose connections are dialed to the
+// listener.
+func (m *testMux) httpClient() *http.Client {
+	return &http.Client{
+		Timeout: testTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return m.dialer.Dial()
+			},
+		},
+	}
+}
+
+// accept accepts the next connection of the listener, failing the test if
+// none comes in time. The connection is closed at the end of the test.
+func accept(t testing.TB, l net.Listener) net.Conn {
+	t.Helper()
+	type result struct {
+		c   net.Conn
+		err error
+	}
+	accepted := make(chan result, 1)
+	go func() {
+		c, err := l.Accept()
+		accepted <- result{c, err}
+	}()
+
+	select {
+	case r := <-accepted:
+		if r.err != nil {
+			t.Fatalf("Accept() = %v", r.err)
+		}
+		t.Cleanup(func() { _ = r.c.Close() })
+		return r.c
+	case <-time.After(testTimeout):
+		t.Fatal("no connection accepted")
+		return nil
+	}
+}
+
+// expectNoAccept fails the test if the listener accepts a connection within d.
+// An Accept call is left pending, so the listener must not be accepted from
+// afterwards.
+func expectNoAccept(t testing.TB, l net.Listener, d time.Duration) {
+	t.Helper()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			accepted <- c
+		}
+	}()
+
+	select {
+	case c := <-accepted:
+		_ = c.Close()
+		t.Fatal("connection accepted")
+	case <-time.After(d):
+	}
+}
+
+// readN reads n bytes from the connection.
+func readN(t testing.TB, c net.Conn, n int) string {
+	t.Helper()
+	_ = c.SetReadDeadline(time.Now().Add(testTimeout))
+	b := make([]byte, n)
+	if _, err := io.ReadFull(c, b); err != nil {
+		t.Fatalf("read %q: %v", b, err)
+	}
+	_ = c.SetReadDeadline(time.Time{})
+	return string(b)
+}
+
+// expectClosed fails the test unless the peer closes the connection in time,
+// and returns what was read before. A peer closing before it read all the
+// data resets the connection, which counts as closed too.
+func expectClosed(t testing.TB, c net.Conn) string {
+	t.Helper()
+	_ = c.SetReadDeadline(time.Now().Add(testTimeout))
+	b, err := ioutil.ReadAll(c)
+	if err != nil && !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("connection not closed: %v", err)
+	}
+	return string(b)
+}
+
+// matcherCase is an input a matcher is expected to match, or not.
+type matcherCase struct {
+	name  string
+	input string
+	want  bool
+}
+
+// testMatcher runs the matcher over the inputs of the cases.
+func testMatcher(t *testing.T, matcher Matcher, cases []matcherCase) {
+	t.Helper()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := matcher(strings.NewReader(tc.input)); got != tc.want {
+				t.Errorf("matcher(%q) = %v, want %v", tc.input, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMatchRoutes(t *testing.T) {
+	m := newTestMux(t)
+	ping := m.Match(MatchPrefix("PING"))
+	fallback := m.Match(MatchAny())
+	m.serve()
+
+	tests := []struct {
+		data  string
+		route net.Listener
+	}{
+		{"PING\r\n", ping},
+		{"PONG\r\n", fallback},
+	}
+	for _, tt := range tests {
+		m.dial(tt.data)
+		c := accept(t, tt.route)
+		if got := readN(t, c, len(tt.data)); got != tt.data {
+			t.Errorf("handler read %q, want %q", got, tt.data)
+		}
+	}
+}
+
+func TestUnmatchedConnectionIsClosed(t *testing.T) {
+	m := newTestMux(t)
+	errs := make(chan error, 1)
+	m.HandleError(func(err error) bool {
+		errs <- err
+		return true
+	})
+	m.Match(MatchPrefix("PING"))
+	m.serve()
+
+	c := m.dial("PONG")
+	expectClosed(t, c)
+	if _, ok := (<-errs).(ErrNotMatched); !ok {
+		t.Error("ErrNotMatched not reported")
+	}
+}
+
+func TestHandleServesRoute(t *testing.T) {
+	m := newTestMux(t)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "hello "+r.URL.Path)
+	})}
+	r := m.Handle("http", ServerFunc(srv.Serve), MatchHTTP())
+	if r.Name() != "http" {
+		t.Errorf("Name() = %q, want http", r.Name())
+	}
+	m.serve()
+
+	resp, err := m.httpClient().Get("http://mux/world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if string(body) != "hello /world" {
+		t.Errorf("body = %q, want %q", body, "hello /world")
+	}
+
+	// Serve waits for the server once the listener is closed
+	_ = m.Close()
+	if err := m.wait(); err == nil {
+		t.Error("Serve() returned no error after Close")
+	}
+	if _, err := r.Accept(); err != ErrListenerClosed {
+		t.Errorf("Accept() = %v, want ErrListenerClosed", err)
+	}
+}
+
+func TestRouteNames(t *testing.T) {
+	m := newTestMux(t)
+	named := m.Route("named", MatchAny())
+	unnamed := m.Route("", MatchAny())
+
+	if named.Name() != "named" || unnamed.Name() != "route-1" {
+		t.Errorf("names = %q, %q, want named, route-1", named.Name(), unnamed.Name())
+	}
+}
+
+func TestSkipLeadingWhitespace(t *testing.T) {
+	const request = "\xEF\xBB\xBF  \r\nGET / HTTP/1.1\r\n\r\n"
+	tests := []struct {
+		name    string
+		consume bool
+		want    string
+	}{
+		{"replayed", false, request},
+		{"consumed", true, "GET / HTTP/1.1\r\n\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMux(t)
+			m.SetSkipLeadingWhitespace(true)
+			m.SetConsumeLeadingWhitespace(tt.consume)
+			r := m.Match(MatchHTTP())
+			m.serve()
+
+			m.dial(request)
+			c := accept(t, r)
+			if got := readN(t, c, len(tt.want)); got != tt.want {
+				t.Errorf("handler read %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLeadingWhitespaceNotSkipped(t *testing.T) {
+	m := newTestMux(t)
+	r := m.Match(MatchHTTP())
+	m.serve()
+
+	c := m.dial("\xEF\xBB\xBFGET / HTTP/1.1\r\n\r\n")
+	expectClosed(t, c)
+	expectNoAccept(t, r, 50*time.Millisecond)
+}
+
+func TestUnmatchedCloseDelay(t *testing.T) {
+	m := newTestMux(t)
+	m.SetUnmatchedCloseDelay(100 * time.Millisecond)
+	m.Match(MatchPrefix("PING"))
+	m.serve()
+
+	start := time.Now()
+	c := m.dial("PONG")
+	expectClosed(t, c)
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Errorf("unmatched connection closed after %v, want the 100ms delay", d)
+	}
+}
+
+func TestUnmatchedCloseDelayInterruptedByClose(t *testing.T) {
+	m := newTestMux(t)
+	m.SetUnmatchedCloseDelay(time.Hour)
+	m.Match(MatchPrefix("PING"))
+	m.serve()
+
+	c := m.dial("PONG")
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	_ = m.Close()
+	expectClosed(t, c)
+	m.wait()
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Close took %v with a held connection", d)
+	}
+}
+
+// upperConn uppercases the bytes read from the connection.
+type upperConn struct {
+	net.Conn
+}
+
+func (c upperConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	copy(b, bytes.ToUpper(b[:n]))
+	return n, err
+}
+
+func TestMatchWithTransform(t *testing.T) {
+	m := newTestMux(t)
+	r := m.MatchWithTransform("upper", func(c net.Conn) net.Conn { return upperConn{c} }, MatchPrefix("hello"))
+	m.serve()
+
+	m.dial("hello world")
+	c := accept(t, r)
+	if got := readN(t, c, 11); got != "HELLO WORLD" {
+		t.Errorf("handler read %q, want HELLO WORLD", got)
+	}
+}
+
+func TestMatchWithHandleDeadline(t *testing.T) {
+	m := newTestMux(t)
+	errs := make(chan error, 1)
+	m.HandleError(func(err error) bool {
+		errs <- err
+		return true
+	})
+	rpc := m.MatchWithHandleDeadline(50*time.Millisecond, "rpc", MatchPrefix("R"))
+	stream := m.Route("stream", MatchPrefix("S"))
+	m.serve()
+
+	rpcClient := m.dial("R")
+	streamClient := m.dial("S")
+	rpcConn := accept(t, rpc)
+	streamConn := accept(t, stream)
+	start := time.Now()
+
+	// Reading keeps the rpc connection active until the deadline closes it
+	go func() { _, _ = ioutil.ReadAll(rpcConn) }()
+	expectClosed(t, rpcClient)
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Errorf("rpc connection closed after %v, want its 50ms deadline", d)
+	}
+	if err := <-errs; err != ErrHandleDeadline {
+		t.Errorf("error = %v, want ErrHandleDeadline", err)
+	}
+
+	go func() { _, _ = streamConn.Write([]byte("still here")) }()
+	if got := readN(t, streamClient, 10); got != "still here" {
+		t.Errorf("stream client read %q, want still here", got)
+	}
+}
+
+func TestMaxRoutes(t *testing.T) {
+	m := newTestMux(t)
+	m.SetMaxRoutes(2)
+	for i := 0; i < 2; i++ {
+		if _, err := m.AddRoute("", MatchAny()); err != nil {
+			t.Fatalf("AddRoute() #%d = %v", i, err)
+		}
+	}
+
+	if _, err := m.AddRoute("extra", MatchAny()); err != ErrTooManyRoutes {
+		t.Errorf("AddRoute() = %v, want ErrTooManyRoutes", err)
+	}
+	if _, err := m.Match(MatchAny()).Accept(); err != ErrTooManyRoutes {
+		t.Errorf("Accept() on the extra route = %v, want ErrTooManyRoutes", err)
+	}
+	if got := len(m.routes); got != 2 {
+		t.Errorf("%d routes registered, want 2", got)
+	}
+}
+
+func TestServeWithoutMatchers(t *testing.T) {
+	m := newTestMux(t)
+	if err := m.Serve(); err != ErrNoMatchers {
+		t.Fatalf("Serve() = %v, want ErrNoMatchers", err)
+	}
+
+	// The listener is left open, so it can be served once a route is added
+	r := m.Match(MatchAny())
+	m.serve()
+	m.dial("x")
+	accept(t, r)
+}
+
+// blockingCloseListener is a listener whose Close blocks until released.
+type blockingCloseListener struct {
+	net.Listener
+	release chan struct{}
+}
+
+func (l *blockingCloseListener) Close() error {
+	<-l.release
+	return l.Listener.Close()
+}
+
+func TestCloseTimeout(t *testing.T) {
+	mem := NewMemoryListener()
+	root := &blockingCloseListener{Listener: mem, release: make(chan struct{})}
+	m := &testMux{Listener: New(root), t: t, dialer: mem, mem: mem}
+	m.Match(MatchAny())
+	m.serve()
+
+	start := time.Now()
+	if err := m.CloseTimeout(50 * time.Millisecond); err != ErrCloseTimeout {
+		t.Errorf("CloseTimeout() = %v, want ErrCloseTimeout", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("CloseTimeout returned after %v", d)
+	}
+
+	// The serve loop exits once the root listener is closed
+	close(root.release)
+	m.wait()
+}
+
+func TestCloseTimeoutInTime(t *testing.T) {
+	m := newTestMux(t)
+	m.Match(MatchAny())
+	m.serve()
+
+	if err := m.CloseTimeout(testTimeout); err != nil {
+		t.Errorf("CloseTimeout() = %v", err)
+	}
+	m.wait()
+}
+
+func TestRootListener(t *testing.T) {
+	mem := NewMemoryListener()
+	m := New(mem)
+	defer m.Close()
+	if m.RootListener() != mem {
+		t.Fatal("RootListener() is not the listener New was given")
+	}
+
+	// Connections accepted from it directly bypass the matchers
+	r := m.Match(MatchAny())
+	go func() { _, _ = mem.Dial() }()
+	c := accept(t, m.RootListener())
+	if _, ok := c.(*Conn); ok {
+		t.Error("connection accepted from the root listener went through the multiplexer")
+	}
+	expectNoAccept(t, r, 20*time.Millisecond)
+}
+
+func TestStatsDRoute(t *testing.T) {
+	m := newTestMux(t)
+	statsd := m.Match(MatchStatsD())
+	fallback := m.Match(MatchAny())
+	m.serve()
+
+	tests := []struct {
+		data  string
+		route net.Listener
+	}{
+		{"foo:1|c\n", statsd},
+		{"hello: world\n", fallback},
+	}
+	for _, tt := range tests {
+		m.dial(tt.data)
+		if got := readN(t, accept(t, tt.route), len(tt.data)); got != tt.data {
+			t.Errorf("handler read %q, want %q", got, tt.data)
+		}
+	}
+}
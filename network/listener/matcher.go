@@ -0,0 +1,1246 @@
+package listener
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Matcher matches a connection based on its content.
+type Matcher func(io.Reader) bool
+
+// maxLineLength is the maximum number of bytes sniffed by the line-based matchers.
+const maxLineLength = 1024
+
+// MatchAny matches any connection.
+func MatchAny() Matcher {
+	return func(r io.Reader) bool { return true }
+}
+
+// MatchPrefix returns a matcher that matches a connection if it
+// starts with any of the strings in strs.
+func MatchPrefix(strs ...string) Matcher {
+	return func(r io.Reader) bool {
+		candidates := make([]string, 0, len(strs))
+		for _, s := range strs {
+			if s == "" {
+				return true
+			}
+			candidates = append(candidates, s)
+		}
+
+		// Read byte by byte so we never wait for more than the prefix needs
+		b := make([]byte, 1)
+		for i := 0; len(candidates) > 0; i++ {
+			if _, err := io.ReadFull(r, b); err != nil {
+				return false
+			}
+
+			next := candidates[:0]
+			for _, s := range candidates {
+				if s[i] != b[0] {
+					continue
+				}
+				if len(s) == i+1 {
+					return true
+				}
+				next = append(next, s)
+			}
+			candidates = next
+		}
+		return false
+	}
+}
+
+// MatchMagic matches connections whose bytes at the offset equal the magic,
+// such as the network magic starting the messages of many peer-to-peer or
+// binary protocols. Only offset+len(magic) bytes are sniffed, and the bytes
+// before the offset are skipped as they are read rather than buffered up front.
+// It panics if the offset is negative.
+func MatchMagic(offset int, magic []byte) Matcher {
+	if offset < 0 {
+		panic("listener: MatchMagic with a negative offset")
+	}
+
+	return func(r io.Reader) bool {
+		if _, err := io.CopyN(ioutil.Discard, r, int64(offset)); err != nil {
+			return false
+		}
+		b := make([]byte, len(magic))
+		if _, err := io.ReadFull(r, b); err != nil {
+			return false
+		}
+		return bytes.Equal(b, magic)
+	}
+}
+
+var defaultHTTPMethods = []string{
+	"OPTIONS",
+	"GET",
+	"HEAD",
+	"POST",
+	"PUT",
+	"DELETE",
+	"TRACE",
+	"CONNECT",
+}
+
+// MatchHTTP only matches the methods in the HTTP request.
+func MatchHTTP(extMethods ...string) Matcher {
+	return MatchPrefix(append(defaultHTTPMethods, extMethods...)...)
+}
+
+// MatchHTTP1Strict matches HTTP/1.x requests whose request line is valid: a
+// known method, a plausible request target and a version which is exactly
+// HTTP/1.0 or HTTP/1.1. Unlike MatchHTTP, it does not match binary data which
+// merely starts with the bytes of a method.
+func MatchHTTP1Strict(extMethods ...string) Matcher {
+	methods := make(map[string]bool)
+	for _, method := range append(defaultHTTPMethods, extMethods...) {
+		methods[method] = true
+	}
+
+	return func(r io.Reader) bool {
+		line, ok := readLine(limitHTTPHeaders(r), maxLineLength)
+		if !ok {
+			return false
+		}
+
+		parts := bytes.Split(line, []byte{' '})
+		if len(parts) != 3 || !methods[string(parts[0])] {
+			return false
+		}
+
+		switch string(parts[2]) {
+		case "HTTP/1.0", "HTTP/1.1":
+		default:
+			return false
+		}
+		return isRequestTarget(string(parts[0]), parts[1])
+	}
+}
+
+// isRequestTarget checks whether the target is a plausible request target for
+// the method: an origin or absolute form, an authority for CONNECT and an
+// asterisk for OPTIONS.
+func isRequestTarget(method string, target []byte) bool {
+	if len(target) == 0 {
+		return false
+	}
+
+	for _, c := range target {
+		if c <= ' ' || c >= 0x7f {
+			return false
+		}
+	}
+
+	switch {
+	case method == "CONNECT":
+		return bytes.IndexByte(target, ':') > 0 && target[0] != '/'
+	case target[0] == '/':
+		return true
+	case method == "OPTIONS" && len(target) == 1 && target[0] == '*':
+		return true
+	}
+	return bytes.HasPrefix(target, []byte("http://")) || bytes.HasPrefix(target, []byte("https://"))
+}
+
+// MatchStatsD matches the StatsD line protocol, where the first line has the
+// form "metric.name:value|type", optionally followed by a sample rate and tags.
+func MatchStatsD() Matcher {
+	return func(r io.Reader) bool {
+		line, ok := readLine(r, maxLineLength)
+		if !ok {
+			return false
+		}
+
+		// The metric name comes first and must not be empty
+		colon := bytes.IndexByte(line, ':')
+		if colon <= 0 || !isMetricName(line[:colon]) {
+			return false
+		}
+
+		fields := bytes.Split(line[colon+1:], []byte{'|'})
+		if len(fields) < 2 || !isMetricValue(fields[0]) {
+			return false
+		}
+
+		switch string(fields[1]) {
+		case "c", "g", "ms", "h", "s", "d":
+		default:
+			return false
+		}
+
+		// Optional sample rate (@0.1) and tags (#tag:value)
+		for _, f := range fields[2:] {
+			if len(f) < 2 || (f[0] != '@' && f[0] != '#') {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// MatchLine matches connections whose first line, without its LF or CRLF
+// terminator, satisfies the predicate. Lines longer than 1024 bytes are never
+// matched and the read timeout of the listener bounds the wait for the line.
+func MatchLine(fn func(line []byte) bool) Matcher {
+	return func(r io.Reader) bool {
+		line, ok := readLine(r, maxLineLength)
+		return ok && fn(line)
+	}
+}
+
+// MatchFirstLine is the string equivalent of MatchLine, convenient for text
+// protocols such as Gopher selectors or custom greetings.
+func MatchFirstLine(fn func(line string) bool) Matcher {
+	return MatchLine(func(line []byte) bool {
+		return fn(string(line))
+	})
+}
+
+// readLine reads a single line, terminated by LF or CRLF, of at most max bytes
+// and returns it without the terminator.
+func readLine(r io.Reader, max int) ([]byte, bool) {
+	line := make([]byte, 0, 64)
+	b := make([]byte, 1)
+	for len(line) <= max {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, false
+		}
+
+		if b[0] == '\n' {
+			return bytes.TrimSuffix(line, []byte{'\r'}), true
+		}
+		line = append(line, b[0])
+	}
+	return nil, false
+}
+
+// isMetricName checks whether the name is made of the characters StatsD
+// clients use for metric names.
+func isMetricName(name []byte) bool {
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// isMetricValue checks whether the value is a (possibly signed) decimal number.
+func isMetricValue(value []byte) bool {
+	if len(value) > 0 && (value[0] == '+' || value[0] == '-') {
+		value = value[1:]
+	}
+
+	digits, dots := 0, 0
+	for _, c := range value {
+		switch {
+		case c >= '0' && c <= '9':
+			digits++
+		case c == '.':
+			dots++
+		default:
+			return false
+		}
+	}
+	return digits > 0 && dots <= 1
+}
+
+// MatchCoAP matches the CoAP over TCP framing of RFC 8323: a byte holding the
+// length and token length nibbles, an optional extended length, a request or
+// signaling code and the token.
+//
+// The first byte of an MQTT CONNECT (0x10) would read as a CoAP message with a
+// single byte of options and no token, which CoAP clients do not send as their
+// first message, so it is never matched.
+func MatchCoAP() Matcher {
+	return func(r io.Reader) bool {
+		b := make([]byte, 1)
+		if _, err := io.ReadFull(r, b); err != nil || b[0] == 0x10 {
+			return false
+		}
+
+		// Token lengths of 9 to 15 are reserved
+		length, tkl := b[0]>>4, int(b[0]&0x0f)
+		if tkl > 8 {
+			return false
+		}
+
+		// Skip the extended length
+		var ext int
+		switch length {
+		case 13:
+			ext = 1
+		case 14:
+			ext = 2
+		case 15:
+			ext = 4
+		}
+
+		header := make([]byte, ext+1+tkl)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return false
+		}
+
+		// Clients open with requests (0.01-0.07) or signals (7.01-7.05), usually a CSM
+		code := header[ext]
+		class, detail := code>>5, code&0x1f
+		switch class {
+		case 0:
+			return detail >= 1 && detail <= 7
+		case 7:
+			return detail >= 1 && detail <= 5
+		}
+		return false
+	}
+}
+
+// maxThriftFrameSize is the default maximum frame size of the Thrift framed
+// transport.
+const maxThriftFrameSize = 16 * 1024 * 1024
+
+// MatchThrift matches the Thrift framed transport: a 4-byte big-endian frame
+// size followed by a message of the binary protocol, starting with its 0x8001
+// version, or of the compact protocol, starting with its 0x82 protocol id. The
+// message type must be a call, a reply, an exception or a oneway call, so other
+// length-prefixed frames are not mistaken for Thrift.
+func MatchThrift() Matcher {
+	return func(r io.Reader) bool {
+		b := make([]byte, 8)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return false
+		}
+
+		size := binary.BigEndian.Uint32(b)
+		if size < 4 || size > maxThriftFrameSize {
+			return false
+		}
+
+		var typ byte
+		switch {
+		case b[4] == 0x80 && b[5] == 0x01:
+			typ = b[7]
+		case b[4] == 0x82 && b[5]&0x1f == 1:
+			typ = b[5] >> 5
+		default:
+			return false
+		}
+		return typ >= 1 && typ <= 4
+	}
+}
+
+// natsInfo is the JSON object describing the server which MatchNATS sends.
+const natsInfo = `{"server_id":"mux","version":"2.10.0","proto":1,"max_payload":1048576}`
+
+// MatchNATS matches the clients of the NATS protocol, where the server speaks
+// first. It sends an INFO line describing a server with the default max
+// payload of 1MB, and matches the clients replying with a CONNECT, PING or SUB
+// command. The handler must not send the INFO line again.
+func MatchNATS() Matcher {
+	return MatchNATSInfo(natsInfo)
+}
+
+// MatchNATSInfo matches the clients of the NATS protocol like MatchNATS, but
+// sends "INFO <info>\r\n", where info is the JSON object describing the
+// server, such as its ID, version and max payload.
+func MatchNATSInfo(info string) Matcher {
+	greeting := []byte("INFO " + info + "\r\n")
+	return MatchWriter(func(w io.Writer, r io.Reader) bool {
+		if _, err := w.Write(greeting); err != nil {
+			return false
+		}
+
+		line, ok := readLine(r, maxLineLength)
+		if !ok {
+			return false
+		}
+
+		op := line
+		if i := bytes.IndexAny(line, " \t"); i >= 0 {
+			op = line[:i]
+		}
+		switch string(bytes.ToUpper(op)) {
+		case "CONNECT", "PING", "SUB":
+			return true
+		}
+		return false
+	})
+}
+
+// MatchSMTP matches the SMTP clients, which wait for the server to speak
+// first. It sends the "220 <host> ESMTP Service ready\r\n" reply, where host is
+// the host name of the machine, and matches the clients replying with an EHLO
+// or a HELO command. The handler must not send the greeting again.
+//
+// A client which sends nothing is not matched once the read timeout expires,
+// but it has got the greeting by then, which the next routes must cope with.
+func MatchSMTP() Matcher {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	return MatchSMTPGreeting(host + " ESMTP Service ready")
+}
+
+// MatchSMTPGreeting matches the SMTP clients like MatchSMTP, but sends the
+// "220 <greeting>\r\n" reply, where greeting is usually the host name followed
+// by ESMTP.
+func MatchSMTPGreeting(greeting string) Matcher {
+	reply := []byte("220 " + greeting + "\r\n")
+	return MatchWriter(func(w io.Writer, r io.Reader) bool {
+		if _, err := w.Write(reply); err != nil {
+			return false
+		}
+
+		line, ok := readLine(r, maxLineLength)
+		if !ok || len(line) < 4 {
+			return false
+		}
+		command := string(bytes.ToUpper(line[:4]))
+		return (command == "EHLO" || command == "HELO") && (len(line) == 4 || line[4] == ' ')
+	})
+}
+
+// MatchIdle matches the connections whose client sends nothing within the
+// window, such as the clients of the protocols where the server speaks first,
+// and does not match the ones sending data. The window is bounded by the read
+// timeout of the listener, whose deadline is restored afterwards.
+//
+// As the other matchers wait for the data they need, MatchIdle should be
+// registered first.
+func MatchIdle(window time.Duration) Matcher {
+	return func(r io.Reader) bool {
+		c := connOf(r)
+		if c == nil {
+			return false
+		}
+
+		deadline := sniffDeadline(r)
+		wait := time.Now().Add(window)
+		if !deadline.IsZero() && deadline.Before(wait) {
+			wait = deadline
+		}
+
+		_ = c.SetReadDeadline(wait)
+		_, err := r.Read(make([]byte, 1))
+		_ = c.SetReadDeadline(deadline)
+
+		ne, ok := err.(net.Error)
+		return ok && ne.Timeout()
+	}
+}
+
+// The codes of the PostgreSQL messages a client can start with.
+const (
+	postgresSSLRequest    = 0x04D2162F
+	postgresGSSENCRequest = 0x04D21630
+	postgresProtocol3     = 0x00030000
+	maxPostgresStartup    = 10000 // The largest startup message the server accepts.
+)
+
+// MatchPostgres matches the PostgreSQL clients, which start with an SSLRequest
+// or a GSSENCRequest, an 8-byte message made of its length and a request code,
+// or with the startup message of the version 3 protocol. The exact codes keep
+// it from matching other length-prefixed protocols.
+func MatchPostgres() Matcher {
+	return func(r io.Reader) bool {
+		b := make([]byte, 8)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return false
+		}
+
+		length, code := binary.BigEndian.Uint32(b), binary.BigEndian.Uint32(b[4:])
+		switch code {
+		case postgresSSLRequest, postgresGSSENCRequest:
+			return length == 8
+		case postgresProtocol3:
+			return length > 8 && length <= maxPostgresStartup
+		}
+		return false
+	}
+}
+
+// The limits of the syslog framing.
+const (
+	maxSyslogLengthDigits = 9   // The digits of the largest octet count accepted.
+	maxSyslogPriority     = 191 // The largest PRI value, of facility 23 and severity 7.
+)
+
+// The months starting the timestamp of the RFC 3164 messages.
+var syslogMonths = map[string]bool{
+	"Jan": true, "Feb": true, "Mar": true, "Apr": true, "May": true, "Jun": true,
+	"Jul": true, "Aug": true, "Sep": true, "Oct": true, "Nov": true, "Dec": true,
+}
+
+// MatchSyslog matches syslog over TCP as of RFC 6587, with either the octet
+// counting framing, where each message is prefixed with its length and a space
+// as in "57 <134>1 ...", or the non-transparent framing, where the message
+// starts right away with its "<PRI>". The PRI must be a valid priority and be
+// followed by the version of an RFC 5424 header or by the month of an RFC 3164
+// timestamp, so numbers or "<" merely starting other protocols do not match.
+func MatchSyslog() Matcher {
+	return func(r io.Reader) bool {
+		b := make([]byte, 1)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return false
+		}
+
+		if b[0] >= '1' && b[0] <= '9' {
+			// Octet counting, the message length and a space come first
+			for digits := 1; ; digits++ {
+				if _, err := io.ReadFull(r, b); err != nil {
+					return false
+				}
+				if b[0] == ' ' {
+					break
+				}
+				if b[0] < '0' || b[0] > '9' || digits == maxSyslogLengthDigits {
+					return false
+				}
+			}
+
+			if _, err := io.ReadFull(r, b); err != nil {
+				return false
+			}
+		}
+
+		return b[0] == '<' && readSyslogHeader(r)
+	}
+}
+
+// readSyslogHeader reads the PRI following its "<" and checks that the start of
+// the header follows.
+func readSyslogHeader(r io.Reader) bool {
+	b := make([]byte, 1)
+	var digits []byte
+	for {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return false
+		}
+		if b[0] == '>' {
+			break
+		}
+		if b[0] < '0' || b[0] > '9' || len(digits) == 3 {
+			return false
+		}
+		digits = append(digits, b[0])
+	}
+
+	// The PRI has no leading zero, the zero PRI excepted
+	if len(digits) == 0 || (len(digits) > 1 && digits[0] == '0') {
+		return false
+	}
+	if pri, _ := strconv.Atoi(string(digits)); pri > maxSyslogPriority {
+		return false
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header[:2]); err != nil {
+		return false
+	}
+	if header[0] == '1' && header[1] == ' ' {
+		return true
+	}
+
+	if _, err := io.ReadFull(r, header[2:]); err != nil {
+		return false
+	}
+	return syslogMonths[string(header[:3])] && header[3] == ' '
+}
+
+// maxGELFSniffSize bounds the bytes of a GELF message read to find its version.
+const maxGELFSniffSize = 32 * 1024
+
+// MatchGELF matches the GELF TCP input of Graylog, where every message is a
+// JSON object terminated by a null byte. The first top-level fields of the
+// object are read, without waiting for the terminator, until a "version" field
+// holding a 1.x version string is found, so other JSON objects do not match.
+func MatchGELF() Matcher {
+	return func(r io.Reader) bool {
+		dec := json.NewDecoder(io.LimitReader(r, maxGELFSniffSize))
+		if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+			return false
+		}
+
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return false
+			}
+
+			if key == "version" {
+				version, err := dec.Token()
+				s, ok := version.(string)
+				return err == nil && ok && strings.HasPrefix(s, "1.")
+			}
+
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return false
+			}
+		}
+		return false
+	}
+}
+
+// The bounds of the Kafka request headers.
+const (
+	maxKafkaAPIKey      = 74                // The highest API key assigned so far.
+	maxKafkaAPIVersion  = 20                // Well above the highest version of any API.
+	maxKafkaRequestSize = 100 * 1024 * 1024 // The default socket.request.max.bytes of the brokers.
+	kafkaClientIDSniff  = 64                // The bytes of the client ID checked.
+)
+
+// MatchKafka matches the clients of the Kafka wire protocol, whose requests
+// start with a 4-byte big-endian size, followed by a header made of the API key,
+// the API version, the correlation ID and the client ID, a nullable string. The
+// API key and version must be in the known ranges, the correlation ID must not
+// be negative and the client ID must be printable and fit in the request, which
+// keeps other length-prefixed protocols, Thrift and PostgreSQL included, from
+// matching.
+func MatchKafka() Matcher {
+	return func(r io.Reader) bool {
+		b := make([]byte, 14)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return false
+		}
+
+		size := int32(binary.BigEndian.Uint32(b))
+		key := int16(binary.BigEndian.Uint16(b[4:]))
+		version := int16(binary.BigEndian.Uint16(b[6:]))
+		correlation := int32(binary.BigEndian.Uint32(b[8:]))
+		clientID := int16(binary.BigEndian.Uint16(b[12:]))
+		switch {
+		case size < 10 || size > maxKafkaRequestSize:
+			return false
+		case key < 0 || key > maxKafkaAPIKey || version < 0 || version > maxKafkaAPIVersion:
+			return false
+		case correlation < 0:
+			return false
+		case clientID == -1:
+			return true
+		case clientID < 0 || int32(clientID) > size-10:
+			return false
+		}
+
+		if clientID > kafkaClientIDSniff {
+			clientID = kafkaClientIDSniff
+		}
+		id := make([]byte, clientID)
+		if _, err := io.ReadFull(r, id); err != nil {
+			return false
+		}
+		for _, c := range id {
+			if c < 0x20 || c > 0x7e {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// The constants of the QUIC long header.
+const (
+	quicVersion2 = 0x6b3343cf // The version whose Initial packets have type 1 instead of 0.
+	maxQUICCID   = 20         // The longest connection ID of QUIC version 1 and 2.
+	minQUICDCID  = 8          // The shortest destination connection ID of a client Initial.
+)
+
+// MatchQUICInitial matches a QUIC Initial packet, as sent by a client to open
+// a connection: a long header, with its header form and fixed bits set, whose
+// version is not zero and whose packet type is Initial, followed by connection
+// IDs of valid lengths, the destination one being at least 8 bytes long. Its
+// first byte never starts a TLS record, so it routes QUIC and TLS apart.
+//
+// It is meant for QUIC tunneled or framed over a stream, where the packet
+// starts the stream, and not for raw UDP datagrams, which the listener does not
+// accept.
+func MatchQUICInitial() Matcher {
+	return func(r io.Reader) bool {
+		b := make([]byte, 6)
+		if _, err := io.ReadFull(r, b); err != nil || b[0]&0xc0 != 0xc0 {
+			return false
+		}
+
+		typ := b[0] >> 4 & 0x03
+		switch version := binary.BigEndian.Uint32(b[1:]); version {
+		case 0:
+			// Version negotiation
+			return false
+		case quicVersion2:
+			if typ != 0x01 {
+				return false
+			}
+		default:
+			if typ != 0x00 {
+				return false
+			}
+		}
+
+		dcil := int(b[5])
+		if dcil < minQUICDCID || dcil > maxQUICCID {
+			return false
+		}
+		cid := make([]byte, dcil+1)
+		if _, err := io.ReadFull(r, cid); err != nil {
+			return false
+		}
+		return int(cid[dcil]) <= maxQUICCID
+	}
+}
+
+// The versions of the RTMP handshake.
+const (
+	rtmpPlain     = 0x03
+	rtmpEncrypted = 0x06
+)
+
+// MatchRTMP matches the RTMP clients, which start the handshake with the C0
+// version byte, 0x03 for plain RTMP and 0x06 for RTMPE, followed by the 1536
+// bytes of the C1 chunk. Only C0 and the start of C1 are sniffed, not the whole
+// chunk. As the version byte alone is a weak signature, the RDP connection
+// requests, whose TPKT header also starts with 0x03, are told apart by their
+// X.224 header, so MatchRTMP should come after the other binary matchers.
+func MatchRTMP() Matcher {
+	return func(r io.Reader) bool {
+		b := make([]byte, 6)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return false
+		}
+
+		switch b[0] {
+		case rtmpPlain:
+			// A TPKT header, its reserved byte and an X.224 connection request
+			return !(b[1] == 0x00 && b[5] == 0xe0)
+		case rtmpEncrypted:
+			return true
+		}
+		return false
+	}
+}
+
+// The OpenVPN packets a client opens a session with, per their opcode.
+const (
+	openVPNHardResetClientV1 = 1
+	openVPNHardResetClientV2 = 7
+	openVPNHardResetClientV3 = 10
+	minOpenVPNReset          = 14   // An opcode, a session ID, an empty ACK array and a packet ID.
+	maxOpenVPNReset          = 2048 // Room for the tls-auth HMAC or a tls-crypt-v2 client key.
+)
+
+// MatchOpenVPN matches the OpenVPN clients over TCP, whose first packet is a
+// hard reset, prefixed with its 2-byte big-endian length. The opcode, in the
+// high 5 bits of its first byte, must be one of the client hard resets and the
+// key ID, in the low 3 bits, must be zero, as for any new session, while the
+// length must fit the packet, so other length-prefixed frames do not match.
+func MatchOpenVPN() Matcher {
+	return func(r io.Reader) bool {
+		b := make([]byte, 3)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return false
+		}
+
+		length := binary.BigEndian.Uint16(b)
+		if length < minOpenVPNReset || length > maxOpenVPNReset || b[2]&0x07 != 0 {
+			return false
+		}
+
+		switch b[2] >> 3 {
+		case openVPNHardResetClientV1, openVPNHardResetClientV2, openVPNHardResetClientV3:
+			return true
+		}
+		return false
+	}
+}
+
+// The commands of the beanstalkd protocol.
+var beanstalkCommands = []string{
+	"put", "use", "reserve", "reserve-with-timeout", "reserve-job", "delete",
+	"release", "bury", "touch", "watch", "ignore", "peek", "peek-ready",
+	"peek-delayed", "peek-buried", "kick", "kick-job", "stats", "stats-job",
+	"stats-tube", "list-tubes", "list-tube-used", "list-tubes-watched",
+	"pause-tube", "quit",
+}
+
+// MatchBeanstalk matches the clients of the beanstalkd job queue protocol,
+// whose first line starts with one of its commands, such as "put 0 0 60 5" or
+// "use jobs", followed by a space or the end of the line.
+// Other text queue protocols are matched by giving their commands, which are
+// case-sensitive like the ones of beanstalkd.
+func MatchBeanstalk(commands ...string) Matcher {
+	if len(commands) == 0 {
+		commands = beanstalkCommands
+	}
+	known := make(map[string]bool, len(commands))
+	for _, command := range commands {
+		known[command] = true
+	}
+
+	return func(r io.Reader) bool {
+		command, ok := readLine(r, maxLineLength)
+		if !ok {
+			return false
+		}
+		if i := bytes.IndexByte(command, ' '); i >= 0 {
+			command = command[:i]
+		}
+		return known[string(command)]
+	}
+}
+
+// The bounds of the length field of a Modbus/TCP request, which counts the
+// unit ID and the PDU of up to 253 bytes.
+const (
+	minModbusLength = 2
+	maxModbusLength = 254
+)
+
+// MatchModbus matches the Modbus/TCP clients, whose requests start with the
+// 7-byte MBAP header, a transaction ID, the protocol ID, zero, the length of
+// the rest of the request and a unit ID, followed by the function code. The
+// length must fit a request and the function code must be one of a request,
+// from 1 to 127, as the codes with the high bit set are exception responses.
+func MatchModbus() Matcher {
+	return func(r io.Reader) bool {
+		b := make([]byte, 8)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return false
+		}
+
+		length := binary.BigEndian.Uint16(b[4:6])
+		return binary.BigEndian.Uint16(b[2:4]) == 0 &&
+			length >= minModbusLength && length <= maxModbusLength &&
+			b[7] >= 1 && b[7] <= 127
+	}
+}
+
+// maxSTOMPFrame bounds the bytes of the connect frame of a STOMP client read to
+// find its end.
+const maxSTOMPFrame = 4096
+
+// MatchSTOMP matches the STOMP clients, whose first frame is a CONNECT, or a
+// STOMP since version 1.2: the command alone on its line, then header lines of
+// the form "name:value", a blank line and a body, usually empty, terminated by
+// a null byte, which must come within 4KB. An HTTP CONNECT request, whose
+// command line carries the target and the version, is not matched.
+func MatchSTOMP() Matcher {
+	return func(r io.Reader) bool {
+		command, ok := readLine(r, maxLineLength)
+		if !ok || string(command) != "CONNECT" && string(command) != "STOMP" {
+			return false
+		}
+
+		read := len(command) + 1
+		for {
+			line, ok := readLine(r, maxLineLength)
+			if !ok {
+				return false
+			}
+			if read += len(line) + 1; read > maxSTOMPFrame {
+				return false
+			}
+			if len(line) == 0 {
+				break
+			}
+			if bytes.IndexByte(line, ':') <= 0 {
+				return false
+			}
+		}
+
+		b := make([]byte, 1)
+		for ; read < maxSTOMPFrame; read++ {
+			if _, err := io.ReadFull(r, b); err != nil {
+				return false
+			}
+			if b[0] == 0 {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// msgpackUintSize returns the number of bytes following the first byte of a
+// MessagePack unsigned integer of up to 32 bits, or -1 if it is not one.
+func msgpackUintSize(b byte) int {
+	switch {
+	case b <= 0x7f: // positive fixint
+		return 0
+	case b == 0xcc: // uint8
+		return 1
+	case b == 0xcd: // uint16
+		return 2
+	case b == 0xce: // uint32
+		return 4
+	}
+	return -1
+}
+
+// MatchMsgpackRPC matches the MessagePack-RPC clients, whose first message is a
+// request, the array [type, msgid, method, params]: a 4-element array header,
+// as a fixarray or an array 16 or 32, the type 0 of a request, a message ID
+// which is an unsigned integer of up to 32 bits, and the header of a string,
+// the method name. Checking the message ID and the method keeps it from
+// matching other binary protocols starting with the bytes of the header.
+func MatchMsgpackRPC() Matcher {
+	return func(r io.Reader) bool {
+		b := make([]byte, 1)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return false
+		}
+
+		var header []byte
+		switch b[0] {
+		case 0x94: // fixarray of 4
+		case 0xdc: // array 16
+			header = []byte{0x00, 0x04}
+		case 0xdd: // array 32
+			header = []byte{0x00, 0x00, 0x00, 0x04}
+		default:
+			return false
+		}
+
+		// The rest of the array header, the type and the first byte of the ID
+		b = make([]byte, len(header)+2)
+		if _, err := io.ReadFull(r, b); err != nil || !bytes.Equal(b[:len(header)], header) || b[len(header)] != 0 {
+			return false
+		}
+		size := msgpackUintSize(b[len(header)+1])
+		if size < 0 {
+			return false
+		}
+
+		// The rest of the ID and the header of the method
+		b = make([]byte, size+1)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return false
+		}
+		method := b[size]
+		return method&0xe0 == 0xa0 || method == 0xd9 || method == 0xda || method == 0xdb
+	}
+}
+
+// The bounds of the X11 connection setup of a client.
+const (
+	x11MajorVersion   = 11
+	maxX11AuthName    = 256  // The longest authorization protocol name.
+	maxX11AuthData    = 1024 // The longest authorization data.
+	x11SetupHeaderLen = 12
+)
+
+// MatchX11 matches the X11 clients, whose connection setup starts with the
+// byte order, 'B' for big-endian or 'l' for little-endian, an unused zero
+// byte and the protocol version in that order, which must be 11.0, followed by
+// the lengths of the authorization protocol name and data. To keep other
+// protocols starting with those letters from matching, the unused byte must be
+// zero and both lengths must be plausible.
+func MatchX11() Matcher {
+	return func(r io.Reader) bool {
+		b := make([]byte, x11SetupHeaderLen)
+		if _, err := io.ReadFull(r, b); err != nil || b[1] != 0 {
+			return false
+		}
+
+		var order binary.ByteOrder
+		switch b[0] {
+		case 'B':
+			order = binary.BigEndian
+		case 'l':
+			order = binary.LittleEndian
+		default:
+			return false
+		}
+
+		return order.Uint16(b[2:4]) == x11MajorVersion && order.Uint16(b[4:6]) == 0 &&
+			order.Uint16(b[6:8]) <= maxX11AuthName && order.Uint16(b[8:10]) <= maxX11AuthData
+	}
+}
+
+// maxHelloArgs bounds the length of the HELLO command of a RESP3 client: the
+// version, then AUTH with a user name and a password, and SETNAME with a name.
+const maxHelloArgs = 7
+
+// MatchRESP3 matches the Redis clients speaking RESP3, whose first command is
+// HELLO 3, sent as an array of bulk strings: "*2\r\n$5\r\nHELLO\r\n$1\r\n3\r\n",
+// possibly followed by its AUTH and SETNAME arguments. The RESP2 clients,
+// which do not send HELLO or ask for version 2, are not matched.
+func MatchRESP3() Matcher {
+	return func(r io.Reader) bool {
+		line, ok := readLine(r, maxLineLength)
+		if !ok || len(line) < 2 || line[0] != '*' {
+			return false
+		}
+		if n, err := strconv.Atoi(string(line[1:])); err != nil || n < 2 || n > maxHelloArgs {
+			return false
+		}
+
+		command, ok := readBulkString(r)
+		if !ok || !strings.EqualFold(command, "HELLO") {
+			return false
+		}
+		version, ok := readBulkString(r)
+		return ok && version == "3"
+	}
+}
+
+// readBulkString reads a RESP bulk string, "$<length>\r\n<bytes>\r\n", of up to
+// maxLineLength bytes.
+func readBulkString(r io.Reader) (string, bool) {
+	line, ok := readLine(r, maxLineLength)
+	if !ok || len(line) < 2 || line[0] != '$' {
+		return "", false
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < 0 || n > maxLineLength {
+		return "", false
+	}
+
+	b := make([]byte, n+2)
+	if _, err := io.ReadFull(r, b); err != nil || b[n] != '\r' || b[n+1] != '\n' {
+		return "", false
+	}
+	return string(b[:n]), true
+}
+
+// The CQL native protocol frames.
+const (
+	cqlMinVersion    = 3 // The first version with the 9-byte header.
+	cqlMaxVersion    = 5
+	cqlResponse      = 0x80 // The direction bit of the version byte.
+	cqlFlagMask      = 0x1f // Compression, tracing, custom payload, warning and beta.
+	cqlOpStartup     = 0x01
+	cqlOpOptions     = 0x05
+	cqlHeaderLen     = 9
+	maxCQLStartupLen = 4096
+)
+
+// MatchCQL matches the Cassandra clients speaking the CQL native protocol, from
+// version 3 to 5, whose first frame is a request: a 9-byte header with the
+// version, whose direction bit is clear, the flags, a non-negative stream ID,
+// the opcode and the length of the body. The drivers open with either OPTIONS,
+// whose body is empty, or STARTUP, whose body is a string map holding the
+// CQL_VERSION option, so any other opcode is not matched, which keeps it apart
+// from the other binary protocols starting with a version byte.
+func MatchCQL() Matcher {
+	return func(r io.Reader) bool {
+		b := make([]byte, cqlHeaderLen)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return false
+		}
+		if b[0]&cqlResponse != 0 || b[0] < cqlMinVersion || b[0] > cqlMaxVersion ||
+			b[1]&^cqlFlagMask != 0 || b[2]&0x80 != 0 {
+			return false
+		}
+
+		length := binary.BigEndian.Uint32(b[5:])
+		switch b[4] {
+		case cqlOpOptions:
+			return length == 0
+		case cqlOpStartup:
+			if length < 2 || length > maxCQLStartupLen {
+				return false
+			}
+			body := make([]byte, length)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return false
+			}
+			return binary.BigEndian.Uint16(body) > 0 && bytes.Contains(body, []byte("CQL_VERSION"))
+		default:
+			return false
+		}
+	}
+}
+
+// The ZMTP 3 greeting.
+const (
+	zmtpSignatureLen = 10
+	zmtpGreetingLen  = 32 // The signature, the versions and the mechanism.
+	zmtpMajorVersion = 3
+	zmtpGreetingWait = 200 * time.Millisecond
+)
+
+// MatchZMTP matches the ZeroMQ peers speaking ZMTP 3.x, whose greeting starts
+// with a 10-byte signature, 0xFF, 8 bytes of padding and 0x7F, followed by the
+// major and minor versions and by the name of the security mechanism, such as
+// NULL, PLAIN or CURVE, padded with zeros to 20 bytes. The signature keeps it
+// from matching the length-prefixed protocols, and the major version must be 3
+// and the mechanism a valid name.
+//
+// As libzmq sends its signature alone and waits for the one of the server
+// before sending the rest of its greeting, a peer sending nothing within 200ms
+// after its signature is matched on it alone.
+func MatchZMTP() Matcher {
+	return func(r io.Reader) bool {
+		b := make([]byte, zmtpGreetingLen)
+		if _, err := io.ReadFull(r, b[:zmtpSignatureLen]); err != nil || b[0] != 0xff || b[9] != 0x7f {
+			return false
+		}
+
+		version := b[zmtpSignatureLen : zmtpSignatureLen+1]
+		if c := connOf(r); c != nil {
+			deadline := sniffDeadline(r)
+			wait := time.Now().Add(zmtpGreetingWait)
+			window := deadline.IsZero() || wait.Before(deadline)
+			if !window {
+				wait = deadline
+			}
+
+			_ = c.SetReadDeadline(wait)
+			_, err := io.ReadFull(r, version)
+			_ = c.SetReadDeadline(deadline)
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && window {
+				return true
+			} else if err != nil {
+				return false
+			}
+		} else if _, err := io.ReadFull(r, version); err != nil {
+			return false
+		}
+
+		if _, err := io.ReadFull(r, b[zmtpSignatureLen+1:]); err != nil || b[10] != zmtpMajorVersion {
+			return false
+		}
+		return validZMTPMechanism(b[12:])
+	}
+}
+
+// validZMTPMechanism returns whether the mechanism field of a ZMTP greeting
+// holds a name of uppercase letters, digits, '-', '_', '.' or '+', padded with
+// zeros.
+func validZMTPMechanism(b []byte) bool {
+	name := bytes.TrimRight(b, "\x00")
+	if len(name) == 0 {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == '+') {
+			return false
+		}
+	}
+	return true
+}
+
+// The Graphite pickle protocol.
+const (
+	pickleProto        = 0x80    // The opcode starting the pickles of protocol 2 and later.
+	pickleMaxProtocol  = 5       // The latest pickle protocol.
+	pickleEmptyList    = ']'     // The opcode of the protocols 0 and 1 starting a list.
+	pickleMark         = '('     // The opcode of the protocols 0 and 1 starting a list of items.
+	maxGraphitePickle  = 1 << 20 // The largest payload carbon accepts.
+	graphitePickleHead = 6       // The length prefix and the first two opcodes.
+)
+
+// MatchGraphitePickle matches the Graphite clients speaking the pickle
+// protocol, whose messages are a 4-byte big-endian length followed by a Python
+// pickle of a list of metrics. The payload must fit the 1MB carbon accepts and
+// start as a pickle does: with the PROTO opcode and a protocol from 2 to 5, or,
+// for the protocols 0 and 1, with the EMPTY_LIST or MARK opcode. The zero bytes
+// of the length keep it apart from the line protocols, such as StatsD.
+func MatchGraphitePickle() Matcher {
+	return func(r io.Reader) bool {
+		b := make([]byte, graphitePickleHead)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return false
+		}
+		if n := binary.BigEndian.Uint32(b); n < 2 || n > maxGraphitePickle {
+			return false
+		}
+
+		switch b[4] {
+		case pickleProto:
+			return b[5] >= 2 && b[5] <= pickleMaxProtocol
+		case pickleEmptyList, pickleMark:
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+// ftpGreeting is the service ready text MatchFTP replies with.
+const ftpGreeting = "Service ready for new user."
+
+// MatchFTP matches the clients of an FTP control connection, which wait for
+// the server to speak first. It sends the "220 Service ready for new user."
+// reply, and matches the clients replying with a USER command, or with AUTH
+// to upgrade to TLS, or FEAT to list the extensions first. The handler must
+// not send the greeting again.
+//
+// A client which sends nothing is not matched once the read timeout expires,
+// but it has got the greeting by then, which the next routes must cope with.
+func MatchFTP() Matcher {
+	return MatchFTPGreeting(ftpGreeting)
+}
+
+// MatchFTPGreeting matches the clients of an FTP control connection like
+// MatchFTP, but sends the "220 <greeting>\r\n" reply, such as the name of the
+// server followed by ready.
+func MatchFTPGreeting(greeting string) Matcher {
+	reply := []byte("220 " + greeting + "\r\n")
+	return MatchWriter(func(w io.Writer, r io.Reader) bool {
+		if _, err := w.Write(reply); err != nil {
+			return false
+		}
+
+		line, ok := readLine(r, maxLineLength)
+		if !ok || len(line) < 4 || len(line) > 4 && line[4] != ' ' {
+			return false
+		}
+		switch string(bytes.ToUpper(line[:4])) {
+		case "USER", "AUTH", "FEAT":
+			return true
+		}
+		return false
+	})
+}
+
+// sipVersion is the version token of the SIP messages.
+const sipVersion = "SIP/2.0"
+
+// sipMethods are the methods of the SIP requests.
+var sipMethods = map[string]bool{
+	"INVITE": true, "ACK": true, "BYE": true, "CANCEL": true, "REGISTER": true,
+	"OPTIONS": true, "PRACK": true, "SUBSCRIBE": true, "NOTIFY": true,
+	"PUBLISH": true, "INFO": true, "REFER": true, "MESSAGE": true, "UPDATE": true,
+}
+
+// MatchSIP matches the SIP peers over TCP, whose first line is either a
+// request line, such as "INVITE sip:bob@example.com SIP/2.0", made of a SIP
+// method, a sip, sips or tel URI and the version, or a status line, such as
+// "SIP/2.0 200 OK". The SIP/2.0 version token keeps it apart from HTTP, whose
+// request line has the same shape.
+func MatchSIP() Matcher {
+	return func(r io.Reader) bool {
+		line, ok := readLine(r, maxLineLength)
+		if !ok {
+			return false
+		}
+
+		fields := strings.Split(string(line), " ")
+		if fields[0] == sipVersion {
+			if len(fields) < 2 || len(fields[1]) != 3 {
+				return false
+			}
+			code, err := strconv.Atoi(fields[1])
+			return err == nil && code >= 100 && code <= 699
+		}
+
+		if len(fields) != 3 || !sipMethods[fields[0]] || fields[2] != sipVersion {
+			return false
+		}
+		uri := strings.ToLower(fields[1])
+		return strings.HasPrefix(uri, "sip:") || strings.HasPrefix(uri, "sips:") || strings.HasPrefix(uri, "tel:")
+	}
+}
@@ -0,0 +1,780 @@
+package listener
+
+import (
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchAny(t *testing.T) {
+	testMatcher(t, MatchAny(), []matcherCase{
+		{"empty", "", true},
+		{"data", "anything", true},
+	})
+}
+
+func TestMatchPrefix(t *testing.T) {
+	testMatcher(t, MatchPrefix("GET", "GEM"), []matcherCase{
+		{"first", "GET /", true},
+		{"second", "GEMINI", true},
+		{"other", "GEX", false},
+		{"short", "GE", false},
+	})
+	testMatcher(t, MatchPrefix(""), []matcherCase{
+		{"empty prefix", "", true},
+	})
+}
+
+func TestMatchHTTP(t *testing.T) {
+	testMatcher(t, MatchHTTP("PROPFIND"), []matcherCase{
+		{"get", "GET / HTTP/1.1\r\n", true},
+		{"post", "POST /x HTTP/1.1\r\n", true},
+		{"extension", "PROPFIND / HTTP/1.1\r\n", true},
+		{"binary", "\x16\x03\x01", false},
+	})
+}
+
+func TestMatchHTTP1Strict(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		strict bool
+	}{
+		{"origin form", "GET /index.html HTTP/1.1\r\n", true},
+		{"absolute form", "GET http://example.com/ HTTP/1.0\r\n", true},
+		{"connect", "CONNECT example.com:443 HTTP/1.1\r\n", true},
+		{"options asterisk", "OPTIONS * HTTP/1.1\r\n", true},
+		{"extension method", "PROPFIND / HTTP/1.1\r\n", true},
+		{"binary target", "GET \x00\x01\x02 HTTP/1.1\r\n", false},
+		{"relative target", "GET index.html HTTP/1.1\r\n", false},
+		{"connect path", "CONNECT /x HTTP/1.1\r\n", false},
+		{"asterisk for get", "GET * HTTP/1.1\r\n", false},
+		{"http 2", "GET / HTTP/2.0\r\n", false},
+		{"no version", "GET /\r\n", false},
+		{"double space", "GET  / HTTP/1.1\r\n", false},
+		{"binary tail", "GET /\xff\xfe\r\n", false},
+		{"unterminated", "GET / HTTP/1.1", false},
+	}
+	loose, strict := MatchHTTP("PROPFIND"), MatchHTTP1Strict("PROPFIND")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !loose(strings.NewReader(tt.input)) {
+				t.Fatalf("MatchHTTP(%q) = false, want a borderline input", tt.input)
+			}
+			if got := strict(strings.NewReader(tt.input)); got != tt.strict {
+				t.Errorf("MatchHTTP1Strict(%q) = %v, want %v", tt.input, got, tt.strict)
+			}
+		})
+	}
+}
+
+func TestMatchStatsD(t *testing.T) {
+	testMatcher(t, MatchStatsD(), []matcherCase{
+		{"counter", "foo:1|c\n", true},
+		{"gauge with rate and tags", "a.b-c:-1.5|g|@0.1|#x:y\r\n", true},
+		{"timer", "api.latency:320|ms\n", true},
+		{"text with a colon", "hello: world\n", false},
+		{"unknown type", "foo:1|x\n", false},
+		{"missing type", "foo:1\n", false},
+		{"empty name", ":1|c\n", false},
+		{"http", "GET / HTTP/1.1\r\n", false},
+		{"unterminated", "foo:1|c", false},
+	})
+}
+
+func TestMatchFirstLine(t *testing.T) {
+	// A Gopher request is a selector, optionally followed by a tab and the
+	// search terms, and the empty selector asks for the root menu.
+	gopher := func(line string) bool {
+		selector := strings.SplitN(line, "\t", 2)[0]
+		return selector == "" || strings.HasPrefix(selector, "/") && !strings.ContainsAny(selector, " ")
+	}
+	testMatcher(t, MatchFirstLine(gopher), []matcherCase{
+		{"root menu", "\r\n", true},
+		{"selector", "/docs/readme.txt\r\n", true},
+		{"search", "/search\tgo mux\r\n", true},
+		{"lf only", "/docs\n", true},
+		{"http", "GET / HTTP/1.1\r\n", false},
+		{"unterminated", "/docs", false},
+		{"too long", "/" + strings.Repeat("a", maxLineLength) + "\r\n", false},
+	})
+}
+
+func TestMatchFirstLineReadTimeout(t *testing.T) {
+	m := newTestMux(t)
+	m.SetReadTimeout(50 * time.Millisecond)
+	r := m.Match(MatchFirstLine(func(string) bool { return true }))
+	m.serve()
+
+	c := m.dial("/docs")
+	expectClosed(t, c)
+	expectNoAccept(t, r, 20*time.Millisecond)
+}
+
+func TestMatchCoAP(t *testing.T) {
+	testMatcher(t, MatchCoAP(), []matcherCase{
+		{"csm signal", "\x00\xe1", true},
+		{"get with token and options", "\x12\x01\xab\xcd\xb1", true},
+		{"extended length", "\xd0\x05\x02\x00", true},
+		{"mqtt connect", "\x10\x0c\x00\x04MQTT\x04\x02\x00\x3c", false},
+		{"reserved token length", "\x09\x01\x00\x00\x00\x00\x00\x00\x00\x00", false},
+		{"response code", "\x00\x45", false},
+		{"http", "GET / HTTP/1.1\r\n", false},
+		{"truncated", "\x12\x01", false},
+	})
+}
+
+func TestMatchThrift(t *testing.T) {
+	testMatcher(t, MatchThrift(), []matcherCase{
+		{"binary call", "\x00\x00\x00\x15\x80\x01\x00\x01\x00\x00\x00\x04ping\x00\x00\x00\x01\x00", true},
+		{"binary oneway", "\x00\x00\x00\x10\x80\x01\x00\x04", true},
+		{"compact call", "\x00\x00\x00\x0a\x82\x21\x01\x04ping", true},
+		{"binary unknown type", "\x00\x00\x00\x10\x80\x01\x00\x05", false},
+		{"compact version 2", "\x00\x00\x00\x0a\x82\x22\x01\x04", false},
+		{"other framed payload", "\x00\x00\x00\x10{\"id\":1}", false},
+		{"oversized frame", "\x7f\x00\x00\x00\x80\x01\x00\x01", false},
+		{"empty frame", "\x00\x00\x00\x00\x80\x01\x00\x01", false},
+		{"unframed binary", "\x80\x01\x00\x01\x00\x00\x00\x04", false},
+	})
+}
+
+func TestMatchNATS(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		nats    bool
+	}{
+		{"connect", "CONNECT {\"verbose\":false}\r\n", true},
+		{"ping", "ping\r\n", true},
+		{"sub", "SUB foo 1\r\n", true},
+		{"pub", "PUB foo 5\r\n", false},
+		{"http", "GET / HTTP/1.1\r\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMux(t)
+			nats := m.Route("nats", MatchNATS())
+			fallback := m.Route("fallback", MatchAny())
+			m.serve()
+
+			// The client reads the INFO line before it replies
+			c := m.dial("")
+			greeting := "INFO " + natsInfo + "\r\n"
+			if got := readN(t, c, len(greeting)); got != greeting {
+				t.Fatalf("client read %q, want %q", got, greeting)
+			}
+			go func() { _, _ = c.Write([]byte(tt.command)) }()
+
+			route := fallback
+			if tt.nats {
+				route = nats
+			}
+			if got := readN(t, accept(t, route), len(tt.command)); got != tt.command {
+				t.Errorf("%s handler read %q, want %q", route.Name(), got, tt.command)
+			}
+		})
+	}
+}
+
+func TestMatchNATSInfo(t *testing.T) {
+	const info = `{"server_id":"edge","version":"2.10.0","max_payload":65536}`
+	m := newTestMux(t)
+	nats := m.Route("nats", MatchNATSInfo(info))
+	m.serve()
+
+	c := m.dial("")
+	if got, want := readN(t, c, len(info)+7), "INFO "+info+"\r\n"; got != want {
+		t.Fatalf("client read %q, want %q", got, want)
+	}
+	go func() { _, _ = c.Write([]byte("CONNECT {}\r\n")) }()
+	readN(t, accept(t, nats), 12)
+}
+
+func TestMatchIdle(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		route string
+	}{
+		{"silent", "", "idle"},
+		{"talkative", "HELLO\r\n", "talk"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMux(t)
+			routes := map[string]*Route{
+				"idle": m.Route("idle", MatchIdle(50*time.Millisecond)),
+				"talk": m.Route("talk", MatchPrefix("HELLO")),
+			}
+			m.serve()
+
+			client := m.dial(tt.data)
+			c := accept(t, routes[tt.route])
+			if got := readN(t, c, len(tt.data)); got != tt.data {
+				t.Errorf("handler read %q, want %q", got, tt.data)
+			}
+
+			// The window does not leave its deadline on the connection
+			time.Sleep(60 * time.Millisecond)
+			go func() { _, _ = client.Write([]byte("later")) }()
+			if _, err := io.ReadFull(c, make([]byte, 5)); err != nil {
+				t.Errorf("handler read after the window: %v", err)
+			}
+		})
+	}
+}
+
+func TestMatchIdleBoundedByReadTimeout(t *testing.T) {
+	m := newTestMux(t)
+	m.SetReadTimeout(50 * time.Millisecond)
+	idle := m.Match(MatchIdle(time.Hour))
+	m.serve()
+
+	start := time.Now()
+	m.dial("")
+	accept(t, idle)
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("silent client matched after %v, want the 50ms read timeout", d)
+	}
+}
+
+func TestMatchPostgres(t *testing.T) {
+	startup := "\x00\x00\x00\x25\x00\x03\x00\x00user\x00postgres\x00database\x00rtms\x00\x00"
+	testMatcher(t, MatchPostgres(), []matcherCase{
+		{"ssl request", "\x00\x00\x00\x08\x04\xd2\x16\x2f", true},
+		{"gssenc request", "\x00\x00\x00\x08\x04\xd2\x16\x30", true},
+		{"startup", startup, true},
+		{"ssl request with a wrong length", "\x00\x00\x00\x10\x04\xd2\x16\x2f", false},
+		{"startup too large", "\x00\x01\x00\x00\x00\x03\x00\x00", false},
+		{"protocol 2", "\x00\x00\x01\x28\x00\x02\x00\x00", false},
+		{"cancel request", "\x00\x00\x00\x10\x04\xd2\x16\x2e", false},
+		{"other framed payload", "\x00\x00\x00\x08\x00\x00\x00\x01", false},
+		{"truncated", "\x00\x00\x00\x08\x04\xd2", false},
+	})
+}
+
+func TestMatchMagic(t *testing.T) {
+	mainnet := []byte{0xf9, 0xbe, 0xb4, 0xd9}
+	testMatcher(t, MatchMagic(0, mainnet), []matcherCase{
+		{"magic", "\xf9\xbe\xb4\xd9version\x00", true},
+		{"other network", "\x0b\x11\x09\x07version\x00", false},
+		{"short", "\xf9\xbe", false},
+	})
+	testMatcher(t, MatchMagic(4, []byte("ftyp")), []matcherCase{
+		{"magic at offset", "\x00\x00\x00\x18ftypmp42", true},
+		{"magic at start", "ftypmp42", false},
+		{"short", "\x00\x00\x00\x18ft", false},
+	})
+	testMatcher(t, MatchMagic(1<<30, []byte("ftyp")), []matcherCase{
+		{"offset past the data", "\x00\x00\x00\x18ftypmp42", false},
+	})
+
+	defer func() {
+		if recover() == nil {
+			t.Error("MatchMagic() with a negative offset did not panic")
+		}
+	}()
+	MatchMagic(-1, mainnet)
+}
+
+func TestMatchSyslog(t *testing.T) {
+	testMatcher(t, MatchSyslog(), []matcherCase{
+		{"octet counting", "57 <134>1 2003-10-11T22:14:15.003Z host app - - - hello", true},
+		{"non-transparent rfc 3164", "<34>Oct 11 22:14:15 mymachine su: 'su root' failed\n", true},
+		{"non-transparent rfc 5424", "<165>1 2003-08-24T05:14:15.000003-07:00 host app\n", true},
+		{"zero priority", "<0>Jan  1 00:00:00 host kernel: boot\n", true},
+		{"priority too large", "<192>Oct 11 22:14:15 host\n", false},
+		{"leading zero", "<034>Oct 11 22:14:15 host\n", false},
+		{"no header", "<34>hello world\n", false},
+		{"number", "12345\n", false},
+		{"length without message", "57 hello\n", false},
+		{"xml", "<?xml version=\"1.0\"?>\n", false},
+	})
+}
+
+func TestMatchGELF(t *testing.T) {
+	testMatcher(t, MatchGELF(), []matcherCase{
+		{"message", `{"version":"1.1","host":"example.org","short_message":"hello"}` + "\x00", true},
+		{"version after other fields", `{"host":"a","_extra":{"nested":[1,2]},"version":"1.1"}` + "\x00", true},
+		{"leading whitespace", " \n{\"version\": \"1.0\"}\x00", true},
+		{"plain json", `{"host":"a","message":"hello"}` + "\n", false},
+		{"other version", `{"version":"2.0"}` + "\x00", false},
+		{"numeric version", `{"version":1.1}` + "\x00", false},
+		{"array", `[{"version":"1.1"}]`, false},
+	})
+}
+
+func TestMatchKafka(t *testing.T) {
+	// ApiVersions v3 as sent by librdkafka
+	apiVersions := "\x00\x00\x00\x24\x00\x12\x00\x03\x00\x00\x00\x01\x00\x07rdkafka\x00" +
+		"\x0blibrdkafka\x062.3.0\x00"
+	testMatcher(t, MatchKafka(), []matcherCase{
+		{"api versions", apiVersions, true},
+		{"null client id", "\x00\x00\x00\x0a\x00\x03\x00\x09\x00\x00\x00\x02\xff\xff", true},
+		{"unknown api key", "\x00\x00\x00\x24\x00\x7f\x00\x03\x00\x00\x00\x01\x00\x07rdkafka", false},
+		{"negative correlation id", "\x00\x00\x00\x24\x00\x12\x00\x03\xff\x00\x00\x01\x00\x07rdkafka", false},
+		{"client id past the request", "\x00\x00\x00\x0c\x00\x12\x00\x03\x00\x00\x00\x01\x00\x07rdkafka", false},
+		{"binary client id", "\x00\x00\x00\x24\x00\x12\x00\x03\x00\x00\x00\x01\x00\x07rd\x00\x01fka", false},
+		{"thrift", "\x00\x00\x00\x15\x80\x01\x00\x01\x00\x00\x00\x04ping\x00\x00\x00\x01\x00", false},
+		{"postgres startup", "\x00\x00\x00\x25\x00\x03\x00\x00user\x00postgres\x00database\x00rtms\x00\x00", false},
+	})
+}
+
+func TestMatchQUICInitial(t *testing.T) {
+	dcid := "\x83\x94\xc8\xf0\x3e\x51\x57\x08"
+	initial := "\xc3\x00\x00\x00\x01\x08" + dcid + "\x00\x00\x44\x9e"
+	testMatcher(t, MatchQUICInitial(), []matcherCase{
+		{"version 1", initial, true},
+		{"version 2", "\xd3\x6b\x33\x43\xcf\x08" + dcid + "\x00", true},
+		{"version 2 with type 0", "\xc3\x6b\x33\x43\xcf\x08" + dcid + "\x00", false},
+		{"handshake packet", "\xe3\x00\x00\x00\x01\x08" + dcid + "\x00", false},
+		{"version negotiation", "\xc0\x00\x00\x00\x00\x08" + dcid + "\x00", false},
+		{"short destination id", "\xc3\x00\x00\x00\x01\x04\x01\x02\x03\x04\x00", false},
+		{"long source id", "\xc3\x00\x00\x00\x01\x08" + dcid + "\x15", false},
+		{"short header", "\x43" + dcid, false},
+		{"tls", clientHelloRecord(sniExtension("a")), false},
+	})
+
+	m := newTestMux(t)
+	quic := m.Match(MatchQUICInitial())
+	tls := m.Match(MatchTLS())
+	m.serve()
+
+	hello := clientHelloRecord(sniExtension("a"))
+	m.dial(hello)
+	readN(t, accept(t, tls), len(hello))
+	m.dial(initial)
+	readN(t, accept(t, quic), len(initial))
+}
+
+func TestMatchSMTP(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	greeting := "220 " + host + " ESMTP Service ready\r\n"
+	tests := []struct {
+		name    string
+		command string
+		smtp    bool
+	}{
+		{"ehlo", "EHLO client.example.org\r\n", true},
+		{"helo", "helo client\r\n", true},
+		{"bare ehlo", "EHLO\r\n", true},
+		{"ehlo prefix", "EHLOX client\r\n", false},
+		{"mail before hello", "MAIL FROM:<a@example.org>\r\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMux(t)
+			smtp := m.Route("smtp", MatchSMTP())
+			fallback := m.Route("fallback", MatchAny())
+			m.serve()
+
+			// The client reads the greeting before it replies
+			c := m.dial("")
+			if got := readN(t, c, len(greeting)); got != greeting {
+				t.Fatalf("client read %q, want %q", got, greeting)
+			}
+			go func() { _, _ = c.Write([]byte(tt.command)) }()
+
+			route := fallback
+			if tt.smtp {
+				route = smtp
+			}
+			if got := readN(t, accept(t, route), len(tt.command)); got != tt.command {
+				t.Errorf("%s handler read %q, want %q", route.Name(), got, tt.command)
+			}
+		})
+	}
+}
+
+func TestMatchSMTPSilentClient(t *testing.T) {
+	m := newTestMux(t)
+	m.SetReadTimeout(50 * time.Millisecond)
+	m.Route("smtp", MatchSMTPGreeting("mx.example.com ESMTP"))
+	m.serve()
+
+	// The silent client got the greeting before it is dropped
+	c := m.dial("")
+	if got := expectClosed(t, c); got != "220 mx.example.com ESMTP\r\n" {
+		t.Errorf("silent client read %q, want the greeting only", got)
+	}
+}
+
+func TestMatchRTMP(t *testing.T) {
+	// C0 and the start of C1: its time and zero fields, then random bytes
+	c1 := "\x00\x00\x00\x00\x00\x00\x00\x00" + strings.Repeat("\x5a", 32)
+	testMatcher(t, MatchRTMP(), []matcherCase{
+		{"plain", "\x03" + c1, true},
+		{"encrypted", "\x06" + c1, true},
+		{"rdp connection request", "\x03\x00\x00\x13\x0e\xe0\x00\x00\x00\x00\x00", false},
+		{"other version", "\x08" + c1, false},
+		{"tls", clientHelloRecord(sniExtension("a")), false},
+		{"short", "\x03\x00", false},
+	})
+
+	// The C1 chunk is not buffered whole
+	route, sniffed := MatchBytes(func(l *Listener) map[string]net.Listener {
+		return map[string]net.Listener{"rtmp": l.Match(MatchRTMP())}
+	}, []byte("\x03"+strings.Repeat("\x00", 1536)))
+	if route != "rtmp" || len(sniffed) > 64 {
+		t.Errorf("MatchBytes() = %q after sniffing %d bytes, want rtmp after a few", route, len(sniffed))
+	}
+}
+
+func TestMatchOpenVPN(t *testing.T) {
+	// A session ID, an empty ACK array and a packet ID
+	reset := "\x77\x1f\x26\x9a\x3b\x10\x44\x5e\x00\x00\x00\x00\x00"
+	testMatcher(t, MatchOpenVPN(), []matcherCase{
+		{"hard reset v2", "\x00\x0e\x38" + reset, true},
+		{"hard reset v1", "\x00\x0e\x08" + reset, true},
+		{"hard reset v3", "\x01\x20\x50" + reset, true},
+		{"server reset", "\x00\x0e\x40" + reset, false},
+		{"data packet", "\x00\x0e\x30" + reset, false},
+		{"key id", "\x00\x0e\x39" + reset, false},
+		{"too short", "\x00\x04\x38" + reset, false},
+		{"too long", "\x10\x00\x38" + reset, false},
+		{"other frame", "\x00\x0e{\"id\":1}", false},
+	})
+}
+
+func TestMatchBeanstalk(t *testing.T) {
+	testMatcher(t, MatchBeanstalk(), []matcherCase{
+		{"put", "put 0 0 60 5\r\nhello\r\n", true},
+		{"reserve", "reserve\r\n", true},
+		{"use", "use jobs\r\n", true},
+		{"uppercase", "PUT 0 0 60 5\r\n", false},
+		{"command prefix", "putx 0 0 60 5\r\n", false},
+		{"bare newline", "put 0 0 60 5\n", true},
+		{"not a command", "hello world\r\n", false},
+		{"http", "GET / HTTP/1.1\r\n", false},
+		{"too long", "put " + strings.Repeat("0", maxLineLength) + "\r\n", false},
+	})
+	testMatcher(t, MatchBeanstalk("enqueue", "dequeue"), []matcherCase{
+		{"custom command", "enqueue jobs 5\r\n", true},
+		{"default command", "put 0 0 60 5\r\n", false},
+	})
+}
+
+func TestMatchModbus(t *testing.T) {
+	// Read holding registers 0 to 9 of unit 1
+	const read = "\x00\x01\x00\x00\x00\x06\x01\x03\x00\x00\x00\x0a"
+	testMatcher(t, MatchModbus(), []matcherCase{
+		{"read holding registers", read, true},
+		{"write single coil", "\x12\x34\x00\x00\x00\x06\xff\x05\x00\x10\xff\x00", true},
+		{"protocol id", "\x00\x01\x00\x01\x00\x06\x01\x03\x00\x00\x00\x0a", false},
+		{"length too short", "\x00\x01\x00\x00\x00\x01\x01\x03", false},
+		{"length too long", "\x00\x01\x00\x00\x01\x00\x01\x03", false},
+		{"function code zero", "\x00\x01\x00\x00\x00\x06\x01\x00\x00\x00\x00\x0a", false},
+		{"exception response", "\x00\x01\x00\x00\x00\x03\x01\x83\x02", false},
+		{"mqtt connect", "\x10\x0c\x00\x04MQTT\x04\x02\x00\x3c", false},
+		{"truncated header", read[:7], false},
+	})
+}
+
+func TestMatchSTOMP(t *testing.T) {
+	testMatcher(t, MatchSTOMP(), []matcherCase{
+		{"connect", "CONNECT\naccept-version:1.2\nhost:broker\n\n\x00", true},
+		{"stomp crlf", "STOMP\r\naccept-version:1.2\r\nhost:broker\r\nlogin:a\r\n\r\n\x00", true},
+		{"no headers", "CONNECT\n\n\x00", true},
+		{"http connect", "CONNECT broker:61613 HTTP/1.1\r\nHost: broker:61613\r\n\r\n", false},
+		{"malformed header", "CONNECT\nhost broker\n\n\x00", false},
+		{"no null byte", "CONNECT\nhost:broker\n\nbody", false},
+		{"frame too long", "CONNECT\nhost:broker\n\n" + strings.Repeat("x", maxSTOMPFrame) + "\x00", false},
+		{"other command", "SEND\ndestination:/queue/a\n\n\x00", false},
+	})
+}
+
+func TestSTOMPRoute(t *testing.T) {
+	m := newTestMux(t)
+	stomp := m.Route("stomp", MatchSTOMP())
+	web := m.Route("http", MatchHTTP())
+	m.serve()
+
+	tests := []struct {
+		data  string
+		route *Route
+	}{
+		{"CONNECT\naccept-version:1.2\nhost:broker\n\n\x00", stomp},
+		{"CONNECT broker:61613 HTTP/1.1\r\nHost: broker:61613\r\n\r\n", web},
+	}
+	for _, tt := range tests {
+		m.dial(tt.data)
+		if got := readN(t, accept(t, tt.route), len(tt.data)); got != tt.data {
+			t.Errorf("%s handler read %q, want %q", tt.route.Name(), got, tt.data)
+		}
+	}
+}
+
+func TestMatchMsgpackRPC(t *testing.T) {
+	// [0, 1, "add", [1, 2]]
+	const params = "\x92\x01\x02"
+	testMatcher(t, MatchMsgpackRPC(), []matcherCase{
+		{"fixarray", "\x94\x00\x01\xa3add" + params, true},
+		{"array 16", "\xdc\x00\x04\x00\x01\xa3add" + params, true},
+		{"array 32", "\xdd\x00\x00\x00\x04\x00\x01\xa3add" + params, true},
+		{"uint16 id", "\x94\x00\xcd\x01\x00\xa3add" + params, true},
+		{"uint32 id", "\x94\x00\xce\x00\x01\x00\x00\xa3add" + params, true},
+		{"str8 method", "\x94\x00\x01\xd9\x03add" + params, true},
+		{"response", "\x94\x01\x01\xc0\x03", false},
+		{"notification", "\x93\x02\xa3add" + params, false},
+		{"array 16 of 3", "\xdc\x00\x03\x00\x01\xa3add", false},
+		{"negative id", "\x94\x00\xff\xa3add" + params, false},
+		{"method not a string", "\x94\x00\x01\x07" + params, false},
+		{"modbus", "\x00\x01\x00\x00\x00\x06\x01\x03\x00\x00\x00\x0a", false},
+	})
+}
+
+func TestMatchX11(t *testing.T) {
+	// The MIT-MAGIC-COOKIE-1 authorization, padded to 4 bytes, and its cookie
+	auth := "MIT-MAGIC-COOKIE-1\x00\x00" + strings.Repeat("\xab", 16)
+	testMatcher(t, MatchX11(), []matcherCase{
+		{"little endian", "l\x00\x0b\x00\x00\x00\x12\x00\x10\x00\x00\x00" + auth, true},
+		{"big endian", "B\x00\x00\x0b\x00\x00\x00\x12\x00\x10\x00\x00" + auth, true},
+		{"no authorization", "l\x00\x0b\x00\x00\x00\x00\x00\x00\x00\x00\x00", true},
+		{"byte order mismatch", "l\x00\x00\x0b\x00\x00\x00\x12\x00\x10\x00\x00" + auth, false},
+		{"version 10", "B\x00\x00\x0a\x00\x00\x00\x12\x00\x10\x00\x00" + auth, false},
+		{"minor version", "B\x00\x00\x0b\x00\x01\x00\x12\x00\x10\x00\x00" + auth, false},
+		{"unused byte", "Bx\x00\x0b\x00\x00\x00\x12\x00\x10\x00\x00" + auth, false},
+		{"name too long", "B\x00\x00\x0b\x00\x00\x10\x00\x00\x10\x00\x00" + auth, false},
+		{"data too long", "B\x00\x00\x0b\x00\x00\x00\x12\x10\x00\x00\x00" + auth, false},
+		{"text", "BEGIN TRANSACTION;\n", false},
+		{"truncated", "l\x00\x0b\x00\x00\x00", false},
+	})
+}
+
+func TestMatchRESP3(t *testing.T) {
+	testMatcher(t, MatchRESP3(), []matcherCase{
+		{"hello 3", "*2\r\n$5\r\nHELLO\r\n$1\r\n3\r\n", true},
+		{"lower case", "*2\r\n$5\r\nhello\r\n$1\r\n3\r\n", true},
+		{"auth and setname", "*7\r\n$5\r\nHELLO\r\n$1\r\n3\r\n$4\r\nAUTH\r\n$1\r\na\r\n$1\r\nb\r\n$7\r\nSETNAME\r\n$1\r\nc\r\n", true},
+		{"hello 2", "*2\r\n$5\r\nHELLO\r\n$1\r\n2\r\n", false},
+		{"hello alone", "*1\r\n$5\r\nHELLO\r\n", false},
+		{"too many arguments", "*8\r\n$5\r\nHELLO\r\n$1\r\n3\r\n", false},
+		{"resp2 ping", "*1\r\n$4\r\nPING\r\n", false},
+		{"get", "*2\r\n$3\r\nGET\r\n$1\r\n3\r\n", false},
+		{"inline hello", "HELLO 3\r\n", false},
+		{"bad bulk length", "*2\r\n$9\r\nHELLO\r\n$1\r\n3\r\n", false},
+	})
+}
+
+func TestRESP3Route(t *testing.T) {
+	m := newTestMux(t)
+	resp3 := m.Route("resp3", MatchRESP3())
+	resp2 := m.Route("resp2", MatchPrefix("*"))
+	m.serve()
+
+	tests := []struct {
+		data  string
+		route *Route
+	}{
+		{"*2\r\n$5\r\nHELLO\r\n$1\r\n3\r\n", resp3},
+		{"*1\r\n$4\r\nPING\r\n", resp2},
+	}
+	for _, tt := range tests {
+		m.dial(tt.data)
+		if got := readN(t, accept(t, tt.route), len(tt.data)); got != tt.data {
+			t.Errorf("%s handler read %q, want %q", tt.route.Name(), got, tt.data)
+		}
+	}
+}
+
+func TestMatchCQL(t *testing.T) {
+	// A string map of one entry, CQL_VERSION=3.0.0
+	const body = "\x00\x01\x00\x0bCQL_VERSION\x00\x053.0.0"
+	startup := func(version, flags byte) string {
+		return string([]byte{version, flags, 0x00, 0x00, cqlOpStartup, 0, 0, 0, byte(len(body))}) + body
+	}
+	testMatcher(t, MatchCQL(), []matcherCase{
+		{"startup v4", startup(0x04, 0x00), true},
+		{"startup v3", startup(0x03, 0x00), true},
+		{"startup v5 beta", startup(0x05, 0x10), true},
+		{"options", "\x04\x00\x00\x01\x05\x00\x00\x00\x00", true},
+		{"response", startup(0x84, 0x00), false},
+		{"version 2", startup(0x02, 0x00), false},
+		{"version 6", startup(0x06, 0x00), false},
+		{"unknown flags", startup(0x04, 0x20), false},
+		{"negative stream", "\x04\x00\x80\x00\x05\x00\x00\x00\x00", false},
+		{"options with body", "\x04\x00\x00\x01\x05\x00\x00\x00\x02\x00\x00", false},
+		{"query opcode", "\x04\x00\x00\x01\x07\x00\x00\x00\x00", false},
+		{"startup without version", "\x04\x00\x00\x00\x01\x00\x00\x00\x02\x00\x00", false},
+		{"startup too long", "\x04\x00\x00\x00\x01\x00\x01\x00\x00", false},
+		{"mqtt connect", "\x10\x0c\x00\x04MQTT\x04\x02\x00\x3c", false},
+	})
+}
+
+// zmtpGreeting returns a ZMTP greeting of the version and the mechanism.
+func zmtpGreeting(major, minor byte, mechanism string) string {
+	b := make([]byte, zmtpGreetingLen)
+	b[0], b[9] = 0xff, 0x7f
+	b[10], b[11] = major, minor
+	copy(b[12:], mechanism)
+	return string(b)
+}
+
+func TestMatchZMTP(t *testing.T) {
+	testMatcher(t, MatchZMTP(), []matcherCase{
+		{"null", zmtpGreeting(3, 0, "NULL"), true},
+		{"curve 3.1", zmtpGreeting(3, 1, "CURVE"), true},
+		{"plain", zmtpGreeting(3, 1, "PLAIN") + "\x04\x19\x05READY", true},
+		{"version 2", zmtpGreeting(2, 0, "NULL"), false},
+		{"lowercase mechanism", zmtpGreeting(3, 0, "null"), false},
+		{"no mechanism", zmtpGreeting(3, 0, ""), false},
+		{"signature", "\xff\x00\x00\x00\x00\x00\x00\x00\x01\x7e" + zmtpGreeting(3, 0, "NULL")[10:], false},
+		{"length prefixed", "\x00\x00\x00\x20" + zmtpGreeting(3, 0, "NULL")[4:], false},
+		{"truncated", zmtpGreeting(3, 0, "NULL")[:20], false},
+	})
+}
+
+func TestZMTPSignatureAlone(t *testing.T) {
+	m := newTestMux(t)
+	zmtp := m.Route("zmtp", MatchZMTP())
+	m.serve()
+
+	// libzmq waits for the signature of the server before its version
+	signature := zmtpGreeting(3, 0, "NULL")[:zmtpSignatureLen]
+	start := time.Now()
+	m.dial(signature)
+	if got := readN(t, accept(t, zmtp), len(signature)); got != signature {
+		t.Errorf("handler read %q, want the signature", got)
+	}
+	if d := time.Since(start); d < zmtpGreetingWait {
+		t.Errorf("matched after %v, want the %v wait for the greeting", d, zmtpGreetingWait)
+	}
+}
+
+func TestMatchGraphitePickle(t *testing.T) {
+	// pickle.dumps([("a.b", (1, 2.0))], protocol) for the protocols 2 and 0
+	const v2 = "\x80\x02]q\x00U\x03a.bq\x01K\x01G@\x00\x00\x00\x00\x00\x00\x00\x86q\x02\x86q\x03a."
+	const v0 = "(lp0\n(S'a.b'\np1\n(I1\nF2.0\ntp2\ntp3\na."
+	frame := func(payload string) string {
+		return string([]byte{0, 0, byte(len(payload) >> 8), byte(len(payload))}) + payload
+	}
+	testMatcher(t, MatchGraphitePickle(), []matcherCase{
+		{"protocol 2", frame(v2), true},
+		{"protocol 0", frame(v0), true},
+		{"empty list", frame("]."), true},
+		{"protocol 5", frame("\x80\x05\x95"), true},
+		{"protocol 6", frame("\x80\x06]."), false},
+		{"protocol 1 proto", frame("\x80\x01]."), false},
+		{"not a pickle", frame("{\"a.b\": 1}"), false},
+		{"too long", "\x00\x10\x00\x01\x80\x02", false},
+		{"too short", "\x00\x00\x00\x01\x80\x02", false},
+		{"line protocol", "a.b 1 1700000000\n", false},
+	})
+}
+
+func TestGraphitePickleRoute(t *testing.T) {
+	m := newTestMux(t)
+	pickle := m.Route("pickle", MatchGraphitePickle())
+	statsd := m.Route("statsd", MatchStatsD())
+	m.serve()
+
+	tests := []struct {
+		data  string
+		route *Route
+	}{
+		{"\x00\x00\x00\x02].", pickle},
+		{"a.b:1|c\n", statsd},
+	}
+	for _, tt := range tests {
+		m.dial(tt.data)
+		if got := readN(t, accept(t, tt.route), len(tt.data)); got != tt.data {
+			t.Errorf("%s handler read %q, want %q", tt.route.Name(), got, tt.data)
+		}
+	}
+}
+
+func TestMatchFTP(t *testing.T) {
+	const greeting = "220 Service ready for new user.\r\n"
+	tests := []struct {
+		name    string
+		command string
+		ftp     bool
+	}{
+		{"user", "USER anonymous\r\n", true},
+		{"auth tls", "AUTH TLS\r\n", true},
+		{"feat", "FEAT\r\n", true},
+		{"lowercase", "user anonymous\r\n", true},
+		{"user prefix", "USERS anonymous\r\n", false},
+		{"pass before user", "PASS secret\r\n", false},
+		{"smtp hello", "EHLO client\r\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMux(t)
+			ftp := m.Route("ftp", MatchFTP())
+			fallback := m.Route("fallback", MatchAny())
+			m.serve()
+
+			// The client reads the greeting before it replies
+			c := m.dial("")
+			if got := readN(t, c, len(greeting)); got != greeting {
+				t.Fatalf("client read %q, want %q", got, greeting)
+			}
+			go func() { _, _ = c.Write([]byte(tt.command)) }()
+
+			route := fallback
+			if tt.ftp {
+				route = ftp
+			}
+			if got := readN(t, accept(t, route), len(tt.command)); got != tt.command {
+				t.Errorf("%s handler read %q, want %q", route.Name(), got, tt.command)
+			}
+		})
+	}
+}
+
+func TestMatchFTPSilentClient(t *testing.T) {
+	m := newTestMux(t)
+	m.SetReadTimeout(50 * time.Millisecond)
+	m.Route("ftp", MatchFTPGreeting("ftp.example.com FTP server ready."))
+	m.serve()
+
+	// The silent client got the greeting before it is dropped
+	c := m.dial("")
+	if got := expectClosed(t, c); got != "220 ftp.example.com FTP server ready.\r\n" {
+		t.Errorf("silent client read %q, want the greeting only", got)
+	}
+}
+
+func TestMatchSIP(t *testing.T) {
+	testMatcher(t, MatchSIP(), []matcherCase{
+		{"invite", "INVITE sip:bob@example.com SIP/2.0\r\nVia: SIP/2.0/TCP a.example.com\r\n", true},
+		{"register sips", "REGISTER sips:example.com SIP/2.0\r\n", true},
+		{"tel uri", "INVITE tel:+15551234567 SIP/2.0\r\n", true},
+		{"status", "SIP/2.0 200 OK\r\n", true},
+		{"provisional status", "SIP/2.0 180 Ringing\n", true},
+		{"status out of range", "SIP/2.0 700 Oops\r\n", false},
+		{"status not a number", "SIP/2.0 2xx OK\r\n", false},
+		{"unknown method", "FETCH sip:bob@example.com SIP/2.0\r\n", false},
+		{"http uri", "OPTIONS http://example.com SIP/2.0\r\n", false},
+		{"http request", "OPTIONS * HTTP/1.1\r\n", false},
+		{"http status", "HTTP/1.1 200 OK\r\n", false},
+	})
+}
+
+func TestSIPRoute(t *testing.T) {
+	m := newTestMux(t)
+	sip := m.Route("sip", MatchSIP())
+	web := m.Route("web", MatchHTTP())
+	m.serve()
+
+	tests := []struct {
+		data  string
+		route *Route
+	}{
+		{"OPTIONS sip:example.com SIP/2.0\r\n", sip},
+		{"OPTIONS / HTTP/1.1\r\n\r\n", web},
+	}
+	for _, tt := range tests {
+		m.dial(tt.data)
+		if got := readN(t, accept(t, tt.route), len(tt.data)); got != tt.data {
+			t.Errorf("%s handler read %q, want %q", tt.route.Name(), got, tt.data)
+		}
+	}
+}
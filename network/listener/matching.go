@@ -0,0 +1,56 @@
+package listener
+
+// matchingSlot is a slot of the semaphore bounding the number of connections
+// being matched. A nil slot is never bounded.
+type matchingSlot struct {
+	sem  chan struct{}
+	done bool
+}
+
+// acquireMatching waits for a matching slot, if the number of connections being
+// matched is bounded. It returns ErrListenerClosed if the listener is closed
+// while it waits, in which case the caller must close the connection.
+func (m *Listener) acquireMatching() (*matchingSlot, error) {
+	m.RLock()
+	sem := m.matching
+	m.RUnlock()
+	if sem == nil {
+		return nil, nil
+	}
+
+	select {
+	case sem <- struct{}{}:
+		return &matchingSlot{sem: sem}, nil
+	case <-m.root.closing():
+		return nil, ErrListenerClosed
+	}
+}
+
+// release gives the slot back. It can be called repeatedly.
+func (s *matchingSlot) release() {
+	if s == nil || s.done {
+		return
+	}
+	s.done = true
+	<-s.sem
+}
+
+// SetMaxMatching bounds the number of connections being matched at once, and
+// so the goroutines held by slow or stalled handshakes. Once the bound is
+// reached, the listener waits for a match to complete before accepting the
+// next connection, which leaves the pending ones in the backlog of the
+// operating system. A connection leaves the matching phase once it's matched,
+// rejected or found unmatched, so the read timeout should be set too, to keep
+// a stalled handshake from holding its slot. Zero removes the bound.
+//
+// The connections already being matched keep the slots they hold when the
+// bound is changed.
+func (m *Listener) SetMaxMatching(n int) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.matching = nil
+	if n > 0 {
+		m.matching = make(chan struct{}, n)
+	}
+}
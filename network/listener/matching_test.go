@@ -0,0 +1,119 @@
+package listener
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMaxMatching(t *testing.T) {
+	const n, limit = 6, 2
+	m := newMemoryTestMux(t)
+	m.SetMaxMatching(limit)
+	var active, peak int32
+	r := m.Match(func(r io.Reader) bool {
+		now := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			max := atomic.LoadInt32(&peak)
+			if now <= max || atomic.CompareAndSwapInt32(&peak, max, now) {
+				break
+			}
+		}
+		_, err := io.ReadFull(r, make([]byte, 1))
+		return err == nil
+	})
+	m.serve()
+
+	// The handshakes stall until the clients send their byte, and the dials
+	// past the limit wait to be accepted
+	clients := make(chan net.Conn, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			if c, err := m.mem.Dial(); err == nil {
+				clients <- c
+			}
+		}()
+	}
+	waitFor(t, "the matching slots to be taken", func() bool { return atomic.LoadInt32(&active) == limit })
+	time.Sleep(50 * time.Millisecond)
+	if got := len(m.MatchingConnections()); got != limit {
+		t.Errorf("%d connections being matched, want %d", got, limit)
+	}
+
+	// The next connections are matched as the slots are freed
+	for i := 0; i < n; i++ {
+		select {
+		case c := <-clients:
+			t.Cleanup(func() { _ = c.Close() })
+			go func() { _, _ = c.Write([]byte("x")) }()
+		case <-time.After(testTimeout):
+			t.Fatalf("%d connections accepted, want %d", i, n)
+		}
+		accept(t, r)
+	}
+	if p := atomic.LoadInt32(&peak); p != limit {
+		t.Errorf("%d connections matched at once, want at most %d", p, limit)
+	}
+}
+
+func TestMaxMatchingInterruptedByClose(t *testing.T) {
+	m := newTestMux(t)
+	m.SetMaxMatching(1)
+	m.Match(MatchPrefix("HELLO"))
+	m.serve()
+
+	// The stalled client holds the only slot, which the accept loop waits for
+	stalled := m.dial("HE")
+	waitFor(t, "the slot to be taken", func() bool { return len(m.MatchingConnections()) == 1 })
+	_ = m.Close()
+
+	// A connection adopted once closed no longer waits for the slot either
+	client, server := net.Pipe()
+	defer client.Close()
+	if err := m.Adopt(server); err != ErrListenerClosed {
+		t.Errorf("Adopt() = %v, want ErrListenerClosed", err)
+	}
+	expectClosed(t, client)
+
+	_ = stalled.Close()
+	if err := m.wait(); err != ErrListenerClosed {
+		t.Errorf("Serve() = %v, want ErrListenerClosed", err)
+	}
+}
+
+func TestCancelMatch(t *testing.T) {
+	m := newTestMux(t)
+	errs := make(chan error, 1)
+	m.HandleError(func(err error) bool {
+		errs <- err
+		return true
+	})
+	m.ScoredRoute("hello", Named("hello", MatchPrefix("HELLO")))
+	m.serve()
+
+	// The client stalls in the middle of its greeting
+	c := m.dial("HE")
+	var infos []ConnInfo
+	waitFor(t, "the match to be listed", func() bool {
+		infos = m.MatchingConnections()
+		return len(infos) == 1
+	})
+	if info := infos[0]; info.Matcher != "hello" || info.Started.IsZero() {
+		t.Errorf("ConnInfo = %+v, want the hello matcher and its start", info)
+	}
+
+	if !m.CancelMatch(infos[0].ID) {
+		t.Fatal("CancelMatch() = false for a connection being matched")
+	}
+	expectClosed(t, c)
+	if err := <-errs; err != ErrMatchCanceled {
+		t.Errorf("error = %v, want ErrMatchCanceled", err)
+	}
+	waitFor(t, "the match to be removed", func() bool { return len(m.MatchingConnections()) == 0 })
+	if m.CancelMatch(infos[0].ID) {
+		t.Error("CancelMatch() = true for a connection no longer being matched")
+	}
+}
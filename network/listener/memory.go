@@ -0,0 +1,86 @@
+package listener
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// MemoryListener is an in-process listener whose connections are opened with
+// Dial, which makes it possible to run a listener without any socket, in tests
+// for instance.
+//
+// Both ends of its connections are net.Pipe connections, whose deadlines behave
+// like the ones of network connections: a read or write past the deadline fails
+// with os.ErrDeadlineExceeded, so the read timeout of the matchers applies. The
+// deadline of Accept is set with SetDeadline, like for a TCP listener.
+type MemoryListener struct {
+	conns    chan net.Conn
+	closing  chan struct{}
+	once     sync.Once
+	deadline *deadline
+}
+
+// NewMemoryListener creates an in-process listener.
+func NewMemoryListener() *MemoryListener {
+	return &MemoryListener{
+		conns:    make(chan net.Conn),
+		closing:  make(chan struct{}),
+		deadline: newDeadline(),
+	}
+}
+
+// Dial opens a connection to the listener and returns its client end. It blocks
+// until the connection is accepted and returns ErrListenerClosed once the
+// listener is closed.
+func (l *MemoryListener) Dial() (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closing:
+		_ = client.Close()
+		_ = server.Close()
+		return nil, ErrListenerClosed
+	}
+}
+
+// Accept waits for and returns the server end of the next connection. It fails
+// with os.ErrDeadlineExceeded once the deadline expires.
+func (l *MemoryListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closing:
+		return nil, ErrListenerClosed
+	case <-l.deadline.wait():
+		return nil, os.ErrDeadlineExceeded
+	}
+}
+
+// SetDeadline sets the deadline of Accept. A zero time clears it.
+func (l *MemoryListener) SetDeadline(t time.Time) error {
+	l.deadline.set(t)
+	return nil
+}
+
+// Close closes the listener. The connections which are already accepted are
+// left open.
+func (l *MemoryListener) Close() error {
+	l.once.Do(func() {
+		close(l.closing)
+	})
+	return nil
+}
+
+// Addr returns the address of the listener.
+func (l *MemoryListener) Addr() net.Addr {
+	return memoryAddr{}
+}
+
+// memoryAddr is the address of an in-process listener.
+type memoryAddr struct{}
+
+func (memoryAddr) Network() string { return "memory" }
+func (memoryAddr) String() string  { return "memory" }
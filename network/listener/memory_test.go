@@ -0,0 +1,72 @@
+package listener
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMemoryListenerAcceptDeadline(t *testing.T) {
+	l := NewMemoryListener()
+	defer l.Close()
+
+	_ = l.SetDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := l.Accept(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Accept() = %v, want os.ErrDeadlineExceeded", err)
+	}
+
+	// Clearing the deadline lets Accept wait for the next connection
+	_ = l.SetDeadline(time.Time{})
+	go func() {
+		if c, err := l.Dial(); err == nil {
+			_ = c.Close()
+		}
+	}()
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept() = %v after clearing the deadline", err)
+	}
+	_ = c.Close()
+
+	_ = l.Close()
+	if _, err := l.Dial(); err != ErrListenerClosed {
+		t.Errorf("Dial() = %v after Close, want ErrListenerClosed", err)
+	}
+}
+
+func TestMemoryConnReadDeadline(t *testing.T) {
+	l := NewMemoryListener()
+	defer l.Close()
+	go func() { _, _ = l.Dial() }()
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_ = c.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := c.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read() = %v, want os.ErrDeadlineExceeded", err)
+	}
+}
+
+func TestSniffTimeoutInMemory(t *testing.T) {
+	m := newMemoryTestMux(t)
+	m.SetReadTimeout(50 * time.Millisecond)
+	readErr := make(chan error, 1)
+	m.Match(func(r io.Reader) bool {
+		_, err := io.ReadFull(r, make([]byte, 4))
+		readErr <- err
+		return err == nil
+	})
+	m.serve()
+
+	// A client sending part of the prefix and stalling is timed out
+	c := m.dial("PI")
+	if err := <-readErr; !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("matcher read error = %v, want os.ErrDeadlineExceeded", err)
+	}
+	expectClosed(t, c)
+}
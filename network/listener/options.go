@@ -0,0 +1,84 @@
+package listener
+
+import (
+	"time"
+)
+
+// Options is a bundle of runtime-tunable listener settings. The bundle is
+// stored as a whole by SetOptions, so a configuration reload never leaves the
+// listener half-updated, and each accepted connection loads the current bundle
+// once, without taking the listener lock.
+//
+// Hot-tunable fields take effect for the next accepted connection. The
+// connections which are already being matched or served keep the values they
+// were accepted with:
+//   - ReadTimeout
+//   - IdleTimeout
+//   - MaxConnections
+//   - AcceptRate and AcceptBurst, which apply from the next accept on
+//   - ConnReadRate, which applies to the connections being served as well
+//
+// Fields which only apply to what is created afterwards:
+//   - BufferSize, the queue size of matched connections, which is fixed
+//     once a queue has been created.
+type Options struct {
+	ReadTimeout    time.Duration // The timeout for the read of matchers, zero disables it.
+	IdleTimeout    time.Duration // The time a served connection can go without reads or writes, zero disables it.
+	BufferSize     int           // The number of matched connections which can be queued.
+	MaxConnections int           // The maximum number of active connections, zero means no limit.
+
+	// AcceptRate limits the number of connections accepted per second, across
+	// all the clients, allowing bursts of up to AcceptBurst connections. Once
+	// it's reached, the listener waits before accepting the next connection,
+	// which leaves the pending ones in the backlog of the operating system
+	// instead of accepting and dropping them. Zero means no limit.
+	AcceptRate  float64
+	AcceptBurst int
+
+	// ConnReadRate limits the number of bytes per second each served
+	// connection can read, smoothing its bursts to protect the downstream of
+	// the handlers. Reads return at most a second worth of bytes and then wait
+	// for the bucket of the connection to refill. Zero means no limit.
+	ConnReadRate int
+}
+
+// defaultOptions are the settings of a new listener.
+var defaultOptions = Options{
+	ReadTimeout: noTimeout,
+	BufferSize:  1024,
+}
+
+// Options returns a snapshot of the current listener settings.
+func (m *Listener) Options() Options {
+	return m.options.Load().(Options)
+}
+
+// SetOptions applies the whole bundle of settings atomically. A zero
+// BufferSize keeps the current one.
+func (m *Listener) SetOptions(o Options) {
+	m.updateOptions(func(current *Options) {
+		if o.BufferSize <= 0 {
+			o.BufferSize = current.BufferSize
+		}
+		*current = o
+	})
+}
+
+// SetMaxConnections sets the maximum number of active connections. Once it's
+// reached, new connections are closed right away with ErrTooManyConnections.
+// Zero means no limit.
+func (m *Listener) SetMaxConnections(n int) {
+	m.updateOptions(func(o *Options) {
+		o.MaxConnections = n
+	})
+}
+
+// updateOptions applies a change to a copy of the current settings and stores
+// it. Writers are serialized by the listener lock so no change gets lost.
+func (m *Listener) updateOptions(fn func(o *Options)) {
+	m.Lock()
+	defer m.Unlock()
+	o := m.options.Load().(Options)
+	fn(&o)
+	m.options.Store(o)
+}
@@ -0,0 +1,170 @@
+package listener
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSetOptions(t *testing.T) {
+	m := newTestMux(t)
+	bundles := []Options{
+		{ReadTimeout: time.Second, IdleTimeout: time.Minute, BufferSize: 16, MaxConnections: 10, AcceptRate: 100, AcceptBurst: 10, ConnReadRate: 1000},
+		{ReadTimeout: 2 * time.Second, IdleTimeout: time.Hour, BufferSize: 32, MaxConnections: 20, AcceptRate: 200, AcceptBurst: 20, ConnReadRate: 2000},
+	}
+
+	for _, o := range bundles {
+		m.SetOptions(o)
+		if got := m.Options(); got != o {
+			t.Errorf("Options() = %+v, want %+v", got, o)
+		}
+	}
+}
+
+func TestSetOptionsKeepsBufferSize(t *testing.T) {
+	m := newTestMux(t)
+	m.SetOptions(Options{BufferSize: 8})
+	m.SetOptions(Options{MaxConnections: 5})
+
+	if got := m.Options(); got.BufferSize != 8 || got.MaxConnections != 5 {
+		t.Errorf("Options() = %+v, want BufferSize 8 and MaxConnections 5", got)
+	}
+}
+
+func TestSetOptionsIsAtomic(t *testing.T) {
+	m := newTestMux(t)
+	a := Options{ReadTimeout: time.Second, IdleTimeout: time.Second, BufferSize: 1, MaxConnections: 1, AcceptRate: 1, AcceptBurst: 1, ConnReadRate: 1}
+	b := Options{ReadTimeout: time.Minute, IdleTimeout: time.Minute, BufferSize: 2, MaxConnections: 2, AcceptRate: 2, AcceptBurst: 2, ConnReadRate: 2}
+	m.SetOptions(a)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if i%2 == 0 {
+				m.SetOptions(a)
+			} else {
+				m.SetOptions(b)
+			}
+		}
+	}()
+
+	for i := 0; i < 10000; i++ {
+		if got := m.Options(); got != a && got != b {
+			t.Fatalf("Options() = %+v, a mix of both bundles", got)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestOptionSetters(t *testing.T) {
+	m := newTestMux(t)
+	m.SetReadTimeout(3 * time.Second)
+	m.SetMaxConnections(7)
+	m.SetGlobalAcceptRate(50, 5)
+	m.SetConnReadRate(4096)
+
+	got := m.Options()
+	if got.ReadTimeout != 3*time.Second || got.MaxConnections != 7 || got.AcceptRate != 50 || got.AcceptBurst != 5 ||
+		got.ConnReadRate != 4096 || got.BufferSize != defaultOptions.BufferSize {
+		t.Errorf("Options() = %+v", got)
+	}
+}
+
+func TestMaxConnectionsReload(t *testing.T) {
+	m := newTestMux(t)
+	errs := make(chan error, 10)
+	m.HandleError(func(err error) bool {
+		errs <- err
+		return true
+	})
+	r := m.Match(MatchAny())
+	m.SetMaxConnections(1)
+	m.serve()
+
+	client := m.dial("a")
+	first := accept(t, r)
+	readN(t, first, 1)
+	expectClosed(t, m.dial("b"))
+	if err := <-errs; err != ErrTooManyConnections {
+		t.Errorf("error = %v, want ErrTooManyConnections", err)
+	}
+
+	// The new limit applies to the next connection
+	m.SetMaxConnections(2)
+	m.dial("c")
+	if got := readN(t, accept(t, r), 1); got != "c" {
+		t.Errorf("handler read %q, want c", got)
+	}
+
+	// Lowering the limit does not close the active connections
+	m.SetMaxConnections(1)
+	expectClosed(t, m.dial("d"))
+	go func() { _, _ = client.Write([]byte("more")) }()
+	if got := readN(t, first, 4); got != "more" {
+		t.Errorf("active connection read %q, want more", got)
+	}
+}
+
+func TestReadTimeoutReload(t *testing.T) {
+	m := newTestMux(t)
+	m.Match(MatchPrefix("PING"))
+	m.serve()
+
+	m.SetReadTimeout(50 * time.Millisecond)
+	start := time.Now()
+	expectClosed(t, m.dial(""))
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("silent connection closed after %v, want the 50ms read timeout", d)
+	}
+}
+
+func TestIdleTimeoutReload(t *testing.T) {
+	m := newTestMux(t)
+	errs := make(chan error, 10)
+	m.HandleError(func(err error) bool {
+		errs <- err
+		return true
+	})
+	r := m.Match(MatchAny())
+	m.serve()
+
+	client := m.dial("a")
+	first := accept(t, r)
+	readN(t, first, 1)
+
+	// The idle timeout and the limit change together mid-serve, and apply to
+	// the next connections only
+	o := m.Options()
+	o.IdleTimeout, o.MaxConnections = 100*time.Millisecond, 2
+	m.SetOptions(o)
+	idle := m.dial("b")
+	readN(t, accept(t, r), 1)
+	expectClosed(t, m.dial("c"))
+	if err := <-errs; err != ErrTooManyConnections {
+		t.Errorf("error = %v, want ErrTooManyConnections", err)
+	}
+
+	start := time.Now()
+	expectClosed(t, idle)
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("idle connection closed after %v, want the 100ms idle timeout", d)
+	}
+	if err := <-errs; err != ErrIdleTimeout {
+		t.Errorf("error = %v, want ErrIdleTimeout", err)
+	}
+
+	// The connection served before the change has no idle timeout
+	go func() { _, _ = client.Write([]byte("more")) }()
+	if got := readN(t, first, 4); got != "more" {
+		t.Errorf("connection served before the change read %q, want more", got)
+	}
+}
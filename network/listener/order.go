@@ -0,0 +1,77 @@
+package listener
+
+import (
+	"sync"
+)
+
+// sequencer hands out turns in accept order and lets each turn proceed only
+// once all the previous ones are done.
+type sequencer struct {
+	sync.Mutex
+	cond *sync.Cond
+	next uint64 // The next turn to hand out.
+	head uint64 // The turn allowed to proceed.
+}
+
+// newSequencer creates a new sequencer.
+func newSequencer() *sequencer {
+	s := new(sequencer)
+	s.cond = sync.NewCond(s)
+	return s
+}
+
+// take hands out the next turn.
+func (s *sequencer) take() *turn {
+	s.Lock()
+	defer s.Unlock()
+	t := &turn{seq: s, id: s.next}
+	s.next++
+	return t
+}
+
+// turn is the position of a connection in the accept order. A nil turn never
+// waits, for connections which are not ordered.
+type turn struct {
+	seq  *sequencer
+	id   uint64
+	done bool
+}
+
+// wait blocks until all the previous turns are done.
+func (t *turn) wait() {
+	if t == nil {
+		return
+	}
+
+	t.seq.Lock()
+	for t.seq.head != t.id {
+		t.seq.cond.Wait()
+	}
+	t.seq.Unlock()
+}
+
+// release lets the next turn proceed. It waits for the previous turns first,
+// so a turn is never released out of order, and can be called repeatedly.
+func (t *turn) release() {
+	if t == nil || t.done {
+		return
+	}
+
+	t.wait()
+	t.done = true
+	t.seq.Lock()
+	t.seq.head++
+	t.seq.cond.Broadcast()
+	t.seq.Unlock()
+}
+
+// SetPreserveAcceptOrder sets whether matched connections are delivered to
+// their route in the order they were accepted. Matching still happens
+// concurrently, but a connection is only dispatched once every connection
+// accepted before it has been dispatched or rejected, so a slow handshake or a
+// full route delays the connections accepted after it.
+func (m *Listener) SetPreserveAcceptOrder(enabled bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.ordered = enabled
+}
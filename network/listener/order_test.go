@@ -0,0 +1,37 @@
+package listener
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPreserveAcceptOrder(t *testing.T) {
+	const n = 20
+	m := newTestMux(t)
+	m.SetPreserveAcceptOrder(true)
+
+	// The earlier connections are matched last
+	r := m.Match(func(r io.Reader) bool {
+		b := make([]byte, 2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return false
+		}
+		i, _ := strconv.Atoi(string(b))
+		time.Sleep(time.Duration(n-i) * time.Millisecond)
+		return true
+	})
+	m.serve()
+
+	for i := 0; i < n; i++ {
+		m.dial(fmt.Sprintf("%02d", i))
+	}
+	for i := 0; i < n; i++ {
+		c := accept(t, r)
+		if got, want := readN(t, c, 2), fmt.Sprintf("%02d", i); got != want {
+			t.Fatalf("connection %s delivered in position %s", got, want)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package listener
+
+import "time"
+
+// listenOverflowsSampleInterval is how often the accept queue overflow counter
+// of the network namespace is sampled.
+const listenOverflowsSampleInterval = 5 * time.Second
+
+// sampleListenOverflows samples the accept queue overflows of the network
+// namespace while the listener serves, where they can be read, and returns the
+// function stopping it.
+func (m *Listener) sampleListenOverflows() (stop func()) {
+	base, err := listenOverflows()
+	if err != nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(listenOverflowsSampleInterval)
+		defer ticker.Stop()
+
+		var last uint64
+		for {
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+
+			n, err := listenOverflows()
+			if err != nil || n < base || n-base == last {
+				continue
+			}
+			last = n - base
+			m.counters.update(func(s *Stats) {
+				s.NamespaceListenOverflows = last
+			})
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,54 @@
+//go:build linux
+// +build linux
+
+package listener
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// netstatPath is the file of the extended TCP counters of the network
+// namespace.
+const netstatPath = "/proc/net/netstat"
+
+var errNoListenOverflows = errors.New("listener: no ListenOverflows counter")
+
+// listenOverflows returns the number of times an accept queue of the network
+// namespace overflowed.
+func listenOverflows() (uint64, error) {
+	return readListenOverflows(netstatPath)
+}
+
+// readListenOverflows reads the ListenOverflows counter from a netstat file,
+// made of pairs of lines, the names of the counters of a group and then their
+// values, such as "TcpExt: SyncookiesSent ... ListenOverflows ...".
+func readListenOverflows(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		names := strings.Fields(scanner.Text())
+		if len(names) == 0 || names[0] != "TcpExt:" || !scanner.Scan() {
+			continue
+		}
+
+		values := strings.Fields(scanner.Text())
+		for i, name := range names {
+			if name == "ListenOverflows" && i < len(values) {
+				return strconv.ParseUint(values[i], 10, 64)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, errNoListenOverflows
+}
@@ -0,0 +1,40 @@
+//go:build linux
+// +build linux
+
+package listener
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadListenOverflows(t *testing.T) {
+	tests := []struct {
+		name    string
+		netstat string
+		want    uint64
+		ok      bool
+	}{
+		{"counter", "TcpExt: SyncookiesSent SyncookiesRecv ListenOverflows ListenDrops\n" +
+			"TcpExt: 0 0 42 45\n" +
+			"IpExt: InNoRoutes InTruncatedPkts\n" +
+			"IpExt: 0 0\n", 42, true},
+		{"after another group", "IpExt: ListenOverflows\nIpExt: 7\n" +
+			"TcpExt: ListenDrops ListenOverflows\nTcpExt: 3 9\n", 9, true},
+		{"missing counter", "TcpExt: SyncookiesSent\nTcpExt: 0\n", 0, false},
+		{"missing values", "TcpExt: ListenOverflows\n", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "netstat")
+			if err := ioutil.WriteFile(path, []byte(tt.netstat), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readListenOverflows(path)
+			if (err == nil) != tt.ok || got != tt.want {
+				t.Errorf("readListenOverflows() = %d, %v, want %d", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package listener
+
+import "errors"
+
+var errNoListenOverflows = errors.New("listener: accept queue overflows are only sampled on linux")
+
+// listenOverflows returns an error as the accept queue overflows are only
+// sampled on linux.
+func listenOverflows() (uint64, error) {
+	return 0, errNoListenOverflows
+}
@@ -0,0 +1,310 @@
+package listener
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/numb3r3/live-go/log"
+)
+
+const (
+	maxDatagramSize       = 64 * 1024        // The largest datagram which can be received.
+	packetSessionBacklog  = 256              // The number of datagrams queued per session.
+	packetSessionIdleTime = 2 * time.Minute  // The time after which an idle session is closed.
+	packetSweepInterval   = 10 * time.Second // How often idle sessions are looked for.
+)
+
+// FromPacketConn creates a listener over a packet-oriented transport. The
+// datagrams are demultiplexed by remote address into per-peer sessions which
+// behave as stream connections: a session is accepted, and goes through the
+// usual matching, when the first datagram of a new peer arrives, its reads
+// return the payloads of the datagrams in order and its writes send one
+// datagram each.
+//
+// A session ends when it is closed, or after two minutes without receiving any
+// datagram; the next datagram of the same peer then starts a new session.
+// Datagrams are dropped when the session can not keep up, as the transport
+// gives no delivery guarantee anyway. Closing the listener closes the packet
+// connection and all the sessions.
+func FromPacketConn(pc net.PacketConn) *Listener {
+	return New(newPacketListener(pc))
+}
+
+// packetListener accepts the sessions of the peers of a packet connection.
+type packetListener struct {
+	sync.Mutex
+	pc       net.PacketConn
+	sessions map[string]*packetSession
+	accepts  chan *packetSession
+	closing  chan struct{}
+	once     sync.Once
+}
+
+// newPacketListener creates the listener and starts reading the datagrams.
+func newPacketListener(pc net.PacketConn) *packetListener {
+	l := &packetListener{
+		pc:       pc,
+		sessions: make(map[string]*packetSession),
+		accepts:  make(chan *packetSession, packetSessionBacklog),
+		closing:  make(chan struct{}),
+	}
+
+	go l.readLoop()
+	go l.sweepLoop()
+	return l
+}
+
+// Accept waits for and returns the session of the next new peer.
+func (l *packetListener) Accept() (net.Conn, error) {
+	select {
+	case s := <-l.accepts:
+		return s, nil
+	case <-l.closing:
+		return nil, ErrListenerClosed
+	}
+}
+
+// Close closes the packet connection and all the sessions.
+func (l *packetListener) Close() (err error) {
+	l.once.Do(func() {
+		close(l.closing)
+		err = l.pc.Close()
+
+		l.Lock()
+		sessions := l.sessions
+		l.sessions = make(map[string]*packetSession)
+		l.Unlock()
+		for _, s := range sessions {
+			_ = s.Close()
+		}
+	})
+	return
+}
+
+// Addr returns the local address of the packet connection.
+func (l *packetListener) Addr() net.Addr {
+	return l.pc.LocalAddr()
+}
+
+// readLoop reads the datagrams and hands them over to the sessions. It backs
+// off on temporary errors, as the accept loop does.
+func (l *packetListener) readLoop() {
+	defer l.Close()
+
+	buffer := make([]byte, maxDatagramSize)
+	var delay time.Duration
+	for {
+		n, addr, err := l.pc.ReadFrom(buffer)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				delay = nextBackoff(delay)
+				if !l.backoff(delay) {
+					return
+				}
+				continue
+			}
+
+			select {
+			case <-l.closing:
+			default:
+				logging.Warningf("packet listener stopped: %v", err)
+			}
+			return
+		}
+
+		delay = 0
+		datagram := make([]byte, n)
+		copy(datagram, buffer[:n])
+		l.deliver(addr, datagram)
+	}
+}
+
+// backoff waits for the delay before reading again, and returns false if the
+// listener is closed in the meantime.
+func (l *packetListener) backoff(delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-l.closing:
+		return false
+	}
+}
+
+// deliver queues a datagram on the session of its peer, creating the session
+// if the peer is new.
+func (l *packetListener) deliver(addr net.Addr, datagram []byte) {
+	key := addr.String()
+	l.Lock()
+	s, ok := l.sessions[key]
+	if !ok {
+		s = newPacketSession(l, addr)
+		select {
+		case l.accepts <- s:
+			l.sessions[key] = s
+		default:
+			// The accept backlog is full, drop the datagram
+			l.Unlock()
+			return
+		}
+	}
+	l.Unlock()
+
+	s.receive(datagram)
+}
+
+// sweepLoop closes the sessions which stayed idle for too long.
+func (l *packetListener) sweepLoop() {
+	ticker := time.NewTicker(packetSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-l.closing:
+			return
+		}
+
+		l.Lock()
+		var idle []*packetSession
+		for _, s := range l.sessions {
+			if s.idleSince() > packetSessionIdleTime {
+				idle = append(idle, s)
+			}
+		}
+		l.Unlock()
+
+		for _, s := range idle {
+			_ = s.Close()
+		}
+	}
+}
+
+// remove forgets the session so the next datagram of its peer starts a new one.
+func (l *packetListener) remove(s *packetSession) {
+	l.Lock()
+	defer l.Unlock()
+	if l.sessions[s.remote.String()] == s {
+		delete(l.sessions, s.remote.String())
+	}
+}
+
+// ------------------------------------------------------------------------------------
+
+// packetSession is the stream-like connection of a single peer.
+type packetSession struct {
+	sync.Mutex
+	listener      *packetListener
+	remote        net.Addr
+	datagrams     chan []byte
+	pending       []byte
+	lastActive    time.Time
+	closing       chan struct{}
+	once          sync.Once
+	readDeadline  *deadline
+	writeDeadline *deadline
+}
+
+// newPacketSession creates the session of a peer.
+func newPacketSession(l *packetListener, remote net.Addr) *packetSession {
+	return &packetSession{
+		listener:      l,
+		remote:        remote,
+		datagrams:     make(chan []byte, packetSessionBacklog),
+		lastActive:    time.Now(),
+		closing:       make(chan struct{}),
+		readDeadline:  newDeadline(),
+		writeDeadline: newDeadline(),
+	}
+}
+
+// receive queues a datagram, dropping it if the session can not keep up.
+func (s *packetSession) receive(datagram []byte) {
+	s.Lock()
+	s.lastActive = time.Now()
+	s.Unlock()
+
+	select {
+	case s.datagrams <- datagram:
+	case <-s.closing:
+	default:
+	}
+}
+
+// idleSince returns the time elapsed since the last datagram was received.
+func (s *packetSession) idleSince() time.Duration {
+	s.Lock()
+	defer s.Unlock()
+	return time.Since(s.lastActive)
+}
+
+// Read reads the payload of the received datagrams.
+func (s *packetSession) Read(b []byte) (int, error) {
+	if len(s.pending) == 0 {
+		select {
+		case s.pending = <-s.datagrams:
+		case <-s.closing:
+			return 0, io.EOF
+		case <-s.readDeadline.wait():
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+
+	n := copy(b, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+// Write sends the data to the peer as a single datagram.
+func (s *packetSession) Write(b []byte) (int, error) {
+	select {
+	case <-s.closing:
+		return 0, io.ErrClosedPipe
+	case <-s.writeDeadline.wait():
+		return 0, os.ErrDeadlineExceeded
+	default:
+	}
+	return s.listener.pc.WriteTo(b, s.remote)
+}
+
+// Close ends the session.
+func (s *packetSession) Close() error {
+	s.once.Do(func() {
+		close(s.closing)
+		s.listener.remove(s)
+	})
+	return nil
+}
+
+// LocalAddr returns the local address of the packet connection.
+func (s *packetSession) LocalAddr() net.Addr {
+	return s.listener.pc.LocalAddr()
+}
+
+// RemoteAddr returns the address of the peer.
+func (s *packetSession) RemoteAddr() net.Addr {
+	return s.remote
+}
+
+// SetDeadline sets both the read and write deadlines.
+func (s *packetSession) SetDeadline(t time.Time) error {
+	s.readDeadline.set(t)
+	s.writeDeadline.set(t)
+	return nil
+}
+
+// SetReadDeadline sets the deadline for the reads of the session.
+func (s *packetSession) SetReadDeadline(t time.Time) error {
+	s.readDeadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for the writes of the session.
+func (s *packetSession) SetWriteDeadline(t time.Time) error {
+	s.writeDeadline.set(t)
+	return nil
+}
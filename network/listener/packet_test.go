@@ -0,0 +1,127 @@
+package listener
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// packetPeer is a UDP socket exchanging datagrams with the listener.
+type packetPeer struct {
+	t    *testing.T
+	pc   net.PacketConn
+	addr net.Addr // The address of the listener.
+}
+
+func newPacketPeer(t *testing.T, addr net.Addr) *packetPeer {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = pc.Close() })
+	return &packetPeer{t: t, pc: pc, addr: addr}
+}
+
+func (p *packetPeer) send(data string) {
+	p.t.Helper()
+	if _, err := p.pc.WriteTo([]byte(data), p.addr); err != nil {
+		p.t.Fatal(err)
+	}
+}
+
+func (p *packetPeer) receive() string {
+	p.t.Helper()
+	_ = p.pc.SetReadDeadline(time.Now().Add(testTimeout))
+	b := make([]byte, maxDatagramSize)
+	n, _, err := p.pc.ReadFrom(b)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return string(b[:n])
+}
+
+func TestFromPacketConn(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &testMux{Listener: FromPacketConn(pc), t: t}
+	t.Cleanup(func() { _ = m.Close() })
+	ping := m.Match(MatchPrefix("PING"))
+	hello := m.Match(MatchPrefix("HELLO"))
+	m.serve()
+
+	a, b := newPacketPeer(t, pc.LocalAddr()), newPacketPeer(t, pc.LocalAddr())
+	a.send("PING")
+	b.send("HELLO")
+
+	ca, cb := accept(t, ping), accept(t, hello)
+	if got := readN(t, ca, 4); got != "PING" {
+		t.Errorf("ping handler read %q", got)
+	}
+	if got := readN(t, cb, 5); got != "HELLO" {
+		t.Errorf("hello handler read %q", got)
+	}
+
+	// The following datagrams of a peer go to its session
+	a.send("again")
+	if got := readN(t, ca, 5); got != "again" {
+		t.Errorf("ping handler read %q, want again", got)
+	}
+
+	// Writes reply to the peer of the session
+	if _, err := cb.Write([]byte("WORLD")); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.receive(); got != "WORLD" {
+		t.Errorf("peer received %q, want WORLD", got)
+	}
+
+	// A closed session is replaced by the next datagram of its peer
+	_ = ca.Close()
+	a.send("PING")
+	if got := readN(t, accept(t, ping), 4); got != "PING" {
+		t.Errorf("new session read %q, want PING", got)
+	}
+}
+
+// failingPacketConn fails its reads with a temporary error while failing is
+// set, counting them.
+type failingPacketConn struct {
+	net.PacketConn
+	failing  int32
+	failures int32
+}
+
+func (pc *failingPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	if atomic.LoadInt32(&pc.failing) == 1 {
+		atomic.AddInt32(&pc.failures, 1)
+		return 0, nil, tempError{}
+	}
+	return pc.PacketConn.ReadFrom(b)
+}
+
+func TestPacketReadBackoff(t *testing.T) {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc := &failingPacketConn{PacketConn: udp, failing: 1}
+	m := &testMux{Listener: FromPacketConn(pc), t: t}
+	t.Cleanup(func() { _ = m.Close() })
+	ping := m.Match(MatchPrefix("PING"))
+	m.serve()
+
+	// The failed reads are retried after a growing delay, not in a busy loop
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&pc.failures); n < 2 || n > 10 {
+		t.Errorf("%d reads failed in 100ms, want a few", n)
+	}
+
+	atomic.StoreInt32(&pc.failing, 0)
+	newPacketPeer(t, udp.LocalAddr()).send("PING")
+	if got := readN(t, accept(t, ping), 4); got != "PING" {
+		t.Errorf("ping handler read %q once the reads recovered", got)
+	}
+}
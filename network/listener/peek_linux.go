@@ -0,0 +1,94 @@
+//go:build linux
+// +build linux
+
+package listener
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+)
+
+// maxPeekSize bounds the number of bytes matchers can peek, as peeked bytes
+// stay in the socket receive buffer.
+const maxPeekSize = 64 * 1024
+
+var errPeekLimit = errors.New("listener: peek limit exceeded")
+
+// peekReader reads the incoming bytes of a socket with MSG_PEEK, leaving them
+// in the receive buffer so nothing needs to be replayed to the handler.
+type peekReader struct {
+	raw    syscall.RawConn
+	buffer []byte
+	offset int
+	budget *sniffReservation // The sniff memory of the connection, nil if unbounded.
+}
+
+// newPeeker returns a function creating peek readers over the connection, or
+// nil if the connection does not expose its socket. The peek buffers grow within
+// the sniff memory reserved, if any.
+func newPeeker(c net.Conn, budget *sniffReservation) func() io.Reader {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return nil
+	}
+
+	return func() io.Reader {
+		return &peekReader{raw: raw, budget: budget}
+	}
+}
+
+// Read peeks the bytes following the ones already read. It waits for more data
+// to arrive when the socket holds no new bytes, within the read deadline.
+func (p *peekReader) Read(b []byte) (int, error) {
+	want := p.offset + len(b)
+	if want > maxPeekSize {
+		if want = maxPeekSize; want <= p.offset {
+			return 0, errPeekLimit
+		}
+	}
+
+	if len(p.buffer) < want {
+		if p.budget != nil && !p.budget.grow(want) {
+			return 0, errSniffMemory
+		}
+		p.buffer = make([]byte, want)
+	}
+
+	var n int
+	var rerr error
+	buffer := p.buffer[:want]
+	if err := p.raw.Read(func(fd uintptr) bool {
+		n, _, rerr = syscall.Recvfrom(int(fd), buffer, syscall.MSG_PEEK)
+		if rerr == syscall.EAGAIN || rerr == syscall.EINTR {
+			return false
+		}
+
+		// Wait for more data if there's nothing new to return yet
+		return rerr != nil || n == 0 || n > p.offset
+	}); err != nil {
+		return 0, err
+	}
+
+	switch {
+	case rerr != nil:
+		return 0, rerr
+	case n == 0:
+		return 0, io.EOF
+	}
+
+	read := copy(b, buffer[p.offset:n])
+	p.offset += read
+	return read, nil
+}
+
+// sniffed returns the bytes peeked so far.
+func (p *peekReader) sniffed() []byte {
+	return p.buffer[:p.offset]
+}
@@ -0,0 +1,130 @@
+//go:build linux
+// +build linux
+
+package listener
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+// newTCPMux creates a listener accepting loopback TCP connections, whose
+// sockets can be peeked, and returns it with its address.
+func newTCPMux(t testing.TB) (*testMux, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &testMux{Listener: New(l), t: t}
+	t.Cleanup(func() { _ = m.Close() })
+	return m, l.Addr().String()
+}
+
+// dialTCP opens a connection to the address which sends the data.
+func dialTCP(t testing.TB, addr, data string) net.Conn {
+	t.Helper()
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	if _, err := io.WriteString(c, data); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestPeekReaderLeavesBytes(t *testing.T) {
+	m, addr := newTCPMux(t)
+	dialTCP(t, addr, "hello")
+	c, err := m.root.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	peeker := newPeeker(c, nil)
+	if peeker == nil {
+		t.Fatal("newPeeker() = nil for a TCP connection")
+	}
+	r := peeker()
+	head := make([]byte, 3)
+	if _, err := io.ReadFull(r, head); err != nil || string(head) != "hel" {
+		t.Fatalf("peeked %q, %v, want hel", head, err)
+	}
+	tail := make([]byte, 2)
+	if _, err := io.ReadFull(r, tail); err != nil || string(tail) != "lo" {
+		t.Fatalf("peeked %q, %v, want lo", tail, err)
+	}
+	if got := readN(t, c, 5); got != "hello" {
+		t.Errorf("read %q after peeking, want hello", got)
+	}
+}
+
+func TestPeekSniffingRoutes(t *testing.T) {
+	inputs := []struct {
+		data  string
+		route string
+	}{
+		{"*1\r\n$4\r\nPING\r\n", "redis"},
+		{"GET / HTTP/1.1\r\nHost: a\r\n\r\n", "http"},
+		{"foo:1|c\n", "statsd"},
+		{"\x00\x01binary\n", "fallback"},
+	}
+	for _, peek := range []bool{false, true} {
+		m, addr := newTCPMux(t)
+		m.SetPeekSniffing(peek)
+		routes := map[string]*Route{
+			"redis":    m.Route("redis", MatchPrefix("*")),
+			"http":     m.Route("http", MatchHTTP()),
+			"statsd":   m.Route("statsd", MatchStatsD()),
+			"fallback": m.Route("fallback", MatchAny()),
+		}
+		m.serve()
+
+		for _, in := range inputs {
+			dialTCP(t, addr, in.data)
+			c := accept(t, routes[in.route])
+			if got := readN(t, c, len(in.data)); got != in.data {
+				t.Errorf("peek %v: handler read %q, want %q", peek, got, in.data)
+			}
+		}
+	}
+}
+
+// benchmarkSniff routes HTTP requests over loopback TCP connections.
+func benchmarkSniff(b *testing.B, peek bool) {
+	const request = "GET / HTTP/1.1\r\nHost: a\r\n\r\n"
+	m, addr := newTCPMux(b)
+	m.SetPeekSniffing(peek)
+	m.Match(MatchPrefix("*"))
+	m.Match(MatchStatsD())
+	r := m.Match(MatchHTTP())
+	m.serve()
+
+	buf := make([]byte, len(request))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		client, err := net.Dial("tcp", addr)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := io.WriteString(client, request); err != nil {
+			b.Fatal(err)
+		}
+		c, err := r.Accept()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := io.ReadFull(c, buf); err != nil {
+			b.Fatal(err)
+		}
+		_ = c.Close()
+		_ = client.Close()
+	}
+}
+
+func BenchmarkSniffPeek(b *testing.B)     { benchmarkSniff(b, true) }
+func BenchmarkSniffBuffered(b *testing.B) { benchmarkSniff(b, false) }
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package listener
+
+import (
+	"io"
+	"net"
+)
+
+// newPeeker returns nil as peeking a socket is only supported on linux, the
+// buffering sniffer is used instead.
+func newPeeker(c net.Conn, budget *sniffReservation) func() io.Reader {
+	return nil
+}
@@ -0,0 +1,55 @@
+package listener
+
+// maxPreAuthBytes bounds the bytes read for the pre-authentication.
+const maxPreAuthBytes = 4096
+
+// ErrPreAuth is the error the connections failing the pre-authentication are
+// rejected with.
+var ErrPreAuth error = errRejected("mux: pre-authentication failed")
+
+// SetPreAuth sets a function authenticating the connections before any of them
+// is matched, such as a check of a shared secret the clients send first, as a
+// cheap gate at the edge. It is called with the bytes received so far every
+// time more arrive, until it returns ok along with the number of bytes the
+// authentication consumed, which are dropped: the matchers and the handler
+// only see what follows. A connection which does not satisfy it before the
+// read timeout, or within 4KB, is rejected with ErrPreAuth, without any reject
+// response. The bytes it is given count against the budget set with
+// SetTotalSniffMemory, which is reserved first. Peek sniffing is not used for
+// the connections then. The connection is rejected if the function panics, and
+// all of them are once it panicked three times, until it is set again. A nil
+// function disables it.
+func (m *Listener) SetPreAuth(fn func(sniffed []byte) (ok bool, consumed int)) {
+	m.Lock()
+	defer m.Unlock()
+	m.hooks.preAuth.reset()
+	m.preAuth = fn
+}
+
+// preAuthenticate reads the connection until fn accepts it, and drops the
+// bytes it consumed. The connection fails if fn panics.
+func preAuthenticate(c *Conn, fn func(sniffed []byte) (bool, int), guard *hookGuard) bool {
+	src := c.startSniffing()
+	buf := make([]byte, maxPreAuthBytes)
+	for n := 0; n < len(buf); {
+		read, err := src.Read(buf[n:])
+		if n += read; read > 0 {
+			ok, consumed := false, 0
+			if !guard.call("pre-authentication", func() { ok, consumed = fn(buf[:n]) }) {
+				return false
+			}
+			if ok {
+				c.doneSniffing()
+				if consumed > 0 {
+					c.buffer.discard(consumed)
+				}
+				c.buffer.rebase()
+				return true
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package listener
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestPreAuth(t *testing.T) {
+	token := []byte("secret-token\n")
+	m := newTestMux(t)
+	errs := make(chan error, 1)
+	m.HandleError(func(err error) bool {
+		errs <- err
+		return true
+	})
+	m.SetReadTimeout(100 * time.Millisecond)
+	m.SetPreAuth(func(sniffed []byte) (bool, int) {
+		return bytes.HasPrefix(sniffed, token), len(token)
+	})
+	r := m.Match(MatchPrefix("*"))
+	m.serve()
+
+	// The token is not replayed to the matchers nor the handler
+	m.dial(string(token) + "*1\r\n")
+	if got := readN(t, accept(t, r), 4); got != "*1\r\n" {
+		t.Errorf("handler read %q, want the command without the token", got)
+	}
+
+	expectClosed(t, m.dial("wrong-token!\n*1\r\n"))
+	if err := <-errs; err != ErrPreAuth {
+		t.Errorf("error = %v, want ErrPreAuth", err)
+	}
+}
@@ -0,0 +1,149 @@
+package listener
+
+import (
+	"container/heap"
+	"io"
+	"net"
+	"sync"
+)
+
+// PriorityConn is a connection whose writes are queued and sent by a single
+// goroutine, the pending writes of higher priority first, so control messages
+// can jump ahead of bulk data. Writes of the same priority keep their order.
+// It can wrap the connections of a route with SetTransform:
+//
+//	r.SetTransform(func(c net.Conn) net.Conn { return NewPriorityConn(c) })
+//
+// Write queues at priority zero. Once a write fails, the pending ones are
+// dropped and every following call returns the error.
+type PriorityConn struct {
+	net.Conn
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   priorityQueue
+	seq     uint64
+	writing bool // Whether a write is being sent.
+	closed  bool
+	err     error
+}
+
+// NewPriorityConn wraps the connection and starts sending its queued writes.
+func NewPriorityConn(c net.Conn) *PriorityConn {
+	pc := &PriorityConn{Conn: c}
+	pc.cond = sync.NewCond(&pc.mu)
+	go pc.sendLoop()
+	return pc
+}
+
+// WritePriority queues a copy of b to be written with the priority p.
+func (c *PriorityConn) WritePriority(p int, b []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.failure(); err != nil {
+		return err
+	}
+
+	w := &priorityWrite{priority: p, seq: c.seq, b: append([]byte(nil), b...)}
+	c.seq++
+	heap.Push(&c.queue, w)
+	c.cond.Broadcast()
+	return nil
+}
+
+// Write queues b at priority zero.
+func (c *PriorityConn) Write(b []byte) (int, error) {
+	if err := c.WritePriority(0, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Flush waits until all the queued writes are sent.
+func (c *PriorityConn) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for (len(c.queue) > 0 || c.writing) && c.failure() == nil {
+		c.cond.Wait()
+	}
+	return c.failure()
+}
+
+// Close closes the connection, dropping the writes which are still queued.
+func (c *PriorityConn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	return c.Conn.Close()
+}
+
+// failure returns the error the writes fail with, if any.
+func (c *PriorityConn) failure() error {
+	if c.err != nil {
+		return c.err
+	}
+	if c.closed {
+		return io.ErrClosedPipe
+	}
+	return nil
+}
+
+// sendLoop sends the queued writes in priority order until the connection is
+// closed or a write fails.
+func (c *PriorityConn) sendLoop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for {
+		for len(c.queue) == 0 && c.failure() == nil {
+			c.cond.Wait()
+		}
+		if c.failure() != nil {
+			c.queue = nil
+			return
+		}
+
+		w := heap.Pop(&c.queue).(*priorityWrite)
+		c.writing = true
+		c.mu.Unlock()
+		_, err := c.Conn.Write(w.b)
+		c.mu.Lock()
+		c.writing = false
+		if err != nil {
+			c.err = err
+		}
+		c.cond.Broadcast()
+	}
+}
+
+// priorityWrite is a write queued on a PriorityConn.
+type priorityWrite struct {
+	priority int
+	seq      uint64 // The order of the write, among the ones of the same priority.
+	b        []byte
+}
+
+// priorityQueue is a heap of writes, the one of highest priority first.
+type priorityQueue []*priorityWrite
+
+func (q priorityQueue) Len() int { return len(q) }
+
+func (q priorityQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q priorityQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *priorityQueue) Push(x interface{}) { *q = append(*q, x.(*priorityWrite)) }
+
+func (q *priorityQueue) Pop() interface{} {
+	old := *q
+	w := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return w
+}
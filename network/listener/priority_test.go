@@ -0,0 +1,78 @@
+package listener
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+func TestPriorityConnOrder(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	pc := NewPriorityConn(server)
+	defer pc.Close()
+
+	// The first write is being sent until the client reads it, so the next
+	// ones get queued
+	_, _ = pc.Write([]byte("bulk0 "))
+	waitFor(t, "the first write to be sent", func() bool {
+		pc.mu.Lock()
+		defer pc.mu.Unlock()
+		return pc.writing
+	})
+	writes := []struct {
+		priority int
+		data     string
+	}{
+		{0, "bulk1 "},
+		{10, "ctrl "},
+		{0, "bulk2 "},
+		{5, "ack "},
+		{10, "ping "},
+	}
+	for _, w := range writes {
+		if err := pc.WritePriority(w.priority, []byte(w.data)); err != nil {
+			t.Fatalf("WritePriority() = %v", err)
+		}
+	}
+
+	const want = "bulk0 ctrl ping ack bulk1 bulk2 "
+	flushed := make(chan error, 1)
+	go func() { flushed <- pc.Flush() }()
+	if got := readN(t, client, len(want)); got != want {
+		t.Errorf("read %q, want %q", got, want)
+	}
+	if err := <-flushed; err != nil {
+		t.Errorf("Flush() = %v", err)
+	}
+}
+
+func TestPriorityConnClosed(t *testing.T) {
+	client, server := net.Pipe()
+	pc := NewPriorityConn(server)
+	_ = pc.Close()
+	_ = client.Close()
+
+	if _, err := pc.Write([]byte("x")); err != io.ErrClosedPipe {
+		t.Errorf("Write() after Close = %v, want io.ErrClosedPipe", err)
+	}
+	if err := pc.Flush(); err != io.ErrClosedPipe {
+		t.Errorf("Flush() after Close = %v, want io.ErrClosedPipe", err)
+	}
+}
+
+func TestPriorityConnWriteFailure(t *testing.T) {
+	client, server := net.Pipe()
+	pc := NewPriorityConn(server)
+	defer pc.Close()
+	_ = client.Close()
+
+	// The failed write is reported by the following calls
+	_, _ = pc.Write([]byte("x"))
+	if err := pc.Flush(); err == nil {
+		t.Fatal("Flush() = nil after the peer closed")
+	}
+	if err := pc.WritePriority(1, []byte("y")); err == nil {
+		t.Error("WritePriority() = nil after a failed write")
+	}
+}
@@ -0,0 +1,333 @@
+package listener
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/numb3r3/live-go/log"
+)
+
+// proxyDialTimeout bounds the dial of the upstream of a proxied connection.
+const proxyDialTimeout = 10 * time.Second
+
+// ProxyTo serves the connections of the route by relaying them to the TCP
+// upstream at the address, until both sides are done, so the route needs no
+// handler. It returns at once, and the route is served until the listener is
+// closed, Serve waiting for the relays to finish like for the servers
+// registered with Handle. A connection whose upstream can not be dialed is closed, and counted
+// in the errors of the route. Shutdown reports how the relayed connections were
+// drained in the Proxies of its result.
+func (r *Route) ProxyTo(addr string) {
+	r.Lock()
+	r.upstream = addr
+	r.Unlock()
+
+	servers := &r.mux.servers
+	servers.Add(1)
+	go func() {
+		defer servers.Done()
+		for {
+			c, err := r.Accept()
+			if err != nil {
+				r.closeWarm()
+				return
+			}
+			servers.Add(1)
+			go func() {
+				defer servers.Done()
+				r.proxy(c, addr)
+			}()
+		}
+	}()
+}
+
+// SetWarmConnections sets the number of connections to the upstream of the
+// proxy route which WarmupUpstreams dials in advance, and the proxied
+// connections then use first, sparing them the latency of the dial. They are
+// not dialed again once used, and the upstream may close them if it has an idle
+// timeout. Zero, the default, dials nothing in advance. The warm connections
+// dialed before are closed.
+func (r *Route) SetWarmConnections(n int) {
+	r.Lock()
+	old := r.warm
+	r.warm = nil
+	if n > 0 {
+		r.warm = make(chan net.Conn, n)
+	}
+	r.Unlock()
+
+	closeConns(old)
+}
+
+// proxyUpstream returns the upstream of the route and its warm connections,
+// the address being empty if the route is not a proxy.
+func (r *Route) proxyUpstream() (string, chan net.Conn) {
+	r.Lock()
+	defer r.Unlock()
+	return r.upstream, r.warm
+}
+
+// proxy relays a connection to the upstream.
+func (r *Route) proxy(c net.Conn, addr string) {
+	defer c.Close()
+	session := r.startSession()
+	defer r.endSession(session)
+
+	up, err := r.dialUpstream(addr)
+	if err != nil {
+		logging.Warningf("route %s: unable to dial upstream %s: %v", r.name, addr, err)
+		atomic.AddInt64(&r.failures, 1)
+		return
+	}
+	defer up.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = io.Copy(relayWriter{Writer: up, session: session}, c)
+		closeWrite(up)
+	}()
+	_, _ = io.Copy(relayWriter{Writer: c, session: session}, up)
+	closeWrite(c)
+	<-done
+}
+
+// ProxyDrainStats describes how Shutdown drained the connections a proxy route
+// was relaying when its drain started, to check no data was lost.
+type ProxyDrainStats struct {
+	Clean        int   // The connections whose relay finished by itself.
+	Forced       int   // The connections closed mid-relay, on the drain deadline or the context expiry.
+	BytesFlushed int64 // The bytes relayed in both directions while draining, until the connections were closed.
+}
+
+// proxySession is a connection relayed to the upstream.
+type proxySession struct {
+	relayed int64         // The bytes relayed in both directions, accessed atomically.
+	done    chan struct{} // Closed once the relay is over.
+}
+
+// relayWriter counts the bytes relayed by a session.
+type relayWriter struct {
+	io.Writer
+	session *proxySession
+}
+
+// Write writes to the connection and counts what was written.
+func (w relayWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	atomic.AddInt64(&w.session.relayed, int64(n))
+	return n, err
+}
+
+// startSession registers a connection relayed by the route.
+func (r *Route) startSession() *proxySession {
+	s := &proxySession{done: make(chan struct{})}
+	r.Lock()
+	if r.proxied == nil {
+		r.proxied = make(map[*proxySession]struct{})
+	}
+	r.proxied[s] = struct{}{}
+	r.Unlock()
+	return s
+}
+
+// endSession forgets a connection once its relay is over.
+func (r *Route) endSession(s *proxySession) {
+	r.Lock()
+	delete(r.proxied, s)
+	r.Unlock()
+	close(s.done)
+}
+
+// drainingSessions are the sessions of a proxy route when its drain started,
+// with the bytes they had relayed then, nil for the other routes.
+type drainingSessions map[*proxySession]int64
+
+// proxySessions returns the sessions of the route, if it is a proxy.
+func (r *Route) proxySessions() drainingSessions {
+	r.Lock()
+	defer r.Unlock()
+	if r.upstream == "" {
+		return nil
+	}
+
+	sessions := make(drainingSessions, len(r.proxied))
+	for s := range r.proxied {
+		sessions[s] = atomic.LoadInt64(&s.relayed)
+	}
+	return sessions
+}
+
+// drained returns how the sessions were drained so far, the ones still
+// relaying being the forced ones, or nil if the route is no proxy.
+func (sessions drainingSessions) drained() *ProxyDrainStats {
+	if sessions == nil {
+		return nil
+	}
+
+	stats := new(ProxyDrainStats)
+	for s, relayed := range sessions {
+		select {
+		case <-s.done:
+			stats.Clean++
+		default:
+			stats.Forced++
+		}
+		stats.BytesFlushed += atomic.LoadInt64(&s.relayed) - relayed
+	}
+	return stats
+}
+
+// dialUpstream returns a warm connection to the upstream, if there's one left,
+// or else dials it.
+func (r *Route) dialUpstream(addr string) (net.Conn, error) {
+	_, warm := r.proxyUpstream()
+	select {
+	case c := <-warm:
+		return c, nil
+	default:
+		return net.DialTimeout("tcp", addr, proxyDialTimeout)
+	}
+}
+
+// closeWarm closes the warm connections left.
+func (r *Route) closeWarm() {
+	_, warm := r.proxyUpstream()
+	closeConns(warm)
+}
+
+// closeConns closes the connections left in the channel.
+func closeConns(warm chan net.Conn) {
+	for {
+		select {
+		case c := <-warm:
+			_ = c.Close()
+		default:
+			return
+		}
+	}
+}
+
+// closeWriter is implemented by the connections which can be half-closed, such
+// as *net.TCPConn.
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// closeWrite half-closes the connection, if it can be, so its peer reads the
+// end of the stream while the other direction is still relayed.
+func closeWrite(c net.Conn) {
+	for c != nil {
+		switch v := c.(type) {
+		case closeWriter:
+			_ = v.CloseWrite()
+			return
+		case *Conn:
+			c = v.Conn
+		default:
+			return
+		}
+	}
+}
+
+// UpstreamError is the failure of the warmup of the upstream of a proxy route.
+type UpstreamError struct {
+	Route string
+	Addr  string
+	Err   error
+}
+
+// Error returns the description of the failure.
+func (e UpstreamError) Error() string {
+	return fmt.Sprintf("route %s: upstream %s: %v", e.Route, e.Addr, e.Err)
+}
+
+// WarmupError is the error returned by WarmupUpstreams, with the upstreams
+// which could not be warmed up, in the order of their routes.
+type WarmupError []UpstreamError
+
+// Error returns the description of the failures.
+func (e WarmupError) Error() string {
+	failures := make([]string, len(e))
+	for i, err := range e {
+		failures[i] = err.Error()
+	}
+	return "mux: upstreams unreachable: " + strings.Join(failures, "; ")
+}
+
+// WarmupUpstreams prepares the upstreams of the proxy routes, typically at
+// startup, so the first connections do not pay for it: it dials their warm
+// connections, or dials them once to check they are reachable if they have
+// none, for all the routes at once. It returns a WarmupError with the upstreams
+// which could not be dialed, their host name not resolving for instance, the
+// others being warmed up nonetheless, or nil if all were. The context bounds the
+// whole warmup.
+func (m *Listener) WarmupUpstreams(ctx context.Context) error {
+	m.RLock()
+	routes := make([]*Route, len(m.routes))
+	copy(routes, m.routes)
+	m.RUnlock()
+
+	errs := make([]error, len(routes))
+	var wg sync.WaitGroup
+	for i, r := range routes {
+		addr, warm := r.proxyUpstream()
+		if addr == "" {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, addr string, warm chan net.Conn) {
+			defer wg.Done()
+			errs[i] = warmup(ctx, addr, warm)
+		}(i, addr, warm)
+	}
+	wg.Wait()
+
+	var failed WarmupError
+	for i, err := range errs {
+		if err != nil {
+			addr, _ := routes[i].proxyUpstream()
+			failed = append(failed, UpstreamError{Route: routes[i].name, Addr: addr, Err: err})
+		}
+	}
+	if len(failed) > 0 {
+		return failed
+	}
+	return nil
+}
+
+// warmup fills the warm connections of the upstream up, or dials it once if it
+// has none.
+func warmup(ctx context.Context, addr string, warm chan net.Conn) error {
+	var dialer net.Dialer
+	if cap(warm) == 0 {
+		// Only check the upstream is reachable
+		c, err := dialer.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			_ = c.Close()
+		}
+		return err
+	}
+
+	for len(warm) < cap(warm) {
+		c, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case warm <- c:
+		default:
+			_ = c.Close()
+			return nil
+		}
+	}
+	return nil
+}
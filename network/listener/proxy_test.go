@@ -0,0 +1,208 @@
+package listener
+
+import (
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// echoUpstream is a TCP upstream echoing what it reads, counting the
+// connections it accepted and the ones closed by their client.
+type echoUpstream struct {
+	net.Listener
+	accepted int64
+	closed   int64
+}
+
+// newEchoUpstream starts an upstream on a loopback port.
+func newEchoUpstream(t *testing.T) *echoUpstream {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	u := &echoUpstream{Listener: l}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt64(&u.accepted, 1)
+			go func() {
+				defer c.Close()
+				_, _ = io.Copy(c, c)
+				atomic.AddInt64(&u.closed, 1)
+			}()
+		}
+	}()
+	return u
+}
+
+// unreachableAddr returns a loopback address nothing listens on.
+func unreachableAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+	return addr
+}
+
+func TestProxyTo(t *testing.T) {
+	up := newEchoUpstream(t)
+	m := newTestMux(t)
+	m.Route("echo", MatchPrefix("ECHO")).ProxyTo(up.Addr().String())
+	m.serve()
+
+	c := m.dial("ECHO hello")
+	if got := readN(t, c, 10); got != "ECHO hello" {
+		t.Errorf("client read %q, want the sniffed bytes relayed back", got)
+	}
+	go func() { _, _ = c.Write([]byte(" world")) }()
+	if got := readN(t, c, 6); got != " world" {
+		t.Errorf("client read %q, want the following bytes relayed back", got)
+	}
+}
+
+func TestWarmupUpstreams(t *testing.T) {
+	up := newEchoUpstream(t)
+	down := unreachableAddr(t)
+	m := newTestMux(t)
+	warm := m.Route("warm", MatchPrefix("W"))
+	warm.SetWarmConnections(2)
+	warm.ProxyTo(up.Addr().String())
+	m.Route("checked", MatchPrefix("C")).ProxyTo(up.Addr().String())
+	m.Route("down", MatchPrefix("D")).ProxyTo(down)
+	m.Route("plain", MatchAny())
+	m.serve()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	err := m.WarmupUpstreams(ctx)
+	failed, ok := err.(WarmupError)
+	if !ok || len(failed) != 1 {
+		t.Fatalf("WarmupUpstreams() = %v, want the unreachable upstream only", err)
+	}
+	if failed[0].Route != "down" || failed[0].Addr != down || failed[0].Err == nil {
+		t.Errorf("failure = %+v, want the down route and its upstream", failed[0])
+	}
+
+	// Two warm connections, and the one checking the other upstream
+	waitFor(t, "the upstream to accept", func() bool { return atomic.LoadInt64(&up.accepted) == 3 })
+	if _, conns := warm.proxyUpstream(); len(conns) != 2 {
+		t.Errorf("got %d warm connections, want 2", len(conns))
+	}
+
+	// The proxied connection uses a warm one rather than dialing
+	c := m.dial("W")
+	if got := readN(t, c, 1); got != "W" {
+		t.Errorf("client read %q, want W relayed back", got)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt64(&up.accepted); n != 3 {
+		t.Errorf("upstream accepted %d connections, want no new dial", n)
+	}
+}
+
+func TestWarmupUpstreamsReachable(t *testing.T) {
+	up := newEchoUpstream(t)
+	m := newTestMux(t)
+	m.Route("echo", MatchAny()).ProxyTo(up.Addr().String())
+	m.serve()
+
+	if err := m.WarmupUpstreams(context.Background()); err != nil {
+		t.Errorf("WarmupUpstreams() = %v, want nil", err)
+	}
+}
+
+func TestSetWarmConnectionsClosesWarm(t *testing.T) {
+	up := newEchoUpstream(t)
+	m := newTestMux(t)
+	r := m.Route("warm", MatchAny())
+	r.SetWarmConnections(2)
+	r.ProxyTo(up.Addr().String())
+	m.serve()
+
+	if err := m.WarmupUpstreams(context.Background()); err != nil {
+		t.Fatalf("WarmupUpstreams() = %v", err)
+	}
+	r.SetWarmConnections(1)
+	waitFor(t, "the warm connections to be closed", func() bool { return atomic.LoadInt64(&up.closed) == 2 })
+}
+
+func TestServeWaitsForProxies(t *testing.T) {
+	up := newEchoUpstream(t)
+	m := newTestMux(t)
+	m.Route("echo", MatchAny()).ProxyTo(up.Addr().String())
+	m.serve()
+
+	c := m.dial("x")
+	readN(t, c, 1)
+	_ = m.Close()
+	select {
+	case err := <-m.done:
+		t.Fatalf("Serve() = %v while relaying", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The relay ends with the client, and Serve returns
+	_ = c.Close()
+	m.wait()
+}
+
+func TestShutdownProxyDrainStats(t *testing.T) {
+	up := newEchoUpstream(t)
+	m := newTestMux(t)
+	echo := m.Route("echo", MatchPrefix("ECHO"))
+	echo.SetDrainDeadline(300 * time.Millisecond)
+	echo.ProxyTo(up.Addr().String())
+	m.Route("plain", MatchAny())
+	m.serve()
+
+	finishing, idle := m.dial("ECHO a"), m.dial("ECHO b")
+	readN(t, finishing, 6)
+	readN(t, idle, 6)
+
+	results := make(chan ShutdownResult, 1)
+	go func() {
+		result, _ := m.Shutdown(context.Background())
+		results <- result
+	}()
+
+	// One client finishes its transfer while draining, the other one is forced
+	waitFor(t, "the lame duck mode", func() bool { return m.State() == StateLameDuck })
+	time.Sleep(20 * time.Millisecond)
+	go func() { _, _ = finishing.Write([]byte("more")) }()
+	if got := readN(t, finishing, 4); got != "more" {
+		t.Errorf("client read %q while draining, want more", got)
+	}
+	_ = finishing.Close()
+	expectClosed(t, idle)
+
+	result := <-results
+	want := map[string]ProxyDrainStats{"echo": {Clean: 1, Forced: 1, BytesFlushed: 8}}
+	if !reflect.DeepEqual(result.Proxies, want) {
+		t.Errorf("Proxies = %+v, want %+v", result.Proxies, want)
+	}
+	if result.ForceClosed != 1 || result.Drained != 1 {
+		t.Errorf("result = %+v, want 1 drained and 1 force-closed", result)
+	}
+}
+
+func TestShutdownWithoutProxies(t *testing.T) {
+	m := newTestMux(t)
+	m.Route("plain", MatchAny())
+	m.serve()
+
+	result, err := m.Shutdown(context.Background())
+	if err != nil || result.Proxies != nil {
+		t.Errorf("Shutdown() = %+v, %v, want no proxy stats", result, err)
+	}
+}
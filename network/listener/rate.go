@@ -0,0 +1,158 @@
+package listener
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenBucket limits the rate of an event, allowing bursts of up to its size.
+type tokenBucket struct {
+	sync.Mutex
+	rate   float64 // The number of tokens added per second.
+	burst  float64 // The maximum number of tokens.
+	tokens float64 // The available tokens, negative when they are reserved ahead.
+	last   time.Time
+}
+
+// newTokenBucket creates a full bucket.
+func newTokenBucket(perSecond float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   perSecond,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// reserve takes a token and returns how long to wait until it becomes
+// available, which is zero when the bucket isn't empty.
+func (b *tokenBucket) reserve() time.Duration {
+	return b.reserveN(1)
+}
+
+// reserveN takes n tokens and returns how long to wait until they become
+// available.
+func (b *tokenBucket) reserveN(n int) time.Duration {
+	b.Lock()
+	defer b.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// SetGlobalAcceptRate sets the AcceptRate and AcceptBurst options, which limit
+// the rate at which the listener accepts connections, across all the clients,
+// allowing bursts of up to burst connections. A rate of zero removes the
+// limit.
+func (m *Listener) SetGlobalAcceptRate(perSecond float64, burst int) {
+	m.updateOptions(func(o *Options) {
+		o.AcceptRate, o.AcceptBurst = perSecond, burst
+	})
+}
+
+// acceptLimiter applies the accept rate of the options to the accept loop,
+// creating its bucket again whenever the rate or the burst changes.
+type acceptLimiter struct {
+	rate   float64
+	burst  int
+	bucket *tokenBucket
+}
+
+// wait waits until the accept rate of the options allows the next accept, or
+// until done is closed.
+func (l *acceptLimiter) wait(o Options, done <-chan struct{}) {
+	if o.AcceptRate != l.rate || o.AcceptBurst != l.burst {
+		l.rate, l.burst, l.bucket = o.AcceptRate, o.AcceptBurst, nil
+		if o.AcceptRate > 0 {
+			l.bucket = newTokenBucket(o.AcceptRate, o.AcceptBurst)
+		}
+	}
+
+	if l.bucket != nil {
+		if d := l.bucket.reserve(); d > 0 {
+			sleep(d, done)
+		}
+	}
+}
+
+// sleep waits for d, or until done is closed.
+func sleep(d time.Duration, done <-chan struct{}) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-done:
+	}
+}
+
+// SetConnReadRate sets the ConnReadRate option, which limits the number of
+// bytes per second each served connection can read, including the ones being
+// served already. Zero removes the limit.
+func (m *Listener) SetConnReadRate(bytesPerSec int) {
+	m.updateOptions(func(o *Options) {
+		o.ConnReadRate = bytesPerSec
+	})
+}
+
+// readLimiter limits the read rate of a connection to the one of the options
+// of its listener.
+type readLimiter struct {
+	sync.Mutex
+	mux     *Listener
+	current int           // The limit the bucket was created for.
+	done    chan struct{} // Closed once the connection is closed.
+	bucket  *tokenBucket
+}
+
+// newReadLimiter creates the read limiter of a served connection.
+func newReadLimiter(m *Listener, c *Conn) *readLimiter {
+	l := &readLimiter{mux: m, done: make(chan struct{})}
+	c.notifyClose(func() { close(l.done) })
+	return l
+}
+
+// limit returns the part of p which can be read at once, updating the bucket
+// when the limit changed.
+func (l *readLimiter) limit(p []byte) []byte {
+	l.Lock()
+	defer l.Unlock()
+
+	if rate := l.mux.Options().ConnReadRate; rate != l.current {
+		l.current, l.bucket = rate, nil
+		if rate > 0 {
+			l.bucket = newTokenBucket(float64(rate), rate)
+		}
+	}
+
+	if l.bucket != nil && len(p) > l.current {
+		return p[:l.current]
+	}
+	return p
+}
+
+// wait waits until the bucket holds the n bytes read, or the connection gets
+// closed.
+func (l *readLimiter) wait(n int) {
+	l.Lock()
+	bucket := l.bucket
+	l.Unlock()
+
+	if bucket != nil && n > 0 {
+		if d := bucket.reserveN(n); d > 0 {
+			sleep(d, l.done)
+		}
+	}
+}
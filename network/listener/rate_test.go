@@ -0,0 +1,97 @@
+package listener
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAcceptRate(t *testing.T) {
+	const n, rate, burst = 8, 20, 2
+	m := newTestMux(t)
+	m.SetGlobalAcceptRate(rate, burst)
+	r := m.Match(MatchAny())
+	m.serve()
+
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		m.dial("x")
+	}
+	for i := 0; i < n; i++ {
+		accept(t, r)
+	}
+
+	// The burst is accepted at once, the rest at the rate
+	min := time.Duration(n-burst) * time.Second / rate
+	if d := time.Since(start); d < min-20*time.Millisecond {
+		t.Errorf("%d connections accepted in %v, want at least %v", n, d, min)
+	}
+}
+
+func TestAcceptRateInterruptedByClose(t *testing.T) {
+	m := newTestMux(t)
+	m.SetOptions(Options{AcceptRate: 0.1, AcceptBurst: 1})
+	r := m.Match(MatchAny())
+	m.serve()
+
+	// The second connection waits ten seconds for a token
+	m.dial("x")
+	accept(t, r)
+	go func() { _, _ = m.dialer.Dial() }()
+	time.Sleep(20 * time.Millisecond)
+
+	start := time.Now()
+	_ = m.Close()
+	m.wait()
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Serve returned %v after Close, want the accept wait interrupted", d)
+	}
+}
+
+func TestConnReadRate(t *testing.T) {
+	const rate, size = 20000, 30000
+	m := newTestMux(t)
+	m.SetConnReadRate(rate)
+	r := m.Match(MatchAny())
+	m.serve()
+
+	m.dial(strings.Repeat("x", size))
+	c := accept(t, r)
+	start := time.Now()
+	readN(t, c, size)
+
+	// A second worth of bytes is read at once, the rest at the rate
+	want := time.Duration(size-rate) * time.Second / rate
+	if d := time.Since(start); d < want-50*time.Millisecond || d > 4*want {
+		t.Errorf("%d bytes read in %v, want about %v", size, d, want)
+	}
+}
+
+func TestConnReadRateReload(t *testing.T) {
+	const size = 10000
+	m := newTestMux(t)
+	m.SetOptions(Options{ConnReadRate: 100})
+	r := m.Match(MatchAny())
+	m.serve()
+
+	m.dial(strings.Repeat("x", size))
+	c := accept(t, r)
+	readN(t, c, 100)
+
+	// Lifting the limit applies to the connection being served
+	m.SetConnReadRate(0)
+	errs := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(c, make([]byte, size-100))
+		errs <- err
+	}()
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("read still limited after the limit was lifted")
+	}
+}
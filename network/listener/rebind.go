@@ -0,0 +1,102 @@
+package listener
+
+import (
+	"net"
+	"sync"
+)
+
+// Rebind swaps the socket the listener accepts the connections from for
+// another one, such as a socket bound to a new address or handed over by a
+// restarting process, without stopping the listener. The accept loop moves to
+// the new socket and the old one is closed, whose error is returned. The
+// connections accepted from the old socket are not affected: the ones being
+// matched complete their match and are dispatched normally, and the served ones
+// keep being served. The connections still waiting in the backlog of the old
+// socket are dropped by the operating system when it gets closed.
+//
+// It returns ErrListenerClosed, leaving the new socket open, if the listener is
+// closed.
+func (m *Listener) Rebind(l net.Listener) error {
+	old, ok := m.root.swap(l)
+	if !ok {
+		return ErrListenerClosed
+	}
+	return old.Close()
+}
+
+// rootListener is the listener the connections are accepted from, whose socket
+// can be swapped while it is accepting.
+type rootListener struct {
+	mu     sync.RWMutex
+	l      net.Listener
+	closed bool
+	done   chan struct{} // Closed once the listener is closed.
+}
+
+// newRootListener creates a root listener accepting from the socket.
+func newRootListener(l net.Listener) *rootListener {
+	return &rootListener{l: l, done: make(chan struct{})}
+}
+
+// closing returns a channel which is closed once the listener is closed, to
+// interrupt the waits of the accept loop.
+func (r *rootListener) closing() <-chan struct{} {
+	return r.done
+}
+
+// current returns the socket the connections are accepted from.
+func (r *rootListener) current() net.Listener {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.l
+}
+
+// swap replaces the socket and returns the old one, or false if the listener
+// is closed.
+func (r *rootListener) swap(l net.Listener) (net.Listener, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, false
+	}
+
+	old := r.l
+	r.l = l
+	return old, true
+}
+
+// Accept waits for and returns the next connection of the current socket. An
+// Accept interrupted by a Rebind moves on to the new socket.
+func (r *rootListener) Accept() (net.Conn, error) {
+	for {
+		l := r.current()
+		c, err := l.Accept()
+		if err == nil {
+			return c, nil
+		}
+
+		r.mu.RLock()
+		swapped := r.l != l && !r.closed
+		r.mu.RUnlock()
+		if !swapped {
+			return nil, err
+		}
+	}
+}
+
+// Close closes the current socket, and the listener for good.
+func (r *rootListener) Close() error {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.done)
+	}
+	l := r.l
+	r.mu.Unlock()
+	return l.Close()
+}
+
+// Addr returns the address of the current socket.
+func (r *rootListener) Addr() net.Addr {
+	return r.current().Addr()
+}
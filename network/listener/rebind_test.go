@@ -0,0 +1,56 @@
+package listener
+
+import (
+	"io"
+	"testing"
+)
+
+func TestRebind(t *testing.T) {
+	m := newMemoryTestMux(t)
+	r := m.Route("hello", MatchPrefix("HELLO"))
+	m.serve()
+
+	// A connection from the old socket, still being matched
+	slow := m.dial("")
+	wrote := make(chan struct{})
+	go func() {
+		_, _ = io.WriteString(slow, "HEL")
+		close(wrote)
+	}()
+	waitFor(t, "the connection to be accepted", func() bool { return m.Stats().Accepted == 1 })
+
+	old := m.mem
+	m.mem = NewMemoryListener()
+	m.dialer = m.mem
+	if err := m.Rebind(m.mem); err != nil {
+		t.Fatalf("Rebind() = %v", err)
+	}
+	if _, err := old.Dial(); err == nil {
+		t.Error("old socket still accepting after Rebind")
+	}
+
+	go func() {
+		<-wrote
+		_, _ = io.WriteString(slow, "LO")
+	}()
+	if got := readN(t, accept(t, r), 5); got != "HELLO" {
+		t.Errorf("in-flight connection read %q, want HELLO", got)
+	}
+	m.dial("HELLO")
+	if got := readN(t, accept(t, r), 5); got != "HELLO" {
+		t.Errorf("connection of the new socket read %q, want HELLO", got)
+	}
+}
+
+func TestRebindClosed(t *testing.T) {
+	m := newTestMux(t)
+	m.serve()
+	_ = m.Close()
+	m.wait()
+
+	l := NewMemoryListener()
+	defer l.Close()
+	if err := m.Rebind(l); err != ErrListenerClosed {
+		t.Errorf("Rebind() = %v, want ErrListenerClosed", err)
+	}
+}
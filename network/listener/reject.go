@@ -0,0 +1,250 @@
+package listener
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/numb3r3/live-go/log"
+)
+
+// rejectTimeout bounds the reads of the reject responders, and by default the
+// write of the response a rejected connection is sent.
+const rejectTimeout = time.Second
+
+// rejectSniffTimeout bounds the sniffing of a rejected connection, which is
+// short as the connections are mostly rejected while the listener sheds load.
+// The clients which send their request right away are still answered.
+const rejectSniffTimeout = 200 * time.Millisecond
+
+// ErrRouteFull is the error the connections matched for a route at its
+// connection limit are rejected with.
+var ErrRouteFull error = errRejected("mux: route full")
+
+// ErrDraining is the error new connections are closed with while the listener
+// is draining.
+var ErrDraining error = errRejected("mux: listener draining")
+
+// StartDraining makes the listener reject the new connections, sending them
+// the reject HTTP response if one is set so the clients reconnect elsewhere,
+// while the connections already accepted keep being served. Unlike Shutdown, it
+// neither stops the listener nor waits for the connections, and StopDraining
+// ends it, which makes it suitable for the window of a rolling restart.
+func (m *Listener) StartDraining() {
+	atomic.StoreInt32(&m.draining, 1)
+	m.notifyState()
+}
+
+// StopDraining makes the listener accept the new connections again.
+func (m *Listener) StopDraining() {
+	atomic.StoreInt32(&m.draining, 0)
+	m.notifyState()
+}
+
+// IsDraining returns whether the listener rejects the new connections.
+func (m *Listener) IsDraining() bool {
+	return atomic.LoadInt32(&m.draining) == 1
+}
+
+// RejectMode is how rejected connections are closed.
+type RejectMode int
+
+// The modes of closing the rejected connections.
+const (
+	RejectFIN RejectMode = iota // Close gracefully, sending a FIN.
+	RejectRST                   // Reset the connection, sending a RST.
+)
+
+// SetRejectMode sets how rejected connections are closed. RejectRST sets the
+// linger time of the connection to zero before closing it, so the operating
+// system resets it, which discourages scanners and frees the socket at once,
+// without the TIME_WAIT state. It applies to the TCP connections, on every
+// platform, while the others are closed as usual. As the reset makes the peer
+// discard its unread data, no HTTP response is sent in this mode.
+func (m *Listener) SetRejectMode(mode RejectMode) {
+	m.Lock()
+	defer m.Unlock()
+	m.rejectMode = mode
+}
+
+// SetRejectHTTPResponse sets the HTTP response sent to the rejected connections
+// which look like HTTP requests, such as the ones over the connection limit,
+// before they are closed, so the clients get a proper error instead of a reset
+// connection. Other rejected connections are just closed. A zero status
+// disables the response.
+func (m *Listener) SetRejectHTTPResponse(status int, body string) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.rejectHTTP = nil
+	if status > 0 {
+		m.rejectHTTP = httpResponse(status, "", body)
+	}
+}
+
+// SetCapacityRetryAfter sets the delay after which the HTTP clients rejected
+// because the listener or their route is at its connection limit, WebSocket
+// upgrades included, are told to retry. They then get a 503 Service
+// Unavailable with a Retry-After header before any handshake, instead of the
+// reject HTTP response. Zero disables it.
+func (m *Listener) SetCapacityRetryAfter(d time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.capacityHTTP = nil
+	if d > 0 {
+		seconds := int((d + time.Second - 1) / time.Second)
+		m.capacityHTTP = httpResponse(http.StatusServiceUnavailable,
+			fmt.Sprintf("Retry-After: %d\r\n", seconds), "server at capacity, retry later\n")
+	}
+}
+
+// RejectResponder writes a protocol-appropriate goodbye to a rejected
+// connection before it is closed, for instance an MQTT CONNACK telling the
+// client the server is unavailable. The bytes sniffed while matching are
+// replayed to it, so it may read the request it answers. Its reads are bounded
+// by a deadline of a second, and its writes by the reject write timeout.
+type RejectResponder func(c net.Conn)
+
+// SetRejectResponder sets the responder called for the connections of the
+// route which get rejected, in place of the reject HTTP response: the ones a
+// matcher of the route rejected, and, if the route is peek-only, the ones over
+// the connection limit or arriving while draining, as they are then matched
+// with the matchers of the peek-only routes to find their route. The responder
+// of an MQTT route could answer with a CONNACK carrying the "server
+// unavailable" return code:
+//
+//	mqtt.SetRejectResponder(func(c net.Conn) {
+//		_, _ = c.Write([]byte{0x20, 0x02, 0x00, 0x03})
+//	})
+//
+// It is not called in RST reject mode.
+func (r *Route) SetRejectResponder(responder RejectResponder) {
+	r.Lock()
+	defer r.Unlock()
+	r.guards.responder.reset()
+	r.responder = responder
+}
+
+// rejectResponder returns the reject responder of the route.
+func (r *Route) rejectResponder() RejectResponder {
+	r.Lock()
+	defer r.Unlock()
+	return r.responder
+}
+
+// rejectedRoute matches a connection rejected before matching to find its
+// route, if any route with a reject responder is peek-only, reading until the
+// deadline. Only the matchers of the peek-only routes are run, as the others
+// may write to the connection or wait for it, which is not worth it for a
+// connection being shed.
+func (m *Listener) rejectedRoute(c *Conn, deadline time.Time) *Route {
+	m.RLock()
+	all := m.matchers
+	m.RUnlock()
+
+	var matchers []processor
+	responders := false
+	for _, sl := range all {
+		if sl.listen.isPeekOnly() {
+			matchers = append(matchers, sl)
+			responders = responders || sl.listen.rejectResponder() != nil
+		}
+	}
+	if !responders {
+		return nil
+	}
+
+	config := m.sniffConfig()
+	_ = c.SetReadDeadline(deadline)
+	for _, sl := range matchers {
+		state := &sniffState{deadline: deadline}
+		if sl.matcher(&sniffReader{Reader: c.startSniffing(), config: config, state: state}) && state.rejected == nil {
+			return sl.listen
+		}
+	}
+	return nil
+}
+
+// SetRejectWriteTimeout sets the write deadline of the responses sent to the
+// rejected connections, reject HTTP responses and reject responders alike, so
+// a client which does not read them can not hold the rejecting goroutine. The
+// connection is closed once the deadline expires, whether the response was
+// written or not. Zero restores the default of a second.
+func (m *Listener) SetRejectWriteTimeout(d time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.rejectWrite = d
+}
+
+// httpResponse formats a minimal HTTP/1.1 response closing the connection,
+// with the extra header lines, each terminated by CRLF.
+func httpResponse(status int, header, body string) []byte {
+	return []byte(fmt.Sprintf("HTTP/1.1 %d %s\r\n"+
+		"Content-Type: text/plain; charset=utf-8\r\n"+
+		"Content-Length: %d\r\n%s"+
+		"Connection: close\r\n\r\n%s", status, http.StatusText(status), len(body), header, body))
+}
+
+// lingerer is implemented by the connections whose linger time can be set,
+// such as *net.TCPConn.
+type lingerer interface {
+	SetLinger(sec int) error
+}
+
+// rejectConn closes a rejected connection as the reject mode says and reports
+// the error. In FIN mode, the reject responder of the route of the connection
+// is called first, r being nil if it is not known yet, or else the reject HTTP
+// response is sent if the client looks like it speaks HTTP.
+func (m *Listener) rejectConn(c *Conn, r *Route, err error) {
+	m.RLock()
+	response, mode := m.rejectHTTP, m.rejectMode
+	writeTimeout := m.rejectWrite
+	if (err == ErrTooManyConnections || err == ErrRouteFull) && m.capacityHTTP != nil {
+		response = m.capacityHTTP
+	}
+	m.RUnlock()
+
+	if writeTimeout <= 0 {
+		writeTimeout = rejectTimeout
+	}
+
+	// The sniffing for the route and the HTTP response share the deadline
+	sniffDeadline := time.Now().Add(rejectSniffTimeout)
+	var responder RejectResponder
+	if err == ErrBanned || err == ErrIPBlocked || err == ErrPreAuth || err == ErrSniffMemory {
+		// Tell scanners and unauthenticated clients nothing, and sniff
+		// nothing without memory
+		response = nil
+	} else if mode == RejectFIN {
+		if r == nil {
+			r = m.rejectedRoute(c, sniffDeadline)
+		}
+		if r != nil {
+			responder = r.rejectResponder()
+		}
+	}
+
+	if mode == RejectRST {
+		if l, ok := c.Conn.(lingerer); ok {
+			_ = l.SetLinger(0)
+		}
+	} else if responder != nil {
+		_ = c.SetReadDeadline(time.Now().Add(rejectTimeout))
+		_ = c.SetWriteDeadline(time.Now().Add(writeTimeout))
+		c.doneSniffing()
+		r.guards.responder.call("reject responder", func() { responder(c) })
+	} else if response != nil {
+		_ = c.SetReadDeadline(sniffDeadline)
+		if MatchHTTP()(c.startSniffing()) {
+			_ = c.SetWriteDeadline(time.Now().Add(writeTimeout))
+			_, _ = c.Write(response)
+		}
+	}
+
+	_ = c.Close()
+	logging.Debugf("connection from %v rejected: %v", c.RemoteAddr(), err)
+	_ = m.handleErr(err)
+}
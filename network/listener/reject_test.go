@@ -0,0 +1,267 @@
+package listener
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// rejectAfterFirst returns a listener serving a single connection at once,
+// with the connection holding the slot.
+func rejectAfterFirst(t *testing.T) *testMux {
+	m := newTestMux(t)
+	m.SetMaxConnections(1)
+	r := m.Match(MatchAny())
+	m.serve()
+
+	m.dial("x")
+	accept(t, r)
+	return m
+}
+
+func TestRejectHTTPResponse(t *testing.T) {
+	m := rejectAfterFirst(t)
+	m.SetRejectHTTPResponse(http.StatusTooManyRequests, "slow down\n")
+
+	resp, err := m.httpClient().Get("http://mux/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || string(body) != "slow down\n" {
+		t.Errorf("response = %d %q, want 429 slow down", resp.StatusCode, body)
+	}
+
+	// Other clients are just closed
+	if got := expectClosed(t, m.dial("PING\r\n")); got != "" {
+		t.Errorf("non-HTTP client read %q, want nothing", got)
+	}
+
+	// A silent client is not sniffed for long
+	start := time.Now()
+	expectClosed(t, m.dial(""))
+	if d := time.Since(start); d >= rejectTimeout {
+		t.Errorf("silent client closed after %v, want the %v sniff timeout", d, rejectSniffTimeout)
+	}
+}
+
+func TestRejectHTTPResponseDisabled(t *testing.T) {
+	m := rejectAfterFirst(t)
+	m.SetRejectHTTPResponse(http.StatusTooManyRequests, "slow down\n")
+	m.SetRejectHTTPResponse(0, "")
+
+	if got := expectClosed(t, m.dial("GET / HTTP/1.1\r\nHost: mux\r\n\r\n")); got != "" {
+		t.Errorf("HTTP client read %q, want nothing", got)
+	}
+}
+
+// lingerListener records the linger times set on its connections.
+type lingerListener struct {
+	net.Listener
+	lingers chan int
+}
+
+func (l *lingerListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &lingerConn{Conn: c, lingers: l.lingers}, nil
+}
+
+type lingerConn struct {
+	net.Conn
+	lingers chan int
+}
+
+func (c *lingerConn) SetLinger(sec int) error {
+	c.lingers <- sec
+	return nil
+}
+
+func TestRejectMode(t *testing.T) {
+	const request = "GET / HTTP/1.1\r\nHost: mux\r\n\r\n"
+	for _, mode := range []RejectMode{RejectFIN, RejectRST} {
+		mem := NewMemoryListener()
+		root := &lingerListener{Listener: mem, lingers: make(chan int, 1)}
+		m := &testMux{Listener: New(root), t: t, dialer: mem, mem: mem}
+		m.SetMaxConnections(1)
+		m.SetRejectMode(mode)
+		m.SetRejectHTTPResponse(http.StatusServiceUnavailable, "")
+		r := m.Match(MatchAny())
+		m.serve()
+
+		m.dial("x")
+		accept(t, r)
+		got := expectClosed(t, m.dial(request))
+
+		select {
+		case sec := <-root.lingers:
+			if mode != RejectRST || sec != 0 {
+				t.Errorf("mode %d: linger set to %d", mode, sec)
+			}
+		default:
+			if mode == RejectRST {
+				t.Error("RST mode: linger not set")
+			}
+		}
+		// The reset would discard the response anyway
+		if sent := got != ""; sent != (mode == RejectFIN) {
+			t.Errorf("mode %d: client read %q", mode, got)
+		}
+	}
+}
+
+func TestDraining(t *testing.T) {
+	m := newTestMux(t)
+	m.SetRejectHTTPResponse(http.StatusServiceUnavailable, "reconnect elsewhere\n")
+	r := m.Match(MatchAny())
+	m.serve()
+
+	first := m.dial("x")
+	existing := accept(t, r)
+
+	m.StartDraining()
+	if !m.IsDraining() {
+		t.Error("IsDraining() = false after StartDraining")
+	}
+	resp, err := m.httpClient().Get("http://mux/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status while draining = %d, want 503", resp.StatusCode)
+	}
+
+	// The existing connection keeps being served
+	go func() { _, _ = existing.Write([]byte("still here")) }()
+	if got := readN(t, first, 10); got != "still here" {
+		t.Errorf("existing client read %q, want still here", got)
+	}
+
+	// A silent client is closed once the short reject sniff expires
+	start := time.Now()
+	expectClosed(t, m.dial(""))
+	if d := time.Since(start); d >= rejectTimeout {
+		t.Errorf("silent client closed after %v while draining, want the %v sniff timeout", d, rejectSniffTimeout)
+	}
+
+	m.StopDraining()
+	m.dial("y")
+	accept(t, r)
+}
+
+func TestCapacityRetryAfter(t *testing.T) {
+	upgrade := httpRequest("Host: rtms.example.com", "Connection: Upgrade", "Upgrade: websocket",
+		"Sec-WebSocket-Version: 13", "Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==")
+	limits := map[string]func(*Listener, *Route){
+		"listener": func(m *Listener, _ *Route) { m.SetMaxConnections(1) },
+		"route":    func(_ *Listener, r *Route) { r.SetMaxConnections(1) },
+	}
+	for name, limit := range limits {
+		t.Run(name, func(t *testing.T) {
+			m := newTestMux(t)
+			m.SetRejectHTTPResponse(http.StatusTooManyRequests, "")
+			m.SetCapacityRetryAfter(1500 * time.Millisecond)
+			r := m.Route("ws", MatchWebSocket())
+			limit(m.Listener, r)
+			m.serve()
+
+			m.dial(upgrade)
+			accept(t, r)
+
+			c := m.dial(upgrade)
+			_ = c.SetReadDeadline(time.Now().Add(testTimeout))
+			resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+			if err != nil {
+				t.Fatalf("ReadResponse() = %v", err)
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "2" {
+				t.Errorf("response = %d, Retry-After %q, want 503 and 2", resp.StatusCode, resp.Header.Get("Retry-After"))
+			}
+		})
+	}
+}
+
+func TestRejectResponder(t *testing.T) {
+	const connect = "\x10\x0c\x00\x04MQTT\x04\x02\x00\x3c"
+	m := newTestMux(t)
+	m.SetMaxConnections(1)
+	m.SetRejectHTTPResponse(http.StatusServiceUnavailable, "")
+	mqtt := m.Route("mqtt", MatchPrefix("\x10"))
+	mqtt.SetPeekOnly(true)
+	mqtt.SetRejectResponder(func(c net.Conn) {
+		// The CONNECT the responder answers is replayed to it
+		b := make([]byte, len(connect))
+		if _, err := io.ReadFull(c, b); err == nil && string(b) == connect {
+			_, _ = c.Write([]byte{0x20, 0x02, 0x00, 0x03})
+		}
+	})
+	m.Route("web", MatchHTTP())
+	m.serve()
+
+	m.dial(connect)
+	accept(t, mqtt)
+
+	// A CONNACK with the "server unavailable" return code
+	if got := expectClosed(t, m.dial(connect)); got != "\x20\x02\x00\x03" {
+		t.Errorf("MQTT client read %q, want the CONNACK", got)
+	}
+	resp, err := m.httpClient().Get("http://mux/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("HTTP client status = %d, want the reject HTTP response", resp.StatusCode)
+	}
+
+	// A silent client is sniffed for its route and the HTTP response within
+	// the short reject sniff timeout
+	start := time.Now()
+	expectClosed(t, m.dial(""))
+	if d := time.Since(start); d >= rejectTimeout {
+		t.Errorf("silent client closed after %v, want the %v sniff timeout", d, rejectSniffTimeout)
+	}
+}
+
+func TestRejectWriteTimeout(t *testing.T) {
+	// The write of the response blocks on the pipe the client does not read
+	m := newMemoryTestMux(t)
+	errs := make(chan error, 1)
+	m.HandleError(func(err error) bool {
+		errs <- err
+		return true
+	})
+	m.SetRejectHTTPResponse(http.StatusTooManyRequests, "slow down\n")
+	m.SetRejectWriteTimeout(50 * time.Millisecond)
+	m.SetMaxConnections(1)
+	r := m.Match(MatchAny())
+	m.serve()
+
+	m.dial("x")
+	accept(t, r)
+	goroutines := runtime.NumGoroutine()
+
+	// The client sends its request but never reads the response
+	start := time.Now()
+	c := m.dial(httpRequest("Host: a"))
+	if err := <-errs; err != ErrTooManyConnections {
+		t.Fatalf("error = %v, want ErrTooManyConnections", err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond || d >= rejectTimeout {
+		t.Errorf("rejected after %v, want the 50ms write timeout", d)
+	}
+	if _, err := c.Write([]byte("x")); err == nil {
+		t.Error("the rejected connection is still open")
+	}
+	waitFor(t, "the reject goroutine to return", func() bool { return runtime.NumGoroutine() <= goroutines })
+}
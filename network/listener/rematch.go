@@ -0,0 +1,95 @@
+package listener
+
+import "net"
+
+// Rematch hands a connection served by a route back to the listener, which
+// matches it again against the current matchers and dispatches it to the route
+// matched, like a connection just accepted. It lets a handler which negotiated
+// an upgrade within the protocol, say an HTTP request switching to a custom
+// protocol, pass the connection on to the route serving what follows:
+//
+//	hj, _ := w.(http.Hijacker)
+//	c, _, _ := hj.Hijack()
+//	_, _ = c.Write([]byte("HTTP/1.1 101 Switching Protocols\r\n\r\n"))
+//	go m.Rematch(c)
+//
+// The handler must give up the connection: it may not keep using it, and
+// must not hold bytes of it in a buffer of its own, like a bufio.Reader ahead
+// of the upgrade, as the matchers only see what it did not read. The bytes
+// replayed to the handler and left unread are matched again. A connection
+// returned by a route of the listener keeps its ID, metadata and counters, while
+// its old route lets it go: it stops counting it, ends its span and stops its
+// handle deadline. Any other connection, like one wrapped by a transform, is
+// matched as a new one.
+//
+// Rematch blocks while matching and returns the error the connection was
+// rejected with, an ErrNotMatched, or ErrListenerClosed, in which case the
+// connection is closed.
+func (m *Listener) Rematch(c net.Conn) error {
+	m.RLock()
+	select {
+	case <-m.closing:
+		m.RUnlock()
+		_ = c.Close()
+		return ErrListenerClosed
+	default:
+	}
+	m.rematching.Add(1)
+	m.RUnlock()
+	defer m.rematching.Done()
+
+	muc, ok := c.(*Conn)
+	if ok {
+		muc.handOff()
+		muc.limiter = nil
+		muc.buffer.rebase()
+	} else {
+		muc = m.accepted(c)
+	}
+	slot, err := m.acquireMatching()
+	if err != nil {
+		_ = muc.Close()
+		return err
+	}
+	return m.match(muc, m.closing, nil, slot)
+}
+
+// Adopt takes over a connection held by a handler of another listener, or by
+// any other code, and matches it against the matchers of this listener, like a
+// connection just accepted, counted and reported as one. It suits the
+// blue/green swaps of a routing configuration within a process: the handlers
+// of the old listener hand their connections to the new one, which routes them
+// as its table says, without dropping them.
+//
+// The handler must give up the connection as for Rematch. The bytes sniffed by
+// the old listener and not read yet are matched again. A connection served by a
+// route of another listener is no longer waited for by the drain of that route,
+// although its counters keep counting it until it gets closed.
+//
+// Adopt blocks while matching and returns the error the connection was
+// rejected with, an ErrNotMatched, or ErrListenerClosed, in which case the
+// connection is closed.
+func (m *Listener) Adopt(c net.Conn) error {
+	m.RLock()
+	select {
+	case <-m.closing:
+		m.RUnlock()
+		_ = c.Close()
+		return ErrListenerClosed
+	default:
+	}
+	m.rematching.Add(1)
+	m.RUnlock()
+	defer m.rematching.Done()
+
+	if held, ok := AsConn(c); ok {
+		held.handOff()
+	}
+	muc := m.accepted(c)
+	slot, err := m.acquireMatching()
+	if err != nil {
+		_ = muc.Close()
+		return err
+	}
+	return m.match(muc, m.closing, nil, slot)
+}
@@ -0,0 +1,130 @@
+package listener
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRematch(t *testing.T) {
+	const upgrade = "GET /chat HTTP/1.1\r\nUpgrade: chat\r\n\r\n"
+	m := newTestMux(t)
+	web := m.Route("web", MatchHTTP())
+	web.SetHandleDeadline(100 * time.Millisecond)
+	chat := m.Route("chat", MatchPrefix("CHAT "))
+	m.serve()
+
+	// The client sends its first message along with the upgrade
+	client := m.dial(upgrade + "CHAT hello\n")
+	c := accept(t, web).(*Conn)
+	readN(t, c, len(upgrade))
+	go func() { _, _ = c.Write([]byte("HTTP/1.1 101 Switching Protocols\r\n\r\n")) }()
+	readN(t, client, 36)
+
+	errs := make(chan error, 1)
+	go func() { errs <- m.Rematch(c) }()
+	rematched := accept(t, chat).(*Conn)
+	if err := <-errs; err != nil {
+		t.Fatalf("Rematch() = %v", err)
+	}
+	if rematched.ID() != c.ID() || rematched.Route() != "chat" {
+		t.Errorf("rematched connection %s on %s, want %s on chat", rematched.ID(), rematched.Route(), c.ID())
+	}
+	if got := readN(t, rematched, 11); got != "CHAT hello\n" {
+		t.Errorf("chat handler read %q, want the first message", got)
+	}
+
+	// The web route lets the connection go, deadline included
+	for _, s := range m.RouteStats() {
+		if want := map[string]int{"web": 0, "chat": 1}[s.Name]; s.Active != want {
+			t.Errorf("%s route has %d active connections, want %d", s.Name, s.Active, want)
+		}
+	}
+	time.Sleep(150 * time.Millisecond)
+	go func() { _, _ = client.Write([]byte("CHAT still here\n")) }()
+	if got := readN(t, rematched, 16); got != "CHAT still here\n" {
+		t.Errorf("chat handler read %q after the web handle deadline", got)
+	}
+}
+
+func TestRematchErrors(t *testing.T) {
+	m := newTestMux(t)
+	r := m.Route("web", MatchHTTP())
+	m.serve()
+
+	client := m.dial(httpRequest("Host: a"))
+	c := accept(t, r)
+	readN(t, c, len(httpRequest("Host: a")))
+	go func() { _, _ = client.Write([]byte("\x00\x01binary\n")) }()
+	var notMatched ErrNotMatched
+	if err := m.Rematch(c); !errors.As(err, &notMatched) {
+		t.Errorf("Rematch() of an unknown protocol = %v, want ErrNotMatched", err)
+	}
+	expectClosed(t, client)
+
+	_ = m.Close()
+	m.wait()
+	server, client := net.Pipe()
+	defer client.Close()
+	if err := m.Rematch(server); err != ErrListenerClosed {
+		t.Errorf("Rematch() once closed = %v, want ErrListenerClosed", err)
+	}
+	expectClosed(t, client)
+}
+
+func TestAdopt(t *testing.T) {
+	old := newTestMux(t)
+	legacy := old.Route("legacy", MatchPrefix("HELLO"))
+	old.serve()
+	m := newTestMux(t)
+	v2 := m.Route("v2", MatchPrefix("HELLO v2"))
+	m.Route("v1", MatchPrefix("HELLO"))
+	m.serve()
+
+	// The old handler hands the connection over without reading it
+	client := old.dial("HELLO v2\n")
+	c := accept(t, legacy)
+	errs := make(chan error, 1)
+	go func() { errs <- m.Adopt(c) }()
+	adopted := accept(t, v2)
+	if err := <-errs; err != nil {
+		t.Fatalf("Adopt() = %v", err)
+	}
+	if got := readN(t, adopted, 9); got != "HELLO v2\n" {
+		t.Errorf("v2 handler read %q, want the bytes sniffed by the old listener", got)
+	}
+	go func() { _, _ = client.Write([]byte("PING\n")) }()
+	if got := readN(t, adopted, 5); got != "PING\n" {
+		t.Errorf("v2 handler read %q, want the following bytes", got)
+	}
+
+	if s := m.Stats(); s.Accepted != 1 || s.Active != 1 {
+		t.Errorf("Stats() = %+v, want the adopted connection counted", s)
+	}
+	waitFor(t, "the old route to let go", func() bool { return old.RouteStats()[0].Active == 0 })
+}
+
+func TestAdoptErrors(t *testing.T) {
+	m := newTestMux(t)
+	m.Route("hello", MatchPrefix("HELLO"))
+	m.serve()
+
+	server, client := net.Pipe()
+	defer client.Close()
+	go func() { _, _ = client.Write([]byte("\x00\x01binary\n")) }()
+	var notMatched ErrNotMatched
+	if err := m.Adopt(server); !errors.As(err, &notMatched) {
+		t.Errorf("Adopt() of an unknown protocol = %v, want ErrNotMatched", err)
+	}
+	expectClosed(t, client)
+
+	_ = m.Close()
+	m.wait()
+	server, client = net.Pipe()
+	defer client.Close()
+	if err := m.Adopt(server); err != ErrListenerClosed {
+		t.Errorf("Adopt() once closed = %v, want ErrListenerClosed", err)
+	}
+	expectClosed(t, client)
+}
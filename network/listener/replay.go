@@ -0,0 +1,73 @@
+package listener
+
+import (
+	"bytes"
+	"net"
+)
+
+// MatchBytes runs the matchers registered by register over recorded client
+// data, such as a stream captured from a real client, and returns the name of
+// the route it matched, along with the bytes the matchers sniffed. The route
+// name is the key of the map register returns, or the name of the route if it
+// isn't in the map. An empty name means no route matched, or a matcher rejected
+// the data. It makes regression tests of a routing table out of captured
+// traffic:
+//
+//	route, _ := listener.MatchBytes(func(l *listener.Listener) map[string]net.Listener {
+//		return map[string]net.Listener{
+//			"mqtt": l.Match(mqttMatcher),
+//			"http": l.Match(listener.MatchHTTP()),
+//		}
+//	}, captured)
+//	if route != "mqtt" {
+//		t.Errorf("captured MQTT client routed to %q", route)
+//	}
+//
+// The data is matched as if the client sent it all at once and then waited,
+// so a matcher needing more bytes than recorded does not match.
+func MatchBytes(register func(*Listener) map[string]net.Listener, data []byte) (string, []byte) {
+	m := New(NewMemoryListener())
+	routes := register(m)
+	defer closeUnserved(m)
+
+	m.RLock()
+	matchers := m.matchers
+	m.RUnlock()
+	config := m.sniffConfig()
+
+	s := &sniffer{source: bytes.NewReader(data)}
+	route := ""
+	for _, sl := range matchers {
+		s.reset(true)
+		state := new(sniffState)
+		matched := sl.matcher(&sniffReader{Reader: s, config: config, state: state})
+		if state.rejected != nil {
+			break
+		}
+
+		if matched {
+			route = sl.listen.name
+			for name, l := range routes {
+				if l == net.Listener(sl.listen) {
+					route = name
+				}
+			}
+			break
+		}
+	}
+	return route, append([]byte(nil), s.sniffed()...)
+}
+
+// closeUnserved closes a listener which never served, closing the queues of
+// its routes so the servers registered with Handle return, and waits for them.
+func closeUnserved(m *Listener) {
+	_ = m.Close()
+
+	m.RLock()
+	routes := m.routes
+	m.RUnlock()
+	for _, r := range routes {
+		r.closeQueues()
+	}
+	m.servers.Wait()
+}
@@ -0,0 +1,37 @@
+package listener
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestMatchBytes(t *testing.T) {
+	register := func(l *Listener) map[string]net.Listener {
+		l.Route("statsd", MatchStatsD())
+		return map[string]net.Listener{
+			"redis": l.Match(MatchPrefix("*")),
+			"http":  l.Match(MatchHTTP()),
+		}
+	}
+	tests := []struct {
+		name  string
+		data  string
+		route string
+	}{
+		{"mapped", "*1\r\n$4\r\nPING\r\n", "redis"},
+		{"route name", "foo:1|c\n", "statsd"},
+		{"no match", "\x00\x01", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route, sniffed := MatchBytes(register, []byte(tt.data))
+			if route != tt.route {
+				t.Errorf("MatchBytes() route = %q, want %q", route, tt.route)
+			}
+			if len(sniffed) == 0 || !strings.HasPrefix(tt.data, string(sniffed)) {
+				t.Errorf("MatchBytes() sniffed %q, want a prefix of the data", sniffed)
+			}
+		})
+	}
+}
@@ -0,0 +1,321 @@
+package listener
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/numb3r3/live-go/log"
+)
+
+// Route is a virtual listener which accepts the connections matched by the
+// matchers it was registered with.
+type Route struct {
+	net.Listener
+	sync.Mutex
+	name          string
+	connections   chan net.Conn
+	done          chan struct{}      // Closed once the route is closed with Close.
+	active        map[*Conn]struct{} // The connections dispatched and not closed yet.
+	drainDeadline time.Duration      // The time given to the connections to finish on shutdown.
+	transform     func(net.Conn) net.Conn
+	err           error // The error returned by Accept once the route is closed.
+	lastPressure  int64 // When the backpressure was last reported, in Unix nanoseconds.
+	priority      int   // The shutdown priority, higher drains first.
+	workers       []chan net.Conn
+	affinity      func(net.Conn) int
+	responder     RejectResponder
+	handleLimit   time.Duration // The time the connections are handled for before being closed.
+	noReplay      bool          // Whether the sniff buffer is released once matched.
+	maxConns      int           // The maximum number of active connections, zero for no limit.
+	exempt        int           // The active connections exempt from the limits, which maxConns does not count.
+	certs         atomic.Value  // The []tls.Certificate set with ReloadCertificates.
+	upstream      string        // The address the connections are proxied to, if any.
+	warm          chan net.Conn // The connections to the upstream dialed in advance.
+	failures      int64         // The errors reported for the connections, accessed atomically.
+	peekOnly      bool          // Whether the matchers are pure, so they can run concurrently.
+	proxied       map[*proxySession]struct{}
+	mux           *Listener   // The listener the route is registered on.
+	guards        routeGuards // The panic guards of the hooks of the route.
+}
+
+// newRoute creates a new route on top of the root listener.
+func newRoute(name string, root net.Listener, bufferSize int) *Route {
+	return &Route{
+		Listener:    root,
+		name:        name,
+		connections: make(chan net.Conn, bufferSize),
+		done:        make(chan struct{}),
+		active:      make(map[*Conn]struct{}),
+	}
+}
+
+// Name returns the name of the route.
+func (r *Route) Name() string {
+	return r.name
+}
+
+// ReportError reports an error of a handler of the route, such as a failed
+// request or a recovered panic, which is counted in the Errors of its
+// RouteStats, for error rate SLOs for instance.
+func (r *Route) ReportError(err error) {
+	atomic.AddInt64(&r.failures, 1)
+	logging.Debugf("route %s: %v", r.name, err)
+}
+
+// SetDrainDeadline sets how long Shutdown waits for the connections of the
+// route to finish before force-closing them. Zero waits as long as the context
+// given to Shutdown allows.
+func (r *Route) SetDrainDeadline(d time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+	r.drainDeadline = d
+}
+
+// SetHandleDeadline sets how long the connections of the route are handled,
+// from the time they are matched, before they get closed and reported to the
+// error handler with ErrHandleDeadline, however active they are. It caps the
+// duration of request-response exchanges. Zero, the default, disables it.
+func (r *Route) SetHandleDeadline(d time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+	r.handleLimit = d
+}
+
+// SetMaxConnections sets the maximum number of connections of the route which
+// can be open at once, independently of the limit of the listener, to protect
+// an upstream for instance. The connections matched while the route is full are
+// rejected with ErrRouteFull, as the reject mode says; the HTTP clients get the
+// capacity response if one is set. Zero means no limit.
+func (r *Route) SetMaxConnections(n int) {
+	r.Lock()
+	defer r.Unlock()
+	r.maxConns = n
+}
+
+// SetNoReplayBuffer sets whether the matched connections of the route give up
+// the buffer of the sniffer right away, keeping only a copy of the sniffed
+// bytes left to replay, before their reads go straight to the socket. It suits
+// the passthrough routes, such as proxies, whose handlers read all the bytes
+// anyway, sparing them the buffer, which otherwise lives until they read past
+// it.
+func (r *Route) SetNoReplayBuffer(enabled bool) {
+	r.Lock()
+	defer r.Unlock()
+	r.noReplay = enabled
+}
+
+// noReplayBuffer returns whether the matched connections give up the sniff
+// buffer.
+func (r *Route) noReplayBuffer() bool {
+	r.Lock()
+	defer r.Unlock()
+	return r.noReplay
+}
+
+// SetShutdownPriority sets the priority of the route on shutdown: the routes
+// of higher priority are drained first, for instance a request-response API
+// before long-lived subscriptions. Routes default to priority zero.
+func (r *Route) SetShutdownPriority(p int) {
+	r.Lock()
+	defer r.Unlock()
+	r.priority = p
+}
+
+// shutdownPriority returns the shutdown priority of the route.
+func (r *Route) shutdownPriority() int {
+	r.Lock()
+	defer r.Unlock()
+	return r.priority
+}
+
+// SetTransform sets a function wrapping the matched connections before they
+// are accepted, for example to decode their stream for the handler.
+func (r *Route) SetTransform(transform func(net.Conn) net.Conn) {
+	r.Lock()
+	defer r.Unlock()
+	r.transform = transform
+}
+
+// wrap applies the transform of the route to a matched connection.
+func (r *Route) wrap(c net.Conn) net.Conn {
+	r.Lock()
+	transform := r.transform
+	r.Unlock()
+
+	if transform == nil {
+		return c
+	}
+	return transform(c)
+}
+
+// track registers a connection dispatched to the route until it is closed.
+// It returns false, without registering it, if the route is full.
+func (r *Route) track(c *Conn) bool {
+	r.Lock()
+	if r.maxConns > 0 && !c.exempt && len(r.active)-r.exempt >= r.maxConns {
+		r.Unlock()
+		return false
+	}
+	r.active[c] = struct{}{}
+	if c.exempt {
+		r.exempt++
+	}
+	r.Unlock()
+
+	c.route, c.owner = r.name, r
+	c.notifyHandoff(func() {
+		r.Lock()
+		delete(r.active, c)
+		if c.exempt {
+			r.exempt--
+		}
+		r.Unlock()
+	})
+	return true
+}
+
+// count returns the number of connections of the route which are still open.
+func (r *Route) count() int {
+	r.Lock()
+	defer r.Unlock()
+	return len(r.active)
+}
+
+// closeActive force-closes all the connections of the route which are still
+// open and returns their number.
+func (r *Route) closeActive() int {
+	r.Lock()
+	conns := make([]*Conn, 0, len(r.active))
+	for c := range r.active {
+		conns = append(conns, c)
+	}
+	r.Unlock()
+
+	for _, c := range conns {
+		_ = c.Close()
+	}
+	return len(conns)
+}
+
+// Accept waits for and returns the next connection matched for the route.
+func (r *Route) Accept() (net.Conn, error) {
+	select {
+	case c, ok := <-r.connections:
+		if !ok {
+			if r.err != nil {
+				return nil, r.err
+			}
+			return nil, ErrListenerClosed
+		}
+		if r.closed() {
+			_ = c.Close()
+			return nil, ErrListenerClosed
+		}
+		return c, nil
+	case <-r.done:
+		return nil, ErrListenerClosed
+	}
+}
+
+// Close closes the route alone, rather than the root listener, so a server of
+// the route can stop, as an http.Server does on Shutdown, while the listener
+// and its other routes keep serving. The listener stops matching connections
+// for the route, which go on to the next routes instead, its Accept returns
+// ErrListenerClosed and the connections waiting to be accepted are closed.
+func (r *Route) Close() error {
+	r.Lock()
+	if r.closed() {
+		r.Unlock()
+		return nil
+	}
+	close(r.done)
+	r.Unlock()
+
+	if r.mux != nil {
+		r.mux.removeMatchers(r)
+	}
+	r.drainQueues()
+	return nil
+}
+
+// closed returns whether the route was closed with Close.
+func (r *Route) closed() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// drainQueues closes the connections waiting in the queues of the route,
+// without closing the queues, which the dispatches in progress may still send
+// to.
+func (r *Route) drainQueues() {
+	r.Lock()
+	queues := append([]chan net.Conn{r.connections}, r.workers...)
+	r.Unlock()
+
+	for _, q := range queues {
+	drain:
+		for {
+			select {
+			case c, ok := <-q:
+				if !ok {
+					break drain
+				}
+				_ = c.Close()
+			default:
+				break drain
+			}
+		}
+	}
+}
+
+// AcceptBatch waits for the next connection matched for the route, like
+// Accept, and returns it along with the others already waiting to be accepted,
+// up to max connections in all, in the order they were dispatched. It does not
+// wait for more connections to fill the batch, so a partial batch is returned
+// as soon as the waiting ones are taken, which keeps the latency of Accept for
+// the handlers processing many connections at once. It returns the error of
+// Accept once the route is closed and has no connection left, and closes the
+// connections waiting once the route is closed while it takes them.
+func (r *Route) AcceptBatch(max int) ([]net.Conn, error) {
+	c, err := r.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	batch := []net.Conn{c}
+	for len(batch) < max {
+		select {
+		case c, ok := <-r.connections:
+			if !ok {
+				return batch, nil
+			}
+			if r.closed() {
+				// Closed while draining, the connections left are not accepted
+				_ = c.Close()
+				r.drainQueues()
+				return batch, nil
+			}
+			batch = append(batch, c)
+		default:
+			return batch, nil
+		}
+	}
+	return batch, nil
+}
+
+// ServerFunc adapts a serve function, such as the Serve method of an
+// http.Server, to the Server interface.
+type ServerFunc func(l net.Listener) error
+
+// Serve serves the listener and logs the error it stopped with.
+func (f ServerFunc) Serve(l net.Listener) {
+	if err := f(l); err != nil && err != ErrListenerClosed {
+		logging.Infof("server stopped: %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package listener
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestRouteMaxConnections(t *testing.T) {
+	m := newTestMux(t)
+	errs := make(chan error, 4)
+	m.HandleError(func(err error) bool {
+		errs <- err
+		return true
+	})
+	ws := m.Route("ws", MatchPrefix("W"))
+	ws.SetMaxConnections(3)
+	proxy := m.Route("proxy", MatchPrefix("P"))
+	proxy.SetMaxConnections(1)
+	m.serve()
+
+	for i := 0; i < 3; i++ {
+		m.dial("W")
+		accept(t, ws)
+	}
+	m.dial("P")
+	held := accept(t, proxy)
+
+	// Each route is full at its own limit
+	for _, data := range []string{"W", "P"} {
+		expectClosed(t, m.dial(data))
+		if err := <-errs; err != ErrRouteFull {
+			t.Errorf("error of a %s connection = %v, want ErrRouteFull", data, err)
+		}
+	}
+
+	_ = held.Close()
+	waitFor(t, "the proxy route to have room", func() bool {
+		for _, s := range m.RouteStats() {
+			if s.Name == "proxy" {
+				return s.Active == 0
+			}
+		}
+		return false
+	})
+	m.dial("P")
+	accept(t, proxy)
+}
+
+func TestAcceptBatch(t *testing.T) {
+	m := newTestMux(t)
+	r := m.Route("ws", MatchAny())
+	m.serve()
+
+	// Queue the connections one after the other, so their order is known
+	for i := 0; i < 5; i++ {
+		m.dial(string(rune('0' + i)))
+		waitFor(t, "the connection to be queued", func() bool { return len(r.connections) == i+1 })
+	}
+
+	read := func(batch []net.Conn) string {
+		var got string
+		for _, c := range batch {
+			got += readN(t, c, 1)
+		}
+		return got
+	}
+	batch, err := r.AcceptBatch(3)
+	if err != nil || read(batch) != "012" {
+		t.Fatalf("AcceptBatch(3) = %d connections, %v, want the first 3 in order", len(batch), err)
+	}
+	if batch, err = r.AcceptBatch(3); err != nil || read(batch) != "34" {
+		t.Fatalf("AcceptBatch(3) = %d connections, %v, want the 2 left", len(batch), err)
+	}
+
+	// An empty queue waits for the next connection, without filling the batch
+	m.dial("5")
+	if batch, err = r.AcceptBatch(3); err != nil || read(batch) != "5" {
+		t.Fatalf("AcceptBatch(3) = %d connections, %v, want the next one alone", len(batch), err)
+	}
+
+	_ = m.Close()
+	if _, err := r.AcceptBatch(3); err != ErrListenerClosed {
+		t.Errorf("AcceptBatch() once closed = %v, want ErrListenerClosed", err)
+	}
+}
+
+func TestRouteClose(t *testing.T) {
+	m := newTestMux(t)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "hello")
+	})}
+	web := m.Handle("web", ServerFunc(srv.Serve), MatchHTTP())
+	m.Handle("echo", EchoServer{}, MatchPrefix("ECHO"))
+	rest := m.Route("rest", MatchAny())
+	m.serve()
+
+	resp, err := m.httpClient().Get("http://mux/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	// Stopping the web server closes its route alone
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := web.Accept(); err != ErrListenerClosed {
+		t.Errorf("Accept() of the closed route = %v, want ErrListenerClosed", err)
+	}
+	if got := readN(t, m.dial("ECHO hi"), 7); got != "ECHO hi" {
+		t.Errorf("echoed %q, want the echo route still served", got)
+	}
+	request := httpRequest("Host: mux")
+	m.dial(request)
+	if got := readN(t, accept(t, rest), len(request)); got != request {
+		t.Errorf("next route read %q, want the request the closed route no longer takes", got)
+	}
+}
@@ -0,0 +1,108 @@
+package listener
+
+import (
+	"io"
+	"sort"
+	"time"
+)
+
+// Scorer is a matcher telling how confident it is that a connection is of its
+// protocol, for the traffic several matchers could claim. A positive score
+// means it matches, and the higher the score, the more confident the scorer.
+// As the plain matchers score 1, the scores are best kept between 0 and 1, 1
+// being certain.
+type Scorer interface {
+	Score(r io.Reader) float64
+}
+
+// ScoreFunc adapts a function to the Scorer interface.
+type ScoreFunc func(r io.Reader) float64
+
+// Score returns the score of the connection read from r.
+func (f ScoreFunc) Score(r io.Reader) float64 {
+	return f(r)
+}
+
+// Score makes a Matcher a Scorer, scoring 1 for the connections it matches and
+// 0 for the others.
+func (m Matcher) Score(r io.Reader) float64 {
+	if m(r) {
+		return 1
+	}
+	return 0
+}
+
+// scoreMatcher adapts a scorer to a Matcher, which matches the connections it
+// gives a positive score.
+func scoreMatcher(s Scorer) Matcher {
+	return func(r io.Reader) bool { return s.Score(r) > 0 }
+}
+
+// ScoredRoute registers a named route like Route does, whose connections are
+// matched by the scorers, any positive score matching. Unless scored matching
+// is enabled, the scores only tell whether they match, and the routes are
+// tried in registration order as usual.
+func (m *Listener) ScoredRoute(name string, scorers ...Scorer) *Route {
+	r, err := m.addRoute(name, scorers)
+	if err != nil {
+		r = newRoute(name, m.root, 0)
+		r.err = err
+		close(r.connections)
+	}
+	return r
+}
+
+// SetScoredMatching sets whether the connections go to the route of the
+// matcher which is the most confident, rather than the first one matching.
+// Every matcher then scores each connection, over the same sniffed bytes, the
+// plain matchers scoring 1 when they match, and the connection goes to the
+// route with the highest score; routes scoring the same are ranked by
+// registration order, so among plain matchers the first registered still wins.
+// The winner is run again to dispatch the connection, so the scorers must be
+// pure: the matchers writing to the connection or performing a handshake while
+// sniffing, such as MatchWriter and MatchClientCert, are not supported. As it
+// waits for every matcher, the match takes as long as the slowest of them.
+func (m *Listener) SetScoredMatching(enabled bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.scoring = enabled
+}
+
+// rankedProcessor is a matcher along with the score of a connection.
+type rankedProcessor struct {
+	processor
+	score float64
+}
+
+// rankMatchers scores the connection with every matcher and returns the ones
+// scoring it positively, by decreasing score and in registration order for the
+// same score, followed by the ones rejecting it, which get to reject it if none
+// matches. It stops scoring once the match is canceled.
+func (m *Listener) rankMatchers(c *Conn, im *inflightMatch, matchers []processor, sniff func() io.Reader,
+	config *sniffConfig, skip bool, deadline time.Time) []processor {
+	var ranked []rankedProcessor
+	for _, sl := range matchers {
+		if !m.inflight.try(im, sl.name) {
+			return nil
+		}
+
+		r := sniff()
+		if skip {
+			r = &leadingSkipper{source: r}
+		}
+		state := &sniffState{conn: c, deadline: deadline}
+		score := sl.scorer.Score(&sniffReader{Reader: r, config: config, state: state})
+		if score > 0 || state.rejected != nil {
+			ranked = append(ranked, rankedProcessor{processor: sl, score: score})
+		}
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].score > ranked[j].score
+	})
+	sorted := make([]processor, len(ranked))
+	for i := range ranked {
+		sorted[i] = ranked[i].processor
+	}
+	return sorted
+}
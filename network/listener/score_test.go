@@ -0,0 +1,72 @@
+package listener
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// prefixScore returns a scorer giving the score to the connections starting
+// with the prefix.
+func prefixScore(prefix string, score float64) ScoreFunc {
+	match := MatchPrefix(prefix)
+	return func(r io.Reader) float64 {
+		if match(r) {
+			return score
+		}
+		return 0
+	}
+}
+
+func TestMatcherScore(t *testing.T) {
+	m := MatchPrefix("HELLO")
+	if s := m.Score(strings.NewReader("HELLO")); s != 1 {
+		t.Errorf("Score() of a match = %v, want 1", s)
+	}
+	if s := m.Score(strings.NewReader("OTHER")); s != 0 {
+		t.Errorf("Score() of a mismatch = %v, want 0", s)
+	}
+}
+
+func TestScoredMatching(t *testing.T) {
+	tests := []struct {
+		name    string
+		scoring bool
+		data    string
+		route   string
+	}{
+		{"highest score wins", true, "HELLO v2", "specific"},
+		{"only match", true, "HELLO v1", "generic"},
+		{"plain matcher scores 1", true, "PLAIN", "plain"},
+		{"ties in registration order", true, "TIE", "first"},
+		{"first match without scoring", false, "HELLO v2", "generic"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMux(t)
+			m.SetScoredMatching(tt.scoring)
+			routes := map[string]*Route{
+				"generic":  m.ScoredRoute("generic", prefixScore("HELLO", 0.3), prefixScore("PLAIN", 0.5)),
+				"specific": m.ScoredRoute("specific", prefixScore("HELLO v2", 0.9)),
+				"plain":    m.Route("plain", MatchPrefix("PLAIN")),
+				"first":    m.ScoredRoute("first", prefixScore("TIE", 0.5)),
+				"second":   m.ScoredRoute("second", prefixScore("TIE", 0.5)),
+			}
+			m.serve()
+
+			m.dial(tt.data)
+			if got := readN(t, accept(t, routes[tt.route]), len(tt.data)); got != tt.data {
+				t.Errorf("%s handler read %q, want %q", tt.route, got, tt.data)
+			}
+		})
+	}
+}
+
+func TestScoredMatchingNoMatch(t *testing.T) {
+	m := newTestMux(t)
+	m.SetScoredMatching(true)
+	m.ScoredRoute("hello", prefixScore("HELLO", 0.5))
+	m.serve()
+
+	expectClosed(t, m.dial("OTHER"))
+}
@@ -0,0 +1,68 @@
+package listener
+
+import (
+	"sync"
+	"time"
+)
+
+// semaphore bounds the units of a resource taken at once, such as the bytes of
+// the sniff memory or the slots of the TLS handshakes.
+type semaphore struct {
+	mu    sync.Mutex
+	limit int64
+	used  int64
+	freed chan struct{} // Closed when units are given back.
+}
+
+// newSemaphore creates a semaphore of limit units.
+func newSemaphore(limit int64) *semaphore {
+	return &semaphore{limit: limit, freed: make(chan struct{})}
+}
+
+// acquire takes n units, if they are available.
+func (s *semaphore) acquire(n int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.used+n > s.limit {
+		return false
+	}
+	s.used += n
+	return true
+}
+
+// wait takes n units, waiting for them to be available until the deadline, if
+// any, or until donec is closed. It returns false if it gave up.
+func (s *semaphore) wait(n int64, deadline time.Time, donec <-chan struct{}) bool {
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for {
+		s.mu.Lock()
+		freed := s.freed
+		s.mu.Unlock()
+		if s.acquire(n) {
+			return true
+		}
+
+		select {
+		case <-freed:
+		case <-expired:
+			return false
+		case <-donec:
+			return false
+		}
+	}
+}
+
+// release gives n units back and wakes the waiters.
+func (s *semaphore) release(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.used -= n
+	close(s.freed)
+	s.freed = make(chan struct{})
+}
@@ -0,0 +1,251 @@
+package listener
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/numb3r3/live-go/log"
+)
+
+// shutdownPollInterval is how often Shutdown checks whether the connections
+// of a route have finished.
+const shutdownPollInterval = 10 * time.Millisecond
+
+// ShutdownResult describes how the connections were drained by Shutdown.
+type ShutdownResult struct {
+	Drained     int           // The connections which finished by themselves.
+	ForceClosed int           // The connections closed on a drain deadline or the context expiry.
+	Duration    time.Duration // The time it took to drain all the routes.
+
+	// Proxies holds how the proxied connections of each proxy route were
+	// drained, by route name. It is nil if there's no proxy route.
+	Proxies map[string]ProxyDrainStats
+}
+
+// Shutdown gracefully shuts down the listener. It first stops accepting new
+// connections, then waits for the connections of every route to be closed.
+// A route with a drain deadline has its remaining connections force-closed
+// once the deadline elapses, independently of the other routes.
+//
+// The teardown runs in this order, so no connection is accepted once the
+// routes are torn down, and none is left undispatched:
+//
+//  1. The root listener is closed, so the accept loop of Serve stops.
+//  2. The connections being matched complete their match: they are dispatched
+//     to their route, or closed as they would be otherwise. The matches left
+//     when the context expires are canceled.
+//  3. The routes are drained, as described below, while their queues get
+//     closed, so their Accept returns ErrListenerClosed and the connections
+//     queued but not accepted yet are closed.
+//
+// The routes are drained by decreasing shutdown priority: the routes of the
+// same priority are drained together, and the next ones only once they are
+// done. The drain deadline of a route starts with the drain of its group, while
+// the context bounds the whole shutdown, so a slow group eats into the time
+// left for the following ones.
+//
+// If the context expires first, all the remaining connections are
+// force-closed and the context's error is returned. The result is reported in
+// both cases.
+func (m *Listener) Shutdown(ctx context.Context) (ShutdownResult, error) {
+	start := time.Now()
+	atomic.StoreInt32(&m.lameDuck, 1)
+	m.notifyState()
+	defer func() {
+		atomic.StoreInt32(&m.lameDuck, 2)
+		m.notifyState()
+	}()
+	err := m.Close()
+	m.awaitMatches(ctx)
+
+	m.RLock()
+	routes := make([]*Route, len(m.routes))
+	copy(routes, m.routes)
+	m.RUnlock()
+
+	m.RLock()
+	progress := m.drainProgress
+	m.RUnlock()
+
+	if progress != nil {
+		done, stopped := make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(stopped)
+			reportDrain(routes, func(n int) {
+				m.hooks.drainProgress.call("drain progress callback", func() { progress(n) })
+			}, done)
+		}()
+		defer func() {
+			close(done)
+			<-stopped
+		}()
+	}
+
+	total := remaining(routes)
+	results := make(chan drainResult, len(routes))
+	for _, group := range shutdownGroups(routes) {
+		var wg sync.WaitGroup
+		for _, r := range group {
+			wg.Add(1)
+			go func(r *Route) {
+				defer wg.Done()
+				results <- r.drain(ctx)
+			}(r)
+		}
+		wg.Wait()
+	}
+	close(results)
+
+	var result ShutdownResult
+	var drainErr error
+	for res := range results {
+		result.ForceClosed += res.closed
+		if res.proxy != nil {
+			if result.Proxies == nil {
+				result.Proxies = make(map[string]ProxyDrainStats)
+			}
+			result.Proxies[res.route] = *res.proxy
+		}
+		if res.err != nil {
+			drainErr = res.err
+		}
+	}
+	if result.Drained = total - result.ForceClosed; result.Drained < 0 {
+		result.Drained = 0
+	}
+	result.Duration = time.Since(start)
+	if drainErr != nil {
+		return result, drainErr
+	}
+	return result, err
+}
+
+// awaitMatches waits for Serve to stop matching connections, canceling the
+// matches left if the context expires first.
+func (m *Listener) awaitMatches(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		m.accepting.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		for _, info := range m.MatchingConnections() {
+			m.CancelMatch(info.ID)
+		}
+	}
+}
+
+// drainResult is the outcome of the drain of a route.
+type drainResult struct {
+	route  string
+	closed int              // The number of connections force-closed.
+	proxy  *ProxyDrainStats // The drain of the proxied connections, nil if the route is no proxy.
+	err    error
+}
+
+// shutdownGroups groups the routes by shutdown priority, in decreasing order,
+// keeping their registration order within a group.
+func shutdownGroups(routes []*Route) [][]*Route {
+	sorted := make([]*Route, len(routes))
+	copy(sorted, routes)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].shutdownPriority() > sorted[j].shutdownPriority()
+	})
+
+	var groups [][]*Route
+	for i, r := range sorted {
+		if i == 0 || r.shutdownPriority() != sorted[i-1].shutdownPriority() {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], r)
+	}
+	return groups
+}
+
+// SetDrainProgress sets a function Shutdown calls with the number of
+// connections which remain open, every time it changes while draining.
+func (m *Listener) SetDrainProgress(fn func(remaining int)) {
+	m.Lock()
+	defer m.Unlock()
+	m.drainProgress = fn
+	m.hooks.drainProgress.reset()
+}
+
+// reportDrain reports the number of remaining connections whenever it changes,
+// until done gets closed.
+func reportDrain(routes []*Route, progress func(int), done <-chan struct{}) {
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	last := -1
+	report := func() {
+		if n := remaining(routes); n != last {
+			progress(n)
+			last = n
+		}
+	}
+
+	for {
+		report()
+		select {
+		case <-ticker.C:
+		case <-done:
+			report()
+			return
+		}
+	}
+}
+
+// remaining returns the number of connections still open on the routes.
+func remaining(routes []*Route) (n int) {
+	for _, r := range routes {
+		n += r.count()
+	}
+	return
+}
+
+// drain waits for the connections of the route to finish, honoring the drain
+// deadline of the route. It returns the number of connections it force-closed,
+// and how the proxied ones were drained.
+func (r *Route) drain(ctx context.Context) drainResult {
+	r.Lock()
+	deadline := r.drainDeadline
+	r.Unlock()
+
+	var expired <-chan time.Time
+	if deadline > 0 {
+		timer := time.NewTimer(deadline)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	sessions := r.proxySessions()
+	result := drainResult{route: r.name}
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if r.count() == 0 {
+			result.proxy = sessions.drained()
+			return result
+		}
+
+		select {
+		case <-ticker.C:
+		case <-expired:
+			logging.Infof("route %s: drain deadline exceeded, closing %d connections", r.name, r.count())
+			result.proxy = sessions.drained()
+			result.closed = r.closeActive()
+			return result
+		case <-ctx.Done():
+			result.proxy = sessions.drained()
+			result.closed, result.err = r.closeActive(), ctx.Err()
+			return result
+		}
+	}
+}
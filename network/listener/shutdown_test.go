@@ -0,0 +1,256 @@
+package listener
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// hold serves the connection until it gets closed, and then sends the time it
+// was closed at.
+func hold(c net.Conn) <-chan time.Time {
+	closed := make(chan time.Time, 1)
+	go func() {
+		_, _ = ioutil.ReadAll(c)
+		closed <- time.Now()
+	}()
+	return closed
+}
+
+func TestShutdownDrainDeadlines(t *testing.T) {
+	m := newTestMux(t)
+	fast := m.Route("fast", MatchPrefix("F"))
+	fast.SetDrainDeadline(50 * time.Millisecond)
+	slow := m.Route("slow", MatchPrefix("S"))
+	slow.SetDrainDeadline(300 * time.Millisecond)
+	m.serve()
+
+	m.dial("F")
+	m.dial("S")
+	fastClosed := hold(accept(t, fast))
+	slowClosed := hold(accept(t, slow))
+
+	start := time.Now()
+	result, err := m.Shutdown(context.Background())
+	if err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+
+	if d := (<-fastClosed).Sub(start); d < 50*time.Millisecond || d >= 300*time.Millisecond {
+		t.Errorf("fast route closed after %v, want its 50ms deadline", d)
+	}
+	if d := (<-slowClosed).Sub(start); d < 300*time.Millisecond {
+		t.Errorf("slow route closed after %v, want its 300ms deadline", d)
+	}
+	if result.ForceClosed != 2 {
+		t.Errorf("ForceClosed = %d, want 2", result.ForceClosed)
+	}
+}
+
+func TestShutdownWaitsForConnections(t *testing.T) {
+	m := newTestMux(t)
+	r := m.Route("r", MatchAny())
+	m.serve()
+
+	m.dial("x")
+	c := accept(t, r)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = c.Close()
+	}()
+
+	result, err := m.Shutdown(context.Background())
+	if err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+	if result.Drained != 1 || result.ForceClosed != 0 {
+		t.Errorf("result = %+v, want 1 drained", result)
+	}
+}
+
+func TestShutdownContextExpiry(t *testing.T) {
+	m := newTestMux(t)
+	r := m.Route("r", MatchAny())
+	m.serve()
+
+	m.dial("x")
+	closed := hold(accept(t, r))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := m.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown() = %v, want context.DeadlineExceeded", err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(testTimeout):
+		t.Error("connection not force-closed")
+	}
+}
+
+func TestDrainProgress(t *testing.T) {
+	m := newTestMux(t)
+	var mu sync.Mutex
+	var reports []int
+	m.SetDrainProgress(func(remaining int) {
+		mu.Lock()
+		defer mu.Unlock()
+		reports = append(reports, remaining)
+	})
+	r := m.Route("r", MatchAny())
+	m.serve()
+
+	const n = 3
+	for i := 0; i < n; i++ {
+		m.dial("x")
+		c := accept(t, r)
+		go func(d time.Duration) {
+			time.Sleep(d)
+			_ = c.Close()
+		}(time.Duration(i+1) * 50 * time.Millisecond)
+	}
+
+	if _, err := m.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reports) == 0 || reports[0] != n || reports[len(reports)-1] != 0 {
+		t.Fatalf("reports = %v, want from %d down to 0", reports, n)
+	}
+	for i := 1; i < len(reports); i++ {
+		if reports[i] >= reports[i-1] {
+			t.Errorf("reports = %v, want a decreasing count", reports)
+			break
+		}
+	}
+}
+
+func TestShutdownResult(t *testing.T) {
+	m := newTestMux(t)
+	r := m.Route("r", MatchAny())
+	r.SetDrainDeadline(100 * time.Millisecond)
+	m.serve()
+
+	for i := 0; i < 4; i++ {
+		m.dial("x")
+		c := accept(t, r)
+		if i%2 == 0 {
+			go func() {
+				time.Sleep(30 * time.Millisecond)
+				_ = c.Close()
+			}()
+		} else {
+			hold(c)
+		}
+	}
+
+	result, err := m.Shutdown(context.Background())
+	if err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+	if result.Drained != 2 || result.ForceClosed != 2 {
+		t.Errorf("result = %+v, want 2 drained and 2 force-closed", result)
+	}
+	if result.Duration < 100*time.Millisecond || result.Duration > time.Second {
+		t.Errorf("Duration = %v, want about the 100ms drain deadline", result.Duration)
+	}
+}
+
+func TestShutdownPriority(t *testing.T) {
+	m := newTestMux(t)
+	api := m.Route("api", MatchPrefix("A"))
+	api.SetShutdownPriority(1)
+	api.SetDrainDeadline(50 * time.Millisecond)
+	pubsub := m.Route("pubsub", MatchPrefix("P"))
+	pubsub.SetDrainDeadline(50 * time.Millisecond)
+	m.serve()
+
+	m.dial("P")
+	m.dial("A")
+	pubsubClosed := hold(accept(t, pubsub))
+	apiClosed := hold(accept(t, api))
+
+	start := time.Now()
+	if _, err := m.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+
+	// The deadline of the pub/sub route only starts once the API one is drained
+	apiAt, pubsubAt := (<-apiClosed).Sub(start), (<-pubsubClosed).Sub(start)
+	if apiAt < 50*time.Millisecond {
+		t.Errorf("api route closed after %v, want its 50ms deadline", apiAt)
+	}
+	if pubsubAt < apiAt+50*time.Millisecond {
+		t.Errorf("pubsub route closed after %v, want 50ms after the api route at %v", pubsubAt, apiAt)
+	}
+}
+
+func TestShutdownRacingDials(t *testing.T) {
+	m := newTestMux(t)
+	r := m.Route("r", MatchPrefix("P"))
+	m.serve()
+	go func() {
+		for {
+			c, err := r.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				if _, err := io.ReadFull(c, make([]byte, 1)); err == nil {
+					_, _ = c.Write([]byte("OK"))
+				}
+			}()
+		}
+	}()
+
+	// Each client is either served or rejected, never left waiting on a
+	// connection no route will dispatch
+	const clients = 50
+	outcomes := make(chan string, clients)
+	for i := 0; i < clients; i++ {
+		go func() {
+			c, err := m.dialer.Dial()
+			if err != nil {
+				outcomes <- "rejected"
+				return
+			}
+			defer c.Close()
+			_ = c.SetDeadline(time.Now().Add(testTimeout))
+			if _, err := c.Write([]byte("P")); err != nil {
+				outcomes <- "rejected"
+				return
+			}
+			b, err := ioutil.ReadAll(c)
+			switch {
+			case string(b) == "OK":
+				outcomes <- "served"
+			case err == nil || errors.Is(err, syscall.ECONNRESET):
+				// Reset if still in the backlog when the socket closed
+				outcomes <- "rejected"
+			default:
+				outcomes <- "stuck"
+			}
+		}()
+	}
+
+	time.Sleep(time.Millisecond)
+	if _, err := m.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+	counts := make(map[string]int)
+	for i := 0; i < clients; i++ {
+		counts[<-outcomes]++
+	}
+	if counts["stuck"] > 0 {
+		t.Errorf("outcomes = %v, want every client served or rejected", counts)
+	}
+}
@@ -0,0 +1,202 @@
+package listener
+
+import (
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// The default limits applied by the matchers while sniffing.
+const (
+	defaultMaxHeaderLines = 100
+)
+
+// sniffConfig holds the listener settings which matchers honor while sniffing
+// a connection.
+type sniffConfig struct {
+	maxHeaderLines int    // The maximum number of HTTP header lines read.
+	maxHeaderBytes int    // The maximum number of bytes of HTTP headers read, zero if unbounded.
+	minTLSVersion  uint16 // The minimum TLS version a ClientHello must advertise.
+
+	// The slots of the concurrent TLS handshakes, nil if unbounded, and their
+	// policy.
+	handshakes *semaphore
+	tlsPolicy  HandshakePolicy
+
+	closing <-chan struct{} // Closed once the listener is closed, to stop the waits.
+}
+
+// defaultSniffConfig is used by matchers reading something else than a
+// connection sniffed by the listener.
+var defaultSniffConfig = sniffConfig{
+	maxHeaderLines: defaultMaxHeaderLines,
+}
+
+// sniffReader is the reader given to the matchers, carrying the settings of
+// the listener along with the sniffed bytes.
+type sniffReader struct {
+	io.Reader
+	config *sniffConfig
+	state  *sniffState
+}
+
+// sniffState is the outcome of the matchers for a connection besides matching.
+type sniffState struct {
+	rejected  error         // The reason the connection must be rejected.
+	conn      *Conn         // The connection, for the matchers which write or set deadlines.
+	deadline  time.Time     // The read deadline of the sniffing, zero if there's none.
+	handshake *tlsHandshake // The TLS handshake performed by a matcher, if any.
+}
+
+// configOf returns the sniff settings a matcher reading from r must honor.
+func configOf(r io.Reader) *sniffConfig {
+	if sr, ok := r.(*sniffReader); ok {
+		return sr.config
+	}
+	return &defaultSniffConfig
+}
+
+// reject makes the listener reject the connection sniffed through r with the
+// error instead of trying the next matchers.
+func reject(r io.Reader, err error) {
+	if sr, ok := r.(*sniffReader); ok && sr.state != nil {
+		sr.state.rejected = err
+	}
+}
+
+// writerOf returns the writer of the connection sniffed through r, or a writer
+// discarding everything for other readers.
+func writerOf(r io.Reader) io.Writer {
+	if c := connOf(r); c != nil {
+		return c
+	}
+	return ioutil.Discard
+}
+
+// connOf returns the connection sniffed through r, or nil for other readers.
+func connOf(r io.Reader) *Conn {
+	if sr, ok := r.(*sniffReader); ok && sr.state != nil {
+		return sr.state.conn
+	}
+	return nil
+}
+
+// sniffDeadline returns the read deadline of the sniffing through r.
+func sniffDeadline(r io.Reader) time.Time {
+	if sr, ok := r.(*sniffReader); ok && sr.state != nil {
+		return sr.state.deadline
+	}
+	return time.Time{}
+}
+
+// sniffedReader is implemented by the readers keeping the bytes they sniffed.
+type sniffedReader interface {
+	sniffed() []byte
+}
+
+// sniffConfig returns the current sniff settings of the listener.
+func (m *Listener) sniffConfig() *sniffConfig {
+	m.RLock()
+	defer m.RUnlock()
+	return &sniffConfig{
+		maxHeaderLines: m.maxHeaderLines,
+		maxHeaderBytes: m.maxHeaderBytes,
+		minTLSVersion:  m.minTLSVersion,
+		handshakes:     m.tlsHandshakes,
+		tlsPolicy:      m.tlsPolicy,
+		closing:        m.root.closing(),
+	}
+}
+
+// SetMaxSniffHeaderLines sets the maximum number of header lines the HTTP
+// header matchers read. A request whose wanted header comes after that many
+// lines is not matched. Zero restores the default of 100 lines.
+func (m *Listener) SetMaxSniffHeaderLines(n int) {
+	m.Lock()
+	defer m.Unlock()
+	if n <= 0 {
+		n = defaultMaxHeaderLines
+	}
+	m.maxHeaderLines = n
+}
+
+// SetSniffPrealloc sets the number of bytes the sniff buffer of each accepted
+// connection is grown to upfront, so sniffing a handshake of the expected size
+// doesn't reallocate it. Zero, the default, grows the buffer on demand.
+func (m *Listener) SetSniffPrealloc(n int) {
+	m.Lock()
+	defer m.Unlock()
+	m.sniffPrealloc = n
+}
+
+// SetMaxHTTPHeaderSniffBytes sets the maximum number of bytes the HTTP
+// matchers read, request line and headers included, independently of the other
+// sniff limits. A request whose wanted header does not fit is not matched,
+// which bounds the work large header blocks cause. Zero, the default, only
+// applies the header line limit.
+func (m *Listener) SetMaxHTTPHeaderSniffBytes(n int) {
+	m.Lock()
+	defer m.Unlock()
+	m.maxHeaderBytes = n
+}
+
+// limitHTTPHeaders bounds the bytes an HTTP matcher reads from r, keeping the
+// sniff settings and state of r.
+func limitHTTPHeaders(r io.Reader) io.Reader {
+	config := configOf(r)
+	if config.maxHeaderBytes <= 0 {
+		return r
+	}
+
+	limited := &sniffReader{Reader: io.LimitReader(r, int64(config.maxHeaderBytes)), config: config}
+	if sr, ok := r.(*sniffReader); ok {
+		limited.state = sr.state
+	}
+	return limited
+}
+
+// MetaSessionID is the metadata key of the session identifier set by the
+// session ID extractor.
+const MetaSessionID = "SessionID"
+
+// SetSessionIDExtractor sets a function extracting a session identifier, such
+// as the resume token a reconnecting client sends in its first frame, from the
+// bytes sniffed to match a connection. The identifier it returns is attached to
+// the matched connection under MetaSessionID, so the handler can resume the
+// session without parsing the frame again. A nil function disables it.
+func (m *Listener) SetSessionIDExtractor(fn func(sniffed []byte) (string, bool)) {
+	m.Lock()
+	defer m.Unlock()
+	m.hooks.sessionID.reset()
+	m.sessionID = fn
+}
+
+// MetaCompression is the metadata key of the compression scheme found by the
+// compression detector.
+const MetaCompression = "Compression"
+
+// SetCompressionDetector sets a function detecting the compression scheme a
+// client advertises in its handshake, like a permessage-deflate WebSocket
+// extension or a codec flag of a binary hello, from the bytes sniffed to match
+// the connection. The scheme it returns is attached to the matched connection
+// under MetaCompression, so the handler can pick its codec; the listener does
+// not compress anything itself. A nil function disables it.
+func (m *Listener) SetCompressionDetector(fn func(sniffed []byte) (scheme string, ok bool)) {
+	m.Lock()
+	defer m.Unlock()
+	m.hooks.compression.reset()
+	m.compression = fn
+}
+
+// stampMeta attaches the value extracted from the bytes the reader sniffed to
+// the connection under the key, unless the extractor panics.
+func stampMeta(c *Conn, r io.Reader, key, name string, extract func([]byte) (string, bool), guard *hookGuard) {
+	if sr, ok := r.(sniffedReader); ok && extract != nil {
+		var value string
+		found := false
+		guard.call(name, func() { value, found = extract(sr.sniffed()) })
+		if found {
+			c.SetMeta(key, value)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package listener
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readerConn is a connection reading from a string, which writes nothing.
+type readerConn struct {
+	*strings.Reader
+}
+
+func (readerConn) Write(b []byte) (int, error)        { return len(b), nil }
+func (readerConn) Close() error                       { return nil }
+func (readerConn) LocalAddr() net.Addr                { return memoryAddr{} }
+func (readerConn) RemoteAddr() net.Addr               { return memoryAddr{} }
+func (readerConn) SetDeadline(t time.Time) error      { return nil }
+func (readerConn) SetReadDeadline(t time.Time) error  { return nil }
+func (readerConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// browserRequest is a WebSocket upgrade of the size browsers send.
+var browserRequest = httpRequest(
+	"Host: rtms.example.com",
+	"User-Agent: Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0",
+	"Accept: */*",
+	"Accept-Language: en-US,en;q=0.5",
+	"Accept-Encoding: gzip, deflate, br",
+	"Sec-WebSocket-Version: 13",
+	"Origin: https://rtms.example.com",
+	"Sec-WebSocket-Extensions: permessage-deflate",
+	"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==",
+	"Connection: keep-alive, Upgrade",
+	"Cookie: session=0123456789abcdef0123456789abcdef",
+	"Pragma: no-cache",
+	"Cache-Control: no-cache",
+	"Upgrade: websocket",
+)
+
+func TestSniffPrealloc(t *testing.T) {
+	m := New(NewMemoryListener())
+	m.SetSniffPrealloc(1024)
+	muc := m.accepted(readerConn{strings.NewReader(browserRequest)})
+	defer muc.Close()
+	if c := muc.buffer.buffer.Cap(); c < 1024 {
+		t.Errorf("sniff buffer capacity = %d, want at least 1024", c)
+	}
+
+	if !MatchWebSocket()(muc.startSniffing()) {
+		t.Fatal("upgrade not matched")
+	}
+	muc.doneSniffing()
+	if got := readN(t, muc, len(browserRequest)); got != browserRequest {
+		t.Error("the sniffed request is not replayed whole")
+	}
+}
+
+// benchmarkSniffPrealloc sniffs a WebSocket upgrade, read a byte at a time by
+// the header matcher, into the buffer of an accepted connection.
+func benchmarkSniffPrealloc(b *testing.B, prealloc int) {
+	m := New(NewMemoryListener())
+	m.SetSniffPrealloc(prealloc)
+	match := MatchWebSocket()
+	r := strings.NewReader(browserRequest)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Reset(browserRequest)
+		muc := m.accepted(readerConn{r})
+		if !match(muc.startSniffing()) {
+			b.Fatal("upgrade not matched")
+		}
+		_ = muc.Close()
+	}
+}
+
+func BenchmarkSniffPrealloc(b *testing.B) {
+	b.Run("off", func(b *testing.B) { benchmarkSniffPrealloc(b, 0) })
+	b.Run("on", func(b *testing.B) { benchmarkSniffPrealloc(b, len(browserRequest)) })
+}
+
+func TestNoReplayBuffer(t *testing.T) {
+	const request = "CONNECT upstream:443 HTTP/1.1\r\n\r\n"
+	data := request + strings.Repeat("x", 128*1024)
+	for _, enabled := range []bool{false, true} {
+		m := newTestMux(t)
+		m.SetSniffPrealloc(64 * 1024)
+		r := m.Route("proxy", MatchPrefix("CONNECT "))
+		r.SetNoReplayBuffer(enabled)
+		m.serve()
+
+		m.dial(data)
+		c := accept(t, r).(*Conn)
+
+		// Only the few sniffed bytes are kept instead of the whole buffer
+		if retained := c.buffer.buffer.Cap(); enabled && retained >= 1024 {
+			t.Errorf("buffer of %d bytes retained without replay buffer", retained)
+		} else if !enabled && retained < 64*1024 {
+			t.Errorf("buffer of %d bytes retained, want the preallocated one", retained)
+		}
+		if got := readN(t, c, len(data)); got != data {
+			t.Errorf("no replay buffer %v: handler did not read the data whole", enabled)
+		}
+	}
+}
+
+// benchmarkNoReplayBuffer proxies requests whose sniff buffer is preallocated,
+// and reports the bytes of the buffer the matched connections retain.
+func benchmarkNoReplayBuffer(b *testing.B, enabled bool) {
+	data := "CONNECT upstream:443 HTTP/1.1\r\n\r\n" + strings.Repeat("x", 16*1024)
+	m := newTestMux(b)
+	m.SetSniffPrealloc(16 * 1024)
+	r := m.Route("proxy", MatchPrefix("CONNECT "))
+	r.SetNoReplayBuffer(enabled)
+	m.serve()
+
+	buf := make([]byte, len(data))
+	retained := 0
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		client, err := m.dialer.Dial()
+		if err != nil {
+			b.Fatal(err)
+		}
+		go func() { _, _ = client.Write([]byte(data)) }()
+		c, err := r.Accept()
+		if err != nil {
+			b.Fatal(err)
+		}
+		retained += c.(*Conn).buffer.buffer.Cap()
+		if _, err := io.ReadFull(c, buf); err != nil {
+			b.Fatal(err)
+		}
+		_ = c.Close()
+		_ = client.Close()
+	}
+	b.ReportMetric(float64(retained)/float64(b.N), "retained-B/op")
+}
+
+func BenchmarkNoReplayBuffer(b *testing.B) {
+	b.Run("off", func(b *testing.B) { benchmarkNoReplayBuffer(b, false) })
+	b.Run("on", func(b *testing.B) { benchmarkNoReplayBuffer(b, true) })
+}
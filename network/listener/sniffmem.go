@@ -0,0 +1,113 @@
+package listener
+
+import (
+	"errors"
+	"time"
+)
+
+// sniffReserve is the sniff memory reserved for a connection before it is
+// sniffed, enough for the buffered readers of the matchers.
+const sniffReserve = 4096
+
+// ErrSniffMemory is the error the connections are rejected with when the sniff
+// memory budget is exhausted. They are closed without a response, as sending
+// one would mean sniffing them.
+var ErrSniffMemory error = errRejected("mux: sniff memory exhausted")
+
+// errSniffMemory is returned to the matchers whose reads would exceed the sniff
+// memory budget.
+var errSniffMemory = errors.New("mux: sniff memory budget exceeded")
+
+// SniffMemoryPolicy is what happens to the connections accepted while the sniff
+// memory budget is exhausted.
+type SniffMemoryPolicy int
+
+// The policies of the connections waiting for sniff memory.
+const (
+	SniffMemoryWait   SniffMemoryPolicy = iota // Wait for memory, within the read timeout.
+	SniffMemoryReject                          // Reject them with ErrSniffMemory at once.
+)
+
+// SetTotalSniffMemory bounds the bytes buffered by all the connections being
+// sniffed at once, which caps the memory a flood of handshakes can take even
+// though each connection only buffers a few kilobytes. Each connection reserves
+// 4KB, or the sniff prealloc if it is larger, before it is sniffed, and the
+// rest as the matchers read, up to the budget: the reads which would exceed it
+// fail, so the connection is not matched. The reservation is given back once
+// the connection leaves the matching phase. The connections which can not
+// reserve memory wait for it as the sniff memory policy says. Zero, the
+// default, removes the bound.
+func (m *Listener) SetTotalSniffMemory(bytes int64) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.sniffMemory = nil
+	if bytes > 0 {
+		m.sniffMemory = newSniffBudget(bytes)
+	}
+}
+
+// SetSniffMemoryPolicy sets what happens to the connections accepted while the
+// sniff memory budget is exhausted. By default, they wait for memory within the
+// read timeout, or until the listener is closed, and are rejected with
+// ErrSniffMemory if none is freed in time.
+func (m *Listener) SetSniffMemoryPolicy(policy SniffMemoryPolicy) {
+	m.Lock()
+	defer m.Unlock()
+	m.sniffPolicy = policy
+}
+
+// sniffBudget is the memory shared by the connections being sniffed, in bytes.
+type sniffBudget struct {
+	semaphore
+}
+
+// newSniffBudget creates a budget of the bytes.
+func newSniffBudget(bytes int64) *sniffBudget {
+	return &sniffBudget{semaphore: *newSemaphore(bytes)}
+}
+
+// sniffReservation is the memory reserved for the sniffing of a connection.
+type sniffReservation struct {
+	budget *sniffBudget
+	n      int64
+}
+
+// reserve reserves the initial sniff memory of a connection, as the policy
+// says. It returns nil if it could not.
+func (b *sniffBudget) reserve(n int64, policy SniffMemoryPolicy, deadline time.Time, donec <-chan struct{}) *sniffReservation {
+	if n > b.limit {
+		n = b.limit
+	}
+
+	reserved := false
+	if policy == SniffMemoryReject {
+		reserved = b.acquire(n)
+	} else {
+		reserved = b.wait(n, deadline, donec)
+	}
+	if !reserved {
+		return nil
+	}
+	return &sniffReservation{budget: b, n: n}
+}
+
+// grow extends the reservation to size bytes, if they are available. Once the
+// reservation is given back, the connection is not sniffed anymore.
+func (r *sniffReservation) grow(size int) bool {
+	extra := int64(size) - r.n
+	if r.budget == nil || extra <= 0 {
+		return true
+	}
+	if !r.budget.acquire(extra) {
+		return false
+	}
+	r.n += extra
+	return true
+}
+
+// release gives the reservation back.
+func (r *sniffReservation) release() {
+	r.budget.release(r.n)
+	r.budget = nil
+}
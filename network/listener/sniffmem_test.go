@@ -0,0 +1,100 @@
+package listener
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sniffMemoryUsed returns the sniff memory reserved by the connections.
+func sniffMemoryUsed(m *testMux) int64 {
+	m.RLock()
+	budget := m.sniffMemory
+	m.RUnlock()
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	return budget.used
+}
+
+func TestTotalSniffMemoryReject(t *testing.T) {
+	m := newTestMux(t)
+	errs := make(chan error, 16)
+	m.HandleError(func(err error) bool {
+		select {
+		case errs <- err:
+		default:
+		}
+		return true
+	})
+	m.SetTotalSniffMemory(2 * sniffReserve)
+	m.SetSniffMemoryPolicy(SniffMemoryReject)
+	r := m.Route("hello", MatchPrefix("HELLO"))
+	m.serve()
+
+	// Both connections keep their reservation until they are matched
+	slow := []io.Writer{m.dial("HEL"), m.dial("HEL")}
+	waitFor(t, "the memory to be reserved", func() bool { return sniffMemoryUsed(m) == 2*sniffReserve })
+
+	expectClosed(t, m.dial("HELLO"))
+	if err := <-errs; err != ErrSniffMemory {
+		t.Errorf("error = %v, want ErrSniffMemory", err)
+	}
+
+	go func() { _, _ = io.WriteString(slow[0], "LO") }()
+	readN(t, accept(t, r), 5)
+	waitFor(t, "the memory to be given back", func() bool { return sniffMemoryUsed(m) == sniffReserve })
+	m.dial("HELLO")
+	if got := readN(t, accept(t, r), 5); got != "HELLO" {
+		t.Errorf("handler read %q once memory was freed, want HELLO", got)
+	}
+}
+
+func TestTotalSniffMemoryWait(t *testing.T) {
+	const budget, clients = 4 * sniffReserve, 12
+	m := newTestMux(t)
+	m.SetTotalSniffMemory(budget)
+	r := m.Route("hello", MatchPrefix("HELLO"))
+	m.serve()
+
+	slow := make([]io.Writer, clients)
+	for i := range slow {
+		slow[i] = m.dial("HEL")
+	}
+	waitFor(t, "the budget to be exhausted", func() bool { return sniffMemoryUsed(m) == budget })
+
+	// The other connections wait before sniffing, so the memory stays bounded
+	time.Sleep(20 * time.Millisecond)
+	if used := sniffMemoryUsed(m); used != budget {
+		t.Errorf("reserved %d bytes under the flood, want the %d of the budget", used, budget)
+	}
+	if got := m.Stats().Active; got != clients {
+		t.Errorf("Active = %d, want the %d connections held, not rejected", got, clients)
+	}
+
+	for _, c := range slow {
+		go func(c io.Writer) { _, _ = io.WriteString(c, "LO") }(c)
+	}
+	for i := 0; i < clients; i++ {
+		if got := readN(t, accept(t, r), 5); got != "HELLO" {
+			t.Errorf("handler read %q, want HELLO", got)
+		}
+	}
+	waitFor(t, "the memory to be given back", func() bool { return sniffMemoryUsed(m) == 0 })
+}
+
+func TestTotalSniffMemoryReads(t *testing.T) {
+	m := newTestMux(t)
+	m.SetTotalSniffMemory(sniffReserve)
+	large := strings.Repeat("x", 2*sniffReserve)
+	r := m.Route("large", MatchPrefix(large))
+	fallback := m.Route("fallback", MatchAny())
+	m.serve()
+
+	// The read past the budget fails, so the prefix is not matched
+	m.dial(large)
+	if got := readN(t, accept(t, fallback), len(large)); got != large {
+		t.Error("fallback handler did not read the data")
+	}
+	expectNoAccept(t, r, 20*time.Millisecond)
+}
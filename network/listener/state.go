@@ -0,0 +1,75 @@
+package listener
+
+import "sync/atomic"
+
+// State is a stage of the lifecycle of the listener.
+type State int
+
+// The states of the listener.
+const (
+	StateIdle     State = iota // Created, not serving yet.
+	StateServing               // Accepting and matching connections.
+	StateDraining              // Serving, but rejecting the new connections, see StartDraining.
+	StateLameDuck              // Shutting down, draining the connections served.
+	StateClosed                // Done serving.
+)
+
+var stateNames = [...]string{"idle", "serving", "draining", "lameduck", "closed"}
+
+func (s State) String() string {
+	if s < 0 || int(s) >= len(stateNames) {
+		return "unknown"
+	}
+	return stateNames[s]
+}
+
+// State returns the current state of the listener.
+func (m *Listener) State() State {
+	switch atomic.LoadInt32(&m.lameDuck) {
+	case 1:
+		return StateLameDuck
+	case 2:
+		return StateClosed
+	}
+
+	select {
+	case <-m.closing:
+		return StateClosed
+	default:
+	}
+
+	switch {
+	case atomic.LoadInt32(&m.serving) == 0:
+		return StateIdle
+	case m.IsDraining():
+		return StateDraining
+	}
+	return StateServing
+}
+
+// SetStateChangeCallback sets a function called on every transition of the
+// listener between its states, for instance to publish them to a service
+// registry. It is called from the goroutine making the transition, without
+// holding any lock of the listener, so it may call its methods.
+func (m *Listener) SetStateChangeCallback(fn func(from, to State)) {
+	m.Lock()
+	defer m.Unlock()
+	m.stateChange = fn
+	m.hooks.stateChange.reset()
+}
+
+// notifyState calls the state change callback if the state of the listener
+// changed since it was last notified.
+func (m *Listener) notifyState() {
+	m.stateMu.Lock()
+	from, to := m.lastState, m.State()
+	m.lastState = to
+	m.stateMu.Unlock()
+
+	m.RLock()
+	fn := m.stateChange
+	m.RUnlock()
+	if fn != nil && from != to {
+		m.hooks.stateChange.call("state change callback", func() { fn(from, to) })
+	}
+}
@@ -0,0 +1,47 @@
+package listener
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestStateChangeCallback(t *testing.T) {
+	m := newTestMux(t)
+	var mu sync.Mutex
+	var transitions []string
+	m.SetStateChangeCallback(func(from, to State) {
+		// Calling back into the listener must not deadlock
+		_ = m.State()
+		_ = m.Health()
+		mu.Lock()
+		defer mu.Unlock()
+		transitions = append(transitions, fmt.Sprintf("%v->%v", from, to))
+	})
+	m.Route("r", MatchAny())
+	m.serve()
+	waitFor(t, "the listener to serve", func() bool { return m.State() == StateServing })
+
+	m.StartDraining()
+	m.StartDraining()
+	m.StopDraining()
+	if _, err := m.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+	m.wait()
+
+	want := []string{
+		"idle->serving",
+		"serving->draining",
+		"draining->serving",
+		"serving->lameduck",
+		"lameduck->closed",
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(transitions, want) {
+		t.Errorf("transitions = %v, want %v", transitions, want)
+	}
+}
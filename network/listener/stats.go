@@ -0,0 +1,179 @@
+package listener
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrWaitTimeout is returned by WaitForActive when the number of active
+// connections is not reached in time.
+var ErrWaitTimeout = errors.New("mux: timed out waiting for active connections")
+
+// Stats represents the connection counters of the listener.
+type Stats struct {
+	Accepted uint64 // The number of connections accepted since the listener was created.
+	Active   int64  // The number of accepted connections which are not closed yet.
+
+	// The number of times an accept queue of the network namespace overflowed
+	// since the listener started serving, sampled every few seconds on linux
+	// from the ListenOverflows counter of /proc/net/netstat. The kernel does
+	// not count the overflows per socket, so this covers all the listening
+	// sockets of the namespace, not only the one of the listener: growing
+	// numbers call for a larger backlog or a faster accept loop somewhere.
+	NamespaceListenOverflows uint64
+}
+
+// counters keeps the connection counters and notifies their changes.
+type counters struct {
+	sync.Mutex
+	stats   Stats
+	changed chan struct{} // Closed and replaced every time the counters change.
+}
+
+// newCounters creates the connection counters.
+func newCounters() *counters {
+	return &counters{changed: make(chan struct{})}
+}
+
+// update applies a change to the counters and notifies the waiters.
+func (c *counters) update(fn func(s *Stats)) {
+	c.Lock()
+	defer c.Unlock()
+	fn(&c.stats)
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// Stats returns a snapshot of the connection counters.
+func (m *Listener) Stats() Stats {
+	m.counters.Lock()
+	defer m.counters.Unlock()
+	return m.counters.stats
+}
+
+// WaitForActive blocks until at least n connections are active, or returns
+// ErrWaitTimeout once the timeout elapses. It makes concurrency tests against
+// the listener deterministic.
+func (m *Listener) WaitForActive(n int, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		m.counters.Lock()
+		active, changed := m.counters.stats.Active, m.counters.changed
+		m.counters.Unlock()
+		if active >= int64(n) {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return ErrWaitTimeout
+		}
+	}
+}
+
+// countConn counts an accepted connection as active until it gets closed.
+func (m *Listener) countConn(c *Conn) {
+	m.counters.update(func(s *Stats) {
+		s.Accepted++
+		s.Active++
+	})
+
+	c.notifyClose(func() {
+		m.counters.update(func(s *Stats) {
+			s.Active--
+		})
+	})
+}
+
+// RouteStats represents the connection counters of a route.
+type RouteStats struct {
+	Name   string // The name of the route.
+	Active int    // The number of connections dispatched to the route and not closed yet.
+	Errors int64  // The number of errors reported for the route.
+}
+
+// RouteStats returns a snapshot of the connection counters of every route, in
+// registration order.
+func (m *Listener) RouteStats() []RouteStats {
+	m.RLock()
+	routes := make([]*Route, len(m.routes))
+	copy(routes, m.routes)
+	m.RUnlock()
+
+	stats := make([]RouteStats, 0, len(routes))
+	for _, r := range routes {
+		stats = append(stats, RouteStats{
+			Name:   r.name,
+			Active: r.count(),
+			Errors: atomic.LoadInt64(&r.failures),
+		})
+	}
+	return stats
+}
+
+// ReportRouteError reports an error of a handler of the route with the name,
+// like Route.ReportError, for the handlers which only know the name of their
+// route, from the Route of their connection for instance. The errors of the
+// unknown routes are ignored.
+func (m *Listener) ReportRouteError(route string, err error) {
+	m.RLock()
+	routes := m.routes
+	m.RUnlock()
+
+	for _, r := range routes {
+		if r.name == route {
+			r.ReportError(err)
+			return
+		}
+	}
+}
+
+// ConnSnapshot represents the state of a connection served by a route.
+type ConnSnapshot struct {
+	ID         string        // The identifier of the connection.
+	RemoteAddr string        // The address of the client.
+	Route      string        // The name of the route serving the connection.
+	Age        time.Duration // The time since the connection was accepted.
+	Idle       time.Duration // The time since the last read or write of the handler.
+	BytesIn    int64         // The bytes the handler read from the connection.
+	BytesOut   int64         // The bytes the handler wrote to the connection.
+}
+
+// ActiveConnections returns a snapshot of the connections served by the
+// routes and not closed yet, oldest first, for a debugging page for instance.
+// The connections closed while it is taken may or may not be in it.
+func (m *Listener) ActiveConnections() []ConnSnapshot {
+	m.RLock()
+	routes := make([]*Route, len(m.routes))
+	copy(routes, m.routes)
+	m.RUnlock()
+
+	now := time.Now()
+	var snapshots []ConnSnapshot
+	for _, r := range routes {
+		r.Lock()
+		for c := range r.active {
+			snapshots = append(snapshots, ConnSnapshot{
+				ID:         c.id,
+				RemoteAddr: remoteAddr(c),
+				Route:      r.name,
+				Age:        now.Sub(c.accepted),
+				Idle:       now.Sub(c.lastActivity()),
+				BytesIn:    atomic.LoadInt64(&c.bytesIn),
+				BytesOut:   atomic.LoadInt64(&c.bytesOut),
+			})
+		}
+		r.Unlock()
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Age > snapshots[j].Age
+	})
+	return snapshots
+}
@@ -0,0 +1,166 @@
+package listener
+
+import (
+	"errors"
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWaitForActive(t *testing.T) {
+	m := newTestMux(t)
+	r := m.Match(MatchAny())
+	m.serve()
+
+	// The connections are counted once accepted, while they are matched
+	waited := make(chan error, 1)
+	go func() { waited <- m.WaitForActive(3, testTimeout) }()
+	for i := 0; i < 3; i++ {
+		m.dial("x")
+	}
+	if err := <-waited; err != nil {
+		t.Fatalf("WaitForActive(3) = %v", err)
+	}
+
+	if err := m.WaitForActive(4, 50*time.Millisecond); err != ErrWaitTimeout {
+		t.Errorf("WaitForActive(4) = %v, want ErrWaitTimeout", err)
+	}
+
+	_ = accept(t, r).Close()
+	waitFor(t, "the closed connection to be counted", func() bool { return m.Stats().Active == 2 })
+	if s := m.Stats(); s.Accepted != 3 {
+		t.Errorf("Accepted = %d, want 3", s.Accepted)
+	}
+}
+
+func TestRouteStatsActive(t *testing.T) {
+	m := newTestMux(t)
+	redis := m.Route("redis", MatchPrefix("*"))
+	other := m.Route("other", MatchAny())
+	m.serve()
+
+	active := func() map[string]int {
+		counts := make(map[string]int)
+		for _, s := range m.RouteStats() {
+			counts[s.Name] = s.Active
+		}
+		return counts
+	}
+	expect := func(redisActive, otherActive int) {
+		t.Helper()
+		waitFor(t, "the route counters", func() bool {
+			counts := active()
+			return counts["redis"] == redisActive && counts["other"] == otherActive
+		})
+	}
+
+	var conns []net.Conn
+	for i := 0; i < 3; i++ {
+		m.dial("*1\r\n")
+		conns = append(conns, accept(t, redis))
+	}
+	for i := 0; i < 2; i++ {
+		m.dial("x")
+		conns = append(conns, accept(t, other))
+	}
+	expect(3, 2)
+
+	// Closing a connection twice only counts it once
+	_ = conns[0].Close()
+	_ = conns[3].Close()
+	_ = conns[3].Close()
+	expect(2, 1)
+
+	// Connections closed concurrently with their dispatch are counted once
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		m.dial("*1\r\n")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = accept(t, redis).Close()
+		}()
+	}
+	wg.Wait()
+	expect(2, 1)
+}
+
+func TestActiveConnections(t *testing.T) {
+	mem := NewMemoryListener()
+	addrs := []string{"10.0.0.1:4000", "10.0.0.2:4000"}
+	m := &testMux{Listener: New(&addrListener{Listener: mem, addrs: addrs}), t: t, dialer: mem, mem: mem}
+	redis := m.Route("redis", MatchPrefix("*"))
+	web := m.Route("web", MatchHTTP())
+	m.serve()
+
+	client := m.dial("*1\r\n")
+	old := accept(t, redis)
+	readN(t, old, 4)
+	go func() { _, _ = client.Read(make([]byte, 3)) }()
+	if _, err := old.Write([]byte("+OK")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	m.dial("GET / HTTP/1.1\r\n\r\n")
+	recent := accept(t, web)
+
+	snapshots := m.ActiveConnections()
+	if len(snapshots) != 2 {
+		t.Fatalf("%d connections in the snapshot, want 2", len(snapshots))
+	}
+	oldConn, _ := AsConn(old)
+	recentConn, _ := AsConn(recent)
+	want := []ConnSnapshot{
+		{ID: oldConn.ID(), RemoteAddr: "10.0.0.1:4000", Route: "redis", BytesIn: 4, BytesOut: 3},
+		{ID: recentConn.ID(), RemoteAddr: "10.0.0.2:4000", Route: "web"},
+	}
+	for i, s := range snapshots {
+		got := s
+		got.Age, got.Idle = 0, 0
+		if got != want[i] {
+			t.Errorf("snapshot %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+	if s := snapshots[0]; s.Age < 20*time.Millisecond || s.Idle < 20*time.Millisecond || s.Idle > s.Age {
+		t.Errorf("old connection aged %v and idle for %v, want both over 20ms", s.Age, s.Idle)
+	}
+
+	// Snapshots are safe while connections get closed
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for len(m.ActiveConnections()) > 0 {
+			runtime.Gosched()
+		}
+	}()
+	_ = old.Close()
+	_ = recent.Close()
+	wg.Wait()
+}
+
+func TestRouteStatsErrors(t *testing.T) {
+	m := newTestMux(t)
+	api := m.Route("api", MatchPrefix("A"))
+	m.Route("ws", MatchPrefix("W"))
+	m.Route("proxy", MatchPrefix("P")).ProxyTo(unreachableAddr(t))
+	m.Route("quiet", MatchAny())
+	m.serve()
+
+	api.ReportError(errors.New("request failed"))
+	api.ReportError(errors.New("handler panicked"))
+	m.ReportRouteError("ws", errors.New("handshake failed"))
+	m.ReportRouteError("unknown", errors.New("ignored"))
+
+	// The proxied connection whose upstream can not be dialed is closed
+	expectClosed(t, m.dial("P"))
+
+	want := map[string]int64{"api": 2, "ws": 1, "proxy": 1, "quiet": 0}
+	for _, s := range m.RouteStats() {
+		if s.Errors != want[s.Name] {
+			t.Errorf("%s route Errors = %d, want %d", s.Name, s.Errors, want[s.Name])
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package listener
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Stream is a bidirectional stream of a multiplexed session, like a quic-go
+// Stream.
+type Stream interface {
+	io.ReadWriteCloser
+	SetDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// StreamSession is a session multiplexing streams, like a quic-go Connection.
+// As the stream type that one returns is its own, it takes a one-line wrapper:
+//
+//	type quicSession struct{ quic.Connection }
+//
+//	func (s quicSession) AcceptStream(ctx context.Context) (listener.Stream, error) {
+//		return s.Connection.AcceptStream(ctx)
+//	}
+type StreamSession interface {
+	AcceptStream(ctx context.Context) (Stream, error)
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+}
+
+// StreamListener is a listener accepting the streams of a session, such as a
+// QUIC connection, as connections, so they get matched and routed by a
+// listener built on top of it like the connections of a socket:
+//
+//	m := listener.New(listener.NewStreamListener(quicSession{conn}))
+//
+// Closing a connection closes its stream, and it reports the addresses of the
+// session. Closing the listener stops accepting streams but leaves the session
+// and the accepted streams open.
+type StreamListener struct {
+	session StreamSession
+	ctx     context.Context
+	cancel  context.CancelFunc
+	once    sync.Once
+}
+
+// NewStreamListener creates a listener accepting the streams of the session.
+func NewStreamListener(session StreamSession) *StreamListener {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &StreamListener{
+		session: session,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// Accept waits for and returns the next stream of the session. It returns the
+// error of the session once it ends, or ErrListenerClosed once the listener is
+// closed.
+func (l *StreamListener) Accept() (net.Conn, error) {
+	s, err := l.session.AcceptStream(l.ctx)
+	if err != nil {
+		if l.ctx.Err() != nil {
+			return nil, ErrListenerClosed
+		}
+		return nil, err
+	}
+	return &streamConn{Stream: s, session: l.session}, nil
+}
+
+// Close stops accepting the streams of the session.
+func (l *StreamListener) Close() error {
+	l.once.Do(l.cancel)
+	return nil
+}
+
+// Addr returns the local address of the session.
+func (l *StreamListener) Addr() net.Addr {
+	return l.session.LocalAddr()
+}
+
+// streamConn is a stream accepted as a connection.
+type streamConn struct {
+	Stream
+	session StreamSession
+}
+
+func (c *streamConn) LocalAddr() net.Addr  { return c.session.LocalAddr() }
+func (c *streamConn) RemoteAddr() net.Addr { return c.session.RemoteAddr() }
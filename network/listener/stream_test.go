@@ -0,0 +1,73 @@
+package listener
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+// fakeSession is a session whose streams are the server ends of pipes.
+type fakeSession struct {
+	streams chan net.Conn
+	addr    net.Addr
+}
+
+func (s *fakeSession) AcceptStream(ctx context.Context) (Stream, error) {
+	select {
+	case c, ok := <-s.streams:
+		if !ok {
+			return nil, errors.New("session closed")
+		}
+		return c, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *fakeSession) LocalAddr() net.Addr  { return memoryAddr{} }
+func (s *fakeSession) RemoteAddr() net.Addr { return s.addr }
+
+// open opens a stream which sends the data, and returns its client end.
+func (s *fakeSession) open(t *testing.T, data string) net.Conn {
+	server, client := net.Pipe()
+	t.Cleanup(func() { _ = client.Close() })
+	s.streams <- server
+	go func() { _, _ = client.Write([]byte(data)) }()
+	return client
+}
+
+func TestStreamListener(t *testing.T) {
+	addr, _ := net.ResolveUDPAddr("udp", "192.0.2.1:443")
+	session := &fakeSession{streams: make(chan net.Conn), addr: addr}
+	m := &testMux{Listener: New(NewStreamListener(session)), t: t}
+	redis := m.Match(MatchPrefix("*"))
+	web := m.Match(MatchHTTP())
+	m.serve()
+
+	request := httpRequest("Host: a")
+	webClient := session.open(t, request)
+	session.open(t, "*1\r\n")
+
+	// The streams of the session are matched independently
+	c := accept(t, web)
+	if got := readN(t, c, len(request)); got != request {
+		t.Errorf("web handler read %q, want the request", got)
+	}
+	if got := readN(t, accept(t, redis), 4); got != "*1\r\n" {
+		t.Errorf("redis handler read %q, want the command", got)
+	}
+	if c.RemoteAddr() != addr {
+		t.Errorf("RemoteAddr() = %v, want the address of the session", c.RemoteAddr())
+	}
+
+	// Closing the connection closes its stream
+	_ = c.Close()
+	expectClosed(t, webClient)
+
+	// Closing the listener stops accepting streams
+	_ = m.Close()
+	if err := m.wait(); err != ErrListenerClosed {
+		t.Errorf("Serve() = %v, want ErrListenerClosed", err)
+	}
+}
@@ -0,0 +1,296 @@
+package listener
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/numb3r3/live-go/log"
+)
+
+// TapFormat is the framing of the data mirrored by the tap.
+type TapFormat int
+
+// The framings of the tap output.
+const (
+	TapRaw             TapFormat = iota // The bytes alone, both directions interleaved.
+	TapLengthDelimited                  // Each chunk prefixed with its connection, direction and length.
+	TapPcap                             // A pcap capture of synthesized IP and TCP packets.
+)
+
+// The pcap framing of the tap.
+const (
+	pcapMagic      = 0xa1b2c3d4 // Microsecond timestamps.
+	pcapSnapLen    = 65535
+	pcapLinkRaw    = 101 // LINKTYPE_RAW, packets starting with their IP header.
+	pcapMaxSegment = pcapSnapLen - 60
+	tcpFlagsPshAck = 0x18
+)
+
+// The directions of the tapped chunks, in the length-delimited framing.
+const (
+	tapIn  = 0 // Read from the client.
+	tapOut = 1 // Written to the client.
+)
+
+// SetTap mirrors the data of the connections served by the routes, the bytes
+// their handlers read, sniffed bytes included, and write, to the writer, for
+// debugging or recording. The data is the one of the connection as matched,
+// before the transform of the route, so the TLS routes mirror the encrypted
+// stream. The writes are serialized, and the tap is disabled on the first one
+// failing. A nil writer disables it.
+func (m *Listener) SetTap(w io.Writer) {
+	m.Lock()
+	defer m.Unlock()
+	m.tapWriter = w
+	m.hooks.tap.reset()
+	m.tap = newTap(w, m.tapFormat, &m.hooks.tap)
+}
+
+// SetTapFormat sets the framing of the data mirrored by the tap:
+//
+//   - TapRaw writes the bytes as they are, which suits a single connection.
+//   - TapLengthDelimited prefixes each chunk with a 13-byte header: the 8-byte
+//     ID of the connection, the direction, 0 for the bytes read from the client
+//     and 1 for the ones written to it, and the 4-byte length of the chunk, all
+//     big-endian.
+//   - TapPcap writes a pcap capture, readable by Wireshark or tcpdump: the
+//     24-byte global header, for version 2.4, microsecond timestamps, a 65535
+//     snap length and the raw IP link type, is written first, and each chunk is
+//     recorded with its 16-byte record header, as IPv4 or IPv6 packets, as the
+//     addresses of the connection say, carrying a TCP segment with the PSH and
+//     ACK flags, whose sequence numbers follow the bytes of each direction. The
+//     handshakes are not synthesized and the chunks are split in segments of
+//     up to 65475 bytes.
+//
+// It restarts the tap, so a pcap capture starts over with its header.
+func (m *Listener) SetTapFormat(format TapFormat) {
+	m.Lock()
+	defer m.Unlock()
+	m.tapFormat = format
+	m.tap = newTap(m.tapWriter, format, &m.hooks.tap)
+}
+
+// tap writes the mirrored data.
+type tap struct {
+	sync.Mutex
+	w       io.Writer
+	format  TapFormat
+	guard   *hookGuard
+	started bool // Whether the pcap global header was written.
+	failed  bool
+}
+
+// newTap creates a tap writing to w, or returns nil if w is nil.
+func newTap(w io.Writer, format TapFormat, guard *hookGuard) *tap {
+	if w == nil {
+		return nil
+	}
+	return &tap{w: w, format: format, guard: guard}
+}
+
+// tapConn is a served connection whose data is mirrored.
+type tapConn struct {
+	net.Conn
+	tap    *tap
+	id     uint64
+	client *net.TCPAddr
+	server *net.TCPAddr
+	seqIn  uint32 // The sequence number of the next byte read, guarded by the tap.
+	seqOut uint32 // The sequence number of the next byte written, guarded by the tap.
+}
+
+// newTapConn mirrors the data of the connection to the tap.
+func newTapConn(c net.Conn, id string, t *tap) *tapConn {
+	n, _ := strconv.ParseUint(id, 10, 64)
+	return &tapConn{Conn: c, tap: t, id: n, client: tcpAddr(c.RemoteAddr()), server: tcpAddr(c.LocalAddr())}
+}
+
+// tcpAddr returns the address as a TCP address, zero if it is not one.
+func tcpAddr(addr net.Addr) *net.TCPAddr {
+	if a, ok := addr.(*net.TCPAddr); ok && a.IP != nil {
+		return a
+	}
+	return &net.TCPAddr{IP: net.IPv4zero}
+}
+
+// Read reads from the connection and mirrors what was read.
+func (c *tapConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.tap.record(c, tapIn, p[:n])
+	}
+	return n, err
+}
+
+// Write writes to the connection and mirrors what was written.
+func (c *tapConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.tap.record(c, tapOut, p[:n])
+	}
+	return n, err
+}
+
+// NetConn returns the mirrored connection.
+func (c *tapConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// record writes a chunk of a connection in the direction.
+func (t *tap) record(c *tapConn, direction byte, b []byte) {
+	t.Lock()
+	defer t.Unlock()
+
+	seq := &c.seqIn
+	if direction == tapOut {
+		seq = &c.seqOut
+	}
+	start := *seq
+	*seq += uint32(len(b))
+	if t.failed {
+		return
+	}
+
+	var err error
+	t.guard.call("tap writer", func() { err = t.write(c, direction, start, b) })
+	if err != nil {
+		t.failed = true
+		logging.Warningf("tap disabled: %v", err)
+	}
+}
+
+// write writes the chunk in the format of the tap.
+func (t *tap) write(c *tapConn, direction byte, seq uint32, b []byte) error {
+	var err error
+	switch t.format {
+	case TapLengthDelimited:
+		header := make([]byte, 13)
+		binary.BigEndian.PutUint64(header, c.id)
+		header[8] = direction
+		binary.BigEndian.PutUint32(header[9:], uint32(len(b)))
+		if _, err = t.w.Write(header); err == nil {
+			_, err = t.w.Write(b)
+		}
+	case TapPcap:
+		err = t.writePcap(c, direction, seq, b)
+	default:
+		_, err = t.w.Write(b)
+	}
+	return err
+}
+
+// writePcap writes the chunk as pcap records, writing the global header first.
+func (t *tap) writePcap(c *tapConn, direction byte, seq uint32, b []byte) error {
+	if !t.started {
+		header := make([]byte, 24)
+		binary.LittleEndian.PutUint32(header, pcapMagic)
+		binary.LittleEndian.PutUint16(header[4:], 2)
+		binary.LittleEndian.PutUint16(header[6:], 4)
+		binary.LittleEndian.PutUint32(header[16:], pcapSnapLen)
+		binary.LittleEndian.PutUint32(header[20:], pcapLinkRaw)
+		if _, err := t.w.Write(header); err != nil {
+			return err
+		}
+		t.started = true
+	}
+
+	src, dst, ack := c.client, c.server, c.seqOut
+	if direction == tapOut {
+		src, dst, ack = c.server, c.client, c.seqIn
+	}
+
+	now := time.Now()
+	for len(b) > 0 {
+		segment := b
+		if len(segment) > pcapMaxSegment {
+			segment = segment[:pcapMaxSegment]
+		}
+		b = b[len(segment):]
+
+		packet := tcpPacket(src, dst, seq, ack, segment)
+		seq += uint32(len(segment))
+
+		record := make([]byte, 16)
+		binary.LittleEndian.PutUint32(record, uint32(now.Unix()))
+		binary.LittleEndian.PutUint32(record[4:], uint32(now.Nanosecond()/1000))
+		binary.LittleEndian.PutUint32(record[8:], uint32(len(packet)))
+		binary.LittleEndian.PutUint32(record[12:], uint32(len(packet)))
+		if _, err := t.w.Write(append(record, packet...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tcpPacket synthesizes an IP packet carrying a TCP segment with the payload,
+// over IPv4 if both addresses are IPv4 ones, or else over IPv6.
+func tcpPacket(src, dst *net.TCPAddr, seq, ack uint32, payload []byte) []byte {
+	segment := make([]byte, 20+len(payload))
+	binary.BigEndian.PutUint16(segment, uint16(src.Port))
+	binary.BigEndian.PutUint16(segment[2:], uint16(dst.Port))
+	binary.BigEndian.PutUint32(segment[4:], seq)
+	binary.BigEndian.PutUint32(segment[8:], ack)
+	segment[12] = 5 << 4 // The data offset, in 32-bit words.
+	segment[13] = tcpFlagsPshAck
+	binary.BigEndian.PutUint16(segment[14:], 0xffff)
+	copy(segment[20:], payload)
+
+	src4, dst4 := src.IP.To4(), dst.IP.To4()
+	if src4 != nil && dst4 != nil {
+		pseudo := make([]byte, 12)
+		copy(pseudo, src4)
+		copy(pseudo[4:], dst4)
+		pseudo[9] = 6 // TCP
+		binary.BigEndian.PutUint16(pseudo[10:], uint16(len(segment)))
+		binary.BigEndian.PutUint16(segment[16:], checksum(pseudo, segment))
+
+		header := make([]byte, 20)
+		header[0] = 4<<4 | 5
+		binary.BigEndian.PutUint16(header[2:], uint16(len(header)+len(segment)))
+		header[8] = 64 // TTL
+		header[9] = 6  // TCP
+		copy(header[12:], src4)
+		copy(header[16:], dst4)
+		binary.BigEndian.PutUint16(header[10:], checksum(header))
+		return append(header, segment...)
+	}
+
+	src16, dst16 := src.IP.To16(), dst.IP.To16()
+	pseudo := make([]byte, 40)
+	copy(pseudo, src16)
+	copy(pseudo[16:], dst16)
+	binary.BigEndian.PutUint32(pseudo[32:], uint32(len(segment)))
+	pseudo[39] = 6 // TCP
+	binary.BigEndian.PutUint16(segment[16:], checksum(pseudo, segment))
+
+	header := make([]byte, 40)
+	header[0] = 6 << 4
+	binary.BigEndian.PutUint16(header[4:], uint16(len(segment)))
+	header[6] = 6  // TCP
+	header[7] = 64 // Hop limit
+	copy(header[8:], src16)
+	copy(header[24:], dst16)
+	return append(header, segment...)
+}
+
+// checksum computes the Internet checksum of the concatenated chunks, each of
+// an even length but the last.
+func checksum(chunks ...[]byte) uint16 {
+	var sum uint32
+	for _, chunk := range chunks {
+		for i := 0; i+1 < len(chunk); i += 2 {
+			sum += uint32(chunk[i])<<8 | uint32(chunk[i+1])
+		}
+		if len(chunk)%2 == 1 {
+			sum += uint32(chunk[len(chunk)-1]) << 8
+		}
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
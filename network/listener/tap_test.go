@@ -0,0 +1,201 @@
+package listener
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+)
+
+// tapPing serves a PING from a client which gets a PONG back, through a
+// listener whose connections have the remote address, and returns the tap
+// output once both are mirrored, with the ID of the connection.
+func tapPing(t *testing.T, format TapFormat, remote string, size int) ([]byte, string) {
+	t.Helper()
+	mem := NewMemoryListener()
+	m := &testMux{Listener: New(&addrListener{Listener: mem, addrs: []string{remote}}), t: t, dialer: mem, mem: mem}
+	t.Cleanup(func() { _ = m.Close() })
+	out := new(syncBuffer)
+	m.SetTapFormat(format)
+	m.SetTap(out)
+	r := m.Route("ping", MatchPrefix("PING"))
+	m.serve()
+
+	client := m.dial("PING\n")
+	c := accept(t, r)
+	readN(t, c, 5)
+	go func() { _, _ = c.Write([]byte("PONG\n")) }()
+	readN(t, client, 5)
+	waitFor(t, "the tap output", func() bool { return len(out.String()) >= size })
+	held, _ := AsConn(c)
+	return []byte(out.String()), held.ID()
+}
+
+func TestTapRaw(t *testing.T) {
+	out, _ := tapPing(t, TapRaw, "10.0.0.1:4000", 10)
+	if string(out) != "PING\nPONG\n" {
+		t.Errorf("tap output = %q, want both directions", out)
+	}
+}
+
+func TestTapLengthDelimited(t *testing.T) {
+	out, id := tapPing(t, TapLengthDelimited, "10.0.0.1:4000", 2*13+10)
+	streams := make(map[byte]string)
+	for len(out) > 0 {
+		if len(out) < 13 {
+			t.Fatalf("truncated header %q", out)
+		}
+		n := int(binary.BigEndian.Uint32(out[9:13]))
+		if got := fmt.Sprint(binary.BigEndian.Uint64(out)); got != id {
+			t.Errorf("chunk of connection %s, want %s", got, id)
+		}
+		streams[out[8]] += string(out[13 : 13+n])
+		out = out[13+n:]
+	}
+	if streams[tapIn] != "PING\n" || streams[tapOut] != "PONG\n" {
+		t.Errorf("streams = %q, want PING in and PONG out", streams)
+	}
+}
+
+// pcapPacket is a TCP segment read back from a pcap capture.
+type pcapPacket struct {
+	src, dst *net.TCPAddr
+	seq, ack uint32
+	payload  string
+}
+
+// readPcap parses a pcap capture of raw IP packets carrying TCP segments,
+// checking their headers and checksums.
+func readPcap(b []byte) ([]pcapPacket, error) {
+	if len(b) < 24 || binary.LittleEndian.Uint32(b) != pcapMagic ||
+		binary.LittleEndian.Uint16(b[4:]) != 2 || binary.LittleEndian.Uint16(b[6:]) != 4 ||
+		binary.LittleEndian.Uint32(b[16:]) != pcapSnapLen || binary.LittleEndian.Uint32(b[20:]) != pcapLinkRaw {
+		return nil, errors.New("bad global header")
+	}
+
+	var packets []pcapPacket
+	for b = b[24:]; len(b) > 0; {
+		if len(b) < 16 {
+			return nil, errors.New("truncated record header")
+		}
+		n, orig := binary.LittleEndian.Uint32(b[8:]), binary.LittleEndian.Uint32(b[12:])
+		if n != orig || int(n) > len(b)-16 {
+			return nil, fmt.Errorf("record of %d bytes, %d captured", orig, n)
+		}
+		packet := b[16 : 16+n]
+		b = b[16+n:]
+
+		var src, dst net.IP
+		var pseudo, segment []byte
+		switch packet[0] >> 4 {
+		case 4:
+			header := packet[:20]
+			if checksum(header) != 0 || int(binary.BigEndian.Uint16(header[2:])) != len(packet) || header[9] != 6 {
+				return nil, errors.New("bad IPv4 header")
+			}
+			src, dst, segment = net.IP(header[12:16]), net.IP(header[16:20]), packet[20:]
+			pseudo = make([]byte, 12)
+			copy(pseudo, src)
+			copy(pseudo[4:], dst)
+			pseudo[9] = 6
+			binary.BigEndian.PutUint16(pseudo[10:], uint16(len(segment)))
+		case 6:
+			header := packet[:40]
+			if int(binary.BigEndian.Uint16(header[4:])) != len(packet)-40 || header[6] != 6 {
+				return nil, errors.New("bad IPv6 header")
+			}
+			src, dst, segment = net.IP(header[8:24]), net.IP(header[24:40]), packet[40:]
+			pseudo = make([]byte, 40)
+			copy(pseudo, src)
+			copy(pseudo[16:], dst)
+			binary.BigEndian.PutUint32(pseudo[32:], uint32(len(segment)))
+			pseudo[39] = 6
+		default:
+			return nil, errors.New("not an IP packet")
+		}
+		if checksum(pseudo, segment) != 0 || segment[12]>>4 != 5 || segment[13] != tcpFlagsPshAck {
+			return nil, errors.New("bad TCP header")
+		}
+
+		packets = append(packets, pcapPacket{
+			src:     &net.TCPAddr{IP: src, Port: int(binary.BigEndian.Uint16(segment))},
+			dst:     &net.TCPAddr{IP: dst, Port: int(binary.BigEndian.Uint16(segment[2:]))},
+			seq:     binary.BigEndian.Uint32(segment[4:]),
+			ack:     binary.BigEndian.Uint32(segment[8:]),
+			payload: string(segment[20:]),
+		})
+	}
+	return packets, nil
+}
+
+func TestTapPcap(t *testing.T) {
+	tests := []struct {
+		name   string
+		remote string
+		header int
+	}{
+		{"ipv4", "10.0.0.1:4000", 20},
+		{"ipv6", "[2001:db8::1]:4000", 40},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, _ := tapPing(t, TapPcap, tt.remote, 24+2*(16+tt.header+20+5))
+			packets, err := readPcap(out)
+			if err != nil {
+				t.Fatalf("invalid capture: %v", err)
+			}
+
+			// The sequence numbers of each direction follow its bytes, and the
+			// acknowledgments the ones of the other
+			client := tt.remote
+			seq := make(map[bool]uint32)
+			var in, outData string
+			for _, p := range packets {
+				fromClient := p.src.String() == client
+				if !fromClient && p.dst.String() != client {
+					t.Fatalf("packet from %v to %v, want the client %s", p.src, p.dst, client)
+				}
+				if p.seq != seq[fromClient] || p.ack != seq[!fromClient] {
+					t.Errorf("packet seq %d ack %d, want %d and %d", p.seq, p.ack, seq[fromClient], seq[!fromClient])
+				}
+				seq[fromClient] += uint32(len(p.payload))
+				if fromClient {
+					in += p.payload
+				} else {
+					outData += p.payload
+				}
+			}
+			if in != "PING\n" || outData != "PONG\n" {
+				t.Errorf("payloads = %q and %q, want PING from the client and PONG to it", in, outData)
+			}
+		})
+	}
+}
+
+// failingWriter fails its writes, counting them.
+type failingWriter struct{ writes int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return 0, errors.New("disk full")
+}
+
+func TestTapDisabledOnFailure(t *testing.T) {
+	m := newTestMux(t)
+	w := new(failingWriter)
+	m.SetTap(w)
+	r := m.Route("ping", MatchPrefix("PING"))
+	m.serve()
+
+	client := m.dial("PING\n")
+	c := accept(t, r)
+	readN(t, c, 5)
+	go func() { _, _ = c.Write([]byte("PONG\n")) }()
+	if got := readN(t, client, 5); got != "PONG\n" {
+		t.Errorf("client read %q, want the connection served nonetheless", got)
+	}
+	if w.writes != 1 {
+		t.Errorf("tap written %d times, want it disabled after the first failure", w.writes)
+	}
+}
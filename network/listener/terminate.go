@@ -0,0 +1,59 @@
+package listener
+
+import (
+	"crypto/tls"
+	"net"
+)
+
+// TerminateTLS makes the route terminate TLS on its matched connections, which
+// are accepted as *tls.Conn, so the handler gets the negotiated state, such as
+// the version, the cipher suite or the client certificates, with their
+// ConnectionState method. The handshake runs on the first read or write of the
+// handler, or when it calls Handshake, within the concurrent handshake limit of
+// the listener set with SetMaxConcurrentHandshakes.
+//
+// Certificates can be rotated without a restart either with the GetCertificate
+// callback of the config, or, if it has none, with ReloadCertificates.
+//
+// It replaces the transform of the route, if any.
+func (r *Route) TerminateTLS(config *tls.Config) {
+	config = config.Clone()
+	if config.GetCertificate == nil {
+		// The callback is skipped for the clients without SNI while the
+		// config has certificates, so it takes them over
+		r.ReloadCertificates(config.Certificates...)
+		config.Certificates = nil
+		config.GetCertificate = r.reloadedCertificate
+	}
+
+	r.SetTransform(func(c net.Conn) net.Conn {
+		return r.tlsServer(c, config)
+	})
+}
+
+// ReloadCertificates atomically replaces the certificates the route presents
+// in the new handshakes when it terminates TLS, for instance after they were
+// renewed, while the established connections keep the ones they negotiated.
+// The first certificate supporting the client hello is used, or else the first
+// one. The certificates of the config given to TerminateTLS are used until the
+// first reload. It has no effect if the config has a GetCertificate callback,
+// which is then in charge of the rotation.
+func (r *Route) ReloadCertificates(certs ...tls.Certificate) {
+	r.certs.Store(append([]tls.Certificate(nil), certs...))
+}
+
+// reloadedCertificate returns the certificate of the handshake among the
+// current ones, or nil if there's none.
+func (r *Route) reloadedCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certs, _ := r.certs.Load().([]tls.Certificate)
+	if len(certs) == 0 {
+		return nil, nil
+	}
+
+	for i := range certs {
+		if hello.SupportsCertificate(&certs[i]) == nil {
+			return &certs[i], nil
+		}
+	}
+	return &certs[0], nil
+}
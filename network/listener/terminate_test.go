@@ -0,0 +1,186 @@
+package listener
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// testCA is a certificate authority issuing the certificates of the tests.
+type testCA struct {
+	t    testing.TB
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t testing.TB) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{t: t, cert: cert, key: key}
+}
+
+// issue issues a certificate for the name, for a server if dnsName is set, or
+// else for a client.
+func (ca *testCA) issue(name string, dnsName bool) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		ca.t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if dnsName {
+		template.DNSNames = []string{name}
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		ca.t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// pool returns a pool holding the certificate of the authority.
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// dialTLS opens a TLS connection to the listener, which is closed at the end
+// of the test, and runs the handshake in the background, sending its error on
+// the returned channel. The client then discards what it receives, so the
+// handler never blocks sending its close alert.
+func (m *testMux) dialTLS(config *tls.Config) <-chan error {
+	m.t.Helper()
+	c, err := m.dialer.Dial()
+	if err != nil {
+		m.t.Fatalf("Dial() = %v", err)
+	}
+	m.t.Cleanup(func() { _ = c.Close() })
+	tc := tls.Client(c, config)
+	handshake := make(chan error, 1)
+	go func() {
+		handshake <- tc.Handshake()
+		_, _ = io.Copy(ioutil.Discard, tc)
+	}()
+	return handshake
+}
+
+func TestTerminateTLSClientCert(t *testing.T) {
+	ca := newTestCA(t)
+	m := newTestMux(t)
+	r := m.Route("mtls", MatchTLS())
+	r.TerminateTLS(&tls.Config{
+		Certificates: []tls.Certificate{ca.issue("rtms.example.com", true)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.pool(),
+	})
+	m.serve()
+
+	handshake := m.dialTLS(&tls.Config{
+		ServerName:   "rtms.example.com",
+		RootCAs:      ca.pool(),
+		Certificates: []tls.Certificate{ca.issue("device-42", false)},
+	})
+
+	// The handler gets the negotiated state of the terminated connection
+	tc, ok := accept(t, r).(*tls.Conn)
+	if !ok {
+		t.Fatal("terminated connection is not a *tls.Conn")
+	}
+	if err := tc.Handshake(); err != nil {
+		t.Fatalf("Handshake() = %v", err)
+	}
+	if err := <-handshake; err != nil {
+		t.Fatalf("client handshake: %v", err)
+	}
+
+	state := tc.ConnectionState()
+	if len(state.PeerCertificates) == 0 || state.PeerCertificates[0].Subject.CommonName != "device-42" {
+		t.Errorf("peer certificates = %v, want device-42", state.PeerCertificates)
+	}
+	if state.Version != tls.VersionTLS13 {
+		t.Errorf("Version = %x, want TLS 1.3", state.Version)
+	}
+}
+
+func TestReloadCertificates(t *testing.T) {
+	ca := newTestCA(t)
+	old, renewed := ca.issue("rtms.example.com", true), ca.issue("rtms.example.com", true)
+	callback := ca.issue("api.example.com", true)
+	m := newTestMux(t)
+	r := m.Route("rtms", MatchSNI("rtms.example.com"))
+	r.TerminateTLS(&tls.Config{Certificates: []tls.Certificate{old}})
+	api := m.Route("api", MatchSNI("api.example.com"))
+	api.TerminateTLS(&tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return &callback, nil },
+	})
+	m.serve()
+
+	// handshake returns the certificate the server of the route presented
+	handshake := func(route *Route, name string) []byte {
+		t.Helper()
+		presented := make(chan []byte, 1)
+		handshake := m.dialTLS(&tls.Config{
+			ServerName: name,
+			RootCAs:    ca.pool(),
+			VerifyConnection: func(cs tls.ConnectionState) error {
+				presented <- cs.PeerCertificates[0].Raw
+				return nil
+			},
+		})
+		if err := accept(t, route).(*tls.Conn).Handshake(); err != nil {
+			t.Fatalf("Handshake() = %v", err)
+		}
+		if err := <-handshake; err != nil {
+			t.Fatalf("client handshake: %v", err)
+		}
+		return <-presented
+	}
+
+	if cert := handshake(r, "rtms.example.com"); !bytes.Equal(cert, old.Certificate[0]) {
+		t.Error("first handshake did not use the configured certificate")
+	}
+	r.ReloadCertificates(renewed)
+	if cert := handshake(r, "rtms.example.com"); !bytes.Equal(cert, renewed.Certificate[0]) {
+		t.Error("handshake after the reload did not use the renewed certificate")
+	}
+
+	// The callback of the config stays in charge
+	api.ReloadCertificates(renewed)
+	if cert := handshake(api, "api.example.com"); !bytes.Equal(cert, callback.Certificate[0]) {
+		t.Error("handshake did not use the certificate of the GetCertificate callback")
+	}
+}
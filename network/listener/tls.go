@@ -0,0 +1,360 @@
+package listener
+
+import (
+	"encoding/binary"
+	"io"
+	"strings"
+)
+
+// The TLS constants needed to parse a ClientHello.
+const (
+	recordTypeHandshake   = 0x16
+	handshakeClientHello  = 0x01
+	maxTLSRecordLength    = 16384 + 2048
+	extServerName         = 0
+	extALPN               = 16
+	extSessionTicket      = 35
+	extPreSharedKey       = 41
+	extSupportedVersions  = 43
+	serverNameTypeHost    = 0
+	recordHeaderLength    = 5
+	handshakeHeaderLength = 4
+)
+
+// ErrTLSVersionTooOld is the error a connection is rejected with when its
+// ClientHello advertises no version as recent as the minimum TLS version.
+var ErrTLSVersionTooOld error = errTLSVersionTooOld("mux: tls version too old")
+
+type errTLSVersionTooOld string
+
+func (e errTLSVersionTooOld) Error() string   { return string(e) }
+func (e errTLSVersionTooOld) Temporary() bool { return true }
+func (e errTLSVersionTooOld) Timeout() bool   { return false }
+
+// clientHello holds the fields of a TLS ClientHello used for routing.
+type clientHello struct {
+	version    uint16   // The legacy client_version field.
+	versions   []uint16 // The versions of the supported_versions extension.
+	serverName string   // The host name of the server_name extension.
+	protocols  []string // The protocols of the ALPN extension.
+	resumption bool     // Whether a session ticket or a pre-shared key is offered.
+}
+
+// MatchTLS matches connections starting with a TLS handshake record holding a
+// ClientHello. Only the record and handshake headers are peeked, unless a
+// minimum TLS version is set on the listener.
+func MatchTLS() Matcher {
+	return func(r io.Reader) bool {
+		if configOf(r).minTLSVersion > 0 {
+			_, ok := readClientHello(r)
+			return ok
+		}
+
+		b := make([]byte, recordHeaderLength+1)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return false
+		}
+		return b[0] == recordTypeHandshake && b[1] == 0x03 && b[5] == handshakeClientHello
+	}
+}
+
+// MatchSNI matches TLS connections whose ClientHello requests one of the
+// server names. A name starting with "*." matches any subdomain.
+//
+// A resumption attempt may carry no server name at all, in which case it is
+// never matched here; see MatchTLSResumption for routing such connections.
+func MatchSNI(names ...string) Matcher {
+	return func(r io.Reader) bool {
+		hello, ok := readClientHello(r)
+		if !ok || hello.serverName == "" {
+			return false
+		}
+
+		for _, name := range names {
+			if matchServerName(name, hello.serverName) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MatchALPN matches TLS connections whose ClientHello advertises one of the
+// application protocols. A resumption attempt without the ALPN extension is
+// never matched here; see MatchTLSResumption.
+func MatchALPN(protocols ...string) Matcher {
+	return func(r io.Reader) bool {
+		hello, ok := readClientHello(r)
+		if !ok {
+			return false
+		}
+
+		for _, p := range hello.protocols {
+			for _, want := range protocols {
+				if p == want {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+// ACMETLSProtocol is the application protocol of the ACME TLS-ALPN-01
+// challenge, RFC 8737.
+const ACMETLSProtocol = "acme-tls/1"
+
+// MatchACMEChallenge matches the TLS-ALPN-01 challenge connections of an ACME
+// certificate authority, which advertise the acme-tls/1 application protocol,
+// so the challenge responder, like the TLS config of autocert, gets them on the
+// port the services share. It must be registered before the other TLS matchers:
+//
+//	acme := l.Route("acme", listener.MatchACMEChallenge())
+//	web := l.Route("web", listener.MatchTLS())
+func MatchACMEChallenge() Matcher {
+	return MatchALPN(ACMETLSProtocol)
+}
+
+// MatchTLSResumption matches the session resumption attempts, offering a
+// session ticket or a pre-shared key, which lack the server name or the ALPN
+// extension. Matchers are tried in registration order, so registering it on
+// the default route after the SNI and ALPN routes sends such connections there
+// instead of leaving them unmatched.
+func MatchTLSResumption() Matcher {
+	return func(r io.Reader) bool {
+		hello, ok := readClientHello(r)
+		return ok && hello.resumption && (hello.serverName == "" || len(hello.protocols) == 0)
+	}
+}
+
+// matchServerName checks whether the requested server name matches the pattern.
+func matchServerName(pattern, name string) bool {
+	if strings.HasPrefix(pattern, "*.") {
+		suffix := pattern[1:]
+		return len(name) > len(suffix) && strings.EqualFold(name[len(name)-len(suffix):], suffix)
+	}
+	return strings.EqualFold(pattern, name)
+}
+
+// SetMinTLSVersion sets the minimum TLS version, such as tls.VersionTLS12, the
+// ClientHello of a connection must advertise. Connections offering only older
+// versions are rejected by the TLS matchers with ErrTLSVersionTooOld. This is
+// advisory, as the version is only negotiated later on by the TLS server.
+func (m *Listener) SetMinTLSVersion(v uint16) {
+	m.Lock()
+	defer m.Unlock()
+	m.minTLSVersion = v
+}
+
+// maxVersion returns the most recent version the ClientHello advertises.
+func (h *clientHello) maxVersion() uint16 {
+	if len(h.versions) == 0 {
+		return h.version
+	}
+
+	var max uint16
+	for _, v := range h.versions {
+		// Skip the GREASE values, such as 0x0a0a
+		if v&0x0f0f == 0x0a0a && v>>8 == v&0xff {
+			continue
+		}
+		if v > max {
+			max = v
+		}
+	}
+	return max
+}
+
+// readClientHello reads the first TLS record and parses the ClientHello it
+// holds. A ClientHello advertising a version older than the minimum TLS version
+// of the listener rejects the connection.
+func readClientHello(r io.Reader) (*clientHello, bool) {
+	hello, ok := readClientHelloRecord(r)
+	if ok {
+		if min := configOf(r).minTLSVersion; min > 0 && hello.maxVersion() < min {
+			reject(r, ErrTLSVersionTooOld)
+			return nil, false
+		}
+	}
+	return hello, ok
+}
+
+// readClientHelloRecord reads the first TLS record and parses its ClientHello.
+func readClientHelloRecord(r io.Reader) (*clientHello, bool) {
+	header := make([]byte, recordHeaderLength)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, false
+	}
+
+	if header[0] != recordTypeHandshake || header[1] != 0x03 {
+		return nil, false
+	}
+
+	length := int(binary.BigEndian.Uint16(header[3:]))
+	if length < handshakeHeaderLength || length > maxTLSRecordLength {
+		return nil, false
+	}
+
+	record := make([]byte, length)
+	if _, err := io.ReadFull(r, record); err != nil {
+		return nil, false
+	}
+	return parseClientHello(record)
+}
+
+// parseClientHello parses a ClientHello handshake message. The message must fit
+// in the record, which is the case for all the usual clients.
+func parseClientHello(b []byte) (*clientHello, bool) {
+	if len(b) < handshakeHeaderLength || b[0] != handshakeClientHello {
+		return nil, false
+	}
+
+	// Handshake header, client version, random and session id
+	s := tlsReader(b[handshakeHeaderLength:])
+	version, ok := s.uint16()
+	if !ok || !s.skip(32) || !s.skipVector8() {
+		return nil, false
+	}
+
+	// Cipher suites and compression methods
+	if !s.skipVector16() || !s.skipVector8() {
+		return nil, false
+	}
+
+	hello := &clientHello{version: version}
+	extensions, ok := s.vector16()
+	if !ok {
+		// Extensions are optional
+		return hello, true
+	}
+
+	for len(extensions) > 0 {
+		typ, ok := extensions.uint16()
+		if !ok {
+			return nil, false
+		}
+
+		data, ok := extensions.vector16()
+		if !ok {
+			return nil, false
+		}
+
+		switch typ {
+		case extServerName:
+			hello.serverName = data.serverName()
+		case extALPN:
+			hello.protocols = data.protocols()
+		case extSessionTicket:
+			hello.resumption = hello.resumption || len(data) > 0
+		case extPreSharedKey:
+			hello.resumption = true
+		case extSupportedVersions:
+			hello.versions = data.versions()
+		}
+	}
+	return hello, true
+}
+
+// tlsReader reads the fields of a TLS message.
+type tlsReader []byte
+
+func (s *tlsReader) skip(n int) bool {
+	if len(*s) < n {
+		return false
+	}
+	*s = (*s)[n:]
+	return true
+}
+
+func (s *tlsReader) uint8() (uint8, bool) {
+	if len(*s) < 1 {
+		return 0, false
+	}
+	v := (*s)[0]
+	*s = (*s)[1:]
+	return v, true
+}
+
+func (s *tlsReader) uint16() (uint16, bool) {
+	if len(*s) < 2 {
+		return 0, false
+	}
+	v := binary.BigEndian.Uint16(*s)
+	*s = (*s)[2:]
+	return v, true
+}
+
+func (s *tlsReader) bytes(n int) (tlsReader, bool) {
+	if len(*s) < n {
+		return nil, false
+	}
+	v := (*s)[:n]
+	*s = (*s)[n:]
+	return v, true
+}
+
+func (s *tlsReader) vector8() (tlsReader, bool) {
+	n, ok := s.uint8()
+	if !ok {
+		return nil, false
+	}
+	return s.bytes(int(n))
+}
+
+func (s *tlsReader) vector16() (tlsReader, bool) {
+	n, ok := s.uint16()
+	if !ok {
+		return nil, false
+	}
+	return s.bytes(int(n))
+}
+
+func (s *tlsReader) skipVector8() bool {
+	_, ok := s.vector8()
+	return ok
+}
+
+func (s *tlsReader) skipVector16() bool {
+	_, ok := s.vector16()
+	return ok
+}
+
+// serverName returns the host name of a server_name extension.
+func (s tlsReader) serverName() string {
+	list, ok := s.vector16()
+	for ok && len(list) > 0 {
+		var typ uint8
+		var name tlsReader
+		if typ, ok = list.uint8(); !ok {
+			break
+		}
+		if name, ok = list.vector16(); ok && typ == serverNameTypeHost {
+			return string(name)
+		}
+	}
+	return ""
+}
+
+// protocols returns the protocol names of an ALPN extension.
+func (s tlsReader) protocols() (protocols []string) {
+	list, ok := s.vector16()
+	for ok && len(list) > 0 {
+		var name tlsReader
+		if name, ok = list.vector8(); ok {
+			protocols = append(protocols, string(name))
+		}
+	}
+	return
+}
+
+// versions returns the versions of a supported_versions extension.
+func (s tlsReader) versions() (versions []uint16) {
+	list, ok := s.vector8()
+	for ok && len(list) > 1 {
+		var v uint16
+		if v, ok = list.uint16(); ok {
+			versions = append(versions, v)
+		}
+	}
+	return
+}
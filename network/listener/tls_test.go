@@ -0,0 +1,200 @@
+package listener
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+)
+
+// extension encodes a ClientHello extension.
+func extension(typ uint16, data []byte) []byte {
+	b := make([]byte, 4, 4+len(data))
+	binary.BigEndian.PutUint16(b, typ)
+	binary.BigEndian.PutUint16(b[2:], uint16(len(data)))
+	return append(b, data...)
+}
+
+// vector16 prefixes the data with its 16-bit length.
+func vector16(data []byte) []byte {
+	return append([]byte{byte(len(data) >> 8), byte(len(data))}, data...)
+}
+
+// sniExtension encodes a server_name extension requesting the host name.
+func sniExtension(name string) []byte {
+	entry := append([]byte{serverNameTypeHost}, vector16([]byte(name))...)
+	return extension(extServerName, vector16(entry))
+}
+
+// alpnExtension encodes an ALPN extension advertising the protocols.
+func alpnExtension(protocols ...string) []byte {
+	var list []byte
+	for _, p := range protocols {
+		list = append(append(list, byte(len(p))), p...)
+	}
+	return extension(extALPN, vector16(list))
+}
+
+// versionsExtension encodes a supported_versions extension.
+func versionsExtension(versions ...uint16) []byte {
+	list := []byte{byte(2 * len(versions))}
+	for _, v := range versions {
+		list = append(list, byte(v>>8), byte(v))
+	}
+	return extension(extSupportedVersions, list)
+}
+
+// clientHelloRecord encodes a TLS record holding a ClientHello of TLS 1.2, or
+// later with a supported_versions extension, with the extensions.
+func clientHelloRecord(extensions ...[]byte) string {
+	return clientHelloVersion(tls.VersionTLS12, extensions...)
+}
+
+// clientHelloVersion encodes a TLS record holding a ClientHello with the legacy
+// version and the extensions.
+func clientHelloVersion(version uint16, extensions ...[]byte) string {
+	var exts []byte
+	for _, e := range extensions {
+		exts = append(exts, e...)
+	}
+
+	// Client version, random, session id, cipher suites and compression methods
+	body := append([]byte{byte(version >> 8), byte(version)}, make([]byte, 32)...)
+	body = append(body, 0, 0, 2, 0x13, 0x01, 1, 0)
+	body = append(body, vector16(exts)...)
+
+	msg := append([]byte{handshakeClientHello, 0}, vector16(body)...)
+	record := append([]byte{recordTypeHandshake, 0x03, 0x01}, vector16(msg)...)
+	return string(record)
+}
+
+func TestMatchSNI(t *testing.T) {
+	testMatcher(t, MatchSNI("example.com", "*.example.net"), []matcherCase{
+		{"exact", clientHelloRecord(sniExtension("example.com")), true},
+		{"case insensitive", clientHelloRecord(sniExtension("EXAMPLE.com")), true},
+		{"wildcard", clientHelloRecord(sniExtension("api.example.net")), true},
+		{"wildcard apex", clientHelloRecord(sniExtension("example.net")), false},
+		{"other", clientHelloRecord(sniExtension("example.org")), false},
+		{"no server name", clientHelloRecord(alpnExtension("h2")), false},
+		{"not tls", "GET / HTTP/1.1\r\n", false},
+	})
+}
+
+func TestMatchALPN(t *testing.T) {
+	testMatcher(t, MatchALPN("h2", "mqtt"), []matcherCase{
+		{"first", clientHelloRecord(alpnExtension("h2", "http/1.1")), true},
+		{"second", clientHelloRecord(sniExtension("a"), alpnExtension("mqtt")), true},
+		{"other", clientHelloRecord(alpnExtension("http/1.1")), false},
+		{"no alpn", clientHelloRecord(sniExtension("a")), false},
+	})
+}
+
+func TestMatchACMEChallenge(t *testing.T) {
+	testMatcher(t, MatchACMEChallenge(), []matcherCase{
+		{"challenge", clientHelloRecord(sniExtension("example.com"), alpnExtension(ACMETLSProtocol)), true},
+		{"h2", clientHelloRecord(sniExtension("example.com"), alpnExtension("h2", "http/1.1")), false},
+		{"no alpn", clientHelloRecord(sniExtension("example.com")), false},
+	})
+}
+
+func TestACMEChallengeRoute(t *testing.T) {
+	m := newTestMux(t)
+	acme := m.Route("acme", MatchACMEChallenge())
+	web := m.Route("web", MatchTLS())
+	m.serve()
+
+	tests := []struct {
+		hello string
+		route *Route
+	}{
+		{clientHelloRecord(sniExtension("example.com"), alpnExtension(ACMETLSProtocol)), acme},
+		{clientHelloRecord(sniExtension("example.com"), alpnExtension("h2")), web},
+	}
+	for _, tt := range tests {
+		m.dial(tt.hello)
+		if got := readN(t, accept(t, tt.route), len(tt.hello)); got != tt.hello {
+			t.Errorf("%s handler did not read the ClientHello", tt.route.Name())
+		}
+	}
+}
+
+func TestMatchTLSResumption(t *testing.T) {
+	ticket := extension(extSessionTicket, []byte{1, 2, 3})
+	testMatcher(t, MatchTLSResumption(), []matcherCase{
+		{"ticket without server name", clientHelloRecord(ticket, alpnExtension("h2")), true},
+		{"ticket without alpn", clientHelloRecord(ticket, sniExtension("a")), true},
+		{"pre-shared key", clientHelloRecord(extension(extPreSharedKey, []byte{0})), true},
+		{"complete ticket", clientHelloRecord(ticket, sniExtension("a"), alpnExtension("h2")), false},
+		{"empty ticket", clientHelloRecord(extension(extSessionTicket, nil)), false},
+		{"full handshake", clientHelloRecord(sniExtension("a")), false},
+	})
+}
+
+func TestResumptionRoutesToDefault(t *testing.T) {
+	m := newTestMux(t)
+	sni := m.Match(MatchSNI("example.com"))
+	alpn := m.Match(MatchALPN("h2"))
+	fallback := m.Match(MatchTLSResumption())
+	m.serve()
+
+	hello := clientHelloRecord(extension(extSessionTicket, []byte{1, 2, 3}), alpnExtension("mqtt"))
+	m.dial(hello)
+	c := accept(t, fallback)
+	if got := readN(t, c, len(hello)); got != hello {
+		t.Error("handler did not read the ClientHello")
+	}
+	expectNoAccept(t, sni, 20*time.Millisecond)
+	expectNoAccept(t, alpn, 20*time.Millisecond)
+}
+
+func TestMinTLSVersion(t *testing.T) {
+	tests := []struct {
+		name  string
+		hello string
+		ok    bool
+	}{
+		{"tls 1.0 only", clientHelloVersion(tls.VersionTLS10, sniExtension("a")), false},
+		{"tls 1.1 in supported versions", clientHelloRecord(versionsExtension(tls.VersionTLS11, tls.VersionTLS10)), false},
+		{"tls 1.2", clientHelloRecord(sniExtension("a")), true},
+		{"tls 1.3 with grease", clientHelloRecord(versionsExtension(0x0a0a, tls.VersionTLS13)), true},
+	}
+	config := &sniffConfig{minTLSVersion: tls.VersionTLS12}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, matcher := range []Matcher{MatchTLS(), MatchSNI("a"), MatchTLSResumption()} {
+				state := new(sniffState)
+				matcher(&sniffReader{Reader: strings.NewReader(tt.hello), config: config, state: state})
+				if rejected := state.rejected == ErrTLSVersionTooOld; rejected == tt.ok {
+					t.Errorf("matcher %d rejected = %v, want %v", i, rejected, !tt.ok)
+				}
+			}
+		})
+	}
+}
+
+func TestMinTLSVersionRejects(t *testing.T) {
+	m := newTestMux(t)
+	errs := make(chan error, 1)
+	m.HandleError(func(err error) bool {
+		errs <- err
+		return true
+	})
+	m.SetMinTLSVersion(tls.VersionTLS12)
+	r := m.Match(MatchTLS())
+	fallback := m.Match(MatchAny())
+	m.serve()
+
+	c := m.dial(clientHelloVersion(tls.VersionTLS10, sniExtension("a")))
+	expectClosed(t, c)
+	if err := <-errs; err != ErrTLSVersionTooOld {
+		t.Errorf("error = %v, want ErrTLSVersionTooOld", err)
+	}
+
+	hello := clientHelloRecord(sniExtension("a"))
+	m.dial(hello)
+	if got := readN(t, accept(t, r), len(hello)); got != hello {
+		t.Error("handler did not read the ClientHello")
+	}
+	expectNoAccept(t, fallback, 20*time.Millisecond)
+}
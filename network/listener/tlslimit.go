@@ -0,0 +1,177 @@
+package listener
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrHandshakeLimit is the error the TLS handshakes over the concurrent
+// handshake limit fail with, unless they can wait for a slot.
+var ErrHandshakeLimit = errors.New("mux: too many concurrent TLS handshakes")
+
+// HandshakePolicy is what happens to the TLS handshakes started while the
+// concurrent handshake limit is reached.
+type HandshakePolicy int
+
+// The policies of the handshakes over the limit.
+const (
+	HandshakeWait   HandshakePolicy = iota // Wait for a slot, within the read deadline of the connection.
+	HandshakeReject                        // Fail them with ErrHandshakeLimit at once.
+)
+
+// SetMaxConcurrentHandshakes bounds the number of TLS handshakes the routes
+// terminating TLS with TerminateTLS, and the MatchClientCert matchers, run at
+// once, as they take most of the CPU under a handshake flood. A handshake takes
+// a slot once it starts reading the ClientHello and gives it back once it
+// completes or the connection is closed, which the handlers do once their
+// handshake fails; the ones over the limit wait for a slot, or fail, as the
+// handshake policy says. It applies to the connections dispatched from then on.
+// Zero, the default, removes the bound.
+func (m *Listener) SetMaxConcurrentHandshakes(n int) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.tlsHandshakes = nil
+	if n > 0 {
+		m.tlsHandshakes = newSemaphore(int64(n))
+	}
+}
+
+// SetHandshakePolicy sets what happens to the TLS handshakes started while the
+// concurrent handshake limit is reached. By default, they wait for a slot,
+// within the read deadline the handler set, or until the connection is
+// closed, and fail with ErrHandshakeLimit if none is freed in time.
+func (m *Listener) SetHandshakePolicy(policy HandshakePolicy) {
+	m.Lock()
+	defer m.Unlock()
+	m.tlsPolicy = policy
+}
+
+// handshakeLimit returns the slots of the concurrent handshakes, nil if they
+// are unbounded, and their policy.
+func (m *Listener) handshakeLimit() (*semaphore, HandshakePolicy) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.tlsHandshakes, m.tlsPolicy
+}
+
+// tlsServer terminates TLS on a connection of the route, within the concurrent
+// handshake limit of its listener.
+func (r *Route) tlsServer(c net.Conn, config *tls.Config) net.Conn {
+	if r.mux == nil {
+		return tls.Server(c, config)
+	}
+	slots, policy := r.mux.handshakeLimit()
+	if conn, ok := AsConn(c); slots == nil || ok && conn.exempt {
+		return tls.Server(c, config)
+	}
+
+	gated := &gatedConn{Conn: c, slots: slots, policy: policy, closed: make(chan struct{})}
+	config = config.Clone()
+	verify := config.VerifyConnection
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		gated.release()
+		if verify != nil {
+			return verify(state)
+		}
+		return nil
+	}
+	return tls.Server(gated, config)
+}
+
+// The states of the slot of a gated connection.
+const (
+	slotNone     = iota // Not taken yet.
+	slotTaken           // Taken by the handshake.
+	slotReleased        // Given back, or never to be taken.
+)
+
+// gatedConn is the transport of a TLS connection whose handshake takes a
+// concurrent handshake slot.
+type gatedConn struct {
+	net.Conn
+	slots    *semaphore
+	policy   HandshakePolicy
+	mu       sync.Mutex
+	state    int
+	deadline time.Time // The read deadline set on the connection.
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// Read takes a handshake slot on the first read, as the handshake starts, and
+// reads from the connection.
+func (c *gatedConn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	state, deadline := c.state, c.deadline
+	c.mu.Unlock()
+
+	if state == slotNone {
+		taken := false
+		if c.policy == HandshakeReject {
+			taken = c.slots.acquire(1)
+		} else {
+			taken = c.slots.wait(1, deadline, c.closed)
+		}
+		if !taken {
+			return 0, ErrHandshakeLimit
+		}
+
+		c.mu.Lock()
+		if c.state == slotNone {
+			c.state = slotTaken
+		} else {
+			taken = false
+		}
+		c.mu.Unlock()
+		if !taken {
+			c.slots.release(1)
+		}
+	}
+	return c.Conn.Read(p)
+}
+
+// release gives the handshake slot back, if it was taken.
+func (c *gatedConn) release() {
+	c.mu.Lock()
+	taken := c.state == slotTaken
+	c.state = slotReleased
+	c.mu.Unlock()
+
+	if taken {
+		c.slots.release(1)
+	}
+}
+
+// Close closes the connection and gives its handshake slot back.
+func (c *gatedConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	c.release()
+	return c.Conn.Close()
+}
+
+// SetDeadline sets the deadlines of the connection, the read one bounding the
+// wait for a handshake slot.
+func (c *gatedConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline of the connection, which bounds the
+// wait for a handshake slot.
+func (c *gatedConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+// NetConn returns the gated connection.
+func (c *gatedConn) NetConn() net.Conn {
+	return c.Conn
+}
@@ -0,0 +1,156 @@
+package listener
+
+import (
+	"crypto/tls"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedHandshakes is a TLS server config whose handshakes wait for the gate
+// to open while they hold their slot, counting the ones running at once.
+type gatedHandshakes struct {
+	mu      sync.Mutex
+	running int
+	peak    int
+	gate    chan struct{}
+}
+
+func (g *gatedHandshakes) config(cert tls.Certificate) *tls.Config {
+	g.gate = make(chan struct{})
+	return &tls.Config{GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		g.mu.Lock()
+		if g.running++; g.running > g.peak {
+			g.peak = g.running
+		}
+		g.mu.Unlock()
+		<-g.gate
+		g.mu.Lock()
+		g.running--
+		g.mu.Unlock()
+		return &cert, nil
+	}}
+}
+
+func (g *gatedHandshakes) counts() (running, peak int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.running, g.peak
+}
+
+// serveHandshakes runs the handshakes of the connections of the route, the
+// ones accepted first with the read deadlines, if not zero, and sends their
+// errors.
+func serveHandshakes(r *Route, deadlines ...time.Duration) <-chan error {
+	errs := make(chan error, 16)
+	go func() {
+		for i := 0; ; i++ {
+			c, err := r.Accept()
+			if err != nil {
+				return
+			}
+			var deadline time.Duration
+			if i < len(deadlines) {
+				deadline = deadlines[i]
+			}
+			go func() {
+				defer c.Close()
+				if deadline > 0 {
+					_ = c.SetReadDeadline(time.Now().Add(deadline))
+				}
+				errs <- c.(*tls.Conn).Handshake()
+			}()
+		}
+	}()
+	return errs
+}
+
+func TestMaxConcurrentHandshakes(t *testing.T) {
+	ca := newTestCA(t)
+	m := newTestMux(t)
+	m.SetMaxConcurrentHandshakes(2)
+	r := m.Route("tls", MatchTLS())
+	var g gatedHandshakes
+	r.TerminateTLS(g.config(ca.issue("example.com", true)))
+	errs := serveHandshakes(r)
+	m.serve()
+
+	const clients = 6
+	client := &tls.Config{RootCAs: ca.pool(), ServerName: "example.com"}
+	var handshakes []<-chan error
+	for i := 0; i < clients; i++ {
+		handshakes = append(handshakes, m.dialTLS(client))
+	}
+
+	// The other handshakes wait for a slot
+	waitFor(t, "the slots to be taken", func() bool { running, _ := g.counts(); return running == 2 })
+	time.Sleep(20 * time.Millisecond)
+	if running, _ := g.counts(); running != 2 {
+		t.Errorf("%d handshakes running at once, want 2", running)
+	}
+
+	close(g.gate)
+	for i := 0; i < clients; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("server Handshake() = %v", err)
+		}
+		if err := <-handshakes[i]; err != nil {
+			t.Errorf("client Handshake() = %v", err)
+		}
+	}
+	if _, peak := g.counts(); peak != 2 {
+		t.Errorf("up to %d handshakes ran at once, want 2", peak)
+	}
+}
+
+func TestHandshakePolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   HandshakePolicy
+		deadline time.Duration
+	}{
+		{"reject", HandshakeReject, 0},
+		{"wait within the deadline", HandshakeWait, 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ca := newTestCA(t)
+			m := newTestMux(t)
+			m.SetMaxConcurrentHandshakes(1)
+			m.SetHandshakePolicy(tt.policy)
+			r := m.Route("tls", MatchTLS())
+			var g gatedHandshakes
+			r.TerminateTLS(g.config(ca.issue("example.com", true)))
+			errs := serveHandshakes(r, 0, tt.deadline)
+			m.serve()
+
+			client := &tls.Config{RootCAs: ca.pool(), ServerName: "example.com"}
+			first := m.dialTLS(client)
+			waitFor(t, "the slot to be taken", func() bool { running, _ := g.counts(); return running == 1 })
+
+			// The handshake over the limit fails, as the policy says
+			m.dialTLS(client)
+			if err := <-errs; !errors.Is(err, ErrHandshakeLimit) {
+				t.Errorf("server Handshake() over the limit = %v, want ErrHandshakeLimit", err)
+			}
+
+			close(g.gate)
+			if err := <-errs; err != nil {
+				t.Errorf("server Handshake() = %v", err)
+			}
+			if err := <-first; err != nil {
+				t.Errorf("client Handshake() = %v", err)
+			}
+
+			// The slot given back serves the next handshake
+			next := m.dialTLS(client)
+			if err := <-errs; err != nil {
+				t.Errorf("server Handshake() once the slot is free = %v", err)
+			}
+			if err := <-next; err != nil {
+				t.Errorf("client Handshake() once the slot is free = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package listener
+
+import (
+	"io"
+	"net"
+
+	"github.com/numb3r3/live-go/log"
+)
+
+// SetMatchTraceFilter sets a function selecting the connections whose matching
+// is traced, for example the ones of a misrouted client given its address. The
+// name of every matcher tried on such a connection, the number of bytes it read
+// and its result are logged at debug level. A nil function disables tracing.
+func (m *Listener) SetMatchTraceFilter(filter func(net.Conn) bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.hooks.traceFilter.reset()
+	m.traceFilter = filter
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	io.Reader
+	n int
+}
+
+// Read reads from the underlying reader and counts the bytes.
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += n
+	return n, err
+}
+
+// traceMatch logs the attempt of a matcher, given its name, on a traced
+// connection.
+func traceMatch(c *Conn, name string, read int, matched bool, state *sniffState) {
+	result := "not matched"
+	switch {
+	case state.rejected != nil:
+		result = "rejected: " + state.rejected.Error()
+	case matched:
+		result = "matched"
+	}
+	logging.Debugf("connection %s from %v: matcher %s read %d bytes, %s", c.id, c.RemoteAddr(), name, read, result)
+}
@@ -0,0 +1,55 @@
+package listener
+
+import (
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	logging "github.com/numb3r3/live-go/log"
+)
+
+// captureLog sends the log, at debug level, to the returned buffer until the
+// end of the test.
+func captureLog(t *testing.T) *syncBuffer {
+	out := new(syncBuffer)
+	level := logging.GetLogLevel()
+	logging.SetLevel(logging.LOG_LEVEL_DEBUG)
+	logging.SetOutput(out)
+	t.Cleanup(func() {
+		logging.SetOutput(os.Stderr)
+		logging.SetLevel(level)
+	})
+	return out
+}
+
+func TestMatchTraceFilter(t *testing.T) {
+	out := captureLog(t)
+	mem := NewMemoryListener()
+	addrs := []string{"10.0.0.1:4000", "10.0.0.2:4000"}
+	m := &testMux{Listener: New(&addrListener{Listener: mem, addrs: addrs}), t: t, dialer: mem, mem: mem}
+	m.SetMatchTraceFilter(func(c net.Conn) bool { return strings.HasPrefix(c.RemoteAddr().String(), "10.0.0.1:") })
+	m.Match(MatchPrefix("*"))
+	r := m.ScoredRoute("web", Named("http", MatchHTTP()))
+	m.serve()
+
+	m.dial(httpRequest("Host: a"))
+	accept(t, r)
+	m.dial(httpRequest("Host: a"))
+	accept(t, r)
+
+	var traced []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if strings.Contains(line, ": matcher ") {
+			traced = append(traced, line)
+		}
+	}
+	if len(traced) != 2 {
+		t.Fatalf("traced %q, want the two matchers of the first connection", traced)
+	}
+	for i, want := range []string{"listener.Matcher read 1 bytes, not matched", "http read 3 bytes, matched"} {
+		if !strings.Contains(traced[i], "from 10.0.0.1:4000") || !strings.Contains(traced[i], want) {
+			t.Errorf("trace %d = %q, want %s from 10.0.0.1", i, traced[i], want)
+		}
+	}
+}
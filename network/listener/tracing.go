@@ -0,0 +1,61 @@
+package listener
+
+// Tracer starts the trace spans of the listener. It is a minimal interface so
+// the listener does not depend on a tracing library; an adapter can forward the
+// spans to OpenTelemetry, for example.
+type Tracer interface {
+	StartSpan(name string, attrs ...Attr) Span
+}
+
+// Span is a trace span started by a Tracer.
+type Span interface {
+	End()
+}
+
+// Attr is an attribute attached to a span when it is started.
+type Attr struct {
+	Key   string
+	Value string
+}
+
+// The names of the spans started by the listener.
+const (
+	SpanMatch = "listener.match" // From the accept of a connection until it is dispatched or rejected.
+	SpanConn  = "listener.conn"  // From the dispatch of a connection until it is closed.
+)
+
+// noopSpan is used when no tracer is set.
+type noopSpan struct{}
+
+func (noopSpan) End() {}
+
+// SetTracer sets the tracer used to create a span for the matching of each
+// connection and a span for the lifetime of each matched connection, with the
+// connection id, the remote address and the route as attributes.
+func (m *Listener) SetTracer(t Tracer) {
+	m.Lock()
+	defer m.Unlock()
+	m.tracer = t
+	m.hooks.tracer.reset()
+}
+
+// startSpan starts a span with the tracer, if there's one.
+func (m *Listener) startSpan(name string, c *Conn) Span {
+	m.RLock()
+	tracer := m.tracer
+	m.RUnlock()
+	if tracer == nil {
+		return noopSpan{}
+	}
+
+	attrs := []Attr{{Key: "conn.id", Value: c.id}, {Key: "remote", Value: remoteAddr(c)}}
+	if c.route != "" {
+		attrs = append(attrs, Attr{Key: "route", Value: c.route})
+	}
+	var span Span
+	m.hooks.tracer.call("tracer", func() { span = tracer.StartSpan(name, attrs...) })
+	if span == nil {
+		return noopSpan{}
+	}
+	return &guardedSpan{Span: span, guard: &m.hooks.tracer}
+}
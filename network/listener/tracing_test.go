@@ -0,0 +1,80 @@
+package listener
+
+import (
+	"sync"
+	"testing"
+)
+
+// fakeTracer records the spans it starts.
+type fakeTracer struct {
+	mu    sync.Mutex
+	spans []*fakeSpan
+}
+
+type fakeSpan struct {
+	tracer *fakeTracer
+	name   string
+	attrs  map[string]string
+	ended  bool
+}
+
+func (t *fakeTracer) StartSpan(name string, attrs ...Attr) Span {
+	s := &fakeSpan{tracer: t, name: name, attrs: make(map[string]string)}
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.spans = append(t.spans, s)
+	return s
+}
+
+func (s *fakeSpan) End() {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.ended = true
+}
+
+// span returns a copy of the first span with the name, if it was started.
+func (t *fakeTracer) span(name string) (fakeSpan, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, s := range t.spans {
+		if s.name == name {
+			return *s, true
+		}
+	}
+	return fakeSpan{}, false
+}
+
+func TestTracer(t *testing.T) {
+	tracer := new(fakeTracer)
+	m := newTestMux(t)
+	m.SetTracer(tracer)
+	r := m.Route("echo", MatchAny())
+	m.serve()
+
+	m.dial("x")
+	c := accept(t, r)
+	match, ok := tracer.span(SpanMatch)
+	if !ok || !match.ended {
+		t.Fatalf("match span = %+v, want an ended span", match)
+	}
+	if match.attrs["conn.id"] == "" || match.attrs["remote"] == "" {
+		t.Errorf("match span attributes = %v, want the conn id and remote", match.attrs)
+	}
+
+	conn, ok := tracer.span(SpanConn)
+	if !ok || conn.ended {
+		t.Fatalf("conn span = %+v, want a started span", conn)
+	}
+	if conn.attrs["route"] != "echo" || conn.attrs["conn.id"] != match.attrs["conn.id"] {
+		t.Errorf("conn span attributes = %v, want route echo and the same conn id", conn.attrs)
+	}
+
+	_ = c.Close()
+	waitFor(t, "the conn span to end", func() bool {
+		s, _ := tracer.span(SpanConn)
+		return s.ended
+	})
+}
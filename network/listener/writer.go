@@ -0,0 +1,20 @@
+package listener
+
+import (
+	"io"
+)
+
+// WriterMatcher matches a connection of a protocol where the server speaks
+// first, such as a greeting or a banner: it may write to the connection before
+// reading the reply of the client.
+type WriterMatcher func(w io.Writer, r io.Reader) bool
+
+// MatchWriter adapts a writer matcher to a Matcher. The bytes it writes are
+// sent to the client right away and are not replayed, so the handler must not
+// send them again. As the other matchers can not take them back, writer
+// matchers should be registered after all the client-speaks-first ones.
+func MatchWriter(match WriterMatcher) Matcher {
+	return func(r io.Reader) bool {
+		return match(writerOf(r), r)
+	}
+}
@@ -0,0 +1,141 @@
+package listener
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+)
+
+// maxObservedHeaders bounds the bytes of the HTTP request scanned for a
+// WebSocket upgrade before the frames are observed.
+const maxObservedHeaders = 16 * 1024
+
+// SetWebSocketFrameObserver sets a function called with the ID of a matched
+// connection and the opcode of every WebSocket frame its client sends, once it
+// upgraded, so keepalive-only or misbehaving clients can be spotted, the ones
+// sending nothing but pings (0x9) for instance. The frames are parsed as the
+// handler reads them, not buffered, and the connections which are not a
+// WebSocket upgrade are not observed past their request headers.
+//
+// The observer sees the bytes the handler reads from the listener, so the
+// connections of a route terminating TLS with TerminateTLS are not observed. A
+// nil function disables it.
+func (m *Listener) SetWebSocketFrameObserver(observer func(connID string, opcode byte)) {
+	m.Lock()
+	defer m.Unlock()
+	m.frameObserver = observer
+	m.hooks.frameObserver.reset()
+}
+
+// The phases of the frame observer.
+const (
+	observeRequest = iota // Scanning the HTTP request headers.
+	observeFrames         // Parsing the frames.
+	observeNothing        // Not a WebSocket connection.
+)
+
+// frameObserver is a connection reporting the opcodes of the WebSocket frames
+// read from it.
+type frameObserver struct {
+	net.Conn
+	id       string
+	observe  func(connID string, opcode byte)
+	guard    *hookGuard
+	phase    int
+	request  []byte // The request headers read so far.
+	header   []byte // The frame header read so far.
+	skipping uint64 // The bytes of the current payload left to skip.
+}
+
+// Read reads from the connection and parses what was read.
+func (o *frameObserver) Read(p []byte) (int, error) {
+	n, err := o.Conn.Read(p)
+	if n > 0 && o.phase != observeNothing {
+		o.feed(p[:n])
+	}
+	return n, err
+}
+
+// NetConn returns the observed connection.
+func (o *frameObserver) NetConn() net.Conn {
+	return o.Conn
+}
+
+// feed parses the bytes read from the connection.
+func (o *frameObserver) feed(b []byte) {
+	if o.phase == observeRequest {
+		start := len(o.request)
+		if start >= 3 {
+			start -= 3
+		}
+		o.request = append(o.request, b...)
+		end := bytes.Index(o.request[start:], []byte("\r\n\r\n"))
+		switch {
+		case end < 0 && len(o.request) > maxObservedHeaders:
+			o.phase, o.request = observeNothing, nil
+			return
+		case end < 0:
+			return
+		}
+
+		end += start + 4
+		rest := o.request[end:]
+		if !MatchWebSocket()(bytes.NewReader(o.request[:end])) {
+			o.phase, o.request = observeNothing, nil
+			return
+		}
+		o.phase, o.request = observeFrames, nil
+		b = rest
+	}
+
+	for len(b) > 0 {
+		if o.skipping > 0 {
+			skip := o.skipping
+			if skip > uint64(len(b)) {
+				skip = uint64(len(b))
+			}
+			o.skipping -= skip
+			b = b[skip:]
+			continue
+		}
+
+		o.header = append(o.header, b[0])
+		b = b[1:]
+		if length, ok := o.frameHeader(); ok {
+			opcode := o.header[0] & 0x0f
+			o.guard.call("WebSocket frame observer", func() { o.observe(o.id, opcode) })
+			o.header, o.skipping = o.header[:0], length
+		}
+	}
+}
+
+// frameHeader returns the payload length of the frame whose header was read,
+// once it's complete.
+func (o *frameObserver) frameHeader() (uint64, bool) {
+	h := o.header
+	if len(h) < 2 {
+		return 0, false
+	}
+
+	size, extended := 2, 0
+	switch h[1] & 0x7f {
+	case 126:
+		extended = 2
+	case 127:
+		extended = 8
+	}
+	if h[1]&0x80 != 0 {
+		size += 4
+	}
+	if len(h) < size+extended {
+		return 0, false
+	}
+
+	switch extended {
+	case 2:
+		return uint64(binary.BigEndian.Uint16(h[2:])), true
+	case 8:
+		return binary.BigEndian.Uint64(h[2:]), true
+	}
+	return uint64(h[1] & 0x7f), true
+}
@@ -0,0 +1,64 @@
+package listener
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+// maskedFrame encodes a final client frame, masked with a zero key.
+func maskedFrame(opcode byte, payload string) string {
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, 0x80|byte(n))
+	default:
+		header = append(header, 0x80|126, byte(n>>8), byte(n))
+	}
+	return string(header) + "\x00\x00\x00\x00" + payload
+}
+
+func TestWebSocketFrameObserver(t *testing.T) {
+	var mu sync.Mutex
+	opcodes := make(map[string][]byte)
+	m := newTestMux(t)
+	m.SetWebSocketFrameObserver(func(id string, opcode byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		opcodes[id] = append(opcodes[id], opcode)
+	})
+	ws := m.Route("ws", MatchWebSocket())
+	web := m.Route("web", MatchHTTP())
+	m.serve()
+
+	upgrade := httpRequest("Host: a", "Upgrade: websocket")
+	frames := maskedFrame(0x9, "") + maskedFrame(0x1, "hi") + maskedFrame(0x2, strings.Repeat("b", 200)) + maskedFrame(0x9, "")
+	m.dial(upgrade + frames)
+	c := accept(t, ws)
+
+	// The frames are parsed across reads of any size
+	for left := len(upgrade + frames); left > 0; left -= 7 {
+		n := 7
+		if left < n {
+			n = left
+		}
+		readN(t, c, n)
+	}
+
+	// A request which is not an upgrade is not observed past its headers
+	request := httpRequest("Host: a")
+	m.dial(request + maskedFrame(0x9, ""))
+	other := accept(t, web)
+	readN(t, other, len(request)+6)
+
+	wsConn, _ := AsConn(c)
+	webConn, _ := AsConn(other)
+	mu.Lock()
+	defer mu.Unlock()
+	if got := string(opcodes[wsConn.ID()]); got != "\x09\x01\x02\x09" {
+		t.Errorf("observed opcodes %x, want 09010209", got)
+	}
+	if got, ok := opcodes[webConn.ID()]; ok {
+		t.Errorf("observed opcodes %x of a plain request, want none", got)
+	}
+}